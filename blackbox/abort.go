@@ -0,0 +1,200 @@
+package blackbox
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/tdigest"
+)
+
+// AbortRule stops an exploration early once an aggregate statistic crosses
+// a threshold, e.g. "failure_rate > 0.2 after 100 runs" or
+// "p95(latency_ms) > 500". Statistics are runs, failures, failure_rate and
+// min, max, mean, sum or pNN of a numeric output.
+type AbortRule struct {
+	Text      string
+	Stat      string
+	Output    string
+	Op        string
+	Threshold float64
+	After     int
+}
+
+var abortRuleRegexp = regexp.MustCompile(`^\s*(\w+(?:\.\d+)?)(?:\(\s*([^()\s]+)\s*\))?\s*(<=|>=|==|!=|<|>)\s*([-+0-9.eE]+)\s*(?:after\s+(\d+)\s+runs?)?\s*$`)
+
+var percentileStatRegexp = regexp.MustCompile(`^p(\d{1,2}(?:\.\d+)?)$`)
+
+func ParseAbortRule(text string) (AbortRule, error) {
+	match := abortRuleRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return AbortRule{}, fmt.Errorf("Unable to parse rule %q, expected e.g. \"failure_rate > 0.2 after 100 runs\"", text)
+	}
+	rule := AbortRule{Text: strings.TrimSpace(text), Stat: match[1], Output: match[2], Op: match[3]}
+	threshold, err := strconv.ParseFloat(match[4], 64)
+	if err != nil {
+		return rule, fmt.Errorf("Invalid threshold in rule %q: %v", text, err)
+	}
+	rule.Threshold = threshold
+	if match[5] != "" {
+		rule.After, _ = strconv.Atoi(match[5])
+	}
+	return rule, checkStat(rule.Stat, rule.Output, text)
+}
+
+// checkStat verifies that a statistic of a rule exists and has an output
+// if it needs one.
+func checkStat(stat, output, text string) error {
+	switch stat {
+	case "runs", "failures", "failure_rate":
+		if output != "" {
+			return fmt.Errorf("%s takes no output in rule %q", stat, text)
+		}
+	case "min", "max", "mean", "sum":
+		if output == "" {
+			return fmt.Errorf("%s needs an output, e.g. %s(latency_ms), in rule %q", stat, stat, text)
+		}
+	default:
+		if !percentileStatRegexp.MatchString(stat) || output == "" {
+			return fmt.Errorf("Unknown statistic %s in rule %q", stat, text)
+		}
+	}
+	return nil
+}
+
+// ParseAbortRules parses semicolon separated rules.
+func ParseAbortRules(text string) ([]AbortRule, error) {
+	rules := []AbortRule{}
+	for _, ruleText := range strings.Split(text, ";") {
+		if strings.TrimSpace(ruleText) == "" {
+			continue
+		}
+		rule, err := ParseAbortRule(ruleText)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+type outputStats struct {
+	count         int
+	sum, min, max float64
+	digest        *tdigest.TDigest
+}
+
+// RunStats aggregates the results of an exploration as they come in.
+type RunStats struct {
+	Runs     int
+	Failures int
+	outputs  map[string]*outputStats
+}
+
+func NewRunStats() *RunStats {
+	return &RunStats{outputs: map[string]*outputStats{}}
+}
+
+// Add records one invocation's outputs, or a failure if failed is set.
+func (s *RunStats) Add(outputMap map[string]string, failed bool) {
+	s.Runs++
+	if failed {
+		s.Failures++
+		return
+	}
+	for name, raw := range outputMap {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		stats, ok := s.outputs[name]
+		if !ok {
+			stats = &outputStats{min: math.Inf(1), max: math.Inf(-1), digest: tdigest.NewWithCompression(100)}
+			s.outputs[name] = stats
+		}
+		stats.count++
+		stats.sum += value
+		stats.min = math.Min(stats.min, value)
+		stats.max = math.Max(stats.max, value)
+		stats.digest.Add(value, 1)
+	}
+}
+
+// Stat returns the value of a statistic, or false if it has no value yet.
+func (s *RunStats) Stat(stat, output string) (float64, bool) {
+	switch stat {
+	case "runs":
+		return float64(s.Runs), true
+	case "failures":
+		return float64(s.Failures), true
+	case "failure_rate":
+		if s.Runs == 0 {
+			return 0, false
+		}
+		return float64(s.Failures) / float64(s.Runs), true
+	}
+	stats, ok := s.outputs[output]
+	if !ok || stats.count == 0 {
+		return 0, false
+	}
+	switch stat {
+	case "min":
+		return stats.min, true
+	case "max":
+		return stats.max, true
+	case "mean":
+		return stats.sum / float64(stats.count), true
+	case "sum":
+		return stats.sum, true
+	}
+	if match := percentileStatRegexp.FindStringSubmatch(stat); match != nil {
+		percentile, _ := strconv.ParseFloat(match[1], 64)
+		return stats.digest.Quantile(percentile / 100), true
+	}
+	return 0, false
+}
+
+func compare(value float64, op string, threshold float64) bool {
+	switch op {
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+// Check returns the abort reason if the rule is triggered by stats.
+func (r AbortRule) Check(stats *RunStats) (string, bool) {
+	if stats.Runs < r.After {
+		return "", false
+	}
+	value, ok := stats.Stat(r.Stat, r.Output)
+	if !ok || !compare(value, r.Op, r.Threshold) {
+		return "", false
+	}
+	name := r.Stat
+	if r.Output != "" {
+		name = fmt.Sprintf("%s(%s)", r.Stat, r.Output)
+	}
+	return fmt.Sprintf("%s (%s=%.4g after %d runs)", r.Text, name, value, stats.Runs), true
+}
+
+// AbortError is returned by an exploration stopped by an abort rule.
+type AbortError struct {
+	Reason string
+}
+
+func (e *AbortError) Error() string {
+	return "Aborted: " + e.Reason
+}
@@ -0,0 +1,59 @@
+package blackbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAbortRule(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    AbortRule
+		wantErr bool
+	}{
+		{
+			text: "failure_rate > 0.2 after 100 runs",
+			want: AbortRule{Text: "failure_rate > 0.2 after 100 runs", Stat: "failure_rate", Op: ">", Threshold: 0.2, After: 100},
+		},
+		{
+			text: " p95(latency_ms) > 500 ",
+			want: AbortRule{Text: "p95(latency_ms) > 500", Stat: "p95", Output: "latency_ms", Op: ">", Threshold: 500},
+		},
+		{
+			text: "p99.9( latency_ms )>=1e3",
+			want: AbortRule{Text: "p99.9( latency_ms )>=1e3", Stat: "p99.9", Output: "latency_ms", Op: ">=", Threshold: 1000},
+		},
+		{
+			text: "mean(score) < -1.5 after 1 run",
+			want: AbortRule{Text: "mean(score) < -1.5 after 1 run", Stat: "mean", Output: "score", Op: "<", Threshold: -1.5, After: 1},
+		},
+		{
+			text: "failures != 0",
+			want: AbortRule{Text: "failures != 0", Stat: "failures", Op: "!=", Threshold: 0},
+		},
+		{text: "runs(latency_ms) > 10", wantErr: true},
+		{text: "max > 10", wantErr: true},
+		{text: "p100(latency_ms) > 10", wantErr: true},
+		{text: "median(latency_ms) > 10", wantErr: true},
+		{text: "failure_rate > 0.2 after runs", wantErr: true},
+		{text: "failure_rate =~ 0.2", wantErr: true},
+		{text: "failure_rate > 1..2", wantErr: true},
+		{text: "", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseAbortRule(test.text)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseAbortRule(%q) = %+v, want an error", test.text, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAbortRule(%q) failed: %v", test.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseAbortRule(%q) = %+v, want %+v", test.text, got, test.want)
+		}
+	}
+}
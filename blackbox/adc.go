@@ -0,0 +1,67 @@
+package blackbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"cloud.google.com/go/compute/metadata"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Account of the Application Default Credentials in use, for sharing hints.
+var defaultCredentialsEmail string
+
+// usesDefaultCredentials reports whether to authenticate with the
+// Application Default Credentials, as on GCE or GKE with Workload
+// Identity, which is when no client secret is configured.
+func usesDefaultCredentials() bool {
+	_, err := os.Stat(clientSecretFile)
+	return os.IsNotExist(err)
+}
+
+// defaultClient returns an HTTP client for the Sheets API authenticated
+// with the Application Default Credentials: GOOGLE_APPLICATION_CREDENTIALS,
+// the gcloud user credentials or the metadata server, with the spreadsheets
+// scope.
+func defaultClient(ctx context.Context) (*http.Client, error) {
+	creds, err := google.FindDefaultCredentials(ctx, spreadsheetsScope, emailScope, driveFileScope)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read client secret file %s, nor find Application Default Credentials: %v", clientSecretFile, err)
+	}
+	defaultCredentialsEmail = credentialsEmail(creds)
+	if defaultCredentialsEmail != "" {
+		Log.Infof("Using the Application Default Credentials of %s\n", defaultCredentialsEmail)
+	} else {
+		Log.Infof("Using the Application Default Credentials\n")
+	}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.IdleConnTimeout = sheetsIdleConnTimeout
+	return &http.Client{
+		Transport: &backoffTransport{next: &oauth2.Transport{Source: creds.TokenSource, Base: base}},
+	}, nil
+}
+
+// credentialsEmail returns the service account of credentials, "" if
+// unknown, e.g. for gcloud user credentials.
+func credentialsEmail(creds *google.Credentials) string {
+	if len(creds.JSON) > 0 {
+		key := struct {
+			ClientEmail string `json:"client_email"`
+		}{}
+		if json.Unmarshal(creds.JSON, &key) == nil {
+			return key.ClientEmail
+		}
+		return ""
+	}
+	if metadata.OnGCE() {
+		// With Workload Identity, the service account bound to the pod
+		if email, err := metadata.Email("default"); err == nil {
+			return email
+		}
+	}
+	return ""
+}
@@ -0,0 +1,303 @@
+package blackbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	airtableAPI = "https://api.airtable.com/v0"
+	// Spreadsheet arguments of this prefix name an Airtable base, whose
+	// tables hold the experiment as the tabs of a spreadsheet would, e.g.
+	// airtable:appXXXXXXXXXXXXXX
+	airtablePrefix = "airtable:"
+	// Records created per request, at most, and wait after a request is
+	// rate limited, as documented by Airtable
+	airtableBatch     = 10
+	airtableRateLimit = 30 * time.Second
+)
+
+// IsAirtableBase reports whether a spreadsheet argument names an Airtable
+// base rather than a Google spreadsheet ID.
+func IsAirtableBase(spreadsheet string) bool {
+	return strings.HasPrefix(spreadsheet, airtablePrefix)
+}
+
+// airtableClient calls the Airtable API for a base with the personal
+// access token in $BLACKBOX_AIRTABLE_TOKEN.
+type airtableClient struct {
+	token string
+	base  string
+}
+
+func newAirtableClient(base string) (*airtableClient, error) {
+	token := GetVariableOrDefault("BLACKBOX_AIRTABLE_TOKEN", "")
+	if token == "" {
+		return nil, fmt.Errorf("Using Airtable needs a personal access token in BLACKBOX_AIRTABLE_TOKEN")
+	}
+	if base == "" {
+		return nil, fmt.Errorf("Missing Airtable base ID, expected e.g. airtable:appXXXXXXXXXXXXXX")
+	}
+	return &airtableClient{token: token, base: base}, nil
+}
+
+// do sends a request with a JSON body, if any, and decodes the JSON
+// response into out, waiting and retrying once if rate limited.
+func (c *airtableClient) do(method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, airtableAPI+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("Unable to call Airtable: %v", err)
+		}
+		content, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("Unable to read Airtable response: %v", err)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt == 0 {
+			Log.Warnf("Airtable rate limit reached, waiting %v\n", airtableRateLimit)
+			time.Sleep(airtableRateLimit)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("Airtable %s %s returned %s: %s", method, path, resp.Status, bytes.TrimSpace(content))
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(content, out)
+	}
+}
+
+type airtableField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type airtableTable struct {
+	ID     string          `json:"id"`
+	Name   string          `json:"name"`
+	Fields []airtableField `json:"fields"`
+}
+
+// tables lists the tables of the base, which needs the schema.bases:read
+// scope.
+func (c *airtableClient) tables() ([]airtableTable, error) {
+	var resp struct {
+		Tables []airtableTable `json:"tables"`
+	}
+	if err := c.do(http.MethodGet, "/meta/bases/"+c.base+"/tables", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tables, nil
+}
+
+// airtableValue renders the value of a field as the cell of a tab would
+// hold it; multiple values, e.g. of multiple select fields, are comma
+// separated, as examples are.
+func airtableValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return FormatValue(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			values = append(values, airtableValue(item))
+		}
+		return strings.Join(values, ", ")
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// AirtableSource reads the tables of an Airtable base as the tabs of a
+// spreadsheet, e.g. an inputs table with variable and examples fields.
+// A table's fields make its header row, sorted by name, since records
+// leave out their empty fields.
+type AirtableSource struct {
+	client *airtableClient
+}
+
+func NewAirtableSource(base string) (*AirtableSource, error) {
+	client, err := newAirtableClient(base)
+	if err != nil {
+		return nil, err
+	}
+	return &AirtableSource{client: client}, nil
+}
+
+func (s *AirtableSource) ReadRows(table string) ([][]string, error) {
+	records := []map[string]interface{}{}
+	offset := ""
+	for {
+		var page struct {
+			Records []struct {
+				Fields map[string]interface{} `json:"fields"`
+			} `json:"records"`
+			Offset string `json:"offset"`
+		}
+		path := "/" + s.client.base + "/" + url.PathEscape(table) + "?pageSize=100"
+		if offset != "" {
+			path += "&offset=" + url.QueryEscape(offset)
+		}
+		if err := s.client.do(http.MethodGet, path, nil, &page); err != nil {
+			return nil, fmt.Errorf("Unable to read table %s: %v", table, err)
+		}
+		for _, record := range page.Records {
+			records = append(records, record.Fields)
+		}
+		if offset = page.Offset; offset == "" {
+			break
+		}
+	}
+	seen := map[string]bool{}
+	header := []string{}
+	for _, fields := range records {
+		for name := range fields {
+			if !seen[name] {
+				seen[name] = true
+				header = append(header, name)
+			}
+		}
+	}
+	sort.Strings(header)
+	rows := [][]string{header}
+	for _, fields := range records {
+		row := make([]string, len(header))
+		for i, name := range header {
+			row[i] = airtableValue(fields[name])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (s *AirtableSource) SheetNames() ([]string, error) {
+	tables, err := s.client.tables()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list tables: %v", err)
+	}
+	names := []string{}
+	for _, table := range tables {
+		names = append(names, table.Name)
+	}
+	return names, nil
+}
+
+// AirtableSink creates a record per result in a table of an Airtable base,
+// for "airtable:BASE/TABLE", or in a new table named after the run, for
+// "airtable:BASE". Missing fields are added as text fields, values being
+// converted to the types of existing ones.
+type AirtableSink struct {
+	client  *airtableClient
+	table   string
+	header  []string
+	pending []map[string]interface{}
+}
+
+func NewAirtableSink(target string, sinkContext *SinkContext) (*AirtableSink, error) {
+	base, table := target, sinkContext.RunName
+	if i := strings.Index(target, "/"); i >= 0 {
+		base, table = target[:i], target[i+1:]
+	}
+	client, err := newAirtableClient(base)
+	if err != nil {
+		return nil, err
+	}
+	return &AirtableSink{client: client, table: table}, nil
+}
+
+func (s *AirtableSink) WriteHeader(header []string) error {
+	s.header = header
+	tables, err := s.client.tables()
+	if err != nil {
+		return fmt.Errorf("Unable to list tables: %v", err)
+	}
+	for _, table := range tables {
+		if table.Name != s.table {
+			continue
+		}
+		existing := map[string]bool{}
+		for _, field := range table.Fields {
+			existing[field.Name] = true
+		}
+		for _, column := range header {
+			if existing[column] {
+				continue
+			}
+			field := airtableField{Name: column, Type: "singleLineText"}
+			if err := s.client.do(http.MethodPost, "/meta/bases/"+s.client.base+"/tables/"+table.ID+"/fields", field, nil); err != nil {
+				return fmt.Errorf("Unable to add field %s to table %s: %v", column, s.table, err)
+			}
+		}
+		return nil
+	}
+	fields := []airtableField{}
+	for _, column := range header {
+		fields = append(fields, airtableField{Name: column, Type: "singleLineText"})
+	}
+	created := map[string]interface{}{"name": s.table, "fields": fields}
+	if err := s.client.do(http.MethodPost, "/meta/bases/"+s.client.base+"/tables", created, nil); err != nil {
+		return fmt.Errorf("Unable to create table %s: %v", s.table, err)
+	}
+	return nil
+}
+
+func (s *AirtableSink) WriteRow(row []string) error {
+	fields := map[string]interface{}{}
+	for i, column := range s.header {
+		if value := cell(row, i); value != "" {
+			fields[column] = value
+		}
+	}
+	s.pending = append(s.pending, map[string]interface{}{"fields": fields})
+	if len(s.pending) >= airtableBatch {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *AirtableSink) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	body := map[string]interface{}{"records": s.pending, "typecast": true}
+	if err := s.client.do(http.MethodPost, "/"+s.client.base+"/"+url.PathEscape(s.table), body, nil); err != nil {
+		return fmt.Errorf("Unable to create records in table %s: %v", s.table, err)
+	}
+	s.pending = nil
+	return nil
+}
+
+func (s *AirtableSink) Close() error {
+	return s.flush()
+}
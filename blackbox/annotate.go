@@ -0,0 +1,92 @@
+package blackbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// SheetID returns the ID of the tab of a spreadsheet titled title.
+func SheetID(srv *sheets.Service, spreadsheetID, title string) (int64, error) {
+	resp, err := srv.Spreadsheets.Get(spreadsheetID).Fields("sheets.properties(sheetId,title)").Do()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read the tabs of the spreadsheet: %v", err)
+	}
+	for _, sheet := range resp.Sheets {
+		if sheet.Properties.Title == title {
+			return sheet.Properties.SheetId, nil
+		}
+	}
+	return 0, fmt.Errorf("No tab %s in the spreadsheet", title)
+}
+
+// textCell returns the update of a single cell.
+func textCell(sheetID int64, row, column int, cell *sheets.CellData, fields string) *sheets.Request {
+	return &sheets.Request{UpdateCells: &sheets.UpdateCellsRequest{
+		Start:  &sheets.GridCoordinate{SheetId: sheetID, RowIndex: int64(row), ColumnIndex: int64(column)},
+		Rows:   []*sheets.RowData{{Values: []*sheets.CellData{cell}}},
+		Fields: fields,
+	}}
+}
+
+// AnnotateBaseline points out the regressions of the current result in
+// the baseline tab, so they are seen next to the values they regress
+// from: as "notes" on the baseline cells of the regressed outputs, or as a
+// "column" listing the regressions of each baseline row.
+func AnnotateBaseline(srv *sheets.Service, spreadsheetID, baseline, current string, baseHeader []string, regressions []Regression, mode string) error {
+	if mode != "notes" && mode != "column" {
+		return fmt.Errorf("Invalid annotations %q, expected notes or column", mode)
+	}
+	sheetID, err := SheetID(srv, spreadsheetID, baseline)
+	if err != nil {
+		return err
+	}
+	index := columnIndex(baseHeader)
+	requests := []*sheets.Request{}
+	if mode == "notes" {
+		for _, regression := range regressions {
+			if regression.BaseRow == 0 {
+				continue
+			}
+			note := fmt.Sprintf("%s: %s in %s row %d (%+.1f%%)",
+				regression.Output, regression.Current, current, regression.CurrentRow+1, regression.Change)
+			requests = append(requests, textCell(sheetID, regression.BaseRow, index[regression.Output], &sheets.CellData{Note: note}, "note"))
+		}
+	} else {
+		byRow := map[int][]string{}
+		for _, regression := range regressions {
+			if regression.BaseRow > 0 {
+				byRow[regression.BaseRow] = append(byRow[regression.BaseRow],
+					fmt.Sprintf("%s %+.1f%% (row %d)", regression.Output, regression.Change, regression.CurrentRow+1))
+			}
+		}
+		rows := []int{}
+		for row := range byRow {
+			rows = append(rows, row)
+		}
+		sort.Ints(rows)
+		column := len(baseHeader)
+		requests = append(requests, &sheets.Request{AppendDimension: &sheets.AppendDimensionRequest{
+			SheetId:   sheetID,
+			Dimension: "COLUMNS",
+			Length:    1,
+		}})
+		cell := func(text string) *sheets.CellData {
+			return &sheets.CellData{UserEnteredValue: &sheets.ExtendedValue{StringValue: &text}}
+		}
+		requests = append(requests, textCell(sheetID, 0, column, cell("regressions in "+current), "userEnteredValue"))
+		for _, row := range rows {
+			requests = append(requests, textCell(sheetID, row, column, cell(strings.Join(byRow[row], "; ")), "userEnteredValue"))
+		}
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	if _, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do(); err != nil {
+		return fmt.Errorf("Unable to annotate %s: %v", baseline, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package blackbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	drive "google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// artifactsColumn links the artifacts uploaded from the working directory
+// of a run, one per line.
+const artifactsColumn = "artifacts"
+
+// ArtifactUploader uploads the files a run of a program leaves in its
+// working directory, e.g. plots, logs or core dumps, to a Drive folder, so
+// that the evidence behind every result row is a link away.
+type ArtifactUploader struct {
+	// Glob patterns, relative to the working directory of a run
+	Patterns []string
+	// Drive folder ID, created after the run if empty
+	Folder  string
+	runName string
+	srv     *drive.Service
+	mu      sync.Mutex
+}
+
+// NewArtifactUploader prepares the upload of the artifacts of the runs of
+// an experiment run, into a folder named after it unless one is given.
+func NewArtifactUploader(patterns []string, folder, runName string) (*ArtifactUploader, error) {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid artifact pattern %q: %v", pattern, err)
+		}
+	}
+	client, err := authClient()
+	if err != nil {
+		return nil, err
+	}
+	srv, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to use Drive: %v", err)
+	}
+	return &ArtifactUploader{Patterns: patterns, Folder: folder, runName: runName, srv: srv}, nil
+}
+
+// folder returns the folder to upload to, creating it on the first upload
+// if none was given.
+func (u *ArtifactUploader) folder() (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.Folder != "" {
+		return u.Folder, nil
+	}
+	created, err := u.srv.Files.Create(&drive.File{
+		Name:     "blackbox artifacts " + u.runName,
+		MimeType: "application/vnd.google-apps.folder",
+	}).Fields("id").Do()
+	if err != nil {
+		return "", fmt.Errorf("Unable to create artifacts folder: %v", err)
+	}
+	u.Folder = created.Id
+	Log.Infof("Uploading artifacts to https://drive.google.com/drive/folders/%s\n", u.Folder)
+	return u.Folder, nil
+}
+
+// Upload uploads the files matching the patterns in the working directory
+// of a run and returns their links. Their names are prefixed by the name
+// of the directory, to tell apart those of different runs.
+func (u *ArtifactUploader) Upload(dir string) ([]string, error) {
+	seen := map[string]bool{}
+	paths := []string{}
+	for _, pattern := range u.Patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid artifact pattern %q: %v", pattern, err)
+		}
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	sort.Strings(paths)
+	folder, err := u.folder()
+	if err != nil {
+		return nil, err
+	}
+	links := []string{}
+	for _, path := range paths {
+		link, err := u.upload(path, filepath.Base(dir)+"_"+filepath.Base(path), folder)
+		if err != nil {
+			return links, err
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+func (u *ArtifactUploader) upload(path, name, folder string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read artifact: %v", err)
+	}
+	defer file.Close()
+	uploaded, err := u.srv.Files.Create(&drive.File{Name: name, Parents: []string{folder}}).
+		Media(file).Fields("id", "webViewLink").SupportsAllDrives(true).Do()
+	if isPermissionDenied(err) {
+		return "", fmt.Errorf("Unable to upload artifact %s to folder %s: the folder must be editable, "+
+			"and tokens authorized before blackbox used Drive need blackbox auth login: %v", name, folder, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Unable to upload artifact %s: %v", name, err)
+	}
+	return uploaded.WebViewLink, nil
+}
+
+// ArtifactsError is the failure of a run that left artifacts, linking
+// them from the error column of its row.
+type ArtifactsError struct {
+	Err   error
+	Links []string
+}
+
+func (e *ArtifactsError) Error() string {
+	return fmt.Sprintf("%v (artifacts: %s)", e.Err, strings.Join(e.Links, " "))
+}
+
+func (e *ArtifactsError) Unwrap() error {
+	return e.Err
+}
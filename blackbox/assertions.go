@@ -0,0 +1,64 @@
+package blackbox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Assertions are read from column A of this optional tab, one expression
+// per row, in addition to the experiment's configured assertions. Their
+// outcome is recorded in the assertionsColumn of every result row.
+const (
+	assertionsSheet  = "assertions"
+	assertionsColumn = "assertions"
+)
+
+// ReadAssertions returns the expressions of the assertions tab, if any.
+func ReadAssertions(source Source) ([]string, error) {
+	return readExpressionTab(source, assertionsSheet)
+}
+
+// CompileAssertions compiles assertions over the columns of the results.
+func CompileAssertions(assertions []string, columns []string) ([]*Expression, error) {
+	expressions := []*Expression{}
+	for _, assertion := range assertions {
+		expression, err := CompileExpression(assertion, columns)
+		if err != nil {
+			return nil, fmt.Errorf("Assertion: %v", err)
+		}
+		expressions = append(expressions, expression)
+	}
+	return expressions, nil
+}
+
+// CheckAssertions returns "pass" or "fail: " followed by the assertions the
+// result row does not satisfy.
+func CheckAssertions(expressions []*Expression, columns, row []string) string {
+	env := ValueEnv(columns, row)
+	failed := []string{}
+	for _, expression := range expressions {
+		if ok, err := expression.EvalBool(env); err != nil || !ok {
+			failed = append(failed, expression.Text)
+		}
+	}
+	if len(failed) == 0 {
+		return "pass"
+	}
+	return "fail: " + strings.Join(failed, "; ")
+}
+
+// IsAssertionFailure reports whether an assertions column value is a failure.
+func IsAssertionFailure(value string) bool {
+	return strings.HasPrefix(value, "fail")
+}
+
+// AssertionError is returned by an exploration in which some runs failed
+// their assertions.
+type AssertionError struct {
+	Failures int
+	Runs     int
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("%d of %d runs failed assertions", e.Failures, e.Runs)
+}
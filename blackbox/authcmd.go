@@ -0,0 +1,131 @@
+package blackbox
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const tokenRevokeURL = "https://oauth2.googleapis.com/revoke"
+
+// AuthCommand implements "blackbox auth": it manages the cached
+// credentials apart from running experiments. "login" authorizes an
+// account, "status" shows the account, scopes and expiry of the cached
+// token and "revoke" invalidates it and deletes the cache.
+func AuthCommand(args []string) error {
+	flags := flag.NewFlagSet("auth", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox auth login|status|revoke\n")
+		fmt.Fprintf(os.Stderr, "The client secret is read from CLIENT_SECRET_FILE (%s) and the credentials cached in CACHED_CREDS_FILE (%s)\n", clientSecretFile, cachedCredsFile)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("auth action is missing")
+	}
+	switch flags.Arg(0) {
+	case "login":
+		return authLogin()
+	case "status":
+		return authStatus()
+	case "revoke":
+		return authRevoke()
+	}
+	flags.Usage()
+	return fmt.Errorf("Unknown auth action %q", flags.Arg(0))
+}
+
+// authLogin authorizes an account, replacing the cached one.
+func authLogin() error {
+	config, err := oauthConfig()
+	if err != nil {
+		return err
+	}
+	tok, err := authorize(config)
+	if err != nil {
+		return err
+	}
+	if info, err := GetTokenInfo(tok.AccessToken); err == nil && info.Email != "" {
+		fmt.Printf("Authorized as %s\n", info.Email)
+	}
+	return nil
+}
+
+// authStatus shows the cached credentials, refreshing the access token to
+// check that they still work.
+func authStatus() error {
+	tok, err := tokenFromFile(cachedCredsFile)
+	if err != nil {
+		return fmt.Errorf("Not authorized: no credentials in %s, run blackbox auth login", cachedCredsFile)
+	}
+	config, err := oauthConfig()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Credentials: %s\n", cachedCredsFile)
+	refreshed, err := config.TokenSource(context.Background(), tok).Token()
+	if err != nil {
+		if isRevokedToken(err) {
+			return fmt.Errorf("The cached credentials are expired or revoked, run blackbox auth login")
+		}
+		return fmt.Errorf("Unable to refresh the oauth token: %v", err)
+	}
+	if refreshed.AccessToken != tok.AccessToken {
+		if err := saveToken(cachedCredsFile, refreshed); err != nil {
+			Log.Warnf("%v", err)
+		}
+	}
+	info, err := GetTokenInfo(refreshed.AccessToken)
+	if err != nil {
+		return err
+	}
+	account := info.Email
+	if account == "" {
+		account = "unknown, authorized without the email scope"
+	}
+	fmt.Printf("Account: %s\n", account)
+	fmt.Printf("Scopes: %s\n", strings.Join(strings.Fields(info.Scope), ", "))
+	if !refreshed.Expiry.IsZero() {
+		fmt.Printf("Access token expires: %s (in %v)\n", refreshed.Expiry.Format(time.RFC1123), time.Until(refreshed.Expiry).Round(time.Second))
+	}
+	if refreshed.RefreshToken != "" {
+		fmt.Printf("Refresh token: cached, renewing the access token until revoked\n")
+	} else {
+		fmt.Printf("Refresh token: none, run blackbox auth login once the access token expires\n")
+	}
+	return nil
+}
+
+// authRevoke invalidates the cached token with Google and deletes the
+// cache. The cache is deleted even if Google could not be told.
+func authRevoke() error {
+	tok, err := tokenFromFile(cachedCredsFile)
+	if err != nil {
+		return fmt.Errorf("Not authorized: no credentials in %s", cachedCredsFile)
+	}
+	// Revoking the refresh token revokes its access tokens too
+	token := tok.RefreshToken
+	if token == "" {
+		token = tok.AccessToken
+	}
+	resp, err := http.PostForm(tokenRevokeURL, url.Values{"token": {token}})
+	if err != nil {
+		Log.Warnf("Unable to revoke the oauth token: %v", err)
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			Log.Warnf("Unable to revoke the oauth token: %s", resp.Status)
+		}
+	}
+	if err := os.Remove(cachedCredsFile); err != nil {
+		return fmt.Errorf("Unable to delete %s: %v", cachedCredsFile, err)
+	}
+	fmt.Printf("Revoked and deleted the credentials in %s\n", cachedCredsFile)
+	return nil
+}
@@ -0,0 +1,104 @@
+package blackbox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Sheets requests rejected for exceeding the quota, or failing on the
+// server side, are retried up to sheetsMaxRetries times, waiting twice as
+// long each time from sheetsInitialBackoff up to sheetsMaxBackoff.
+const (
+	sheetsMaxRetries     = 8
+	sheetsInitialBackoff = time.Second
+	sheetsMaxBackoff     = 64 * time.Second
+)
+
+// sheetsRetries counts the retried Sheets requests of the process.
+var sheetsRetries int64
+
+// backoffTransport retries Sheets requests rejected with 429 or a rate
+// limit 403, and idempotent requests failing with a 5xx status, with
+// exponential backoff and jitter, or after the delay given by the
+// Retry-After header. Other requests failing on the server side may have
+// been applied, e.g. rows appended, so retrying them could write twice.
+type backoffTransport struct {
+	next http.RoundTripper
+}
+
+// shouldBackOff reports whether the response to req is worth retrying
+// later.
+func shouldBackOff(req *http.Request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return isIdempotent(req)
+	case http.StatusForbidden:
+		// Older quotas answer 403 with a rate limit reason
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return err == nil && bytes.Contains(bytes.ToLower(body), []byte("ratelimitexceeded"))
+	}
+	return false
+}
+
+// retryAfter returns the delay asked by a Retry-After header, in seconds
+// or as a date, or 0.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func (t *backoffTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := sheetsInitialBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || attempt > sheetsMaxRetries || !shouldBackOff(req, resp) || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		wait := retryAfter(resp)
+		if wait <= 0 {
+			// Jitter spreads out the retries of parallel writers
+			wait = time.Duration(rand.Int63n(int64(backoff))) + backoff/2
+		}
+		if backoff *= 2; backoff > sheetsMaxBackoff {
+			backoff = sheetsMaxBackoff
+		}
+		total := atomic.AddInt64(&sheetsRetries, 1)
+		Log.Warnf("Sheets API answered %s, retry %d of %d in %v (%d retries so far)\n",
+			resp.Status, attempt, sheetsMaxRetries, wait.Round(time.Millisecond), total)
+		resp.Body.Close()
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		retried := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retried.Body = body
+		}
+		req = retried
+	}
+}
@@ -0,0 +1,38 @@
+package blackbox
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestShouldBackOff(t *testing.T) {
+	tests := []struct {
+		method string
+		status int
+		body   string
+		want   bool
+	}{
+		{method: http.MethodPost, status: http.StatusTooManyRequests, want: true},
+		{method: http.MethodGet, status: http.StatusTooManyRequests, want: true},
+		{method: http.MethodPost, status: http.StatusForbidden, body: `{"error": {"errors": [{"reason": "rateLimitExceeded"}]}}`, want: true},
+		{method: http.MethodPost, status: http.StatusForbidden, body: `{"error": {"errors": [{"reason": "forbidden"}]}}`, want: false},
+		{method: http.MethodGet, status: http.StatusInternalServerError, want: true},
+		{method: http.MethodPut, status: http.StatusServiceUnavailable, want: true},
+		{method: http.MethodPost, status: http.StatusServiceUnavailable, want: false},
+		{method: http.MethodPost, status: http.StatusBadGateway, want: false},
+		{method: http.MethodGet, status: http.StatusNotFound, want: false},
+		{method: http.MethodPost, status: http.StatusOK, want: false},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, "https://sheets.googleapis.com/v4/spreadsheets/x", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := &http.Response{StatusCode: test.status, Body: ioutil.NopCloser(strings.NewReader(test.body))}
+		if got := shouldBackOff(req, resp); got != test.want {
+			t.Errorf("shouldBackOff(%s, %d %s) = %v, want %v", test.method, test.status, test.body, got, test.want)
+		}
+	}
+}
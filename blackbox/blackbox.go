@@ -0,0 +1,935 @@
+// Package blackbox explores programs over the input sets defined in a
+// spreadsheet, running them through a Target and recording their outputs
+// to Sinks. The blackbox command is a thin CLI over RunExperiment and
+// RunCampaign; programs can run experiments themselves, e.g. over Go
+// functions registered with RegisterFunc.
+package blackbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// GetVariableOrDefault returns an environment variable, or a default if it
+// is unset.
+func GetVariableOrDefault(varName, defaultValue string) string {
+	varValue := os.Getenv(varName)
+	if len(varValue) > 0 {
+		return varValue
+	}
+	return defaultValue
+}
+
+var clientSecretFile = GetVariableOrDefault("CLIENT_SECRET_FILE", "client_secret.json")
+var cachedCredsFile = GetVariableOrDefault("CACHED_CREDS_FILE", "blackbox.creds.json")
+
+const spreadsheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+// oauthConfig reads the OAuth client of blackbox from the client secret
+// file.
+func oauthConfig() (*oauth2.Config, error) {
+	b, err := ioutil.ReadFile(clientSecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read client secret file: %v", err)
+	}
+
+	config, err := google.ConfigFromJSON(b, spreadsheetsScope, emailScope, driveFileScope)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse client secret file to config: %v", err)
+	}
+	return config, nil
+}
+
+// Auth returns a Sheets client authorized with the cached credentials,
+// asking for them the first time.
+func Auth() (*sheets.Service, error) {
+	client, err := authClient()
+	if err != nil {
+		return nil, err
+	}
+	return sheets.New(client)
+}
+
+// authClient returns an HTTP client authorized for the Google APIs
+// blackbox uses.
+func authClient() (*http.Client, error) {
+	ctx := context.Background()
+	if usesDefaultCredentials() {
+		return defaultClient(ctx)
+	}
+	config, err := oauthConfig()
+	if err != nil {
+		return nil, err
+	}
+	return getClient(ctx, config)
+}
+
+func getClient(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
+	tok, err := tokenFromFile(cachedCredsFile)
+	if err != nil {
+		if tok, err = authorize(config); err != nil {
+			return nil, err
+		}
+	}
+	source := newRefreshingTokenSource(ctx, config, tok)
+	// An expired or revoked token fails here rather than mid-run
+	if _, err := source.Token(); err != nil {
+		return nil, err
+	}
+	return newReauthClient(source), nil
+}
+
+// authorize runs the authorization flow and caches the token.
+func authorize(config *oauth2.Config) (*oauth2.Token, error) {
+	tok, err := getTokenFromWeb(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveToken(cachedCredsFile, tok); err != nil {
+		Log.Warnf("%v", err)
+	}
+	return tok, nil
+}
+
+// tokenFromFile retrieves a Token from a given file path.
+// It returns the retrieved Token and any read error encountered.
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	t := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(t)
+	defer f.Close()
+	return t, err
+}
+
+// saveToken uses a file path to create a file and store the
+// token in it. The file is replaced at once, so that a run interrupted
+// while saving a refreshed token does not leave a truncated cache.
+func saveToken(file string, token *oauth2.Token) error {
+	Log.Infof("Saving credential file to: %s\n", file)
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("Unable to cache oauth token: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if err := json.NewEncoder(f).Encode(token); err != nil {
+		f.Close()
+		return fmt.Errorf("Unable to cache oauth token: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to cache oauth token: %v", err)
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		return fmt.Errorf("Unable to cache oauth token: %v", err)
+	}
+	return nil
+}
+
+func ReadSetupRows(service *sheets.Service, spreadsheetID, setupSheetName string) ([][]string, error) {
+	return readRows(service, spreadsheetID, setupSheetName+"!A1:Z")
+}
+
+// ReadSheetRows reads every row of a tab, however wide.
+func ReadSheetRows(service *sheets.Service, spreadsheetID, sheetName string) ([][]string, error) {
+	return readRows(service, spreadsheetID, sheetName)
+}
+
+func readRows(service *sheets.Service, spreadsheetID, readRange string) ([][]string, error) {
+	rows := [][]string{}
+
+	// Numbers and formula results come as displayed, e.g. 1,000 or 50%
+	resp, err := service.Spreadsheets.Values.Get(spreadsheetID, readRange).ValueRenderOption("FORMATTED_VALUE").Do()
+	if err != nil {
+		return rows, fmt.Errorf("Unable to retrieve data from sheet. %v", err)
+	}
+
+	if len(resp.Values) > 0 {
+		for i, row := range resp.Values {
+			stringRow := []string{}
+			for j, item := range row {
+				value, err := cellString(item)
+				if err != nil {
+					return nil, fmt.Errorf("Unable to read cell %s: %v", rangeCell(resp.Range, i, j), err)
+				}
+				stringRow = append(stringRow, value)
+			}
+			rows = append(rows, stringRow)
+		}
+	} else {
+		return rows, fmt.Errorf("No data found.")
+	}
+
+	return rows, nil
+}
+
+// cellString converts a cell value read from the Sheets API to a string.
+func cellString(item interface{}) (string, error) {
+	switch value := item.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+	return "", fmt.Errorf("unexpected %T value %v", item, item)
+}
+
+// rangeCell returns the A1 notation of the cell at row and column of a
+// range, relative to its top left cell, e.g. inputs!B3 for 2, 1 of
+// inputs!A1:Z.
+func rangeCell(a1 string, row, column int) string {
+	sheet, cells := "", a1
+	if i := strings.LastIndex(a1, "!"); i >= 0 {
+		sheet, cells = a1[:i+1], a1[i+1:]
+	}
+	if i := strings.Index(cells, ":"); i >= 0 {
+		cells = cells[:i]
+	}
+	letters := strings.TrimRight(cells, "0123456789")
+	var startColumn int64
+	for _, letter := range letters {
+		startColumn = startColumn*26 + int64(letter-'A'+1)
+	}
+	startRow, err := strconv.Atoi(cells[len(letters):])
+	if err != nil || startColumn == 0 {
+		return fmt.Sprintf("%s row %d column %d", a1, row+1, column+1)
+	}
+	return fmt.Sprintf("%s%s%d", sheet, columnLetters(startColumn-1+int64(column)), startRow+row)
+}
+
+func ExtractExamples(examplesCell string) []string {
+	examples := []string{}
+	for _, example := range strings.Split(examplesCell, ",") {
+		trimmed := strings.Trim(example, " \t")
+		if trimmed != "" {
+			examples = append(examples, trimmed)
+		}
+	}
+	return examples
+}
+
+func GetInputSets(exampleSets [][]string) [][]string {
+	result := [][]string{}
+	if len(exampleSets) == 0 {
+		return result
+	}
+	if len(exampleSets) == 1 {
+		for _, item := range exampleSets[0] {
+			result = append(result, []string{item})
+		}
+	}
+	head := exampleSets[0]
+	tail := exampleSets[1:]
+	for _, item := range head {
+		for _, subitem := range GetInputSets(tail) {
+			result = append(result, append([]string{item}, subitem...))
+		}
+	}
+	return result
+}
+
+func GetVarsExamplesSets(setupRows [][]string) ([]string, [][]string, error) {
+	vars := []string{}
+	examplesSets := [][]string{}
+
+	for i, setupRow := range setupRows {
+		varCell := setupRow[0]
+		examplesCell := setupRow[1]
+		varName, varType := ParseVarCell(varCell)
+		if varName == "" {
+			return vars, examplesSets, fmt.Errorf("Could not extract var name from row %d", i)
+		}
+		examples, err := ExtractTypedExamples(varType, examplesCell)
+		if err != nil {
+			return vars, examplesSets, fmt.Errorf("Invalid examples of %s in row %d: %v", varName, i, err)
+		}
+		if len(examples) == 0 {
+			return vars, examplesSets, fmt.Errorf("Could not extract examples from row %d", i)
+		}
+		vars = append(vars, varName)
+		examplesSets = append(examplesSets, examples)
+	}
+
+	return vars, examplesSets, nil
+}
+
+func RecordSortedKeys(output map[string]string) []string {
+	keys := make([]string, 0)
+	for key, _ := range output {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// InputMap returns the inputs of an input set sent to the program, without
+// the meta-variables.
+func InputMap(varNames, inputSet []string) map[string]string {
+	inputMap := make(map[string]string)
+	for i, inputItem := range inputSet {
+		if !IsMetaVar(varNames[i]) {
+			inputMap[varNames[i]] = inputItem
+		}
+	}
+	return inputMap
+}
+
+// ProgramInput returns the JSON object of an input set sent to the program.
+func ProgramInput(types map[string]string, varNames, inputSet []string) ([]byte, error) {
+	inputMap, err := TypedInputMap(types, varNames, inputSet)
+	if err != nil {
+		return nil, err
+	}
+	// Marshal into JSON
+	return json.Marshal(inputMap)
+}
+
+func RunBlackBoxCmd(progPath string, config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	jsonBytes, err := ProgramInput(config.Types, varNames, inputSet)
+	if err != nil {
+		return nil, err
+	}
+	output, usage, err := RunProgramUsage(progPath, config, jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	// Unmarshal output
+	outputMap := make(map[string]string)
+	err = json.Unmarshal(output, &outputMap)
+	addUsage(outputMap, usage)
+	return outputMap, err
+}
+
+// RunProgram runs the program with input on its stdin and returns its
+// stdout.
+func RunProgram(progPath string, config RunnerConfig, jsonBytes []byte) ([]byte, error) {
+	output, _, err := RunProgramUsage(progPath, config, jsonBytes)
+	return output, err
+}
+
+// RunProgramUsage runs the program as RunProgram, also returning the
+// resources it used when config.MeasureRusage is set, see ProcessUsage,
+// and its working directory when config.WorkdirPerRun is set.
+func RunProgramUsage(progPath string, config RunnerConfig, jsonBytes []byte) ([]byte, map[string]string, error) {
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	var workdir string
+	if config.WorkdirPerRun {
+		var err error
+		if workdir, err = runWorkdir(); err != nil {
+			return nil, nil, err
+		}
+		progPath = commandPath(progPath)
+	}
+	cmd := exec.CommandContext(ctx, progPath)
+	cmd.Dir = workdir
+	if len(config.Env) > 0 {
+		cmd.Env = append(os.Environ(), config.Env...)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		if workdir != "" {
+			releaseWorkdir(workdir, false, err)
+		}
+		return nil, nil, err
+	}
+	go func() {
+		stdin.Write(jsonBytes)
+		defer stdin.Close()
+	}()
+	// Read output
+
+	var output []byte
+	if config.Limits.Set() {
+		output, err = runLimited(cmd, config.Limits)
+	} else {
+		output, err = cmd.Output()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%s timed out after %v", progPath, config.Timeout)
+	}
+	usage := map[string]string{}
+	if workdir != "" {
+		if config.Artifacts != nil {
+			links, uploadErr := config.Artifacts.Upload(workdir)
+			if uploadErr != nil {
+				Log.Warnf("%v\n", uploadErr)
+			}
+			if len(links) > 0 {
+				usage[artifactsColumn] = strings.Join(links, "\n")
+				if err != nil {
+					err = &ArtifactsError{Err: err, Links: links}
+				}
+			}
+		}
+		releaseWorkdir(workdir, config.KeepFailed, err)
+		usage[workdirColumn] = workdir
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, nil, err
+	}
+	if config.MeasureRusage && cmd.ProcessState != nil {
+		for name, value := range ProcessUsage(cmd.ProcessState) {
+			usage[name] = value
+		}
+	}
+	return output, usage, err
+}
+
+// RecordResults passes the rows of resultChannel, header first, on to every
+// sink, then closes them all. A sink failing to write stops the recording
+// once the other sinks got the row, and the errors of all sinks are
+// returned together.
+func RecordResults(sinks []Sink, resultChannel chan []string) error {
+	headerWritten := false
+	errs := []error{}
+	// While info is coming from the channel, pass rows on to every sink
+	for resultLine := range resultChannel {
+		for _, sink := range sinks {
+			var err error
+			if headerWritten {
+				err = sink.WriteRow(resultLine)
+			} else {
+				err = sink.WriteHeader(resultLine)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		headerWritten = true
+		if len(errs) > 0 {
+			break
+		}
+	}
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+// joinErrors returns nil for no errors, the error itself for one, or an
+// error listing the messages of several.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// ExplorationOptions tune how RunExploration handles failures and reports
+// progress.
+type ExplorationOptions struct {
+	// KeepGoing records failed runs in an "error" column instead of
+	// stopping the exploration at the first failure.
+	KeepGoing  bool
+	AbortRules []AbortRule
+	// Assertions over the result columns, checked for every run
+	Assertions []string
+	Progress   func(completed, total int)
+
+	// Adaptive explorations choose their input sets as results come in:
+	// Observe sees every completed run, and NextBatch returns the input
+	// sets to run once the previous batch completed, none when done.
+	Observe   func(inputSet []string, outputMap map[string]string, runErr error)
+	NextBatch func() [][]string
+	// Expected number of runs, for progress reports of adaptive explorations
+	Total int
+
+	// Affinity names a variable whose input sets sharing a value all run
+	// on the same worker, to make the most of its caches
+	Affinity string
+
+	// BatchSize, above 1, sends up to that many input sets sharing a
+	// config to the program at once, see BatchTarget
+	BatchSize int
+
+	// Limiter bounds the rate of runs of all the workers, every input set
+	// of a batch counting as a run
+	Limiter *RateLimiter
+
+	// Context of the exploration, whose trace records the runs if set
+	Context context.Context
+	// Status of the run served by the status endpoint, if any
+	Status *RunStatus
+
+	// MaxRuns and MaxDuration, if set, stop the exploration with a
+	// BudgetError once that many runs completed or that much time passed;
+	// runs in progress complete and are recorded
+	MaxRuns     int
+	MaxDuration time.Duration
+
+	// StateDir, if set, holds a directory per parallel worker, kept for the
+	// whole exploration, whose path is passed to the program in
+	// $BLACKBOX_STATE_DIR, e.g. for caches of compiled models.
+	StateDir string
+
+	// Cancel, once closed, stops the exploration with errCanceled: runs in
+	// progress complete and are recorded, but no new ones start
+	Cancel <-chan struct{}
+}
+
+// errCanceled is returned by RunExploration when its Cancel channel closed.
+var errCanceled = errors.New("The exploration was canceled")
+
+// AffinityWorker returns the worker, out of workers, running the input
+// sets with a value of the affinity variable.
+func AffinityWorker(value string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// workerEnv returns the environment telling the program which worker runs
+// it and, if configured, where its state directory is.
+func workerEnv(worker int, stateDir string) ([]string, error) {
+	env := []string{fmt.Sprintf("BLACKBOX_WORKER=%d", worker)}
+	if stateDir == "" {
+		return env, nil
+	}
+	dir := filepath.Join(stateDir, fmt.Sprintf("worker-%d", worker))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create worker state directory: %v", err)
+	}
+	return append(env, "BLACKBOX_STATE_DIR="+dir), nil
+}
+
+// sameConfig reports whether input sets of two configs can share a batch.
+func sameConfig(a, b RunnerConfig) bool {
+	return a.Timeout == b.Timeout && strings.Join(a.Env, "\x00") == strings.Join(b.Env, "\x00")
+}
+
+// Batches splits the indexes of input sets into batches of consecutive
+// input sets sharing a config, of at most batchSize input sets but small
+// enough to keep all workers busy.
+func Batches(indexes []int, configs []RunnerConfig, batchSize, workers int) [][]int {
+	size := batchSize
+	if workers > 0 && (len(indexes)+workers-1)/workers < size {
+		size = (len(indexes) + workers - 1) / workers
+	}
+	batches := [][]int{}
+	for _, i := range indexes {
+		last := len(batches) - 1
+		if last < 0 || len(batches[last]) >= size || !sameConfig(configs[batches[last][0]], configs[i]) {
+			batches = append(batches, []int{i})
+			continue
+		}
+		batches[last] = append(batches[last], i)
+	}
+	return batches
+}
+
+// errorColumn holds the failure message of a run when KeepGoing is set.
+const errorColumn = "error"
+
+// runBatch runs the program over input sets, passing every outcome to
+// record, until done, stopped or, unless options.KeepGoing, a run failed.
+func runBatch(target Target, baseConfig RunnerConfig, varNames []string, inputSets [][]string, options ExplorationOptions,
+	record func(inputSet []string, outputMap map[string]string, runErr error), stop chan struct{}) error {
+	// Resolve meta-variables up front so invalid values fail before any run
+	configs := make([]RunnerConfig, len(inputSets))
+	for i, inputSet := range inputSets {
+		config, err := RunnerConfigFor(baseConfig, varNames, inputSet)
+		if err != nil {
+			return err
+		}
+		configs[i] = config
+	}
+
+	// Input sets sharing a concurrency level run together in one worker pool
+	groups := map[int][]int{}
+	concurrencies := []int{}
+	for i, config := range configs {
+		if _, ok := groups[config.Concurrency]; !ok {
+			concurrencies = append(concurrencies, config.Concurrency)
+		}
+		groups[config.Concurrency] = append(groups[config.Concurrency], i)
+	}
+
+	affinityColumn := -1
+	for i, varName := range varNames {
+		if options.Affinity != "" && varName == options.Affinity {
+			affinityColumn = i
+		}
+	}
+
+	batchSize := options.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	batchTarget, _ := target.(BatchTarget)
+
+	for _, concurrency := range concurrencies {
+		// Workers take the next batch of input sets from indexes, or, with
+		// an affinity, only those routed to them in their queue
+		indexes := make(chan []int)
+		queues := make([]chan []int, concurrency)
+		if affinityColumn >= 0 {
+			routed := make([][]int, concurrency)
+			for _, i := range groups[concurrency] {
+				w := AffinityWorker(inputSets[i][affinityColumn], concurrency)
+				routed[w] = append(routed[w], i)
+			}
+			for w := range queues {
+				batches := Batches(routed[w], configs, batchSize, 1)
+				queues[w] = make(chan []int, len(batches))
+				for _, batch := range batches {
+					queues[w] <- batch
+				}
+				close(queues[w])
+			}
+		} else {
+			for w := range queues {
+				queues[w] = indexes
+			}
+		}
+		errs := make(chan error, concurrency)
+		// failed stops the other workers after a failure
+		failed := make(chan struct{})
+		var failOnce sync.Once
+		var wg sync.WaitGroup
+		for w := 0; w < concurrency; w++ {
+			env, err := workerEnv(w, options.StateDir)
+			if err != nil {
+				failOnce.Do(func() { close(failed) })
+				close(indexes)
+				wg.Wait()
+				return err
+			}
+			wg.Add(1)
+			go func(queue chan []int) {
+				defer wg.Done()
+				for batch := range queue {
+					select {
+					case <-stop:
+						return
+					case <-failed:
+						return
+					default:
+					}
+					options.Limiter.Wait(len(batch))
+					config := configs[batch[0]]
+					config.Env = append(append([]string{}, config.Env...), env...)
+					outputMaps := make([]map[string]string, len(batch))
+					spanContext := options.Context
+					if spanContext == nil {
+						spanContext = context.Background()
+					}
+					for _, i := range batch {
+						options.Status.Started(varNames, inputSets[i])
+					}
+					_, span := tracer.Start(spanContext, "program.run", trace.WithAttributes(
+						append(InputAttributes(varNames, inputSets[batch[0]]), attribute.Int("blackbox.batch_size", len(batch)))...))
+					var err error
+					if len(batch) == 1 {
+						outputMaps[0], err = target.Run(config, varNames, inputSets[batch[0]])
+					} else {
+						batchSets := make([][]string, len(batch))
+						for j, i := range batch {
+							batchSets[j] = inputSets[i]
+						}
+						var batchOutputs []map[string]string
+						if batchOutputs, err = batchTarget.RunBatch(config, varNames, batchSets); err == nil {
+							outputMaps = batchOutputs
+						}
+					}
+					endSpan(span, err)
+					if err != nil && !options.KeepGoing {
+						errs <- err
+						failOnce.Do(func() { close(failed) })
+						return
+					}
+					for j, i := range batch {
+						record(inputSets[i], outputMaps[j], err)
+					}
+				}
+			}(queues[w])
+		}
+
+		if affinityColumn >= 0 {
+			// Everything is queued already
+			wg.Wait()
+			if len(errs) > 0 {
+				return <-errs
+			}
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			continue
+		}
+
+		var err error
+	feed:
+		for _, batch := range Batches(groups[concurrency], configs, batchSize, concurrency) {
+			select {
+			case indexes <- batch:
+			case err = <-errs:
+				break feed
+			case <-stop:
+				break feed
+			}
+		}
+		close(indexes)
+		wg.Wait()
+		if err == nil && len(errs) > 0 {
+			err = <-errs
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+	return nil
+}
+
+func RunExploration(target Target, baseConfig RunnerConfig, varNames []string, inputSets [][]string, resultChan chan []string, options ExplorationOptions) error {
+	if options.Affinity != "" {
+		known := false
+		for _, varName := range varNames {
+			known = known || varName == options.Affinity
+		}
+		if !known {
+			return fmt.Errorf("Unknown affinity variable %s", options.Affinity)
+		}
+	}
+	if _, ok := target.(BatchTarget); options.BatchSize > 1 && !ok {
+		return fmt.Errorf("The target does not run batches of input sets")
+	}
+	total := len(inputSets)
+	if options.Total > 0 {
+		total = options.Total
+	}
+	options.Status.SetTotal(total)
+	outputVars := []string{}
+	headerSent := false
+	// Failed runs wait here until a successful run tells the output columns
+	type failure struct {
+		inputSet []string
+		err      error
+	}
+	pendingFailures := []failure{}
+	stats := NewRunStats()
+	completed := 0
+	var assertions []*Expression
+	assertionFailures := 0
+	// stopErr ends the exploration early, e.g. when an abort rule triggers
+	var stopErr error
+	stop := make(chan struct{})
+	var mu sync.Mutex
+	start := time.Now()
+	if options.MaxDuration > 0 {
+		deadline := time.AfterFunc(options.MaxDuration, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if stopErr == nil {
+				stopErr = &BudgetError{Runs: completed, Reason: fmt.Sprintf("max duration %v", options.MaxDuration)}
+				close(stop)
+			}
+		})
+		defer deadline.Stop()
+	}
+	if options.Cancel != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-options.Cancel:
+				mu.Lock()
+				defer mu.Unlock()
+				if stopErr == nil {
+					stopErr = errCanceled
+					close(stop)
+				}
+			case <-done:
+			}
+		}()
+	}
+
+	sendLine := func(inputSet []string, outputMap map[string]string, runErr error) {
+		resultLine := append([]string{}, inputSet...)
+		for _, outputVar := range outputVars {
+			resultLine = append(resultLine, outputMap[outputVar])
+		}
+		if options.KeepGoing {
+			errorMessage := ""
+			if runErr != nil {
+				errorMessage = runErr.Error()
+			}
+			resultLine = append(resultLine, errorMessage)
+		}
+		if len(options.Assertions) > 0 {
+			outcome := ""
+			if runErr == nil && assertions != nil {
+				columns := append(append([]string{}, varNames...), outputVars...)
+				outcome = CheckAssertions(assertions, columns, resultLine)
+				if IsAssertionFailure(outcome) {
+					assertionFailures++
+				}
+			}
+			resultLine = append(resultLine, outcome)
+		}
+		resultChan <- resultLine
+	}
+	sendHeader := func() {
+		header := append(append([]string{}, varNames...), outputVars...)
+		if options.KeepGoing {
+			header = append(header, errorColumn)
+		}
+		if len(options.Assertions) > 0 {
+			header = append(header, assertionsColumn)
+		}
+		resultChan <- header
+		headerSent = true
+		for _, pending := range pendingFailures {
+			sendLine(pending.inputSet, nil, pending.err)
+		}
+	}
+	record := func(inputSet []string, outputMap map[string]string, runErr error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if runErr == nil && !headerSent {
+			outputVars = RecordSortedKeys(outputMap)
+			if len(options.Assertions) > 0 {
+				var err error
+				assertions, err = CompileAssertions(options.Assertions, append(append([]string{}, varNames...), outputVars...))
+				if err != nil && stopErr == nil {
+					stopErr = err
+					close(stop)
+				}
+			}
+			sendHeader()
+		}
+		if headerSent {
+			sendLine(inputSet, outputMap, runErr)
+		} else {
+			pendingFailures = append(pendingFailures, failure{inputSet, runErr})
+		}
+		completed++
+		options.Status.Finished(varNames, inputSet, runErr)
+		stats.Add(outputMap, runErr != nil)
+		if options.Observe != nil {
+			options.Observe(inputSet, outputMap, runErr)
+		}
+		if options.Progress != nil {
+			options.Progress(completed, total)
+		}
+		if stopErr != nil {
+			return
+		}
+		for _, rule := range options.AbortRules {
+			if reason, triggered := rule.Check(stats); triggered {
+				stopErr = &AbortError{Reason: reason}
+				close(stop)
+				return
+			}
+		}
+		if reason, exhausted := budgetReason(options, completed, time.Since(start)); exhausted {
+			stopErr = &BudgetError{Runs: completed, Reason: reason}
+			close(stop)
+		}
+	}
+	finish := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !headerSent && len(pendingFailures) > 0 {
+			sendHeader()
+		}
+	}
+
+	for batch := inputSets; len(batch) > 0; batch = options.NextBatch() {
+		if err := runBatch(target, baseConfig, varNames, batch, options, record, stop); err != nil {
+			return err
+		}
+		mu.Lock()
+		err := stopErr
+		mu.Unlock()
+		if err != nil {
+			finish()
+			return err
+		}
+		if options.NextBatch == nil {
+			break
+		}
+	}
+	finish()
+	if assertionFailures > 0 {
+		return &AssertionError{Failures: assertionFailures, Runs: completed}
+	}
+	return nil
+}
+
+// CreateNewResultSheet adds a tab to the spreadsheet and returns its sheet ID.
+func CreateNewResultSheet(srv *sheets.Service, spreadsheetID, sheetName string) (int64, error) {
+
+	addRequest := sheets.Request{}
+
+	requestsString := fmt.Sprintf(`{
+      "addSheet": {
+        "properties": {
+          "title": "%s",
+          "tabColor": {
+            "red": 1.0,
+            "green": 0.3,
+            "blue": 0.4
+          }
+        }
+      }
+	}`, sheetName)
+	err := json.Unmarshal([]byte(requestsString), &addRequest)
+	if err != nil {
+		return 0, err
+	}
+
+	rb := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{&addRequest},
+	}
+
+	resp, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do()
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Replies[0].AddSheet.Properties.SheetId, nil
+}
@@ -0,0 +1,184 @@
+package blackbox
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BrowseCommand implements "blackbox browse": it serves a read-only web
+// view of the local CSV results under a directory, by default the run
+// output directories, to filter and sort them without a spreadsheet.
+func BrowseCommand(args []string) error {
+	flags := flag.NewFlagSet("browse", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "address to serve the results browser on")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox browse [-addr HOST:PORT] [DIR]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	root := runsDir
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	if _, err := os.Stat(root); err != nil {
+		return fmt.Errorf("Unable to browse results: %v", err)
+	}
+	browser := &resultsBrowser{root: root}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", browser.list)
+	mux.HandleFunc("/view", browser.view)
+	Log.Infof("Browsing the results in %s on http://%s/\n", root, *addr)
+	return http.ListenAndServe(*addr, mux)
+}
+
+type resultsBrowser struct {
+	root string
+}
+
+var browseTemplate = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>blackbox {{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; }
+th { background: #eee; }
+th a { color: inherit; }
+</style>
+</head>
+<body>
+{{if .Files}}
+<h1>Results in {{.Title}}</h1>
+<ul>
+{{range .Files}}<li><a href="/view?file={{.}}">{{.}}</a></li>
+{{end}}</ul>
+{{else}}
+<h1><a href="/">results</a> / {{.Title}}</h1>
+<form action="/view">
+<input type="hidden" name="file" value="{{.Title}}">
+<input type="hidden" name="sort" value="{{.Sort}}">
+<input type="hidden" name="desc" value="{{.Desc}}">
+<input type="text" name="q" value="{{.Query}}" placeholder="filter rows">
+<input type="submit" value="Filter">
+{{len .Rows}} rows
+</form>
+<table>
+<tr>{{range $i, $column := .Header}}<th><a href="{{index $.SortLinks $i}}">{{$column}}</a></th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// browsePage is what the browse template renders: either the list of
+// result files, or the rows of one of them.
+type browsePage struct {
+	Title     string
+	Files     []string
+	Header    []string
+	Rows      [][]string
+	SortLinks []string
+	Query     string
+	Sort      int
+	Desc      bool
+}
+
+// list lists the result files, most recent runs first.
+func (b *resultsBrowser) list(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	files := []string{}
+	filepath.Walk(b.root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ".csv") {
+			if rel, err := filepath.Rel(b.root, path); err == nil {
+				files = append(files, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	if len(files) == 0 {
+		fmt.Fprintf(w, "No CSV results in %s\n", b.root)
+		return
+	}
+	b.render(w, browsePage{Title: b.root, Files: files})
+}
+
+// view shows the rows of a result file containing the query, sorted by a
+// column, numerically if its values are numbers.
+func (b *resultsBrowser) view(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("file")
+	path := filepath.Join(b.root, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(b.root, path); err != nil || strings.HasPrefix(rel, "..") || !strings.HasSuffix(path, ".csv") {
+		http.Error(w, "Not a result file", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil || len(rows) == 0 {
+		http.Error(w, fmt.Sprintf("Unable to read %s: %v", name, err), http.StatusInternalServerError)
+		return
+	}
+
+	page := browsePage{Title: name, Header: rows[0], Query: r.FormValue("q"), Sort: -1}
+	for _, row := range rows[1:] {
+		if page.Query == "" || strings.Contains(strings.Join(row, "\t"), page.Query) {
+			page.Rows = append(page.Rows, row)
+		}
+	}
+	if column, err := strconv.Atoi(r.FormValue("sort")); err == nil && column >= 0 {
+		page.Sort, page.Desc = column, r.FormValue("desc") == "true"
+		sort.SliceStable(page.Rows, func(i, j int) bool {
+			if page.Desc {
+				i, j = j, i
+			}
+			return lessCell(cell(page.Rows[i], column), cell(page.Rows[j], column))
+		})
+	}
+	for i := range page.Header {
+		desc := i == page.Sort && !page.Desc
+		page.SortLinks = append(page.SortLinks, fmt.Sprintf("/view?file=%s&q=%s&sort=%d&desc=%t",
+			template.URLQueryEscaper(name), template.URLQueryEscaper(page.Query), i, desc))
+	}
+	b.render(w, page)
+}
+
+func (b *resultsBrowser) render(w http.ResponseWriter, page browsePage) {
+	if err := browseTemplate.Execute(w, page); err != nil {
+		Log.Errorf("Unable to render the results browser: %v\n", err)
+	}
+}
+
+// lessCell orders numbers numerically before other values.
+func lessCell(a, b string) bool {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return x < y
+	case errA == nil:
+		return true
+	case errB == nil:
+		return false
+	}
+	return a < b
+}
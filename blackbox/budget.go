@@ -0,0 +1,29 @@
+package blackbox
+
+import (
+	"fmt"
+	"time"
+)
+
+// BudgetError ends an exploration that used up its budget of runs or time
+// before running every input set. Its results are complete up to there.
+type BudgetError struct {
+	Runs   int
+	Reason string
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("Budget exhausted after %d runs: %s", e.Runs, e.Reason)
+}
+
+// budgetReason returns why an exploration that completed runs in elapsed
+// time must stop, if it must.
+func budgetReason(options ExplorationOptions, runs int, elapsed time.Duration) (string, bool) {
+	if options.MaxRuns > 0 && runs >= options.MaxRuns {
+		return fmt.Sprintf("max runs %d", options.MaxRuns), true
+	}
+	if options.MaxDuration > 0 && elapsed >= options.MaxDuration {
+		return fmt.Sprintf("max duration %v", options.MaxDuration), true
+	}
+	return "", false
+}
@@ -0,0 +1,183 @@
+package blackbox
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Attempts made to write a batch with the "retry" failure policy.
+const bufferRetries = 3
+
+// BufferPolicy configures how rows are batched before reaching a sink,
+// e.g. in an experiment config:
+//
+//	"buffering": {
+//	  "sheets": {"rows": 50, "max_latency": "30s", "on_failure": "retry"},
+//	  "bq": {"rows": 1000, "max_latency": "1m"}
+//	}
+type BufferPolicy struct {
+	// Rows per write
+	Rows int `json:"rows"`
+	// Longest time a row may wait for its batch to fill, e.g. "30s"
+	MaxLatency string `json:"max_latency"`
+	// What to do when a write fails: "abort" (default), "retry" or "skip"
+	OnFailure string `json:"on_failure"`
+
+	// Minimum time between writes, set to stay within API quotas
+	MinInterval time.Duration `json:"-"`
+}
+
+var defaultBufferRows = map[string]int{
+	"bq": 500,
+}
+
+// BufferPolicyFor returns the configured policy of a sink kind, with
+// defaults filled in.
+func BufferPolicyFor(kind string, buffering map[string]BufferPolicy) BufferPolicy {
+	policy := buffering[kind]
+	if policy.Rows < 1 {
+		policy.Rows = defaultBufferRows[kind]
+	}
+	if policy.Rows < 1 {
+		policy.Rows = 1
+	}
+	if policy.OnFailure == "" {
+		policy.OnFailure = "abort"
+	}
+	return policy
+}
+
+// BatchSink is implemented by sinks that write several rows at once more
+// cheaply than one by one.
+type BatchSink interface {
+	WriteRows(rows [][]string) error
+}
+
+// BufferedSink batches the rows of a sink according to a BufferPolicy.
+type BufferedSink struct {
+	mu         sync.Mutex
+	sink       Sink
+	policy     BufferPolicy
+	maxLatency time.Duration
+	pending    [][]string
+	lastWrite  time.Time
+	timer      *time.Timer
+	// error of a flush triggered by the latency timer
+	err error
+}
+
+func NewBufferedSink(sink Sink, policy BufferPolicy) (*BufferedSink, error) {
+	b := &BufferedSink{sink: sink, policy: policy}
+	if policy.MaxLatency != "" {
+		maxLatency, err := time.ParseDuration(policy.MaxLatency)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid max_latency %q: %v", policy.MaxLatency, err)
+		}
+		b.maxLatency = maxLatency
+	}
+	switch policy.OnFailure {
+	case "abort", "retry", "skip":
+	default:
+		return nil, fmt.Errorf("Invalid on_failure %q, expected abort, retry or skip", policy.OnFailure)
+	}
+	return b, nil
+}
+
+func (b *BufferedSink) WriteHeader(header []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.flushLocked(); err != nil {
+		return err
+	}
+	return b.sink.WriteHeader(header)
+}
+
+func (b *BufferedSink) WriteRow(row []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.pending = append(b.pending, row)
+	if len(b.pending) == 1 && b.maxLatency > 0 {
+		b.timer = time.AfterFunc(b.maxLatency, b.flushLate)
+	}
+	if len(b.pending) < b.policy.Rows || time.Since(b.lastWrite) < b.policy.MinInterval {
+		return nil
+	}
+	return b.flushLocked()
+}
+
+// flushLate writes rows that waited for max_latency.
+func (b *BufferedSink) flushLate() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if wait := b.policy.MinInterval - time.Since(b.lastWrite); wait > 0 {
+		b.timer = time.AfterFunc(wait, b.flushLate)
+		return
+	}
+	if err := b.flushLocked(); err != nil && b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *BufferedSink) write(rows [][]string) error {
+	if batchSink, ok := b.sink.(BatchSink); ok {
+		return batchSink.WriteRows(rows)
+	}
+	for _, row := range rows {
+		if err := b.sink.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BufferedSink) flushLocked() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return nil
+	}
+	rows := b.pending
+	b.pending = nil
+	err := b.write(rows)
+	switch b.policy.OnFailure {
+	case "retry":
+		for attempt := 1; err != nil && attempt < bufferRetries; attempt++ {
+			Log.Warnf("Write of %d rows failed, retrying: %v\n", len(rows), err)
+			time.Sleep(time.Duration(attempt) * time.Second)
+			err = b.write(rows)
+		}
+	case "skip":
+		if err != nil {
+			Log.Errorf("Dropping %d rows after failed write: %v\n", len(rows), err)
+			err = nil
+		}
+	}
+	b.lastWrite = time.Now()
+	return err
+}
+
+func (b *BufferedSink) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.flushLocked()
+	if err == nil {
+		err = b.err
+	}
+	if closeErr := b.sink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (b *BufferedSink) Finalize() error {
+	if finalizer, ok := b.sink.(Finalizer); ok {
+		return finalizer.Finalize()
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package blackbox
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BundleCommand implements "blackbox bundle": it archives the local history
+// of a run (metadata with the program hash, inputs snapshot, plan, results
+// CSV and log) into a .tar.gz for sharing outside Google.
+func BundleCommand(args []string) error {
+	flags := flag.NewFlagSet("bundle", flag.ExitOnError)
+	output := flags.String("o", "", "archive to write (default RUN.tar.gz)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox bundle [-o FILE.tar.gz] RUN\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return fmt.Errorf("run param is missing")
+	}
+	run := flags.Arg(0)
+	if *output == "" {
+		*output = run + ".tar.gz"
+	}
+
+	dir, err := FindRunDir(run)
+	if err != nil {
+		return err
+	}
+	if err := WriteBundle(dir, run, *output); err != nil {
+		return err
+	}
+	Log.Infof("Bundled %s into %s\n", run, *output)
+	return nil
+}
+
+// WriteBundle writes the files of dir into a gzipped tarball under prefix.
+func WriteBundle(dir, prefix, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to bundle %s: %v", dir, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
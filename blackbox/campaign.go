@@ -0,0 +1,136 @@
+package blackbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Campaign groups related experiments that run against one spreadsheet,
+// e.g. several programs or scenarios (inputs tabs) to compare.
+//
+//	{
+//	  "name": "compilers",
+//	  "parallel": true,
+//	  "max_parallel": 2,
+//	  "runs": [
+//	    {"name": "gcc", "program": "./gcc-adapter", "inputs": "inputs"},
+//	    {"name": "clang", "program": "./clang-adapter", "inputs": "inputs"}
+//	  ]
+//	}
+type Campaign struct {
+	Name     string `json:"name"`
+	Parallel bool   `json:"parallel"`
+	// Runs at once when parallel, 0 for all of them
+	MaxParallel int          `json:"max_parallel"`
+	Runs        []Experiment `json:"runs"`
+
+	// Prefix of the summary tab, "campaign" if empty
+	summaryPrefix string
+}
+
+func LoadCampaign(path string) (*Campaign, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read campaign file: %v", err)
+	}
+	campaign := &Campaign{}
+	if err := json.Unmarshal(b, campaign); err != nil {
+		return nil, fmt.Errorf("Unable to parse campaign file: %v", err)
+	}
+	if len(campaign.Runs) == 0 {
+		return nil, fmt.Errorf("Campaign %s has no runs", path)
+	}
+	for i, run := range campaign.Runs {
+		if run.Name == "" {
+			campaign.Runs[i].Name = fmt.Sprintf("run%d", i+1)
+		}
+	}
+	return campaign, nil
+}
+
+// RunCampaign runs every experiment of the campaign, sequentially or in
+// parallel, and then writes a campaign summary tab. Failed runs do not stop
+// the campaign; their errors are reported in the results and the summary.
+func RunCampaign(srv *sheets.Service, spreadsheetID string, campaign *Campaign) ([]RunResult, error) {
+	start := time.Now()
+	results := make([]RunResult, len(campaign.Runs))
+	if campaign.Parallel {
+		slots := len(campaign.Runs)
+		if campaign.MaxParallel > 0 && campaign.MaxParallel < slots {
+			slots = campaign.MaxParallel
+		}
+		running := make(chan bool, slots)
+		var wg sync.WaitGroup
+		for i, experiment := range campaign.Runs {
+			wg.Add(1)
+			running <- true
+			go func(i int, experiment Experiment) {
+				defer wg.Done()
+				defer func() { <-running }()
+				results[i] = RunExperiment(srv, spreadsheetID, experiment)
+			}(i, experiment)
+		}
+		wg.Wait()
+	} else {
+		for i, experiment := range campaign.Runs {
+			Log.Infof("Campaign run %d/%d: %s\n", i+1, len(campaign.Runs), experiment.Name)
+			results[i] = RunExperiment(srv, spreadsheetID, experiment)
+		}
+	}
+
+	prefix := campaign.summaryPrefix
+	if prefix == "" {
+		prefix = "campaign"
+	}
+	summaryName := fmt.Sprintf("%s_%d", prefix, start.Unix())
+	if campaign.Name != "" {
+		summaryName = fmt.Sprintf("%s_%s_%d", prefix, campaign.Name, start.Unix())
+	}
+	return results, WriteCampaignSummary(srv, spreadsheetID, summaryName, results)
+}
+
+// WriteCampaignSummary records one row per campaign run in a new tab next
+// to the inputs, linking to the result tabs of a Google spreadsheet.
+func WriteCampaignSummary(srv *sheets.Service, spreadsheetID, sheetName string, results []RunResult) error {
+	sheetIDs := map[string]int64{}
+	if srv != nil && IsGoogleSpreadsheet(spreadsheetID) {
+		resp, err := srv.Spreadsheets.Get(spreadsheetID).Fields("sheets.properties(sheetId,title)").Do()
+		if err != nil {
+			Log.Warnf("Unable to link the result tabs from the summary: %v\n", err)
+		} else {
+			for _, sheet := range resp.Sheets {
+				sheetIDs[sheet.Properties.Title] = sheet.Properties.SheetId
+			}
+		}
+	}
+	rows := [][]string{
+		{"run", "run_id", "program", "inputs", "result", "input sets", "duration (s)", "status"},
+	}
+	for _, result := range results {
+		status := "ok"
+		if result.Err != nil {
+			status = result.Err.Error()
+		}
+		resultCell := result.ResultName
+		if sheetID, ok := sheetIDs[result.ResultName]; ok {
+			resultCell = fmt.Sprintf(`=HYPERLINK("#gid=%d", "%s")`, sheetID, result.ResultName)
+		}
+		rows = append(rows, []string{
+			result.Experiment.Name,
+			result.RunID,
+			result.Experiment.Program,
+			result.Experiment.Inputs,
+			resultCell,
+			strconv.Itoa(result.InputSets),
+			strconv.FormatFloat(result.Duration.Seconds(), 'f', 1, 64),
+			status,
+		})
+	}
+	return WriteRows(srv, spreadsheetID, sheetName, rows)
+}
@@ -0,0 +1,107 @@
+package blackbox
+
+import (
+	"fmt"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// ChartSpec describes a chart of an output against an input variable,
+// embedded in the result tab once the run completed.
+type ChartSpec struct {
+	Output string
+	Input  string
+	// SCATTER or LINE
+	Type string
+}
+
+// ParseChartSpec parses "out_var:in_var", optionally followed by
+// ":scatter" (the default) or ":line".
+func ParseChartSpec(spec string) (ChartSpec, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return ChartSpec{}, fmt.Errorf("Invalid chart %q, expected out_var:in_var[:scatter|line]", spec)
+	}
+	chart := ChartSpec{Output: strings.TrimSpace(parts[0]), Input: strings.TrimSpace(parts[1]), Type: "SCATTER"}
+	if len(parts) == 3 {
+		switch strings.ToLower(strings.TrimSpace(parts[2])) {
+		case "scatter":
+		case "line":
+			chart.Type = "LINE"
+		default:
+			return ChartSpec{}, fmt.Errorf("Invalid chart type %q, expected scatter or line", parts[2])
+		}
+	}
+	return chart, nil
+}
+
+func ParseChartSpecs(specs []string) ([]ChartSpec, error) {
+	charts := []ChartSpec{}
+	for _, spec := range specs {
+		chart, err := ParseChartSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		charts = append(charts, chart)
+	}
+	return charts, nil
+}
+
+// Rows of the result tab between the anchors of consecutive charts.
+const chartRowSpacing = 20
+
+// AddCharts embeds charts of the first rows of a result tab (header
+// included) to the right of its columns.
+func AddCharts(srv *sheets.Service, spreadsheetID string, sheetID int64, header []string, rows int, charts []ChartSpec) error {
+	index := columnIndex(header)
+	// source is the range of a result column, header included
+	source := func(column int) *sheets.ChartData {
+		return &sheets.ChartData{SourceRange: &sheets.ChartSourceRange{Sources: []*sheets.GridRange{{
+			SheetId:          sheetID,
+			EndRowIndex:      int64(rows),
+			StartColumnIndex: int64(column),
+			EndColumnIndex:   int64(column + 1),
+		}}}}
+	}
+	requests := []*sheets.Request{}
+	for _, chart := range charts {
+		outputColumn, hasOutput := index[chart.Output]
+		inputColumn, hasInput := index[chart.Input]
+		if !hasOutput || !hasInput {
+			Log.Warnf("Skipping chart %s:%s, not a result column\n", chart.Output, chart.Input)
+			continue
+		}
+		requests = append(requests, &sheets.Request{AddChart: &sheets.AddChartRequest{Chart: &sheets.EmbeddedChart{
+			Spec: &sheets.ChartSpec{
+				Title: chart.Output + " vs " + chart.Input,
+				BasicChart: &sheets.BasicChartSpec{
+					ChartType:      chart.Type,
+					LegendPosition: "NO_LEGEND",
+					HeaderCount:    1,
+					Axis: []*sheets.BasicChartAxis{
+						{Position: "BOTTOM_AXIS", Title: chart.Input},
+						{Position: "LEFT_AXIS", Title: chart.Output},
+					},
+					Domains: []*sheets.BasicChartDomain{{Domain: source(inputColumn)}},
+					Series:  []*sheets.BasicChartSeries{{Series: source(outputColumn), TargetAxis: "LEFT_AXIS"}},
+				},
+			},
+			Position: &sheets.EmbeddedObjectPosition{OverlayPosition: &sheets.OverlayPosition{
+				AnchorCell: &sheets.GridCoordinate{
+					SheetId:     sheetID,
+					RowIndex:    int64(len(requests) * chartRowSpacing),
+					ColumnIndex: int64(len(header) + 1),
+				},
+			}},
+		}}})
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	if _, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do(); err != nil {
+		return fmt.Errorf("Unable to add charts: %v", err)
+	}
+	return nil
+}
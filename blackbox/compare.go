@@ -0,0 +1,312 @@
+package blackbox
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// mismatchColumn lists, per input set, the outputs on which the compared
+// programs disagree.
+const mismatchColumn = "mismatch"
+
+// outliersColumn lists, per input set compared over more than two
+// programs, those disagreeing with the majority of the others.
+const outliersColumn = "outliers"
+
+// Prefix of the columns of the majority outputs of more than two programs.
+const consensusPrefix = "consensus."
+
+// CompareSpec is one of the programs an experiment compares, run on its
+// own target, e.g.
+//
+//	"compare": [
+//	  {"name": "reference", "program": "./fib"},
+//	  {"name": "rewrite", "target": "docker:fib:2", "program": "-"},
+//	  {"name": "service", "target": "http://localhost:8080/fib", "program": "-"}
+//	]
+type CompareSpec struct {
+	// Prefix of its output columns, after the program or target if empty
+	Name    string `json:"name"`
+	Target  string `json:"target"`
+	Program string `json:"program"`
+}
+
+// label names the columns of a compared program.
+func (s CompareSpec) label() string {
+	if s.Name != "" {
+		return sanitizeIdentifier(s.Name)
+	}
+	if s.Program != "" && s.Program != "-" {
+		return sanitizeIdentifier(strings.TrimSuffix(filepath.Base(s.Program), filepath.Ext(s.Program)))
+	}
+	return sanitizeIdentifier(s.Target)
+}
+
+// compareSpecs returns the programs an experiment compares: those of
+// Compare, or else Program and CompareWith on its target, if any.
+func compareSpecs(experiment Experiment) []CompareSpec {
+	if len(experiment.Compare) > 0 {
+		return experiment.Compare
+	}
+	if experiment.CompareWith == "" {
+		return nil
+	}
+	return []CompareSpec{
+		{Target: experiment.Target, Program: experiment.Program},
+		{Target: experiment.Target, Program: experiment.CompareWith},
+	}
+}
+
+// CompareTarget runs every input set through several programs and
+// returns their outputs side by side, each output prefixed by the label of
+// its program, e.g. prog_v1.latency_ms, with a mismatch column listing
+// the outputs that differ. Over more than two programs, it adds the value
+// of every output most programs agree on, as consensus.OUTPUT, and the
+// outliers disagreeing with them. A program failing records its error as
+// its error output; the input set fails only if every program does.
+type CompareTarget struct {
+	Labels  []string
+	Targets []Target
+	// Outputs expected to differ, e.g. timings, left out of the mismatches
+	Ignore map[string]bool
+}
+
+// OpenCompareTarget opens the targets of the compared programs.
+func OpenCompareTarget(specs []CompareSpec, options TargetOptions, ignore []string) (*CompareTarget, error) {
+	if len(specs) < 2 {
+		return nil, fmt.Errorf("Comparing needs at least two programs, got %d", len(specs))
+	}
+	t := &CompareTarget{Ignore: map[string]bool{}}
+	used := map[string]int{}
+	for _, spec := range specs {
+		target, err := OpenTarget(spec.Target, spec.Program, options)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		label := spec.label()
+		if used[label]++; used[label] > 1 {
+			label += "_" + strconv.Itoa(used[label])
+		}
+		t.Labels = append(t.Labels, label)
+		t.Targets = append(t.Targets, target)
+	}
+	for _, output := range ignore {
+		t.Ignore[output] = true
+	}
+	return t, nil
+}
+
+// Close releases the targets holding processes or connections.
+func (t *CompareTarget) Close() error {
+	for _, target := range t.Targets {
+		if closer, ok := target.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	return nil
+}
+
+func (t *CompareTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	results := make([]map[string]string, len(t.Targets))
+	failures := []string{}
+	for i, target := range t.Targets {
+		outputs, err := target.Run(config, varNames, inputSet)
+		if err != nil {
+			outputs = map[string]string{errorColumn: err.Error()}
+			failures = append(failures, fmt.Sprintf("%s: %v", t.Labels[i], err))
+		}
+		results[i] = outputs
+	}
+	if len(failures) == len(t.Targets) {
+		return nil, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	outputMap := map[string]string{mismatchColumn: strings.Join(t.mismatches(results), ", ")}
+	for i, outputs := range results {
+		for name, value := range outputs {
+			outputMap[t.Labels[i]+"."+name] = value
+		}
+	}
+	if len(t.Targets) > 2 {
+		outputMap[outliersColumn] = strings.Join(t.consensus(results, outputMap), ", ")
+	}
+	return outputMap, nil
+}
+
+// compared returns the outputs of any program compared, in order.
+func (t *CompareTarget) compared(results []map[string]string) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, outputs := range results {
+		for name := range outputs {
+			if !t.Ignore[name] && !isRunSpecificColumn(name) && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// comparedValue returns the value of an output compared between programs.
+// Errors are compared by whether the programs failed, as their messages
+// name the programs.
+func comparedValue(outputs map[string]string, name string) string {
+	if name == errorColumn && outputs[name] != "" {
+		return "failed"
+	}
+	return outputs[name]
+}
+
+// mismatches returns the outputs whose values differ between programs,
+// a missing output counting as empty.
+func (t *CompareTarget) mismatches(results []map[string]string) []string {
+	differing := []string{}
+	for _, name := range t.compared(results) {
+		for _, outputs := range results[1:] {
+			if comparedValue(outputs, name) != comparedValue(results[0], name) {
+				differing = append(differing, name)
+				break
+			}
+		}
+	}
+	return differing
+}
+
+// consensus records in outputMap the value of every output a majority of
+// the programs agree on, and returns the labels of the programs
+// disagreeing with a majority on any output.
+func (t *CompareTarget) consensus(results []map[string]string, outputMap map[string]string) []string {
+	outlier := make([]bool, len(results))
+	for _, name := range t.compared(results) {
+		votes := map[string]int{}
+		for _, outputs := range results {
+			votes[comparedValue(outputs, name)]++
+		}
+		majority, found := "", false
+		for value, count := range votes {
+			if 2*count > len(results) {
+				majority, found = value, true
+			}
+		}
+		if !found {
+			continue
+		}
+		if name != errorColumn {
+			outputMap[consensusPrefix+name] = majority
+		}
+		for i, outputs := range results {
+			if comparedValue(outputs, name) != majority {
+				outlier[i] = true
+			}
+		}
+	}
+	outliers := []string{}
+	for i, isOutlier := range outlier {
+		if isOutlier {
+			outliers = append(outliers, t.Labels[i])
+		}
+	}
+	return outliers
+}
+
+// CompareCommand implements "blackbox compare": it runs the input sets of
+// a spreadsheet through two programs or more, e.g. two versions of one or
+// reimplementations of it, recording their outputs side by side, and fails
+// with exit status 3 when they disagree.
+func CompareCommand(args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	var outputs ListFlags
+	flags.Var(&outputs, "output", "where to record results, as for a sweep (repeatable, default next to the inputs)")
+	inputs := flags.String("inputs", "", "tab or named range defining the input variables (default inputs)")
+	target := flags.String("target", "", "where the programs given as arguments run, as for a sweep (default local)")
+	targets := flags.String("targets", "", "JSON file listing more programs to compare, as {\"name\", \"target\", \"program\"} objects, e.g. docker images or HTTP services")
+	ignore := flags.String("ignore", "", "comma separated outputs expected to differ, e.g. timings")
+	timeout := flags.Duration("timeout", 0, "kill a program if a single run takes longer than this (0 for no limit)")
+	concurrency := flags.Int("concurrency", 1, "number of input sets to run in parallel")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox compare [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH OTHER_PROGPATH...\n")
+		fmt.Fprintf(os.Stderr, "       blackbox compare -targets FILE.json [flags] SPREADSHEET_ID|FILE.xlsx [PROGPATH...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return fmt.Errorf("spreadsheet param is missing")
+	}
+	spreadsheet := SpreadsheetID(flags.Arg(0))
+	specs := []CompareSpec{}
+	for _, program := range flags.Args()[1:] {
+		specs = append(specs, CompareSpec{Target: *target, Program: program})
+	}
+	if *targets != "" {
+		content, err := ioutil.ReadFile(*targets)
+		if err != nil {
+			return fmt.Errorf("Unable to read targets: %v", err)
+		}
+		listed := []CompareSpec{}
+		if err := json.Unmarshal(content, &listed); err != nil {
+			return fmt.Errorf("Unable to parse targets %s: %v", *targets, err)
+		}
+		specs = append(specs, listed...)
+	}
+	if len(specs) < 2 {
+		flags.Usage()
+		return fmt.Errorf("Comparing needs at least two programs, got %d", len(specs))
+	}
+
+	var srv *sheets.Service
+	if NeedsSheetsService(spreadsheet, outputs) {
+		var err error
+		if srv, err = Auth(); err != nil {
+			return err
+		}
+	}
+	if WritesToSpreadsheet(spreadsheet, outputs) {
+		if err := CheckEditAccess(srv, spreadsheet); err != nil {
+			return err
+		}
+	}
+	experiment := Experiment{
+		Program:       specs[0].Program,
+		Compare:       specs,
+		CompareIgnore: ExtractExamples(*ignore),
+		Inputs:        *inputs,
+		Outputs:       outputs,
+		Target:        specs[0].Target,
+		Concurrency:   *concurrency,
+		KeepGoing:     true,
+	}
+	if *timeout > 0 {
+		experiment.Timeout = timeout.String()
+	}
+	result := RunExperiment(srv, spreadsheet, experiment)
+	if result.Err != nil {
+		return result.Err
+	}
+	disagreements := 0
+	if len(result.Recorded) > 0 {
+		column := columnIndex(result.Recorded[0])[mismatchColumn]
+		for _, row := range result.Recorded[1:] {
+			if cell(row, column) != "" {
+				disagreements++
+			}
+		}
+	}
+	if disagreements > 0 {
+		return &GateError{Reasons: []string{fmt.Sprintf("the programs disagree on %d of %d input sets, see %s", disagreements, result.Runs, result.ResultName)}}
+	}
+	Log.Infof("The programs agree on all %d input sets\n", result.Runs)
+	return nil
+}
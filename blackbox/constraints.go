@@ -0,0 +1,96 @@
+package blackbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Constraints are read from column A of this optional tab, one expression
+// per row, in addition to the experiment's configured constraints.
+const constraintsSheet = "constraints"
+
+// ReadConstraints returns the expressions of the constraints tab, if any.
+func ReadConstraints(source Source) ([]string, error) {
+	return readExpressionTab(source, constraintsSheet)
+}
+
+// readExpressionTab reads one expression per row from column A of an
+// optional tab.
+func readExpressionTab(source Source, sheetName string) ([]string, error) {
+	rows, err := ReadOptionalRows(source, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	expressions := []string{}
+	for _, row := range rows {
+		if len(row) > 0 && strings.TrimSpace(row[0]) != "" {
+			expressions = append(expressions, strings.TrimSpace(row[0]))
+		}
+	}
+	return expressions, nil
+}
+
+// FilterInputSets drops the input sets violating any of the constraints,
+// boolean expressions over the variables such as "min <= max" or
+// `mode != "tls" || port == 443`.
+func FilterInputSets(varNames []string, inputSets [][]string, constraints []string) ([][]string, error) {
+	if len(constraints) == 0 {
+		return inputSets, nil
+	}
+	expressions := []*Expression{}
+	for _, constraint := range constraints {
+		expression, err := CompileExpression(constraint, varNames)
+		if err != nil {
+			return nil, fmt.Errorf("Constraint: %v", err)
+		}
+		expressions = append(expressions, expression)
+	}
+
+	result := [][]string{}
+	for _, inputSet := range inputSets {
+		env := ValueEnv(varNames, inputSet)
+		allowed := true
+		for _, expression := range expressions {
+			ok, err := expression.EvalBool(env)
+			if err != nil {
+				return nil, fmt.Errorf("Constraint %q failed for %v: %v", expression.Text, inputSet, err)
+			}
+			if !ok {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			result = append(result, inputSet)
+		}
+	}
+	Log.Infof("Constraints pruned %d of %d input sets\n", len(inputSets)-len(result), len(inputSets))
+	return result, nil
+}
+
+// OrderInputSets sorts input sets by the score of an expression over the
+// variables, lowest first, so that the most interesting ones run first,
+// e.g. "abs(rate-100)" for rates closest to 100. Ties keep their order.
+func OrderInputSets(varNames []string, inputSets [][]string, orderBy string) ([][]string, error) {
+	expression, err := CompileExpression(orderBy, varNames)
+	if err != nil {
+		return nil, fmt.Errorf("Order: %v", err)
+	}
+	scores := make([]float64, len(inputSets))
+	for i, inputSet := range inputSets {
+		if scores[i], err = expression.EvalNumber(ValueEnv(varNames, inputSet)); err != nil {
+			return nil, fmt.Errorf("Order %q failed for %v: %v", orderBy, inputSet, err)
+		}
+	}
+	order := make([]int, len(inputSets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] < scores[order[j]] })
+	ordered := make([][]string, len(inputSets))
+	for i, j := range order {
+		ordered[i] = inputSets[j]
+	}
+	return ordered, nil
+}
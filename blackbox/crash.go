@@ -0,0 +1,198 @@
+package blackbox
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Default program runs spent minimizing a crash, crashes minimized per
+// run and stderr bytes kept per crash.
+const (
+	DefaultMinimizeRuns = 50
+	maxTriagedCrashes   = 10
+	crashStderrBytes    = 2000
+)
+
+var crashesHeader = []string{"input", "minimized", "signal", "stderr", "minimize_runs"}
+
+// CrashSignal returns the signal that killed the program of a failed run,
+// e.g. "segmentation fault", and what it wrote to stderr. Programs exiting
+// with an error status did not crash.
+func CrashSignal(err error) (string, string, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return "", "", false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return "", "", false
+	}
+	return status.Signal().String(), string(exitErr.Stderr), true
+}
+
+// Crash is an input set that crashed the program.
+type Crash struct {
+	InputSet []string
+	Signal   string
+	Stderr   string
+}
+
+// CrashTriage collects the input sets crashing the program during a run,
+// then looks for smaller input sets crashing it the same way by reducing
+// each variable toward a default value, e.g. 0 or an empty string, and
+// records them in a crashes tab to reproduce the crashes simply.
+type CrashTriage struct {
+	mu       sync.Mutex
+	target   Target
+	config   RunnerConfig
+	varNames []string
+	// Variables left as they are, such as derived variables
+	fixed   map[string]bool
+	budget  int
+	crashes []Crash
+}
+
+// NewCrashTriage prepares the triage of the crashes of target, spending up
+// to budget runs per crash minimizing it, none if negative.
+func NewCrashTriage(target Target, config RunnerConfig, varNames []string, derived []DerivedVar, budget int) *CrashTriage {
+	if budget == 0 {
+		budget = DefaultMinimizeRuns
+	}
+	fixed := map[string]bool{}
+	for _, derivedVar := range derived {
+		fixed[derivedVar.Name] = true
+	}
+	return &CrashTriage{target: target, config: config, varNames: varNames, fixed: fixed, budget: budget}
+}
+
+// Observe records the run of an input set if it crashed.
+func (t *CrashTriage) Observe(inputSet []string, runErr error) {
+	signal, stderr, crashed := CrashSignal(runErr)
+	if !crashed {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(stderr) > crashStderrBytes {
+		stderr = stderr[len(stderr)-crashStderrBytes:]
+	}
+	t.crashes = append(t.crashes, Crash{InputSet: append([]string{}, inputSet...), Signal: signal, Stderr: stderr})
+}
+
+// Write minimizes the first crashes and writes the crashes tab of the run,
+// if the program crashed.
+func (t *CrashTriage) Write(sinkContext *SinkContext) error {
+	t.mu.Lock()
+	crashes := t.crashes
+	t.mu.Unlock()
+	if len(crashes) == 0 {
+		return nil
+	}
+	Log.Warnf("The program crashed on %d input sets\n", len(crashes))
+	rows := [][]string{crashesHeader}
+	// Each distinct minimized crash is recorded once
+	seen := map[string]bool{}
+	for i, crash := range crashes {
+		minimized, runs := crash.InputSet, 0
+		if i < maxTriagedCrashes && t.budget > 0 {
+			minimized, runs = t.minimize(crash)
+		}
+		key := crash.Signal + "\x00" + inputSetKey(minimized)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, []string{
+			inputLabel(t.varNames, crash.InputSet),
+			inputLabel(t.varNames, minimized),
+			crash.Signal,
+			crash.Stderr,
+			strconv.Itoa(runs),
+		})
+	}
+	sheetName := relatedSheetName("crashes", sinkContext.RunName)
+	if err := WriteRows(sinkContext.Service, sinkContext.SpreadsheetID, sheetName, rows); err != nil {
+		return err
+	}
+	Log.Infof("Wrote %s\n", sheetName)
+	return nil
+}
+
+// minimize reduces the variables of a crash one at a time, keeping every
+// reduction that still crashes the program with the same signal, until
+// none does or the budget is spent. It returns the smallest input set
+// found and the runs it took.
+func (t *CrashTriage) minimize(crash Crash) ([]string, int) {
+	current := append([]string{}, crash.InputSet...)
+	runs := 0
+	for reduced := true; reduced && runs < t.budget; {
+		reduced = false
+		for i, varName := range t.varNames {
+			if IsMetaVar(varName) || t.fixed[varName] {
+				continue
+			}
+			for _, candidate := range reductions(current[i]) {
+				if runs >= t.budget {
+					break
+				}
+				trial := append([]string{}, current...)
+				trial[i] = candidate
+				config, err := RunnerConfigFor(t.config, t.varNames, trial)
+				if err != nil {
+					continue
+				}
+				runs++
+				_, runErr := t.target.Run(config, t.varNames, trial)
+				if signal, _, crashed := CrashSignal(runErr); crashed && signal == crash.Signal {
+					current, reduced = trial, true
+					break
+				}
+			}
+		}
+	}
+	return current, runs
+}
+
+// reductions returns simpler values than value to try in its place,
+// simplest first: the default value of its kind, then about half of it.
+func reductions(value string) []string {
+	if value == "true" {
+		return []string{"false"}
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if n == 0 {
+			return nil
+		}
+		candidates := []string{"0"}
+		if n/2 != 0 {
+			candidates = append(candidates, strconv.FormatInt(n/2, 10))
+		}
+		return candidates
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		if f == 0 {
+			return nil
+		}
+		candidates := []string{"0"}
+		if truncated := float64(int64(f)); truncated != f && truncated != 0 {
+			candidates = append(candidates, FormatValue(truncated))
+		}
+		return candidates
+	}
+	runes := []rune(strings.TrimSpace(value))
+	if len(runes) == 0 {
+		return nil
+	}
+	candidates := []string{""}
+	if len(runes) > 2 {
+		candidates = append(candidates, string(runes[:len(runes)/2]))
+	}
+	if len(runes) > 1 {
+		candidates = append(candidates, string(runes[:len(runes)-1]))
+	}
+	return candidates
+}
@@ -0,0 +1,75 @@
+package blackbox
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	drive "google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Scope granting access to the Drive files blackbox creates, to place new
+// spreadsheets in a folder.
+const driveFileScope = "https://www.googleapis.com/auth/drive.file"
+
+// CreateExperimentSpreadsheet creates a spreadsheet whose inputs tab holds
+// the rows of a local CSV file laid out like an inputs tab, in a Drive
+// folder if any, for running an experiment without setting up a
+// spreadsheet by hand. It returns the ID and URL of the spreadsheet.
+func CreateExperimentSpreadsheet(srv *sheets.Service, inputsPath, folder string) (string, string, error) {
+	content, err := ioutil.ReadFile(inputsPath)
+	if err != nil {
+		return "", "", fmt.Errorf("Unable to read inputs file: %v", err)
+	}
+	inputs, err := parseCSV(content)
+	if err != nil || len(inputs) == 0 {
+		return "", "", fmt.Errorf("Unable to read inputs file %s: %v", inputsPath, err)
+	}
+	title := "blackbox " + strings.TrimSuffix(filepath.Base(inputsPath), filepath.Ext(inputsPath))
+	spreadsheet, url, defaultSheetID, err := CreateSpreadsheet(srv, title)
+	if err != nil {
+		return "", "", err
+	}
+	if folder != "" {
+		if err := MoveToFolder(spreadsheet, folder); err != nil {
+			return "", "", err
+		}
+	}
+	if err := WriteRows(srv, spreadsheet, "inputs", inputs); err != nil {
+		return "", "", err
+	}
+	if err := DeleteSheet(srv, spreadsheet, defaultSheetID); err != nil {
+		return "", "", err
+	}
+	return spreadsheet, url, nil
+}
+
+// MoveToFolder moves a Drive file into a folder.
+func MoveToFolder(fileID, folder string) error {
+	client, err := authClient()
+	if err != nil {
+		return err
+	}
+	srv, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return fmt.Errorf("Unable to use Drive: %v", err)
+	}
+	file, err := srv.Files.Get(fileID).Fields("parents").SupportsAllDrives(true).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to move the spreadsheet to folder %s: %v", folder, err)
+	}
+	_, err = srv.Files.Update(fileID, &drive.File{}).AddParents(folder).
+		RemoveParents(strings.Join(file.Parents, ",")).SupportsAllDrives(true).Do()
+	if isPermissionDenied(err) {
+		return fmt.Errorf("Unable to move the spreadsheet to folder %s: the folder must be editable, "+
+			"and tokens authorized before blackbox used Drive need blackbox auth login: %v", folder, err)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to move the spreadsheet to folder %s: %v", folder, err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package blackbox
+
+import (
+	"fmt"
+	"time"
+
+	cron "github.com/robfig/cron/v3"
+)
+
+// RunOnSchedule runs an exploration at every time of a cron schedule, e.g.
+// "0 3 * * *" for every night at 3:00, until killed, turning blackbox
+// into a benchmark tracker. A failed run is logged and the schedule goes
+// on.
+func RunOnSchedule(spec string, run func() error) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("Invalid cron schedule %q: %v", spec, err)
+	}
+	for {
+		next := schedule.Next(time.Now())
+		Log.Infof("Next run at %s\n", next.Format("2006-01-02 15:04:05"))
+		time.Sleep(time.Until(next))
+		if err := run(); err != nil {
+			Log.Errorf("Scheduled run failed: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package blackbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Derived variables are computed from the other variables of each input
+// set instead of being enumerated. In the inputs tab their examples cell
+// holds the expression after this prefix, e.g. "expr: batch_size * batches"
+// (a leading "=" would make it a spreadsheet formula).
+const derivedPrefix = "expr:"
+
+// DerivedVar is a variable computed by an expression over the variables
+// before it.
+type DerivedVar struct {
+	Name       string
+	Expression string
+}
+
+// ParseDerivedVar parses a "name = expression" definition.
+func ParseDerivedVar(definition string) (DerivedVar, error) {
+	parts := strings.SplitN(definition, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return DerivedVar{}, fmt.Errorf("Invalid derived variable %q, expected name = expression", definition)
+	}
+	return DerivedVar{Name: strings.TrimSpace(parts[0]), Expression: strings.TrimSpace(parts[1])}, nil
+}
+
+// SplitDerivedRows separates the derived variable rows of the inputs tab
+// from the rows listing examples.
+func SplitDerivedRows(setupRows [][]string) ([][]string, []DerivedVar) {
+	rows := [][]string{}
+	derived := []DerivedVar{}
+	for _, row := range setupRows {
+		if len(row) > 1 && strings.HasPrefix(strings.TrimSpace(row[1]), derivedPrefix) {
+			derived = append(derived, DerivedVar{
+				Name:       strings.TrimSpace(row[0]),
+				Expression: strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(row[1]), derivedPrefix)),
+			})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, derived
+}
+
+// FormatValue renders an expression result the way a spreadsheet cell
+// would hold it.
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// AddDerivedVars appends a column for each derived variable to the input
+// sets. A derived variable may refer to the variables and derived variables
+// defined before it.
+func AddDerivedVars(varNames []string, inputSets [][]string, derived []DerivedVar) ([]string, [][]string, error) {
+	for _, derivedVar := range derived {
+		expression, err := CompileExpression(derivedVar.Expression, varNames)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Derived variable %s: %v", derivedVar.Name, err)
+		}
+		for i, inputSet := range inputSets {
+			value, err := expression.Eval(ValueEnv(varNames, inputSet))
+			if err != nil {
+				return nil, nil, fmt.Errorf("Derived variable %s failed for %v: %v", derivedVar.Name, inputSet, err)
+			}
+			inputSets[i] = append(append([]string{}, inputSet...), FormatValue(value))
+		}
+		varNames = append(append([]string{}, varNames...), derivedVar.Name)
+	}
+	return varNames, inputSets, nil
+}
@@ -0,0 +1,370 @@
+package blackbox
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// regressionsColumn lists, per compared row, the outputs that got worse
+// beyond the threshold.
+const regressionsColumn = "regressions"
+
+// onlyInColumn names, for the rows of only one of the compared results,
+// that result: baseline or current.
+const onlyInColumn = "only_in"
+
+// DiffOptions tune how two result sheets are compared.
+type DiffOptions struct {
+	// Input columns identifying a row in both results
+	Keys []string
+	// Percentage change beyond which a worse output is a regression
+	Threshold float64
+	// Outputs for which higher values are better; lower is better otherwise
+	Maximize map[string]bool
+}
+
+// isBookkeepingColumn reports whether a result column is written by
+// blackbox itself rather than by the program.
+func isBookkeepingColumn(column string) bool {
+	return column == errorColumn || column == assertionsColumn || isRunSpecificColumn(column)
+}
+
+// isRunSpecificColumn reports whether a result column differs between runs
+// of an input set whatever the program does, e.g. its working directory.
+func isRunSpecificColumn(column string) bool {
+	return column == workdirColumn || column == artifactsColumn
+}
+
+func columnIndex(header []string) map[string]int {
+	index := map[string]int{}
+	for i, column := range header {
+		index[column] = i
+	}
+	return index
+}
+
+func cell(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// Regression is an output that got worse in a row of the current result.
+type Regression struct {
+	Output string
+	// Rows of the baseline and current results, header being row 0
+	BaseRow    int
+	CurrentRow int
+	Current    string
+	// Percentage change
+	Change float64
+}
+
+// regressedRows returns the number of current rows with regressions.
+func regressedRows(regressions []Regression) int {
+	rows := map[int]bool{}
+	for _, regression := range regressions {
+		rows[regression.CurrentRow] = true
+	}
+	return len(rows)
+}
+
+// CompareResults joins the rows of two results (header first) on the key
+// columns and computes the delta and percentage change of every numeric
+// output present in both. Rows of only one result are kept, after the
+// others for baseline ones, and marked in an only_in column. It returns the
+// comparison rows, header first, and the regressions.
+func CompareResults(baseline, current [][]string, options DiffOptions) ([][]string, []Regression, error) {
+	if len(baseline) == 0 || len(current) == 0 {
+		return nil, nil, fmt.Errorf("Both results need a header row")
+	}
+	baseIndex, currentIndex := columnIndex(baseline[0]), columnIndex(current[0])
+	isKey := map[string]bool{}
+	for _, key := range options.Keys {
+		if _, ok := baseIndex[key]; !ok {
+			return nil, nil, fmt.Errorf("Baseline has no column %s", key)
+		}
+		if _, ok := currentIndex[key]; !ok {
+			return nil, nil, fmt.Errorf("Current result has no column %s", key)
+		}
+		isKey[key] = true
+	}
+	outputs := []string{}
+	for _, column := range current[0] {
+		if _, ok := baseIndex[column]; ok && !isKey[column] && !isBookkeepingColumn(column) {
+			outputs = append(outputs, column)
+		}
+	}
+
+	rowKey := func(row []string, index map[string]int) string {
+		values := []string{}
+		for _, key := range options.Keys {
+			values = append(values, cell(row, index[key]))
+		}
+		return strings.Join(values, "\x00")
+	}
+	baseRows := map[string]int{}
+	for i, row := range baseline {
+		if i > 0 {
+			baseRows[rowKey(row, baseIndex)] = i
+		}
+	}
+
+	header := append([]string{}, options.Keys...)
+	for _, output := range outputs {
+		header = append(header, output+" baseline", output+" current", output+" delta", output+" %")
+	}
+	header = append(header, regressionsColumn, onlyInColumn)
+	comparison := [][]string{header}
+	all := []Regression{}
+	matched := map[int]bool{}
+
+	for currentRow, row := range current {
+		if currentRow == 0 {
+			continue
+		}
+		line := []string{}
+		for _, key := range options.Keys {
+			line = append(line, cell(row, currentIndex[key]))
+		}
+		baseRowIndex, found := baseRows[rowKey(row, currentIndex)]
+		baseRow := baseline[baseRowIndex]
+		onlyIn := ""
+		if found {
+			matched[baseRowIndex] = true
+		} else {
+			onlyIn = "current"
+		}
+		regressions := []string{}
+		for _, output := range outputs {
+			currentValue := cell(row, currentIndex[output])
+			baseValue := ""
+			if found {
+				baseValue = cell(baseRow, baseIndex[output])
+			}
+			delta, percent := "", ""
+			b, baseErr := strconv.ParseFloat(baseValue, 64)
+			c, currentErr := strconv.ParseFloat(currentValue, 64)
+			if baseErr == nil && currentErr == nil {
+				delta = FormatValue(c - b)
+				if b != 0 {
+					change := (c - b) / math.Abs(b) * 100
+					percent = strconv.FormatFloat(change, 'f', 1, 64)
+					worse := change > options.Threshold
+					if options.Maximize[output] {
+						worse = change < -options.Threshold
+					}
+					if worse {
+						regressions = append(regressions, fmt.Sprintf("%s %+.1f%%", output, change))
+						all = append(all, Regression{
+							Output:     output,
+							BaseRow:    baseRowIndex,
+							CurrentRow: currentRow,
+							Current:    currentValue,
+							Change:     change,
+						})
+					}
+				}
+			}
+			line = append(line, baseValue, currentValue, delta, percent)
+		}
+		comparison = append(comparison, append(line, strings.Join(regressions, "; "), onlyIn))
+	}
+	for baseRowIndex, row := range baseline {
+		if baseRowIndex == 0 || matched[baseRowIndex] {
+			continue
+		}
+		line := []string{}
+		for _, key := range options.Keys {
+			line = append(line, cell(row, baseIndex[key]))
+		}
+		for _, output := range outputs {
+			line = append(line, cell(row, baseIndex[output]), "", "", "")
+		}
+		comparison = append(comparison, append(line, "", "baseline"))
+	}
+	return comparison, all, nil
+}
+
+// unmatchedRows returns how many comparison rows are only in the baseline
+// and only in the current result.
+func unmatchedRows(comparison [][]string) (int, int) {
+	baseline, current := 0, 0
+	for _, row := range comparison[1:] {
+		switch row[len(row)-1] {
+		case "baseline":
+			baseline++
+		case "current":
+			current++
+		}
+	}
+	return baseline, current
+}
+
+// ReadVarNames returns the variable names defined in the inputs tab.
+func ReadVarNames(source Source, inputsSheet string) ([]string, error) {
+	setupRows, err := source.ReadRows(inputsSheet)
+	if err != nil {
+		return nil, err
+	}
+	layout := ParseSetupLayout(setupRows)
+	setupRows, _ = layout.Rows(setupRows)
+	setupRows, derived := SplitDerivedRows(setupRows)
+	varNames, _, err := GetVarsExamplesSets(setupRows)
+	if err != nil {
+		return nil, err
+	}
+	for _, derivedVar := range derived {
+		varNames = append(varNames, derivedVar.Name)
+	}
+	return varNames, nil
+}
+
+// WriteRows records rows (header first) in a new tab named sheetName next
+// to the inputs, or in runsDir/sheetName.csv with -no-write-sheet.
+func WriteRows(srv *sheets.Service, spreadsheet, sheetName string, rows [][]string) error {
+	output := DefaultOutput(spreadsheet)
+	if output == "csv" {
+		if err := os.MkdirAll(runsDir, 0755); err != nil {
+			return fmt.Errorf("Unable to create %s: %v", runsDir, err)
+		}
+		output = "csv:" + filepath.Join(runsDir, sheetName+".csv")
+	}
+	sink, err := OpenSink(output, &SinkContext{
+		Service:       srv,
+		SpreadsheetID: spreadsheet,
+		RunName:       sheetName,
+	})
+	if err != nil {
+		return err
+	}
+	if err := sink.WriteHeader(rows[0]); err != nil {
+		return err
+	}
+	for _, row := range rows[1:] {
+		if err := sink.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	if err := sink.Close(); err != nil {
+		return err
+	}
+	return FinalizeSinks([]Sink{sink})
+}
+
+// sharedVarNames returns the input variables of the run of baseline that
+// the run of current has too.
+func sharedVarNames(source Source, baseline, current string) ([]string, error) {
+	baseVars, err := RunVarNames(source, baseline)
+	if err != nil {
+		return nil, err
+	}
+	currentVars, err := RunVarNames(source, current)
+	if err != nil {
+		return nil, err
+	}
+	isCurrentVar := map[string]bool{}
+	for _, varName := range currentVars {
+		isCurrentVar[varName] = true
+	}
+	shared := []string{}
+	for _, varName := range baseVars {
+		if isCurrentVar[varName] {
+			shared = append(shared, varName)
+		}
+	}
+	if len(shared) == 0 {
+		return nil, fmt.Errorf("%s and %s share no input variable", baseline, current)
+	}
+	return shared, nil
+}
+
+// DiffCommand implements "blackbox diff": it compares two result tabs and
+// writes a comparison tab highlighting regressions.
+func DiffCommand(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	baseline := flags.String("baseline", "", "result tab to compare against")
+	current := flags.String("current", "", "result tab to compare")
+	key := flags.String("key", "", "comma separated input columns to join on (default: the input variables shared by both runs, from their meta tabs)")
+	threshold := flags.Float64("threshold", 10, "percentage change in the worse direction counted as a regression")
+	maximize := flags.String("maximize", "", "comma separated outputs for which higher is better")
+	annotate := flags.String("annotate", "", "also point out regressions in the baseline tab: \"notes\" on the regressed cells, or a \"column\" listing the regressions of each row")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox diff -baseline TAB -current TAB [flags] SPREADSHEET_ID|FILE.xlsx\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 || *baseline == "" || *current == "" {
+		flags.Usage()
+		return fmt.Errorf("spreadsheet, baseline or current param is missing")
+	}
+	if *annotate != "" && *annotate != "notes" && *annotate != "column" {
+		return fmt.Errorf("Invalid annotations %q, expected notes or column", *annotate)
+	}
+	spreadsheet := SpreadsheetID(flags.Arg(0))
+
+	var srv *sheets.Service
+	if IsGoogleSpreadsheet(spreadsheet) {
+		var err error
+		if srv, err = Auth(); err != nil {
+			return err
+		}
+	}
+	source, err := OpenSource(srv, spreadsheet)
+	if err != nil {
+		return err
+	}
+
+	options := DiffOptions{Keys: ExtractExamples(*key), Threshold: *threshold, Maximize: map[string]bool{}}
+	for _, output := range ExtractExamples(*maximize) {
+		options.Maximize[output] = true
+	}
+	if len(options.Keys) == 0 {
+		if options.Keys, err = sharedVarNames(source, *baseline, *current); err != nil {
+			return fmt.Errorf("Unable to find the input columns, use -key: %v", err)
+		}
+	}
+
+	baseRows, err := source.ReadRows(*baseline)
+	if err != nil {
+		return err
+	}
+	currentRows, err := source.ReadRows(*current)
+	if err != nil {
+		return err
+	}
+	comparison, regressions, err := CompareResults(baseRows, currentRows, options)
+	if err != nil {
+		return err
+	}
+
+	sheetName := fmt.Sprintf("diff_%d", time.Now().Unix())
+	if err := WriteRows(srv, spreadsheet, sheetName, comparison); err != nil {
+		return err
+	}
+	onlyBaseline, onlyCurrent := unmatchedRows(comparison)
+	Log.Infof("Wrote %s: %d of %d rows regressed beyond %g%%\n", sheetName, regressedRows(regressions),
+		len(comparison)-1-onlyBaseline-onlyCurrent, *threshold)
+	if onlyBaseline > 0 || onlyCurrent > 0 {
+		Log.Warnf("%d rows are only in %s and %d only in %s\n", onlyBaseline, *baseline, onlyCurrent, *current)
+	}
+	if *annotate != "" {
+		if srv == nil {
+			return fmt.Errorf("Annotations need a Google spreadsheet")
+		}
+		if err := AnnotateBaseline(srv, spreadsheet, *baseline, *current, baseRows[0], regressions, *annotate); err != nil {
+			return err
+		}
+		Log.Infof("Annotated %d regressions in %s\n", len(regressions), *baseline)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package blackbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareResults(t *testing.T) {
+	baseline := [][]string{
+		{"size", "latency_ms", "error"},
+		{"1", "100", ""},
+		{"2", "200", ""},
+		{"3", "300", ""},
+	}
+	current := [][]string{
+		{"size", "latency_ms", "error"},
+		{"2", "250", ""},
+		{"1", "100", ""},
+		{"4", "400", ""},
+	}
+	comparison, regressions, err := CompareResults(baseline, current, DiffOptions{Keys: []string{"size"}, Threshold: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"size", "latency_ms baseline", "latency_ms current", "latency_ms delta", "latency_ms %", "regressions", "only_in"},
+		{"2", "200", "250", "50", "25.0", "latency_ms +25.0%", ""},
+		{"1", "100", "100", "0", "0.0", "", ""},
+		{"4", "", "400", "", "", "", "current"},
+		{"3", "300", "", "", "", "", "baseline"},
+	}
+	if !reflect.DeepEqual(comparison, want) {
+		t.Errorf("CompareResults = %q, want %q", comparison, want)
+	}
+	wantRegressions := []Regression{{Output: "latency_ms", BaseRow: 2, CurrentRow: 1, Current: "250", Change: 25}}
+	if !reflect.DeepEqual(regressions, wantRegressions) {
+		t.Errorf("CompareResults regressions = %+v, want %+v", regressions, wantRegressions)
+	}
+	if onlyBaseline, onlyCurrent := unmatchedRows(comparison); onlyBaseline != 1 || onlyCurrent != 1 {
+		t.Errorf("unmatchedRows = %d, %d, want 1, 1", onlyBaseline, onlyCurrent)
+	}
+
+	if _, _, err := CompareResults(baseline, current, DiffOptions{Keys: []string{"rate"}}); err == nil {
+		t.Errorf("CompareResults on a missing key column succeeded")
+	}
+}
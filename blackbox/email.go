@@ -0,0 +1,118 @@
+package blackbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const emailPrefix = "email:"
+
+// SMTP settings of email notifications, e.g. BLACKBOX_SMTP_ADDR of
+// smtp.example.com:587. Without a user, mail is sent unauthenticated, as to
+// a local relay.
+var (
+	smtpAddr     = GetVariableOrDefault("BLACKBOX_SMTP_ADDR", "localhost:25")
+	smtpUser     = GetVariableOrDefault("BLACKBOX_SMTP_USER", "")
+	smtpPassword = GetVariableOrDefault("BLACKBOX_SMTP_PASSWORD", "")
+	smtpFrom     = GetVariableOrDefault("BLACKBOX_SMTP_FROM", "blackbox@localhost")
+)
+
+// AttachesResults reports whether a notification target needs the
+// recorded results, to attach them.
+func AttachesResults(targets []string) bool {
+	for _, target := range targets {
+		if strings.HasPrefix(target, emailPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendSummaryEmail mails the summary of a run to recipients, linking the
+// result spreadsheet and attaching the results as CSV, for sharing a sweep
+// with people who do not run blackbox.
+func SendSummaryEmail(recipients []string, summary RunSummary) error {
+	to := []string{}
+	for _, recipient := range recipients {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			to = append(to, recipient)
+		}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("No recipient to email the run summary to")
+	}
+	message, err := summaryEmail(to, summary)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if smtpUser != "" {
+		host, _, err := net.SplitHostPort(smtpAddr)
+		if err != nil {
+			return fmt.Errorf("Invalid BLACKBOX_SMTP_ADDR %q: %v", smtpAddr, err)
+		}
+		auth = smtp.PlainAuth("", smtpUser, smtpPassword, host)
+	}
+	if err := smtp.SendMail(smtpAddr, auth, smtpFrom, to, message); err != nil {
+		return fmt.Errorf("Unable to email the run summary to %s: %v", strings.Join(to, ", "), err)
+	}
+	return nil
+}
+
+// summaryEmail renders the summary as a MIME message with the results
+// attached as <run>.csv.
+func summaryEmail(to []string, summary RunSummary) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	text, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(text, "The blackbox run %s (%s) finished with status %s.\r\n\r\n", summary.Run, summary.RunID, summary.Status)
+	fmt.Fprintf(text, "Runs: %d\r\nFailed: %d\r\nDuration: %v\r\n\r\n", summary.Runs, summary.Failures,
+		(time.Duration(summary.Duration) * time.Second).Round(time.Second))
+	fmt.Fprintf(text, "Results: %s\r\n", summary.Results)
+	if len(summary.recorded) > 0 {
+		var results bytes.Buffer
+		w := csv.NewWriter(&results)
+		w.WriteAll(summary.recorded)
+		if err := w.Error(); err != nil {
+			return nil, fmt.Errorf("Unable to export the results as CSV: %v", err)
+		}
+		attachment, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/csv; charset=utf-8"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", summary.Run+".csv")},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(results.Bytes())
+		for len(encoded) > 76 {
+			fmt.Fprintf(attachment, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(attachment, "%s\r\n", encoded)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", smtpFrom)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: blackbox run %s: %d runs, %d failed\r\n", summary.Run, summary.Runs, summary.Failures)
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
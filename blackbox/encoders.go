@@ -0,0 +1,119 @@
+package blackbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Encoder renders result values, received as strings, for a sink.
+type Encoder interface {
+	// Kind returns the column kind of a value, for sinks with typed columns.
+	Kind(value string) ValueKind
+	// Encode converts a value of a column of the given kind. It returns
+	// false if the value does not fit the kind.
+	Encode(kind ValueKind, value string) (interface{}, bool)
+}
+
+// EncodingSink is implemented by sinks whose values can be rendered by
+// another encoder than their default one.
+type EncodingSink interface {
+	SetEncoder(encoder Encoder)
+}
+
+// StringEncoder keeps values as strings.
+type StringEncoder struct{}
+
+func (StringEncoder) Kind(value string) ValueKind {
+	return KindString
+}
+
+func (StringEncoder) Encode(kind ValueKind, value string) (interface{}, bool) {
+	return value, true
+}
+
+// TypedEncoder turns values into numbers and booleans when they look like
+// ones.
+type TypedEncoder struct{}
+
+func (TypedEncoder) Kind(value string) ValueKind {
+	return InferValueKind(value)
+}
+
+func (TypedEncoder) Encode(kind ValueKind, value string) (interface{}, bool) {
+	return ParseValue(kind, value)
+}
+
+// SheetsEncoder turns URLs into HYPERLINK formulas and leaves the other
+// values, including formulas, for Sheets to interpret as if typed in.
+type SheetsEncoder struct{}
+
+func (SheetsEncoder) Kind(value string) ValueKind {
+	return KindString
+}
+
+func (SheetsEncoder) Encode(kind ValueKind, value string) (interface{}, bool) {
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		return fmt.Sprintf(`=HYPERLINK("%s")`, strings.ReplaceAll(value, `"`, `""`)), true
+	}
+	return value, true
+}
+
+// LiteralEncoder keeps Sheets from interpreting values, so that e.g. "007"
+// or "1/2" are recorded as sent rather than as a number or a date.
+type LiteralEncoder struct{}
+
+func (LiteralEncoder) Kind(value string) ValueKind {
+	return KindString
+}
+
+func (LiteralEncoder) Encode(kind ValueKind, value string) (interface{}, bool) {
+	if value == "" {
+		return value, true
+	}
+	return "'" + value, true
+}
+
+// encoders are the encoders configurable per output kind, e.g. in an
+// experiment config:
+//
+//	"encoders": {"sheets": "literal", "parquet": "string"}
+var encoders = map[string]Encoder{
+	"string":  StringEncoder{},
+	"typed":   TypedEncoder{},
+	"sheets":  SheetsEncoder{},
+	"literal": LiteralEncoder{},
+}
+
+// defaultEncoders are the encoders of each output kind unless configured.
+var defaultEncoders = map[string]string{
+	"sheets":   "sheets",
+	"csv":      "string",
+	"bq":       "typed",
+	"parquet":  "typed",
+	"xlsx":     "typed",
+	"postgres": "typed",
+	"mysql":    "typed",
+}
+
+// EncoderFor returns the encoder configured for an output kind, nil for
+// kinds without one.
+func EncoderFor(kind string, configured map[string]string) (Encoder, error) {
+	name, ok := configured[kind]
+	if !ok {
+		name = defaultEncoders[kind]
+	}
+	if name == "" {
+		return nil, nil
+	}
+	encoder, ok := encoders[name]
+	if !ok {
+		names := []string{}
+		for name := range encoders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown encoder %q for %s, expected one of %s", name, kind, strings.Join(names, ", "))
+	}
+	return encoder, nil
+}
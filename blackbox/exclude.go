@@ -0,0 +1,102 @@
+package blackbox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Input combinations to skip, e.g. known crashes or cases covered by hand,
+// are listed in this optional tab under a header of variable names:
+//
+//	size | mode
+//	0    | tls
+//	1000 |
+//
+// A blank cell matches any value, so that the second row skips every input
+// set of size 1000.
+const excludeSheet = "exclude"
+
+// Exclusion is an input combination to skip, by variable name.
+type Exclusion map[string]string
+
+// ReadExclusions returns the combinations of the exclude tab, if any.
+func ReadExclusions(source Source) ([]Exclusion, error) {
+	rows, err := ReadOptionalRows(source, excludeSheet)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	header := rows[0]
+	exclusions := []Exclusion{}
+	for _, row := range rows[1:] {
+		exclusion := Exclusion{}
+		for i, column := range header {
+			if column = strings.TrimSpace(column); column != "" && strings.TrimSpace(cell(row, i)) != "" {
+				exclusion[column] = strings.TrimSpace(cell(row, i))
+			}
+		}
+		if len(exclusion) > 0 {
+			exclusions = append(exclusions, exclusion)
+		}
+	}
+	return exclusions, nil
+}
+
+// ExcludeInputSets drops the input sets matching any of the exclusions.
+func ExcludeInputSets(varNames []string, inputSets [][]string, exclusions []Exclusion) ([][]string, error) {
+	if len(exclusions) == 0 {
+		return inputSets, nil
+	}
+	index := map[string]int{}
+	for i, varName := range varNames {
+		index[varName] = i
+	}
+	for _, exclusion := range exclusions {
+		for varName := range exclusion {
+			if _, ok := index[varName]; !ok {
+				return nil, fmt.Errorf("Exclusion of unknown variable %s", varName)
+			}
+		}
+	}
+
+	result := [][]string{}
+	for _, inputSet := range inputSets {
+		excluded := false
+		for _, exclusion := range exclusions {
+			matches := true
+			for varName, value := range exclusion {
+				if inputSet[index[varName]] != value {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			result = append(result, inputSet)
+		}
+	}
+	Log.Infof("Exclusions skipped %d of %d input sets\n", len(inputSets)-len(result), len(inputSets))
+	return result, nil
+}
+
+// DedupeInputSets drops the repeats of input sets, e.g. from an example
+// listed twice, keeping the first one.
+func DedupeInputSets(inputSets [][]string) [][]string {
+	seen := map[string]bool{}
+	result := [][]string{}
+	for _, inputSet := range inputSets {
+		key := strings.Join(inputSet, "\x00")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, inputSet)
+	}
+	if len(result) < len(inputSets) {
+		Log.Infof("Dropped %d repeated input sets\n", len(inputSets)-len(result))
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+package blackbox
+
+import (
+	"fmt"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Expressions (constraints and friends) use expr-lang syntax, see
+// https://expr-lang.org. Variables are addressed by name; names that are not
+// identifiers, like meta-variables, are also available sanitized, e.g.
+// "@timeout" as _timeout.
+
+// ValueEnv exposes named values to expressions, typing values that look like
+// numbers or booleans so that e.g. "min <= max" compares numerically.
+func ValueEnv(names, values []string) map[string]interface{} {
+	env := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		if i >= len(values) {
+			break
+		}
+		value, _ := ParseValue(InferValueKind(values[i]), values[i])
+		env[name] = value
+		env[sanitizeIdentifier(name)] = value
+	}
+	return env
+}
+
+// Expression is a compiled expression together with its source text.
+type Expression struct {
+	Text    string
+	program *vm.Program
+}
+
+// CompileExpression compiles an expression that may only refer to names.
+func CompileExpression(text string, names []string) (*Expression, error) {
+	declared := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		declared[name] = nil
+		declared[sanitizeIdentifier(name)] = nil
+	}
+	program, err := expr.Compile(text, expr.Env(declared))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid expression %q: %v", text, err)
+	}
+	return &Expression{Text: text, program: program}, nil
+}
+
+func (e *Expression) Eval(env map[string]interface{}) (interface{}, error) {
+	return expr.Run(e.program, env)
+}
+
+// EvalBool evaluates an expression that must produce a boolean.
+func (e *Expression) EvalBool(env map[string]interface{}) (bool, error) {
+	result, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("Expression %q returned %v, not a boolean", e.Text, result)
+	}
+	return b, nil
+}
+
+// EvalNumber evaluates an expression that must produce a number.
+func (e *Expression) EvalNumber(env map[string]interface{}) (float64, error) {
+	result, err := e.Eval(env)
+	if err != nil {
+		return 0, err
+	}
+	switch n := result.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("Expression %q returned %v, not a number", e.Text, result)
+}
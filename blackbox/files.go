@@ -0,0 +1,60 @@
+package blackbox
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Examples starting with filePrefix are globs of files, e.g.
+// "@testdata/*.png", each matching file being an example whose path is
+// sent to the program; with contentPrefix, e.g. "@@testdata/*.png", the
+// program gets the base64 content of the file instead, in the JSON of
+// its inputs. Either way the path is what results record.
+const (
+	filePrefix    = "@"
+	contentPrefix = "@@"
+)
+
+// ExpandFileExamples replaces the file globs of examples by the paths of
+// the matching files, telling whether the variable sends their content.
+func ExpandFileExamples(examples []string) ([]string, bool, error) {
+	expanded := []string{}
+	content := 0
+	for _, example := range examples {
+		if !strings.HasPrefix(example, filePrefix) {
+			expanded = append(expanded, example)
+			continue
+		}
+		pattern := strings.TrimPrefix(example, filePrefix)
+		if strings.HasPrefix(example, contentPrefix) {
+			pattern = strings.TrimPrefix(example, contentPrefix)
+			content++
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, false, fmt.Errorf("Invalid file pattern %q: %v", pattern, err)
+		}
+		if len(paths) == 0 {
+			return nil, false, fmt.Errorf("No file matches %q", pattern)
+		}
+		sort.Strings(paths)
+		expanded = append(expanded, paths...)
+	}
+	if content > 0 && content < len(examples) {
+		return nil, false, fmt.Errorf("Examples sending file contents (%s) cannot be mixed with others", contentPrefix)
+	}
+	return expanded, content > 0, nil
+}
+
+// FileContent returns the base64 content of a file input.
+func FileContent(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read input file: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
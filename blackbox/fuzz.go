@@ -0,0 +1,200 @@
+package blackbox
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fuzzStrategy runs random input sets drawn from a generator per
+// variable, see ParseGenerator, recording the crashes and assertion
+// failures they cause.
+const fuzzStrategy = "fuzz"
+
+// Default number of input sets generated by the fuzz strategy.
+const DefaultFuzzRuns = 100
+
+// Generator draws a random value of a variable.
+type Generator func(rng *rand.Rand) string
+
+var generatorRegexp = regexp.MustCompile(`^\s*(\w+)\s*(?:\((.*)\))?\s*$`)
+
+// Default bounds of timestamp generators.
+var (
+	defaultTimestampFrom = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	defaultTimestampTo   = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// ParseGenerator parses the generator column of a variable row:
+//
+//	int(MIN, MAX)               an integer, bounds included
+//	float(MIN, MAX)             a number
+//	bool                        true or false
+//	string(ALPHABET, MIN, MAX)  MIN to MAX characters of ALPHABET, e.g. a-z0-9_
+//	enum(A, B, ...)             one of the values
+//	uuid                        a random (version 4) UUID
+//	timestamp(FROM, TO)         an RFC 3339 time, between dates or times
+func ParseGenerator(text string) (Generator, error) {
+	match := generatorRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return nil, fmt.Errorf("Invalid generator %q, expected e.g. int(0, 100)", text)
+	}
+	name, args := strings.ToLower(match[1]), ExtractExamples(match[2])
+	arity := map[string][]int{"int": {2}, "float": {2}, "bool": {0}, "string": {1, 3}, "uuid": {0}, "timestamp": {0, 2}}
+	if counts, ok := arity[name]; ok {
+		valid := false
+		for _, count := range counts {
+			valid = valid || len(args) == count
+		}
+		if !valid {
+			return nil, fmt.Errorf("Invalid generator %q: %s takes %v arguments", text, name, counts)
+		}
+	}
+	switch name {
+	case "int":
+		min, errMin := strconv.ParseInt(args[0], 10, 64)
+		max, errMax := strconv.ParseInt(args[1], 10, 64)
+		if errMin != nil || errMax != nil || max < min {
+			return nil, fmt.Errorf("Invalid generator %q: expected integer bounds", text)
+		}
+		return func(rng *rand.Rand) string {
+			return strconv.FormatInt(min+rng.Int63n(max-min+1), 10)
+		}, nil
+	case "float":
+		min, errMin := strconv.ParseFloat(args[0], 64)
+		max, errMax := strconv.ParseFloat(args[1], 64)
+		if errMin != nil || errMax != nil || max < min {
+			return nil, fmt.Errorf("Invalid generator %q: expected number bounds", text)
+		}
+		return func(rng *rand.Rand) string {
+			return FormatValue(min + rng.Float64()*(max-min))
+		}, nil
+	case "bool":
+		return func(rng *rand.Rand) string {
+			return strconv.FormatBool(rng.Intn(2) == 1)
+		}, nil
+	case "string":
+		alphabet := expandAlphabet(args[0])
+		min, max := 0, 16
+		if len(args) == 3 {
+			var errMin, errMax error
+			min, errMin = strconv.Atoi(args[1])
+			max, errMax = strconv.Atoi(args[2])
+			if errMin != nil || errMax != nil || min < 0 || max < min {
+				return nil, fmt.Errorf("Invalid generator %q: expected length bounds", text)
+			}
+		}
+		if len(alphabet) == 0 {
+			return nil, fmt.Errorf("Invalid generator %q: empty alphabet", text)
+		}
+		return func(rng *rand.Rand) string {
+			value := make([]rune, min+rng.Intn(max-min+1))
+			for i := range value {
+				value[i] = alphabet[rng.Intn(len(alphabet))]
+			}
+			return string(value)
+		}, nil
+	case "enum":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("Invalid generator %q: no values", text)
+		}
+		return enumGenerator(args), nil
+	case "uuid":
+		return func(rng *rand.Rand) string {
+			b := make([]byte, 16)
+			rng.Read(b)
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+		}, nil
+	case "timestamp":
+		from, to := defaultTimestampFrom, defaultTimestampTo
+		if len(args) == 2 {
+			var errFrom, errTo error
+			from, errFrom = parseGeneratorTime(args[0])
+			to, errTo = parseGeneratorTime(args[1])
+			if errFrom != nil || errTo != nil || !to.After(from) {
+				return nil, fmt.Errorf("Invalid generator %q: expected dates or RFC 3339 times", text)
+			}
+		}
+		return func(rng *rand.Rand) string {
+			return from.Add(time.Duration(rng.Int63n(int64(to.Sub(from))))).Format(time.RFC3339)
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown generator %q, expected int, float, bool, string, enum, uuid or timestamp", name)
+}
+
+func enumGenerator(values []string) Generator {
+	return func(rng *rand.Rand) string {
+		return values[rng.Intn(len(values))]
+	}
+}
+
+func parseGeneratorTime(text string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", text); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, text)
+}
+
+// expandAlphabet expands the ranges of an alphabet, e.g. a-c_ to abc_.
+func expandAlphabet(text string) []rune {
+	chars := []rune(text)
+	alphabet := []rune{}
+	for i := 0; i < len(chars); i++ {
+		if i+2 < len(chars) && chars[i+1] == '-' && chars[i] <= chars[i+2] {
+			for c := chars[i]; c <= chars[i+2]; c++ {
+				alphabet = append(alphabet, c)
+			}
+			i += 2
+			continue
+		}
+		alphabet = append(alphabet, chars[i])
+	}
+	return alphabet
+}
+
+// FuzzInputSets draws runs input sets of the variable rows of an inputs
+// tab, each variable from its generator, or else among its examples.
+func FuzzInputSets(setupRows [][]string, generators map[string]string, runs int, rng *rand.Rand) ([]string, [][]string, error) {
+	if runs < 1 {
+		runs = DefaultFuzzRuns
+	}
+	varNames := []string{}
+	draws := []Generator{}
+	for i, setupRow := range setupRows {
+		varName, varType := ParseVarCell(setupRow[0])
+		if varName == "" {
+			return nil, nil, fmt.Errorf("Could not extract var name from row %d", i)
+		}
+		var generator Generator
+		if text := generators[varName]; text != "" {
+			var err error
+			if generator, err = ParseGenerator(text); err != nil {
+				return nil, nil, fmt.Errorf("Invalid generator of %s: %v", varName, err)
+			}
+		} else {
+			examples, err := ExtractTypedExamples(varType, setupRow[1])
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid examples of %s in row %d: %v", varName, i, err)
+			}
+			if len(examples) == 0 {
+				return nil, nil, fmt.Errorf("Neither a generator nor examples for %s", varName)
+			}
+			generator = enumGenerator(examples)
+		}
+		varNames = append(varNames, varName)
+		draws = append(draws, generator)
+	}
+	inputSets := make([][]string, runs)
+	for i := range inputSets {
+		inputSets[i] = make([]string, len(draws))
+		for j, draw := range draws {
+			inputSets[i][j] = draw(rng)
+		}
+	}
+	return varNames, inputSets, nil
+}
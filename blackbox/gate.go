@@ -0,0 +1,235 @@
+package blackbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Exit status of a run whose results regressed, told apart from errors by
+// CI pipelines.
+const GateExitCode = 3
+
+// Offending rows printed per failed gate.
+const gateRowsShown = 20
+
+// GateRule fails a completed run when a statistic of its results compares
+// to a threshold, which may refer to the same statistic of a baseline
+// run, e.g. "p95(latency_ms) > baseline*1.1" or "failure_rate > 0.01".
+// Statistics are those of abort rules.
+type GateRule struct {
+	Text      string
+	Stat      string
+	Output    string
+	Op        string
+	threshold *Expression
+}
+
+var gateRuleRegexp = regexp.MustCompile(`^\s*(\w+)(?:\(\s*([^()\s]+)\s*\))?\s*(<=|>=|==|!=|<|>)\s*(.+?)\s*$`)
+
+var baselineRegexp = regexp.MustCompile(`\bbaseline\b`)
+
+func ParseGateRule(text string) (GateRule, error) {
+	match := gateRuleRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return GateRule{}, fmt.Errorf("Unable to parse rule %q, expected e.g. \"p95(latency_ms) > baseline*1.1\"", text)
+	}
+	rule := GateRule{Text: strings.TrimSpace(text), Stat: match[1], Output: match[2], Op: match[3]}
+	if err := checkStat(rule.Stat, rule.Output, text); err != nil {
+		return rule, err
+	}
+	threshold, err := CompileExpression(match[4], []string{"baseline"})
+	if err != nil {
+		return rule, fmt.Errorf("Invalid threshold in rule %q: %v", text, err)
+	}
+	rule.threshold = threshold
+	return rule, nil
+}
+
+// ParseGateRules parses semicolon separated rules.
+func ParseGateRules(text string) ([]GateRule, error) {
+	rules := []GateRule{}
+	for _, ruleText := range strings.Split(text, ";") {
+		if strings.TrimSpace(ruleText) == "" {
+			continue
+		}
+		rule, err := ParseGateRule(ruleText)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// UsesBaseline reports whether the threshold of the rule refers to the
+// baseline.
+func (r GateRule) UsesBaseline() bool {
+	return baselineRegexp.MatchString(r.threshold.Text)
+}
+
+// Check returns why the rule fails the results of stats, compared to the
+// baseline if any, and the threshold it was compared to.
+func (r GateRule) Check(stats, baseline *RunStats) (string, float64, error) {
+	env := map[string]interface{}{}
+	if r.UsesBaseline() {
+		if baseline == nil {
+			return "", 0, fmt.Errorf("Rule %q needs a -baseline", r.Text)
+		}
+		value, ok := baseline.Stat(r.Stat, r.Output)
+		if !ok {
+			return "", 0, fmt.Errorf("Baseline has no %s of %s for rule %q", r.Stat, r.Output, r.Text)
+		}
+		env["baseline"] = value
+	}
+	threshold, err := r.threshold.EvalNumber(env)
+	if err != nil {
+		return "", 0, err
+	}
+	value, ok := stats.Stat(r.Stat, r.Output)
+	if !ok || !compare(value, r.Op, threshold) {
+		return "", threshold, nil
+	}
+	name := r.Stat
+	if r.Output != "" {
+		name = fmt.Sprintf("%s(%s)", r.Stat, r.Output)
+	}
+	return fmt.Sprintf("%s (%s=%.4g, threshold %.4g)", r.Text, name, value, threshold), threshold, nil
+}
+
+// offends reports whether a result row contributes to the failure of the
+// rule: a failed run for failure statistics, or an output beyond the
+// threshold.
+func (r GateRule) offends(index map[string]int, row []string, threshold float64) bool {
+	switch r.Stat {
+	case "runs":
+		return false
+	case "failures", "failure_rate":
+		column, ok := index[errorColumn]
+		return ok && cell(row, column) != ""
+	}
+	column, ok := index[r.Output]
+	if !ok {
+		return false
+	}
+	value, err := strconv.ParseFloat(cell(row, column), 64)
+	return err == nil && compare(value, r.Op, threshold)
+}
+
+// StatsOfRows aggregates recorded results, header first, as RunStats.
+func StatsOfRows(rows [][]string) *RunStats {
+	stats := NewRunStats()
+	if len(rows) == 0 {
+		return stats
+	}
+	header := rows[0]
+	errors, hasErrors := columnIndex(header)[errorColumn]
+	for _, row := range rows[1:] {
+		if hasErrors && cell(row, errors) != "" {
+			stats.Add(nil, true)
+			continue
+		}
+		outputs := map[string]string{}
+		for i, column := range header {
+			if !isBookkeepingColumn(column) {
+				outputs[column] = cell(row, i)
+			}
+		}
+		stats.Add(outputs, false)
+	}
+	return stats
+}
+
+// rowLabel describes a result row, e.g. "size=10 latency_ms=12.5".
+func rowLabel(header, row []string) string {
+	parts := []string{}
+	for i, column := range header {
+		if value := cell(row, i); value != "" {
+			parts = append(parts, column+"="+value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// GateError is returned for a run whose results fail gate rules.
+type GateError struct {
+	Reasons []string
+}
+
+func (e *GateError) Error() string {
+	return "Results regressed: " + strings.Join(e.Reasons, "; ")
+}
+
+// ReadBaseline reads the results of a baseline run: a result tab of the
+// spreadsheet, or a CSV file.
+func ReadBaseline(srv *sheets.Service, spreadsheetID, baseline string) ([][]string, error) {
+	if strings.HasSuffix(baseline, ".csv") {
+		content, err := ioutil.ReadFile(baseline)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read baseline: %v", err)
+		}
+		return parseCSV(content)
+	}
+	source, err := OpenSource(srv, spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := source.ReadRows(baseline)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read baseline %s: %v", baseline, err)
+	}
+	return rows, nil
+}
+
+// CheckGates evaluates the gate rules of an experiment over its recorded
+// results, printing the rows offending the rules that fail.
+func CheckGates(srv *sheets.Service, spreadsheetID string, experiment Experiment, recorded [][]string) error {
+	rules, err := ParseGateRules(experiment.FailIf)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	var baseline *RunStats
+	if experiment.Baseline != "" {
+		rows, err := ReadBaseline(srv, spreadsheetID, experiment.Baseline)
+		if err != nil {
+			return err
+		}
+		baseline = StatsOfRows(rows)
+	}
+	stats := StatsOfRows(recorded)
+	reasons := []string{}
+	for _, rule := range rules {
+		reason, threshold, err := rule.Check(stats, baseline)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			continue
+		}
+		reasons = append(reasons, reason)
+		Log.Errorf("Failed %s\n", reason)
+		if len(recorded) == 0 {
+			continue
+		}
+		index := columnIndex(recorded[0])
+		shown := 0
+		for _, row := range recorded[1:] {
+			if !rule.offends(index, row, threshold) {
+				continue
+			}
+			if shown++; shown > gateRowsShown {
+				Log.Errorf("  ...\n")
+				break
+			}
+			Log.Errorf("  %s\n", rowLabel(recorded[0], row))
+		}
+	}
+	if len(reasons) > 0 {
+		return &GateError{Reasons: reasons}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package blackbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Golden holds the recorded outputs of a program by input set, e.g.
+//
+//	{"size=10 mode=fast": {"latency_ms": "12", "error": ""}}
+//
+// Errors are outputs too: a run that used to fail must still fail the same
+// way.
+type Golden map[string]map[string]string
+
+// GoldenSink records the outputs of a run to a golden file, or compares
+// them to those of the golden file if it exists, failing the run with a
+// GateError when outputs differ, to characterize the behavior of a
+// program and catch changes to it.
+type GoldenSink struct {
+	path     string
+	varNames []string
+	header   []string
+	results  Golden
+	// Input labels in the order of the runs
+	labels []string
+}
+
+func NewGoldenSink(path string, sinkContext *SinkContext) *GoldenSink {
+	return &GoldenSink{path: path, varNames: sinkContext.VarNames, results: Golden{}}
+}
+
+// GoldenPath returns the golden file of a named experiment of a campaign,
+// e.g. golden_fib.json for golden.json.
+func GoldenPath(path, name string) string {
+	if path == "" || name == "" {
+		return path
+	}
+	extension := filepath.Ext(path)
+	return strings.TrimSuffix(path, extension) + "_" + name + extension
+}
+
+func (s *GoldenSink) WriteHeader(header []string) error {
+	s.header = header
+	return nil
+}
+
+func (s *GoldenSink) WriteRow(row []string) error {
+	n := len(s.varNames)
+	if n > len(row) {
+		n = len(row)
+	}
+	label := inputLabel(s.varNames[:n], row[:n])
+	outputs := map[string]string{}
+	for i := n; i < len(s.header); i++ {
+		if column := s.header[i]; column != assertionsColumn && !isRunSpecificColumn(column) {
+			outputs[column] = cell(row, i)
+		}
+	}
+	if _, ok := s.results[label]; !ok {
+		s.labels = append(s.labels, label)
+	}
+	s.results[label] = outputs
+	return nil
+}
+
+func (s *GoldenSink) Close() error {
+	return nil
+}
+
+// Finalize records the golden file on the first run and compares to it on
+// later ones.
+func (s *GoldenSink) Finalize() error {
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s.record()
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read golden file: %v", err)
+	}
+	golden := Golden{}
+	if err := json.Unmarshal(content, &golden); err != nil {
+		return fmt.Errorf("Unable to parse golden file %s: %v", s.path, err)
+	}
+	differing, missing := 0, 0
+	for _, label := range s.labels {
+		expected, ok := golden[label]
+		if !ok {
+			missing++
+			Log.Warnf("No golden outputs for %s\n", label)
+			continue
+		}
+		differences := DiffOutputs(expected, s.results[label])
+		if len(differences) == 0 {
+			continue
+		}
+		if differing++; differing <= gateRowsShown {
+			Log.Errorf("%s: %s\n", label, strings.Join(differences, ", "))
+		} else if differing == gateRowsShown+1 {
+			Log.Errorf("...\n")
+		}
+	}
+	if missing > 0 {
+		Log.Warnf("%d input sets are not in %s, delete it to record them\n", missing, s.path)
+	}
+	if differing > 0 {
+		return &GateError{Reasons: []string{fmt.Sprintf("%d of %d input sets differ from %s", differing, len(s.labels), s.path)}}
+	}
+	Log.Infof("Outputs of %d input sets match %s\n", len(s.labels)-missing, s.path)
+	return nil
+}
+
+func (s *GoldenSink) record() error {
+	content, err := json.MarshalIndent(s.results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("Unable to write golden file: %v", err)
+	}
+	Log.Infof("Recorded the outputs of %d input sets to %s\n", len(s.results), s.path)
+	return nil
+}
+
+// DiffOutputs describes the outputs that differ from the expected ones,
+// e.g. `latency_ms: "12" -> "15"`.
+func DiffOutputs(expected, actual map[string]string) []string {
+	columns := []string{}
+	for column := range expected {
+		columns = append(columns, column)
+	}
+	for column := range actual {
+		if _, ok := expected[column]; !ok {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+	differences := []string{}
+	for _, column := range columns {
+		want, hadIt := expected[column]
+		got, hasIt := actual[column]
+		switch {
+		case !hadIt:
+			differences = append(differences, fmt.Sprintf("%s: new %q", column, got))
+		case !hasIt:
+			differences = append(differences, fmt.Sprintf("%s: missing, was %q", column, want))
+		case want != got:
+			differences = append(differences, fmt.Sprintf("%s: %q -> %q", column, want, got))
+		}
+	}
+	return differences
+}
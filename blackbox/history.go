@@ -0,0 +1,242 @@
+package blackbox
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every run keeps a local record of its definition, plan, results and log
+// in a directory named after the run ID under historyDir.
+var historyDir = GetVariableOrDefault("BLACKBOX_HISTORY_DIR", filepath.Join(".blackbox", "runs"))
+
+// RunMetadata describes a run for reproducing it later.
+type RunMetadata struct {
+	RunID         string    `json:"run_id"`
+	Run           string    `json:"run"`
+	Spreadsheet   string    `json:"spreadsheet"`
+	Inputs        string    `json:"inputs"`
+	Variables     []string  `json:"variables,omitempty"`
+	Program       string    `json:"program"`
+	Target        string    `json:"target,omitempty"`
+	ProgramSHA256 string    `json:"program_sha256,omitempty"`
+	GitCommit     string    `json:"git_commit,omitempty"`
+	Host          string    `json:"host"`
+	Args          []string  `json:"args"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Strategy      string    `json:"strategy"`
+	Seed          int64     `json:"seed,omitempty"`
+	InputSets     int       `json:"input_sets"`
+	Status        string    `json:"status"`
+}
+
+// RunRecord is the local history directory of a run. A nil *RunRecord
+// records nothing, so history stays optional.
+type RunRecord struct {
+	Dir      string
+	Metadata RunMetadata
+	log      *os.File
+}
+
+// runLogs copies the log output into the logs of the runs being recorded.
+var runLogs = &logTee{files: map[*os.File]bool{}}
+var runLogsOnce sync.Once
+
+type logTee struct {
+	mu    sync.Mutex
+	files map[*os.File]bool
+}
+
+func (t *logTee) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for file := range t.files {
+		file.Write(p)
+	}
+	return os.Stderr.Write(p)
+}
+
+func (t *logTee) add(file *os.File) {
+	runLogsOnce.Do(func() { log.SetOutput(runLogs) })
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.files[file] = true
+}
+
+func (t *logTee) remove(file *os.File) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.files, file)
+}
+
+// HashFile returns the hex SHA-256 of a file, looked up in PATH if needed.
+func HashFile(path string) (string, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// NewRunMetadata describes a run starting now.
+func NewRunMetadata(runID, runName, spreadsheet string, experiment Experiment, start time.Time) RunMetadata {
+	host, _ := os.Hostname()
+	metadata := RunMetadata{
+		RunID:       runID,
+		Run:         runName,
+		Spreadsheet: spreadsheet,
+		Inputs:      experiment.Inputs,
+		Program:     experiment.Program,
+		Target:      experiment.Target,
+		GitCommit:   GitCommit(experiment.Program),
+		Host:        host,
+		Args:        os.Args,
+		Start:       start,
+		Strategy:    experiment.Strategy,
+		Seed:        experiment.Seed,
+		Status:      "running",
+	}
+	if metadata.Strategy == "" {
+		metadata.Strategy = "exhaustive"
+	}
+	// Programs of other targets are not local files
+	if experiment.Target != "" && experiment.Target != "local" {
+		return metadata
+	}
+	var err error
+	if metadata.ProgramSHA256, err = HashFile(experiment.Program); err != nil {
+		Log.Warnf("Unable to hash program %s: %v\n", experiment.Program, err)
+	}
+	return metadata
+}
+
+// Finish records the outcome of the run.
+func (m *RunMetadata) Finish(result RunResult) {
+	m.End = m.Start.Add(result.Duration)
+	m.InputSets = result.InputSets
+	m.Variables = result.VarNames
+	m.Status = "ok"
+	if result.Err != nil {
+		m.Status = result.Err.Error()
+	}
+}
+
+// GitCommit returns the commit checked out in the repository containing
+// the program, or the working directory, if any.
+func GitCommit(program string) string {
+	dir := "."
+	if resolved, err := exec.LookPath(program); err == nil {
+		dir = filepath.Dir(resolved)
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func NewRunRecord(metadata RunMetadata) (*RunRecord, error) {
+	dir := filepath.Join(historyDir, metadata.RunID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create run history directory: %v", err)
+	}
+	logFile, err := os.Create(filepath.Join(dir, "run.log"))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create run log: %v", err)
+	}
+	record := &RunRecord{
+		Dir:      dir,
+		log:      logFile,
+		Metadata: metadata,
+	}
+	if err := record.writeMetadata(); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	runLogs.add(logFile)
+	return record, nil
+}
+
+func (r *RunRecord) writeMetadata() error {
+	b, err := json.MarshalIndent(r.Metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(r.Dir, "metadata.json"), b, 0644)
+}
+
+// FindRunDir returns the history directory of a run given its ID or its
+// name.
+func FindRunDir(run string) (string, error) {
+	dir := filepath.Join(historyDir, run)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir, nil
+	}
+	dirs, _ := filepath.Glob(filepath.Join(historyDir, "*", "metadata.json"))
+	for _, path := range dirs {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var metadata RunMetadata
+		if json.Unmarshal(b, &metadata) == nil && metadata.Run == run {
+			return filepath.Dir(path), nil
+		}
+	}
+	return "", fmt.Errorf("No local history of run %s in %s", run, historyDir)
+}
+
+// WriteTable saves rows as a CSV file of the run directory.
+func (r *RunRecord) WriteTable(name string, rows [][]string) error {
+	if r == nil {
+		return nil
+	}
+	f, err := os.Create(filepath.Join(r.Dir, name))
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ResultsSink returns a sink keeping a CSV copy of the results.
+func (r *RunRecord) ResultsSink() (Sink, error) {
+	return NewCSVSink(filepath.Join(r.Dir, "results.csv"), "")
+}
+
+// Finish records the outcome of the run and stops copying the log.
+func (r *RunRecord) Finish(result RunResult) error {
+	if r == nil {
+		return nil
+	}
+	runLogs.remove(r.log)
+	r.log.Close()
+	r.Metadata.Finish(result)
+	return r.writeMetadata()
+}
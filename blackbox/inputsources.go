@@ -0,0 +1,122 @@
+package blackbox
+
+import (
+	"fmt"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// InputVars are the variable rows of an inputs tab, as returned by
+// SetupLayout.Rows, along with their constraints and generators.
+type InputVars struct {
+	// Where the variables come from, for error messages
+	Origin      string
+	Rows        [][]string
+	Constraints []string
+	Generators  map[string]string
+}
+
+// ParseInputSource parses the spreadsheet and tab of another inputs tab,
+// written sheets:ID#TAB, FILE.xlsx#TAB, airtable:BASE#TABLE or as a
+// spreadsheet URL, whose #gid= gives the tab. The tab defaults to inputs.
+func ParseInputSource(srv *sheets.Service, spec string) (string, string, error) {
+	if id, gid := ParseSpreadsheetRef(spec); id != spec {
+		if gid < 0 {
+			return id, "inputs", nil
+		}
+		if srv == nil {
+			return "", "", fmt.Errorf("Not authenticated to read spreadsheet %s", id)
+		}
+		tab, err := SheetTitle(srv, id, gid)
+		return id, tab, err
+	}
+	spreadsheet, tab := strings.TrimPrefix(spec, "sheets:"), "inputs"
+	if i := strings.LastIndex(spreadsheet, "#"); i >= 0 {
+		spreadsheet, tab = spreadsheet[:i], spreadsheet[i+1:]
+	}
+	if spreadsheet == "" || tab == "" {
+		return "", "", fmt.Errorf("Invalid input source %q, expected sheets:ID#TAB or FILE.xlsx#TAB", spec)
+	}
+	return spreadsheet, tab, nil
+}
+
+// ReadInputSource reads the variables of another inputs tab, e.g. a
+// library of variables shared by several experiments in a central
+// spreadsheet, laid out like an inputs tab.
+func ReadInputSource(srv *sheets.Service, spec, experiment string) (InputVars, error) {
+	spreadsheet, tab, err := ParseInputSource(srv, spec)
+	if err != nil {
+		return InputVars{}, err
+	}
+	source, err := OpenSource(srv, spreadsheet)
+	if err != nil {
+		return InputVars{}, err
+	}
+	setupRows, err := source.ReadRows(tab)
+	if err != nil {
+		return InputVars{}, fmt.Errorf("Unable to read inputs %s of %s: %v", tab, spreadsheet, err)
+	}
+	layout := ParseSetupLayout(setupRows)
+	layout.Experiment = experiment
+	rows, constraints := layout.Rows(setupRows)
+	if err := CheckInputTypes(tab, layout, rows); err != nil {
+		return InputVars{}, fmt.Errorf("%v of %s", err, spreadsheet)
+	}
+	return InputVars{Origin: spreadsheet + "#" + tab, Rows: rows, Constraints: constraints, Generators: layout.Generators}, nil
+}
+
+// Merge adds the variables of other inputs tabs. A variable several of
+// them define must have the same type, examples and generator in each.
+func (v *InputVars) Merge(other InputVars) error {
+	defined := map[string][]string{}
+	for _, row := range v.Rows {
+		name, _ := ParseVarCell(row[0])
+		defined[name] = row
+	}
+	for _, row := range other.Rows {
+		name, varType := ParseVarCell(row[0])
+		existing, ok := defined[name]
+		if !ok {
+			v.Rows = append(v.Rows, row)
+			defined[name] = row
+			continue
+		}
+		_, existingType := ParseVarCell(existing[0])
+		if existingType != varType || strings.TrimSpace(existing[1]) != strings.TrimSpace(row[1]) {
+			return fmt.Errorf("Variable %s is defined differently in %s and %s", name, v.Origin, other.Origin)
+		}
+	}
+	for name, generator := range other.Generators {
+		if existing, ok := v.Generators[name]; ok && existing != generator {
+			return fmt.Errorf("Variable %s has different generators in %s and %s", name, v.Origin, other.Origin)
+		}
+		v.Generators[name] = generator
+	}
+	known := map[string]bool{}
+	for _, constraint := range v.Constraints {
+		known[constraint] = true
+	}
+	for _, constraint := range other.Constraints {
+		if !known[constraint] {
+			known[constraint] = true
+			v.Constraints = append(v.Constraints, constraint)
+		}
+	}
+	return nil
+}
+
+// InputSourcesNeedSheets reports whether any input source is a Google
+// spreadsheet, to authenticate even when the inputs are in an Excel file.
+func InputSourcesNeedSheets(specs []string) bool {
+	for _, spec := range specs {
+		spreadsheet := strings.TrimPrefix(spec, "sheets:")
+		if i := strings.LastIndex(spreadsheet, "#"); i >= 0 {
+			spreadsheet = spreadsheet[:i]
+		}
+		if IsGoogleSpreadsheet(spreadsheet) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package blackbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Google closes HTTP connections idle for about two minutes; connections
+// are dropped well before that instead of being reused after a slow run.
+const sheetsIdleConnTimeout = 45 * time.Second
+
+// Tokens expiring sooner than this are refreshed before a write.
+const tokenRefreshMargin = 5 * time.Minute
+
+// refreshingTokenSource refreshes the OAuth token ahead of its expiry,
+// saving refreshed tokens to the credentials cache, and can be told to
+// refresh when the API rejects a token. When the refresh token itself
+// expired or was revoked, it authorizes again if run from a terminal.
+type refreshingTokenSource struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	config *oauth2.Config
+	token  *oauth2.Token
+	file   string
+}
+
+func newRefreshingTokenSource(ctx context.Context, config *oauth2.Config, token *oauth2.Token) *refreshingTokenSource {
+	return &refreshingTokenSource{ctx: ctx, config: config, token: token, file: cachedCredsFile}
+}
+
+func (s *refreshingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.Valid() && (s.token.Expiry.IsZero() || time.Until(s.token.Expiry) > tokenRefreshMargin) {
+		return s.token, nil
+	}
+	expired := *s.token
+	expired.Expiry = time.Now().Add(-time.Minute)
+	token, err := s.config.TokenSource(s.ctx, &expired).Token()
+	if err != nil {
+		if !isRevokedToken(err) {
+			return nil, fmt.Errorf("Unable to refresh the oauth token: %v", err)
+		}
+		// Authorizing again needs someone to answer the flow
+		if !isInteractive() {
+			return nil, fmt.Errorf("The cached credentials in %s are expired or revoked; "+
+				"run blackbox from a terminal, or remove %s, to authorize again", s.file, s.file)
+		}
+		Log.Warnf("The cached credentials in %s are expired or revoked, authorizing again", s.file)
+		if token, err = getTokenFromWeb(s.config); err != nil {
+			return nil, err
+		}
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = s.token.RefreshToken
+	}
+	s.token = token
+	if err := saveToken(s.file, token); err != nil {
+		Log.Warnf("%v", err)
+	}
+	return token, nil
+}
+
+// isRevokedToken reports whether a token refresh failed because the
+// refresh token expired or was revoked, so that only authorizing again
+// can help.
+func isRevokedToken(err error) bool {
+	retrieveErr, ok := err.(*oauth2.RetrieveError)
+	return ok && strings.Contains(string(retrieveErr.Body), "invalid_grant")
+}
+
+// isInteractive reports whether blackbox runs from a terminal.
+func isInteractive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// invalidate forces a refresh on the next request.
+func (s *refreshingTokenSource) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token.Expiry = time.Now().Add(-time.Minute)
+}
+
+// reauthTransport retries a request once over a fresh connection when it
+// failed on a stale one, and with a refreshed token when it was rejected
+// as unauthorized. Requests that failed after being sent are only retried
+// when idempotent, as the server may have applied them, e.g. appended the
+// rows of a write.
+type reauthTransport struct {
+	base   *http.Transport
+	source *refreshingTokenSource
+	next   http.RoundTripper
+}
+
+// isIdempotent reports whether sending a request again has no other effect
+// than sending it once.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (t *reauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var sent int32
+	trace := &httptrace.ClientTrace{WroteHeaders: func() { atomic.StoreInt32(&sent, 1) }}
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	retry := false
+	switch {
+	case err != nil && req.Context().Err() == nil && atomic.LoadInt32(&sent) == 1 && !isIdempotent(req):
+		Log.Warnf("Sheets %s request failed after being sent, not retrying it: %v\n", req.Method, err)
+	case err != nil && req.Context().Err() == nil:
+		Log.Warnf("Sheets request failed, retrying on a new connection: %v\n", err)
+		t.base.CloseIdleConnections()
+		retry = true
+	case err == nil && resp.StatusCode == http.StatusUnauthorized:
+		Log.Warnf("Sheets token rejected, re-authenticating")
+		t.source.invalidate()
+		retry = true
+	}
+	if !retry || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	retried := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return resp, err
+		}
+		retried.Body = body
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return t.next.RoundTrip(retried)
+}
+
+// newReauthClient returns an HTTP client for the Sheets API that survives
+// long pauses between writes: idle connections are not reused once Google
+// may have closed them, tokens are refreshed ahead of expiry and failed
+// requests are retried once after reconnecting or re-authenticating, and
+// with backoff while rate limited.
+func newReauthClient(source *refreshingTokenSource) *http.Client {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.IdleConnTimeout = sheetsIdleConnTimeout
+	return &http.Client{
+		Transport: &backoffTransport{next: &reauthTransport{
+			base:   base,
+			source: source,
+			next:   &oauth2.Transport{Source: source, Base: base},
+		}},
+	}
+}
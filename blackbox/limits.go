@@ -0,0 +1,98 @@
+package blackbox
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ResourceLimits cap the memory and CPU of every run of a local program,
+// with cgroups on Linux and job objects on Windows, so that an input set
+// running away cannot take the host down during an unattended sweep.
+type ResourceLimits struct {
+	// Bytes of memory, 0 for no limit
+	Memory int64
+	// CPUs the program may use at most, e.g. 0.5, 0 for no limit
+	CPUs float64
+}
+
+func (l ResourceLimits) Set() bool {
+	return l.Memory > 0 || l.CPUs > 0
+}
+
+// ParseResourceLimits parses a memory size, e.g. 512M or 2G, and a number
+// of CPUs, either being empty for no limit.
+func ParseResourceLimits(memory, cpus string) (ResourceLimits, error) {
+	limits := ResourceLimits{}
+	if memory != "" {
+		size, err := ParseByteSize(memory)
+		if err != nil || size <= 0 {
+			return limits, fmt.Errorf("Invalid memory limit %q, expected e.g. 512M", memory)
+		}
+		limits.Memory = size
+	}
+	if cpus != "" {
+		n, err := strconv.ParseFloat(cpus, 64)
+		if err != nil || n <= 0 {
+			return limits, fmt.Errorf("Invalid CPU limit %q, expected a positive number", cpus)
+		}
+		limits.CPUs = n
+	}
+	return limits, nil
+}
+
+// ParseByteSize parses a number of bytes with an optional binary unit,
+// K, M, G or T, e.g. 512M or 1.5g.
+func ParseByteSize(text string) (int64, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "B"), "I")
+	multiplier := int64(1)
+	for i, unit := range "KMGT" {
+		if strings.HasSuffix(text, string(unit)) {
+			multiplier = 1 << (10 * uint(i+1))
+			text = strings.TrimSuffix(text, string(unit))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// resourceLimit confines one run of a program, see newResourceLimit of
+// each system.
+type resourceLimit interface {
+	// started is called once the program started, before waiting for it
+	started(cmd *exec.Cmd) error
+	// release frees the limit once the program exited, telling when the
+	// limit killed it in the returned error
+	release(err error) error
+}
+
+// runLimited runs a command prepared as for cmd.Output within limits.
+func runLimited(cmd *exec.Cmd, limits ResourceLimits) ([]byte, error) {
+	limit, err := newResourceLimit(cmd, limits)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, limit.release(err)
+	}
+	if err := limit.started(cmd); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, limit.release(err)
+	}
+	err = cmd.Wait()
+	// As cmd.Output does, for crash triage
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), limit.release(err)
+}
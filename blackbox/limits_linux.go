@@ -0,0 +1,160 @@
+package blackbox
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Period of the CPU quota of a cgroup, in microseconds.
+const cgroupCPUPeriod = 100000
+
+var (
+	cgroupOnce   sync.Once
+	cgroupParent string
+	cgroupErr    error
+	cgroupRuns   int64
+)
+
+// cgroupLimit runs a program in a cgroup v2 of its own, created under
+// BLACKBOX_CGROUP, or else under the cgroup of blackbox, which it must be
+// allowed to manage, e.g. when started with
+// systemd-run --user --scope -p Delegate=yes blackbox ...
+type cgroupLimit struct {
+	dir string
+	fd  *os.File
+}
+
+// setupCgroups finds the cgroup to create those of the runs in, enabling
+// the memory and cpu controllers for them. A cgroup holding processes
+// cannot enable controllers, so blackbox moves itself to a leaf of its own
+// cgroup first.
+func setupCgroups() (string, error) {
+	parent := GetVariableOrDefault("BLACKBOX_CGROUP", "")
+	if parent == "" {
+		own, err := ownCgroup()
+		if err != nil {
+			return "", err
+		}
+		parent = filepath.Join("/sys/fs/cgroup", own)
+		leaf := filepath.Join(parent, fmt.Sprintf("blackbox-%d", os.Getpid()))
+		if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+			return "", delegationError(parent, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			return "", delegationError(parent, err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644); err != nil {
+		return "", delegationError(parent, err)
+	}
+	return parent, nil
+}
+
+// ownCgroup returns the cgroup v2 of blackbox, from /proc/self/cgroup.
+func ownCgroup() (string, error) {
+	file, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("Unable to find the cgroup of blackbox: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "0::") {
+			return strings.TrimPrefix(scanner.Text(), "0::"), nil
+		}
+	}
+	return "", fmt.Errorf("Resource limits need cgroup v2, mounted on /sys/fs/cgroup")
+}
+
+func delegationError(parent string, err error) error {
+	return fmt.Errorf("Unable to manage cgroup %s: %v; run blackbox with systemd-run --user --scope -p Delegate=yes, or set BLACKBOX_CGROUP to a cgroup it may manage", parent, err)
+}
+
+func newResourceLimit(cmd *exec.Cmd, limits ResourceLimits) (resourceLimit, error) {
+	cgroupOnce.Do(func() {
+		cgroupParent, cgroupErr = setupCgroups()
+	})
+	if cgroupErr != nil {
+		return nil, cgroupErr
+	}
+	dir := filepath.Join(cgroupParent, fmt.Sprintf("run-%d-%d", os.Getpid(), atomic.AddInt64(&cgroupRuns, 1)))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create cgroup: %v", err)
+	}
+	l := &cgroupLimit{dir: dir}
+	settings := map[string]string{}
+	if limits.Memory > 0 {
+		settings["memory.max"] = strconv.FormatInt(limits.Memory, 10)
+		settings["memory.swap.max"] = "0"
+	}
+	if limits.CPUs > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(limits.CPUs*cgroupCPUPeriod), cgroupCPUPeriod)
+	}
+	for name, value := range settings {
+		// Without swap accounting, there is no memory.swap.max to set
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil && !(name == "memory.swap.max" && os.IsNotExist(err)) {
+			l.release(nil)
+			return nil, fmt.Errorf("Unable to set %s of cgroup: %v", name, err)
+		}
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		l.release(nil)
+		return nil, fmt.Errorf("Unable to open cgroup: %v", err)
+	}
+	l.fd = fd
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+	return l, nil
+}
+
+// started has nothing to do, the program being started in its cgroup.
+func (l *cgroupLimit) started(cmd *exec.Cmd) error {
+	return nil
+}
+
+func (l *cgroupLimit) release(err error) error {
+	if l.fd != nil {
+		l.fd.Close()
+	}
+	if err != nil && l.oomKilled() {
+		err = fmt.Errorf("%v (killed by the memory limit)", err)
+	}
+	// Kill what the program left behind, so that the cgroup can be removed
+	ioutil.WriteFile(filepath.Join(l.dir, "cgroup.kill"), []byte("1"), 0644)
+	for attempt := 0; attempt < 10; attempt++ {
+		if os.Remove(l.dir) == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+// oomKilled tells whether the memory limit killed a process of the cgroup.
+func (l *cgroupLimit) oomKilled() bool {
+	content, err := ioutil.ReadFile(filepath.Join(l.dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" && fields[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+
+package blackbox
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func newResourceLimit(cmd *exec.Cmd, limits ResourceLimits) (resourceLimit, error) {
+	return nil, fmt.Errorf("Resource limits need cgroups (Linux) or job objects (Windows)")
+}
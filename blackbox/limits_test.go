@@ -0,0 +1,69 @@
+package blackbox
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    int64
+		wantErr bool
+	}{
+		{text: "100", want: 100},
+		{text: "512M", want: 512 << 20},
+		{text: "1.5g", want: 3 << 29},
+		{text: " 2GiB ", want: 2 << 30},
+		{text: "10KB", want: 10 << 10},
+		{text: "1T", want: 1 << 40},
+		{text: "64b", want: 64},
+		{text: "", wantErr: true},
+		{text: "M", wantErr: true},
+		{text: "12X", wantErr: true},
+		{text: "one G", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseByteSize(test.text)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseByteSize(%q) = %d, want an error", test.text, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v, want %d", test.text, got, err, test.want)
+		}
+	}
+}
+
+func TestParseResourceLimits(t *testing.T) {
+	tests := []struct {
+		memory, cpus string
+		want         ResourceLimits
+		wantErr      bool
+	}{
+		{want: ResourceLimits{}},
+		{memory: "512M", want: ResourceLimits{Memory: 512 << 20}},
+		{cpus: "0.5", want: ResourceLimits{CPUs: 0.5}},
+		{memory: "2G", cpus: "2", want: ResourceLimits{Memory: 2 << 30, CPUs: 2}},
+		{memory: "0", wantErr: true},
+		{memory: "-1G", wantErr: true},
+		{memory: "lots", wantErr: true},
+		{cpus: "0", wantErr: true},
+		{cpus: "-1", wantErr: true},
+		{cpus: "half", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseResourceLimits(test.memory, test.cpus)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseResourceLimits(%q, %q) = %+v, want an error", test.memory, test.cpus, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ParseResourceLimits(%q, %q) = %+v, %v, want %+v", test.memory, test.cpus, got, err, test.want)
+		}
+		if got.Set() != (test.memory != "" || test.cpus != "") {
+			t.Errorf("ParseResourceLimits(%q, %q).Set() = %v", test.memory, test.cpus, got.Set())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package blackbox
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Job object CPU rate control, not defined by x/sys/windows.
+const (
+	jobObjectCPURateControlInformationClass = 15
+	jobObjectCPURateControlEnable           = 0x1
+	jobObjectCPURateControlHardCap          = 0x4
+)
+
+type jobCPURateControl struct {
+	ControlFlags uint32
+	// Share of the cycles of all processors, in 1/10000ths
+	CPURate uint32
+}
+
+// jobLimit runs a program in a job object of its own. A program exceeding
+// the memory limit fails to allocate more, rather than being killed.
+type jobLimit struct {
+	job windows.Handle
+}
+
+func newResourceLimit(cmd *exec.Cmd, limits ResourceLimits) (resourceLimit, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create job object: %v", err)
+	}
+	l := &jobLimit{job: job}
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{}
+	// Kill what the program left behind once the job is released
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	if limits.Memory > 0 {
+		info.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_PROCESS_MEMORY
+		info.ProcessMemoryLimit = uintptr(limits.Memory)
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		l.release(nil)
+		return nil, fmt.Errorf("Unable to set the memory limit of job object: %v", err)
+	}
+	if limits.CPUs > 0 {
+		rate := int(limits.CPUs / float64(runtime.NumCPU()) * 10000)
+		if rate < 1 {
+			rate = 1
+		}
+		if rate > 10000 {
+			rate = 10000
+		}
+		cpuInfo := jobCPURateControl{
+			ControlFlags: jobObjectCPURateControlEnable | jobObjectCPURateControlHardCap,
+			CPURate:      uint32(rate),
+		}
+		if _, err := windows.SetInformationJobObject(job, jobObjectCPURateControlInformationClass, uintptr(unsafe.Pointer(&cpuInfo)), uint32(unsafe.Sizeof(cpuInfo))); err != nil {
+			l.release(nil)
+			return nil, fmt.Errorf("Unable to set the CPU limit of job object: %v", err)
+		}
+	}
+	return l, nil
+}
+
+// started assigns the program to the job. Processes it started before
+// are not limited, which is short enough not to matter in practice.
+func (l *jobLimit) started(cmd *exec.Cmd) error {
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		return fmt.Errorf("Unable to open process %d: %v", cmd.Process.Pid, err)
+	}
+	defer windows.CloseHandle(process)
+	if err := windows.AssignProcessToJobObject(l.job, process); err != nil {
+		return fmt.Errorf("Unable to assign process %d to job object: %v", cmd.Process.Pid, err)
+	}
+	return nil
+}
+
+func (l *jobLimit) release(err error) error {
+	windows.CloseHandle(l.job)
+	return err
+}
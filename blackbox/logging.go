@@ -0,0 +1,119 @@
+package blackbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel orders log messages by importance; messages below the level of
+// the logger are dropped.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("Unknown log level %q, expected one of %s", name, strings.Join(logLevelNames, ", "))
+}
+
+// Logger writes leveled messages through the standard logger, so that run
+// logs still get a copy, as text or as JSON lines with time, level and msg
+// for machines, e.g. in CI.
+type Logger struct {
+	mu    sync.Mutex
+	level LogLevel
+	json  bool
+}
+
+// Log is configured by -log-level and -log-format, or for subcommands
+// by BLACKBOX_LOG_LEVEL and BLACKBOX_LOG_FORMAT.
+var Log = &Logger{level: LevelInfo}
+
+func init() {
+	if err := Log.Configure(GetVariableOrDefault("BLACKBOX_LOG_LEVEL", "info"), GetVariableOrDefault("BLACKBOX_LOG_FORMAT", "text")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// Configure sets the level and the format, text or json, of the logger.
+func (l *Logger) Configure(level, format string) error {
+	parsed, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("Unknown log format %q, expected text or json", format)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = parsed
+	l.json = format == "json"
+	if l.json {
+		log.SetFlags(0)
+	} else {
+		log.SetFlags(log.LstdFlags)
+	}
+	return nil
+}
+
+// Enabled reports whether messages of level are written.
+func (l *Logger) Enabled(level LogLevel) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level >= l.level
+}
+
+// JSON reports whether messages are written as JSON lines.
+func (l *Logger) JSON() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.json
+}
+
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	if !l.JSON() {
+		log.Printf("%-5s %s\n", strings.ToUpper(level.String()), msg)
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+	}{time.Now().Format(time.RFC3339Nano), level.String(), msg})
+	log.Println(string(line))
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(LevelDebug, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.logf(LevelInfo, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.logf(LevelWarn, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(LevelError, format, args...) }
+
+// Fatalf logs an error and exits with status 1.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.logf(LevelError, format, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,79 @@
+package blackbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Variables starting with metaVarPrefix configure the runner instead of
+// being passed to the program, e.g. "@timeout", "@concurrency" or
+// "@env.GOMAXPROCS". They are swept and recorded like any other variable.
+const metaVarPrefix = "@"
+
+// RunnerConfig holds the execution conditions of a program invocation.
+type RunnerConfig struct {
+	Timeout     time.Duration
+	Concurrency int
+	Env         []string
+	// Types of the tagged input variables, by name
+	Types map[string]string
+	// Record the resources used by local programs as outputs
+	MeasureRusage bool
+	// Limits of every run of a local program
+	Limits ResourceLimits
+	// Run local programs in a fresh working directory each, kept when the
+	// run fails if KeepFailed is set
+	WorkdirPerRun bool
+	KeepFailed    bool
+	// Uploads the artifacts left in the working directory of every run
+	Artifacts *ArtifactUploader
+}
+
+func IsMetaVar(varName string) bool {
+	return strings.HasPrefix(varName, metaVarPrefix)
+}
+
+// WithMetaVar returns a copy of the config adjusted by a meta-variable value.
+func (c RunnerConfig) WithMetaVar(varName, value string) (RunnerConfig, error) {
+	name := strings.TrimPrefix(varName, metaVarPrefix)
+	switch {
+	case name == "timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return c, fmt.Errorf("Invalid %s value %q: %v", varName, value, err)
+		}
+		c.Timeout = timeout
+	case name == "concurrency":
+		concurrency, err := strconv.Atoi(value)
+		if err != nil || concurrency < 1 {
+			return c, fmt.Errorf("Invalid %s value %q: must be a positive integer", varName, value)
+		}
+		c.Concurrency = concurrency
+	case strings.HasPrefix(name, "env.") && len(name) > len("env."):
+		c.Env = append(append([]string{}, c.Env...), strings.TrimPrefix(name, "env.")+"="+value)
+	default:
+		return c, fmt.Errorf("Unknown meta-variable %s", varName)
+	}
+	return c, nil
+}
+
+// RunnerConfigFor applies the meta-variables of an input set to the base
+// runner config.
+func RunnerConfigFor(base RunnerConfig, varNames, inputSet []string) (RunnerConfig, error) {
+	config := base
+	for i, varName := range varNames {
+		if !IsMetaVar(varName) {
+			continue
+		}
+		var err error
+		if config, err = config.WithMetaVar(varName, inputSet[i]); err != nil {
+			return config, err
+		}
+	}
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	return config, nil
+}
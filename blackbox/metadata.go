@@ -0,0 +1,68 @@
+package blackbox
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// MetadataRows renders run metadata as key/value rows, header first.
+func MetadataRows(metadata RunMetadata) [][]string {
+	seed := ""
+	if metadata.Seed != 0 {
+		seed = strconv.FormatInt(metadata.Seed, 10)
+	}
+	return [][]string{
+		{"key", "value"},
+		{"run_id", metadata.RunID},
+		{"run", metadata.Run},
+		{"program", metadata.Program},
+		{"target", metadata.Target},
+		{"program_sha256", metadata.ProgramSHA256},
+		{"git_commit", metadata.GitCommit},
+		{"host", metadata.Host},
+		{"args", strings.Join(metadata.Args, " ")},
+		{"spreadsheet", metadata.Spreadsheet},
+		{"inputs", metadata.Inputs},
+		{"variables", strings.Join(metadata.Variables, ",")},
+		{"start", metadata.Start.Format(time.RFC3339)},
+		{"end", metadata.End.Format(time.RFC3339)},
+		{"duration", metadata.End.Sub(metadata.Start).Round(time.Second).String()},
+		{"strategy", metadata.Strategy},
+		{"seed", seed},
+		{"input_sets", strconv.Itoa(metadata.InputSets)},
+		{"status", metadata.Status},
+	}
+}
+
+// RunVarNames returns the input variables of the run recorded in a result
+// tab: those listed in its meta tab, or else those of the inputs tab the
+// meta tab names, or of the inputs tab for runs without one.
+func RunVarNames(source Source, run string) ([]string, error) {
+	metadata, err := ReadOptionalRows(source, relatedSheetName("meta", run))
+	if err != nil {
+		return nil, err
+	}
+	inputsSheet := "inputs"
+	for _, row := range metadata {
+		switch value := cell(row, 1); cell(row, 0) {
+		case "variables":
+			if value != "" {
+				return strings.Split(value, ","), nil
+			}
+		case "inputs":
+			if value != "" {
+				inputsSheet = value
+			}
+		}
+	}
+	return ReadVarNames(source, inputsSheet)
+}
+
+// WriteMetaTab records how a run was made in a meta tab next to its
+// results, e.g. meta_fib_1600000000 for result_fib_1600000000.
+func WriteMetaTab(srv *sheets.Service, spreadsheet string, metadata RunMetadata) error {
+	return WriteRows(srv, spreadsheet, relatedSheetName("meta", metadata.Run), MetadataRows(metadata))
+}
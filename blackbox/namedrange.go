@@ -0,0 +1,124 @@
+package blackbox
+
+import (
+	"fmt"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// NamedRange is a named range of a spreadsheet, e.g. PARAMS, which owners
+// can move around without breaking the runs referring to it.
+type NamedRange struct {
+	ID    string
+	Name  string
+	Sheet string
+	Range *sheets.GridRange
+}
+
+// FindNamedRange looks up a named range of a spreadsheet.
+func FindNamedRange(srv *sheets.Service, spreadsheetID, name string) (*NamedRange, error) {
+	resp, err := srv.Spreadsheets.Get(spreadsheetID).Fields("namedRanges,sheets.properties(sheetId,title)").Do()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the named ranges of the spreadsheet: %v", err)
+	}
+	for _, namedRange := range resp.NamedRanges {
+		if namedRange.Name != name {
+			continue
+		}
+		for _, sheet := range resp.Sheets {
+			if sheet.Properties.SheetId == namedRange.Range.SheetId {
+				return &NamedRange{ID: namedRange.NamedRangeId, Name: name, Sheet: sheet.Properties.Title, Range: namedRange.Range}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("No named range %s in the spreadsheet", name)
+}
+
+// columnLetters returns the A1 notation of a 0-based column index.
+func columnLetters(column int64) string {
+	letters := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		letters = string(rune('A'+(column-1)%26)) + letters
+	}
+	return letters
+}
+
+// A1 returns the A1 notation of the cell at row and column of the range,
+// relative to its top left cell.
+func (r *NamedRange) A1(row, column int64) string {
+	return fmt.Sprintf("'%s'!%s%d", r.Sheet, columnLetters(r.Range.StartColumnIndex+column), r.Range.StartRowIndex+row+1)
+}
+
+// NamedRangeSink writes results from the top left cell of a named range,
+// replacing what the range held, and resizes the range to the results
+// once the run finishes, so formulas and charts referring to it follow.
+type NamedRangeSink struct {
+	srv           *sheets.Service
+	spreadsheetID string
+	namedRange    *NamedRange
+	rows          int64
+	columns       int64
+	encoder       Encoder
+}
+
+func NewNamedRangeSink(srv *sheets.Service, spreadsheetID, name string) (*NamedRangeSink, error) {
+	namedRange, err := FindNamedRange(srv, spreadsheetID, name)
+	if err != nil {
+		return nil, err
+	}
+	return &NamedRangeSink{srv: srv, spreadsheetID: spreadsheetID, namedRange: namedRange, encoder: SheetsEncoder{}}, nil
+}
+
+func (s *NamedRangeSink) SetEncoder(encoder Encoder) {
+	s.encoder = encoder
+}
+
+// WriteHeader clears the previous results held by the range.
+func (s *NamedRangeSink) WriteHeader(header []string) error {
+	if _, err := s.srv.Spreadsheets.Values.Clear(s.spreadsheetID, s.namedRange.Name, &sheets.ClearValuesRequest{}).Do(); err != nil {
+		return fmt.Errorf("Unable to clear named range %s: %v", s.namedRange.Name, err)
+	}
+	return s.WriteRow(header)
+}
+
+func (s *NamedRangeSink) WriteRow(row []string) error {
+	values := []interface{}{}
+	for _, value := range row {
+		encoded, _ := s.encoder.Encode(s.encoder.Kind(value), value)
+		values = append(values, encoded)
+	}
+	vr := sheets.ValueRange{Values: [][]interface{}{values}}
+	if _, err := s.srv.Spreadsheets.Values.Update(s.spreadsheetID, s.namedRange.A1(s.rows, 0), &vr).ValueInputOption("USER_ENTERED").Do(); err != nil {
+		return err
+	}
+	s.rows++
+	if int64(len(row)) > s.columns {
+		s.columns = int64(len(row))
+	}
+	return nil
+}
+
+func (s *NamedRangeSink) Close() error {
+	return nil
+}
+
+// Finalize resizes the named range to the results.
+func (s *NamedRangeSink) Finalize() error {
+	r := s.namedRange.Range
+	resized := &sheets.GridRange{
+		SheetId:          r.SheetId,
+		StartRowIndex:    r.StartRowIndex,
+		EndRowIndex:      r.StartRowIndex + s.rows,
+		StartColumnIndex: r.StartColumnIndex,
+		EndColumnIndex:   r.StartColumnIndex + s.columns,
+	}
+	request := &sheets.Request{UpdateNamedRange: &sheets.UpdateNamedRangeRequest{
+		NamedRange: &sheets.NamedRange{NamedRangeId: s.namedRange.ID, Name: s.namedRange.Name, Range: resized},
+		Fields:     "range",
+	}}
+	rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{request}}
+	if _, err := s.srv.Spreadsheets.BatchUpdate(s.spreadsheetID, rb).Do(); err != nil {
+		return fmt.Errorf("Unable to resize named range %s: %v", s.namedRange.Name, err)
+	}
+	return nil
+}
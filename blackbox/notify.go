@@ -0,0 +1,132 @@
+package blackbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RunCounter is a sink counting the recorded runs of an experiment and
+// those that failed, for its summary, and keeping them too if the summary
+// attaches them.
+type RunCounter struct {
+	result      *RunResult
+	errorColumn int
+	keep        bool
+}
+
+func NewRunCounter(result *RunResult, keep bool) *RunCounter {
+	return &RunCounter{result: result, errorColumn: -1, keep: keep}
+}
+
+func (c *RunCounter) WriteHeader(header []string) error {
+	for i, column := range header {
+		if column == errorColumn {
+			c.errorColumn = i
+		}
+	}
+	if c.keep {
+		c.result.Recorded = [][]string{header}
+	}
+	return nil
+}
+
+func (c *RunCounter) WriteRow(row []string) error {
+	c.result.Runs++
+	if c.keep {
+		c.result.Recorded = append(c.result.Recorded, row)
+	}
+	if c.errorColumn >= 0 && cell(row, c.errorColumn) != "" {
+		c.result.Failures++
+	}
+	return nil
+}
+
+func (c *RunCounter) Close() error {
+	return nil
+}
+
+// RunSummary is what notifications tell about a finished run.
+type RunSummary struct {
+	RunID    string  `json:"run_id"`
+	Run      string  `json:"run"`
+	Status   string  `json:"status"`
+	Runs     int     `json:"runs"`
+	Failures int     `json:"failures"`
+	Duration float64 `json:"duration_seconds"`
+	Results  string  `json:"results"`
+	// Header and rows of the results, for attaching them
+	recorded [][]string
+}
+
+// NewRunSummary summarises a finished run whose results are in
+// spreadsheet.
+func NewRunSummary(result RunResult, spreadsheet string) RunSummary {
+	summary := RunSummary{
+		RunID:    result.RunID,
+		Run:      result.ResultName,
+		Status:   "ok",
+		Runs:     result.Runs,
+		Failures: result.Failures,
+		Duration: result.Duration.Seconds(),
+		Results:  spreadsheet,
+		recorded: result.Recorded,
+	}
+	if result.Err != nil {
+		summary.Status = result.Err.Error()
+	}
+	if IsGoogleSpreadsheet(spreadsheet) {
+		summary.Results = "https://docs.google.com/spreadsheets/d/" + spreadsheet
+	} else if IsAirtableBase(spreadsheet) {
+		summary.Results = "https://airtable.com/" + strings.TrimPrefix(spreadsheet, airtablePrefix)
+	}
+	return summary
+}
+
+// Text renders the summary as a chat message.
+func (s RunSummary) Text() string {
+	icon := ":white_check_mark:"
+	if s.Status != "ok" {
+		icon = ":x:"
+	}
+	return fmt.Sprintf("%s blackbox run %s (%s): %d runs, %d failed, in %v, status %s. Results: %s",
+		icon, s.Run, s.RunID, s.Runs, s.Failures, (time.Duration(s.Duration) * time.Second).Round(time.Second), s.Status, s.Results)
+}
+
+// Notify posts the summary of a run to a target: slack://HOOK for a Slack
+// incoming webhook, e.g. slack://hooks.slack.com/services/T0/B0/XYZ, an
+// http(s) URL receiving the summary as JSON, or email:ADDRESS[,ADDRESS...]
+// to mail it with the results attached, see SendSummaryEmail.
+func Notify(target string, summary RunSummary) error {
+	var url string
+	var payload interface{}
+	switch {
+	case strings.HasPrefix(target, emailPrefix):
+		return SendSummaryEmail(strings.Split(strings.TrimPrefix(target, emailPrefix), ","), summary)
+	case strings.HasPrefix(target, "slack://"):
+		url = "https://" + strings.TrimPrefix(target, "slack://")
+		payload = map[string]string{"text": summary.Text()}
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		url = target
+		payload = summary
+	default:
+		return fmt.Errorf("Unknown notification target %q, expected slack://HOOK, an http(s) URL or email:ADDRESS", target)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Unable to notify %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Unable to notify %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package blackbox
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// getTokenFromWeb uses Config to request a Token: it opens the consent
+// page in a browser and captures the authorization code on a loopback
+// redirect to a local listener. Where no browser can be opened, e.g. over
+// SSH, the code or the URL the browser was redirected to, even if that
+// page failed to load, can be pasted instead.
+// It returns the retrieved Token.
+func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen for the authorization code: %v", err)
+	}
+	defer listener.Close()
+	loopback := *config
+	loopback.RedirectURL = "http://" + listener.Addr().String() + "/"
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	authURL := loopback.AuthCodeURL(state, oauth2.AccessTypeOffline)
+
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	// Only the first code or error counts, e.g. when the page is reloaded
+	send := func(code string, err error) {
+		if err != nil {
+			select {
+			case errs <- err:
+			default:
+			}
+			return
+		}
+		select {
+		case codes <- code:
+		default:
+		}
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Get("state") != state:
+			http.Error(w, "Unexpected authorization state", http.StatusBadRequest)
+			return
+		case query.Get("error") != "":
+			fmt.Fprintf(w, "Authorization failed: %s. You can close this page.\n", query.Get("error"))
+			send("", fmt.Errorf("Authorization failed: %s", query.Get("error")))
+			return
+		}
+		fmt.Fprintf(w, "blackbox is authorized. You can close this page.\n")
+		send(query.Get("code"), nil)
+	})}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	if canOpenBrowser() && openBrowser(authURL) == nil {
+		fmt.Printf("Authorize blackbox in your browser, or go to the following link:\n%v\n", authURL)
+	} else {
+		fmt.Printf("Go to the following link in your browser, then type the authorization code "+
+			"or the address the browser was redirected to:\n%v\n", authURL)
+		go func() {
+			send(readAuthorizationCode(state))
+		}()
+	}
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return nil, err
+	}
+	tok, err := loopback.Exchange(oauth2.NoContext, code)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve token from web %v", err)
+	}
+	return tok, nil
+}
+
+// readAuthorizationCode reads an authorization code typed in, or the
+// redirected URL holding it.
+func readAuthorizationCode(state string) (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", fmt.Errorf("Unable to read authorization code %v", err)
+	}
+	if !strings.Contains(line, "://") {
+		return line, nil
+	}
+	redirect, err := url.Parse(line)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read authorization code from %s: %v", line, err)
+	}
+	if redirect.Query().Get("state") != state {
+		return "", fmt.Errorf("Unexpected authorization state in %s", line)
+	}
+	if redirect.Query().Get("code") == "" {
+		return "", fmt.Errorf("No authorization code in %s", line)
+	}
+	return redirect.Query().Get("code"), nil
+}
+
+// randomState returns the state parameter tying the redirect to this
+// authorization request.
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Unable to generate an authorization state: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// canOpenBrowser reports whether a browser on this machine can be reached
+// by the user, which is not the case in an SSH session or without display.
+func canOpenBrowser() bool {
+	if os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != "" {
+		return false
+	}
+	if runtime.GOOS == "linux" || runtime.GOOS == "freebsd" {
+		return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+	}
+	return true
+}
+
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
@@ -0,0 +1,68 @@
+package blackbox
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// OptimizeCommand implements "blackbox optimize": instead of running every
+// input set, it searches the input space for the best value of an output,
+// recording the search trajectory as the results and the best input set
+// found in a best tab.
+func OptimizeCommand(args []string) error {
+	flags := flag.NewFlagSet("optimize", flag.ExitOnError)
+	objective := flags.String("objective", "", "output to optimize, e.g. \"minimize latency_ms\" or \"maximize:throughput\"")
+	strategy := flags.String("strategy", "anneal", "search strategy: "+StrategyNames())
+	budget := flags.Int("budget", 0, "maximum number of program runs (default 50, population × generations for genetic)")
+	population := flags.Int("population", DefaultPopulation, "input sets per generation of the genetic strategy")
+	generations := flags.Int("generations", DefaultGenerations, "number of generations of the genetic strategy")
+	seed := flags.Int64("seed", 0, "random seed, to repeat a search (default: chosen at start and recorded)")
+	var outputs ListFlags
+	flags.Var(&outputs, "output", "where to record results, as for a sweep (repeatable, default next to the inputs)")
+	timeout := flags.Duration("timeout", 0, "kill the program if a single run takes longer than this (0 for no limit)")
+	concurrency := flags.Int("concurrency", 1, "number of program invocations to run in parallel")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox optimize -objective 'minimize OUTPUT' [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 || *objective == "" {
+		flags.Usage()
+		return fmt.Errorf("spreadsheet, program or objective param is missing")
+	}
+	if !IsSearchStrategy(*strategy) {
+		return fmt.Errorf("Unknown strategy %s, expected one of %s", *strategy, StrategyNames())
+	}
+	spreadsheet := SpreadsheetID(flags.Arg(0))
+
+	var srv *sheets.Service
+	if NeedsSheetsService(spreadsheet, outputs) {
+		var err error
+		if srv, err = Auth(); err != nil {
+			return err
+		}
+	}
+	if WritesToSpreadsheet(spreadsheet, outputs) {
+		if err := CheckEditAccess(srv, spreadsheet); err != nil {
+			return err
+		}
+	}
+	experiment := Experiment{
+		Program:     flags.Arg(1),
+		Outputs:     outputs,
+		Concurrency: *concurrency,
+		Strategy:    *strategy,
+		Objective:   *objective,
+		Budget:      *budget,
+		Seed:        *seed,
+		Population:  *population,
+		Generations: *generations,
+	}
+	if *timeout > 0 {
+		experiment.Timeout = timeout.String()
+	}
+	return RunExperiment(srv, spreadsheet, experiment).Err
+}
@@ -0,0 +1,85 @@
+package blackbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/tdigest"
+)
+
+var trackedQuantiles = []float64{0.5, 0.9, 0.99}
+
+// PercentileTracker is a sink maintaining streaming percentiles (t-digests)
+// of selected numeric outputs while the exploration runs.
+type PercentileTracker struct {
+	mu      sync.Mutex
+	vars    []string
+	columns []int
+	digests []*tdigest.TDigest
+}
+
+func NewPercentileTracker(vars []string) *PercentileTracker {
+	tracker := &PercentileTracker{vars: vars}
+	for range vars {
+		tracker.columns = append(tracker.columns, -1)
+		tracker.digests = append(tracker.digests, tdigest.NewWithCompression(100))
+	}
+	return tracker
+}
+
+func (t *PercentileTracker) WriteHeader(header []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, varName := range t.vars {
+		for column, name := range header {
+			if name == varName {
+				t.columns[i] = column
+			}
+		}
+		if t.columns[i] < 0 {
+			Log.Warnf("Tracked output %s is not in the results\n", varName)
+		}
+	}
+	return nil
+}
+
+func (t *PercentileTracker) WriteRow(row []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, column := range t.columns {
+		if column < 0 || column >= len(row) {
+			continue
+		}
+		if value, err := strconv.ParseFloat(row[column], 64); err == nil {
+			t.digests[i].Add(value, 1)
+		}
+	}
+	return nil
+}
+
+// Summary renders the current percentiles, e.g. "latency_ms p50=12 p90=40 p99=95".
+func (t *PercentileTracker) Summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := []string{}
+	for i, varName := range t.vars {
+		if t.digests[i].Count() == 0 {
+			continue
+		}
+		part := varName
+		for _, q := range trackedQuantiles {
+			part += fmt.Sprintf(" p%g=%.4g", q*100, t.digests[i].Quantile(q))
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " | ")
+}
+
+func (t *PercentileTracker) Close() error {
+	if summary := t.Summary(); summary != "" {
+		Log.Infof("Percentiles: %s\n", summary)
+	}
+	return nil
+}
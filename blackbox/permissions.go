@@ -0,0 +1,129 @@
+package blackbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Scope granting the address of the authorized account, for sharing hints.
+const emailScope = "https://www.googleapis.com/auth/userinfo.email"
+
+const tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+// TokenInfo is what Google tells about an access token.
+type TokenInfo struct {
+	Email string `json:"email"`
+	// Space separated scopes granted
+	Scope string `json:"scope"`
+}
+
+// GetTokenInfo looks up the account and scopes of an access token.
+func GetTokenInfo(accessToken string) (*TokenInfo, error) {
+	resp, err := http.Get(tokenInfoURL + "?access_token=" + url.QueryEscape(accessToken))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to look up the oauth token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to look up the oauth token: %s", resp.Status)
+	}
+	info := &TokenInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, fmt.Errorf("Unable to look up the oauth token: %v", err)
+	}
+	return info, nil
+}
+
+// AuthenticatedEmail returns the address of the account blackbox acts as,
+// "" if unknown, e.g. for tokens granted before the email scope was asked.
+func AuthenticatedEmail() string {
+	if defaultCredentialsEmail != "" {
+		return defaultCredentialsEmail
+	}
+	tok, err := tokenFromFile(cachedCredsFile)
+	if err != nil {
+		return ""
+	}
+	info, err := GetTokenInfo(tok.AccessToken)
+	if err != nil {
+		return ""
+	}
+	return info.Email
+}
+
+// shareHint tells whom to share the spreadsheet with.
+func shareHint() string {
+	if email := AuthenticatedEmail(); email != "" {
+		return fmt.Sprintf("share it with %s as an editor", email)
+	}
+	return fmt.Sprintf("share it as an editor with the Google account authorized in %s, or delete that file to sign in with another account", cachedCredsFile)
+}
+
+func isPermissionDenied(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && (apiErr.Code == http.StatusForbidden || apiErr.Code == http.StatusNotFound)
+}
+
+// NoWriteSheet leaves the spreadsheet untouched, for reading the inputs
+// of a spreadsheet that can only be viewed: results go to CSV files by
+// default, and the tabs written alongside them, e.g. the summary, to CSV
+// files under runsDir. Set by -no-write-sheet.
+var NoWriteSheet bool
+
+// CheckNoWriteSheet verifies that no output writes to the spreadsheet in
+// read-only mode.
+func CheckNoWriteSheet(outputs []string) error {
+	for _, output := range outputs {
+		if output == "sheets" || strings.HasPrefix(output, "sheets:") {
+			return fmt.Errorf("Output %s writes to the spreadsheet, which -no-write-sheet leaves untouched", output)
+		}
+	}
+	return nil
+}
+
+// WritesToSpreadsheet reports whether results go to tabs of the
+// spreadsheet, by default or with a sheets or sheets:NAMED_RANGE output.
+func WritesToSpreadsheet(spreadsheet string, outputs []string) bool {
+	if !IsGoogleSpreadsheet(spreadsheet) || NoWriteSheet {
+		return false
+	}
+	for _, output := range outputs {
+		if output == "sheets" || strings.HasPrefix(output, "sheets:") {
+			return true
+		}
+	}
+	return len(outputs) == 0
+}
+
+// CheckEditAccess verifies, before anything runs, that the spreadsheet can
+// be edited, rather than failing on the first write of results.
+func CheckEditAccess(srv *sheets.Service, spreadsheetID string) error {
+	resp, err := srv.Spreadsheets.Get(spreadsheetID).Fields("properties.title").Do()
+	if isPermissionDenied(err) {
+		return fmt.Errorf("Unable to open spreadsheet %s: to use it, %s", spreadsheetID, shareHint())
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to open spreadsheet %s: %v", spreadsheetID, err)
+	}
+	// Setting the title to itself changes nothing but needs edit rights
+	rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{{
+		UpdateSpreadsheetProperties: &sheets.UpdateSpreadsheetPropertiesRequest{
+			Properties: &sheets.SpreadsheetProperties{Title: resp.Properties.Title},
+			Fields:     "title",
+		},
+	}}}
+	_, err = srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do()
+	if isPermissionDenied(err) {
+		return fmt.Errorf("Spreadsheet %s is read-only: to record results in it, %s", spreadsheetID, shareHint())
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to check access to spreadsheet %s: %v", spreadsheetID, err)
+	}
+	return nil
+}
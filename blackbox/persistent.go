@@ -0,0 +1,242 @@
+package blackbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// persistentProcess is a running program answering input sets line by
+// line.
+type persistentProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func (p *persistentProcess) stop() {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+}
+
+// Time processes get to exit at the end of their input before being killed.
+const persistentExitGrace = 5 * time.Second
+
+// close ends the input of the process and waits for it to exit, killing it
+// if it ignores the end of its input.
+func (p *persistentProcess) close() {
+	p.stdin.Close()
+	exited := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(persistentExitGrace):
+		Log.Warnf("%s still running %v after the end of its input, killing it\n", p.cmd.Path, persistentExitGrace)
+		p.cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// PersistentTarget starts the program once and sends it one JSON line per
+// input set, which it answers with a line of outputs, saving the cost of
+// starting a process per input set. A process that dies, or runs past the
+// timeout, fails its input set and is replaced for the next ones.
+type PersistentTarget struct {
+	Program string
+	mu      sync.Mutex
+	// Idle processes by environment, as runs with another environment
+	// need another process
+	idle map[string][]*persistentProcess
+}
+
+func NewPersistentTarget(program string) *PersistentTarget {
+	return &PersistentTarget{Program: program, idle: map[string][]*persistentProcess{}}
+}
+
+func (t *PersistentTarget) start(env []string) (*persistentProcess, error) {
+	cmd := exec.Command(t.Program)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &persistentProcess{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// take returns an idle process of the environment, or a new one.
+func (t *PersistentTarget) take(env []string) (*persistentProcess, error) {
+	key := strings.Join(env, "\x00")
+	t.mu.Lock()
+	if idle := t.idle[key]; len(idle) > 0 {
+		p := idle[len(idle)-1]
+		t.idle[key] = idle[:len(idle)-1]
+		t.mu.Unlock()
+		return p, nil
+	}
+	t.mu.Unlock()
+	return t.start(env)
+}
+
+func (t *PersistentTarget) put(env []string, p *persistentProcess) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := strings.Join(env, "\x00")
+	t.idle[key] = append(t.idle[key], p)
+}
+
+func (t *PersistentTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	jsonBytes, err := ProgramInput(config.Types, varNames, inputSet)
+	if err != nil {
+		return nil, err
+	}
+	p, err := t.take(config.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	type response struct {
+		line []byte
+		err  error
+	}
+	done := make(chan response, 1)
+	go func() {
+		if _, err := p.stdin.Write(append(jsonBytes, '\n')); err != nil {
+			done <- response{nil, err}
+			return
+		}
+		line, err := p.stdout.ReadBytes('\n')
+		done <- response{line, err}
+	}()
+	var timeout <-chan time.Time
+	if config.Timeout > 0 {
+		timeout = time.After(config.Timeout)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil {
+			p.stop()
+			Log.Warnf("%s exited, restarting it: %v\n", t.Program, r.err)
+			return nil, fmt.Errorf("%s exited: %v", t.Program, r.err)
+		}
+		t.put(config.Env, p)
+		outputMap := make(map[string]string)
+		err = json.Unmarshal(r.line, &outputMap)
+		return outputMap, err
+	case <-timeout:
+		p.stop()
+		return nil, fmt.Errorf("%s timed out after %v", t.Program, config.Timeout)
+	}
+}
+
+// Close stops the idle processes, letting them exit on end of input.
+func (t *PersistentTarget) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, idle := range t.idle {
+		for _, p := range idle {
+			wg.Add(1)
+			go func(p *persistentProcess) {
+				defer wg.Done()
+				p.close()
+			}(p)
+		}
+	}
+	wg.Wait()
+	t.idle = map[string][]*persistentProcess{}
+	return nil
+}
+
+// LineSession is a run of the program exchanging JSON lines: it gets a
+// line of inputs on its stdin for every line of outputs expected on its
+// stdout. The timeout of the config covers the whole session.
+type LineSession struct {
+	program string
+	timeout time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+}
+
+func StartLineSession(program string, config RunnerConfig) (*LineSession, error) {
+	s := &LineSession{program: program, timeout: config.Timeout}
+	if config.Timeout > 0 {
+		s.ctx, s.cancel = context.WithTimeout(context.Background(), config.Timeout)
+	} else {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	s.cmd = exec.CommandContext(s.ctx, program)
+	if len(config.Env) > 0 {
+		s.cmd.Env = append(os.Environ(), config.Env...)
+	}
+	var err error
+	if s.stdin, err = s.cmd.StdinPipe(); err != nil {
+		s.cancel()
+		return nil, err
+	}
+	stdout, err := s.cmd.StdoutPipe()
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	if err := s.cmd.Start(); err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.stdout = bufio.NewReader(stdout)
+	return s, nil
+}
+
+// Exchange sends a line of inputs and returns the line of outputs
+// answering it.
+func (s *LineSession) Exchange(inputMap map[string]string) (map[string]string, error) {
+	line, err := json.Marshal(inputMap)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.stdin.Write(append(line, '\n')); err == nil {
+		line, err = s.stdout.ReadBytes('\n')
+	}
+	if s.ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %v", s.program, s.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s stopped: %v", s.program, err)
+	}
+	outputMap := make(map[string]string)
+	if err := json.Unmarshal(line, &outputMap); err != nil {
+		return nil, fmt.Errorf("Unable to read the outputs of %s: %v", s.program, err)
+	}
+	return outputMap, nil
+}
+
+// Close ends the input of the program and waits for it to exit.
+func (s *LineSession) Close() error {
+	s.stdin.Close()
+	err := s.cmd.Wait()
+	s.cancel()
+	return err
+}
@@ -0,0 +1,171 @@
+package blackbox
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// PivotSpec describes a pivot table of the results, added in a tab of its
+// own once the run completed.
+type PivotSpec struct {
+	Rows    []string
+	Columns []string
+	Values  []PivotValue
+}
+
+// PivotValue summarizes an output in the cells of a pivot table.
+type PivotValue struct {
+	// Sheets summarize function, e.g. AVERAGE
+	Function string
+	Column   string
+}
+
+var pivotValueRegexp = regexp.MustCompile(`^(\w+)\(([^()]+)\)$`)
+
+// Functions of the values of a pivot table, by name in a pivot spec.
+var pivotFunctions = map[string]string{
+	"sum":     "SUM",
+	"avg":     "AVERAGE",
+	"average": "AVERAGE",
+	"count":   "COUNTA",
+	"min":     "MIN",
+	"max":     "MAX",
+	"median":  "MEDIAN",
+	"stdev":   "STDEV",
+}
+
+// ParsePivotSpec parses rows=, cols= and values= fields separated by
+// spaces or semicolons, each a comma separated list, e.g.
+// "rows=size cols=algo values=avg(ms)"; values are sum, avg, count, min,
+// max, median or stdev of a column.
+func ParsePivotSpec(spec string) (PivotSpec, error) {
+	pivot := PivotSpec{}
+	fields := strings.FieldsFunc(spec, func(r rune) bool { return r == ';' || unicode.IsSpace(r) })
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return pivot, fmt.Errorf("Invalid pivot field %q in %q, expected rows=, cols= or values=", field, spec)
+		}
+		items := ExtractExamples(parts[1])
+		switch strings.ToLower(parts[0]) {
+		case "rows":
+			pivot.Rows = append(pivot.Rows, items...)
+		case "cols", "columns":
+			pivot.Columns = append(pivot.Columns, items...)
+		case "values":
+			for _, item := range items {
+				match := pivotValueRegexp.FindStringSubmatch(item)
+				if match == nil {
+					return pivot, fmt.Errorf("Invalid pivot value %q, expected e.g. avg(output)", item)
+				}
+				function, ok := pivotFunctions[strings.ToLower(match[1])]
+				if !ok {
+					return pivot, fmt.Errorf("Unknown pivot function %q, expected sum, avg, count, min, max, median or stdev", match[1])
+				}
+				pivot.Values = append(pivot.Values, PivotValue{Function: function, Column: strings.TrimSpace(match[2])})
+			}
+		default:
+			return pivot, fmt.Errorf("Invalid pivot field %q in %q, expected rows=, cols= or values=", field, spec)
+		}
+	}
+	if len(pivot.Rows)+len(pivot.Columns) == 0 || len(pivot.Values) == 0 {
+		return pivot, fmt.Errorf("Invalid pivot %q, expected rows= or cols= and values=", spec)
+	}
+	return pivot, nil
+}
+
+func ParsePivotSpecs(specs []string) ([]PivotSpec, error) {
+	pivots := []PivotSpec{}
+	for _, spec := range specs {
+		pivot, err := ParsePivotSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		pivots = append(pivots, pivot)
+	}
+	return pivots, nil
+}
+
+// AddPivotTables adds a tab per pivot table over the first rows of a
+// result tab (header included), pivot_NAME, pivot_NAME_2...
+func AddPivotTables(srv *sheets.Service, spreadsheetID string, sheetID int64, runName string, header []string, rows int, pivots []PivotSpec) error {
+	index := columnIndex(header)
+	for i, pivot := range pivots {
+		table := &sheets.PivotTable{
+			Source: &sheets.GridRange{
+				SheetId:          sheetID,
+				StartRowIndex:    0,
+				EndRowIndex:      int64(rows),
+				StartColumnIndex: 0,
+				EndColumnIndex:   int64(len(header)),
+				ForceSendFields:  []string{"SheetId", "StartRowIndex", "StartColumnIndex"},
+			},
+		}
+		missing := []string{}
+		group := func(column string) *sheets.PivotGroup {
+			offset, ok := index[column]
+			if !ok {
+				missing = append(missing, column)
+			}
+			return &sheets.PivotGroup{
+				SourceColumnOffset: int64(offset),
+				ShowTotals:         true,
+				SortOrder:          "ASCENDING",
+				ForceSendFields:    []string{"SourceColumnOffset"},
+			}
+		}
+		for _, column := range pivot.Rows {
+			table.Rows = append(table.Rows, group(column))
+		}
+		for _, column := range pivot.Columns {
+			table.Columns = append(table.Columns, group(column))
+		}
+		for _, value := range pivot.Values {
+			offset, ok := index[value.Column]
+			if !ok {
+				missing = append(missing, value.Column)
+			}
+			table.Values = append(table.Values, &sheets.PivotValue{
+				SummarizeFunction:  value.Function,
+				SourceColumnOffset: int64(offset),
+				Name:               strings.ToLower(value.Function) + "(" + value.Column + ")",
+				ForceSendFields:    []string{"SourceColumnOffset"},
+			})
+		}
+		if len(missing) > 0 {
+			Log.Warnf("Skipping pivot table of %s, not result columns\n", strings.Join(missing, ", "))
+			continue
+		}
+		name := relatedSheetName("pivot", runName)
+		if i > 0 {
+			name += "_" + strconv.Itoa(i+1)
+		}
+		rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{{
+			AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: name}},
+		}}}
+		resp, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do()
+		if err != nil {
+			return fmt.Errorf("Unable to add pivot tab %s: %v", name, err)
+		}
+		pivotSheetID := resp.Replies[0].AddSheet.Properties.SheetId
+		rb = &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{{
+			UpdateCells: &sheets.UpdateCellsRequest{
+				Start: &sheets.GridCoordinate{SheetId: pivotSheetID, ForceSendFields: []string{"SheetId", "RowIndex", "ColumnIndex"}},
+				Rows: []*sheets.RowData{{
+					Values: []*sheets.CellData{{PivotTable: table}},
+				}},
+				Fields: "pivotTable",
+			},
+		}}}
+		if _, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do(); err != nil {
+			return fmt.Errorf("Unable to add pivot table to %s: %v", name, err)
+		}
+		Log.Infof("Wrote %s\n", name)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package blackbox
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Columns of a queue tab, followed by the input variables and then the
+// outputs, added as they come.
+const (
+	queueStatusColumn  = "status"
+	queueWorkerColumn  = "worker"
+	queueClaimedColumn = "claimed_at"
+	queueErrorColumn   = "error"
+)
+
+// Statuses of the input sets of a queue.
+const (
+	queuePending = "pending"
+	queueRunning = "running"
+	queueDone    = "done"
+	queueFailed  = "failed"
+)
+
+// Sheets has no atomic update: a claim is written, then read back after
+// this long, and whoever wrote last owns the row.
+const queueClaimSettle = 2 * time.Second
+
+// QueueCommand implements "blackbox queue": a lightweight way to spread an
+// exploration over several machines with nothing but the spreadsheet. The
+// first process writes every input set to a queue tab as pending; every
+// process, started anywhere against the same spreadsheet, then claims
+// pending rows through their status and worker columns, runs them and
+// fills in their outputs, until none is left. Rows claimed longer than the
+// lease ago, e.g. by a worker that died, are claimed again.
+func QueueCommand(args []string) error {
+	flags := flag.NewFlagSet("queue", flag.ExitOnError)
+	queueSheet := flags.String("queue", "queue", "tab of the spreadsheet holding the queue")
+	inputs := flags.String("inputs", "", "tab or named range defining the input variables, when creating the queue (default inputs, or inputs_NAME with -experiment)")
+	experimentName := flags.String("experiment", "", "name of the experiment queued")
+	target := flags.String("target", "", "where to run the program, as for a run")
+	timeout := flags.Duration("timeout", 0, "kill the program if a single run takes longer than this (0 for no limit)")
+	lease := flags.Duration("lease", 30*time.Minute, "claim again rows running for longer than this, whose worker is presumed dead")
+	hostname, _ := os.Hostname()
+	worker := flags.String("worker", fmt.Sprintf("%s-%d", hostname, os.Getpid()), "name of this worker in the worker column")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox queue [flags] SPREADSHEET_ID PROGPATH\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return fmt.Errorf("spreadsheet or progpath param is missing")
+	}
+	spreadsheet := SpreadsheetID(flags.Arg(0))
+	if !IsGoogleSpreadsheet(spreadsheet) {
+		return fmt.Errorf("A queue is shared through a Google spreadsheet, not %s", spreadsheet)
+	}
+	experiment := Experiment{
+		Name:    *experimentName,
+		Program: flags.Arg(1),
+		Inputs:  *inputs,
+		Target:  *target,
+	}
+	if *timeout > 0 {
+		experiment.Timeout = timeout.String()
+	}
+
+	srv, err := Auth()
+	if err != nil {
+		return err
+	}
+	if err := CheckEditAccess(srv, spreadsheet); err != nil {
+		return err
+	}
+	source, err := OpenSource(srv, spreadsheet)
+	if err != nil {
+		return err
+	}
+	inputsSheet, err := InputsSheet(source, experiment)
+	if err != nil {
+		return err
+	}
+	setupRows, err := source.ReadRows(inputsSheet)
+	if err != nil {
+		return err
+	}
+	config, varNames, inputSets, err := planInputSets(source, inputsSheet, setupRows, experiment)
+	if err != nil {
+		return err
+	}
+	runTarget, err := OpenTarget(experiment.Target, experiment.Program, TargetOptions{})
+	if err != nil {
+		return err
+	}
+	queue := &SheetQueue{srv: srv, spreadsheetID: spreadsheet, sheetName: *queueSheet, worker: *worker, lease: *lease}
+	if err := queue.Create(varNames, inputSets); err != nil {
+		return err
+	}
+	return queue.Work(runTarget, config, varNames)
+}
+
+// SheetQueue is a queue of input sets in a tab shared by workers.
+type SheetQueue struct {
+	srv           *sheets.Service
+	spreadsheetID string
+	sheetName     string
+	worker        string
+	lease         time.Duration
+}
+
+// Create writes the queue tab with every input set pending, unless another
+// worker already did.
+func (q *SheetQueue) Create(varNames []string, inputSets [][]string) error {
+	names, err := (&SheetsSource{srv: q.srv, spreadsheetID: q.spreadsheetID}).SheetNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == q.sheetName {
+			Log.Infof("Joining queue %s\n", q.sheetName)
+			return nil
+		}
+	}
+	if _, err := CreateNewResultSheet(q.srv, q.spreadsheetID, q.sheetName); err != nil {
+		// Another worker starting at the same time created it first
+		Log.Infof("Joining queue %s: %v\n", q.sheetName, err)
+		return nil
+	}
+	header := append([]string{queueStatusColumn, queueWorkerColumn, queueClaimedColumn, queueErrorColumn}, varNames...)
+	rows := [][]string{header}
+	for _, inputSet := range inputSets {
+		rows = append(rows, append([]string{queuePending, "", "", ""}, inputSet...))
+	}
+	if err := q.update(fmt.Sprintf("%s!A1", q.sheetName), rows); err != nil {
+		return fmt.Errorf("Unable to fill queue %s: %v", q.sheetName, err)
+	}
+	Log.Infof("Queued %d input sets in %s\n", len(inputSets), q.sheetName)
+	return nil
+}
+
+func (q *SheetQueue) update(address string, rows [][]string) error {
+	values := [][]interface{}{}
+	for _, row := range rows {
+		value := []interface{}{}
+		for _, cell := range row {
+			value = append(value, cell)
+		}
+		values = append(values, value)
+	}
+	vr := sheets.ValueRange{Values: values}
+	_, err := q.srv.Spreadsheets.Values.Update(q.spreadsheetID, address, &vr).ValueInputOption("RAW").Do()
+	return err
+}
+
+// claimable returns the line of the first row to claim, 0 if none.
+func (q *SheetQueue) claimable(rows [][]string) int {
+	for i, row := range rows[1:] {
+		switch cell(row, 0) {
+		case queuePending:
+			return i + 2
+		case queueRunning:
+			claimed, err := time.Parse(time.RFC3339, cell(row, 2))
+			if err == nil && time.Since(claimed) > q.lease {
+				Log.Warnf("Claim of line %d by %s expired, claiming it again\n", i+2, cell(row, 1))
+				return i + 2
+			}
+		}
+	}
+	return 0
+}
+
+// claim marks a row running by this worker, and reports whether it still
+// is once other workers had time to claim it too.
+func (q *SheetQueue) claim(line int) (bool, error) {
+	address := fmt.Sprintf("%s!A%d:C%d", q.sheetName, line, line)
+	claim := []string{queueRunning, q.worker, time.Now().UTC().Format(time.RFC3339)}
+	if err := q.update(address, [][]string{claim}); err != nil {
+		return false, err
+	}
+	time.Sleep(queueClaimSettle + time.Duration(rand.Int63n(int64(time.Second))))
+	rows, err := readRows(q.srv, q.spreadsheetID, address)
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0 && cell(rows[0], 1) == q.worker, nil
+}
+
+// Work claims and runs the input sets of the queue until none is left.
+func (q *SheetQueue) Work(target Target, base RunnerConfig, varNames []string) error {
+	runs := 0
+	for {
+		rows, err := ReadSheetRows(q.srv, q.spreadsheetID, q.sheetName)
+		if err != nil {
+			return err
+		}
+		for i, varName := range varNames {
+			if cell(rows[0], 4+i) != varName {
+				return fmt.Errorf("Queue %s has other variables than %s, remove it to queue a new experiment", q.sheetName, strings.Join(varNames, ", "))
+			}
+		}
+		line := q.claimable(rows)
+		if line == 0 {
+			Log.Infof("Queue %s has nothing left to claim, %d runs by %s\n", q.sheetName, runs, q.worker)
+			return nil
+		}
+		owned, err := q.claim(line)
+		if err != nil {
+			return fmt.Errorf("Unable to claim line %d of %s: %v", line, q.sheetName, err)
+		}
+		if !owned {
+			continue
+		}
+		inputSet := make([]string, len(varNames))
+		for i := range varNames {
+			inputSet[i] = cell(rows[line-1], 4+i)
+		}
+		Log.Infof("Running line %d: %s\n", line, inputLabel(varNames, inputSet))
+		outputs, err := q.run(target, base, varNames, inputSet)
+		if err := q.complete(line, len(varNames), outputs, err); err != nil {
+			return err
+		}
+		runs++
+	}
+}
+
+func (q *SheetQueue) run(target Target, base RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	config, err := RunnerConfigFor(base, varNames, inputSet)
+	if err != nil {
+		return nil, err
+	}
+	return target.Run(config, varNames, inputSet)
+}
+
+// complete records the outcome of a row: its status, error and outputs,
+// adding the columns of outputs no row had yet.
+func (q *SheetQueue) complete(line, vars int, outputs map[string]string, runErr error) error {
+	status, message := queueDone, ""
+	if runErr != nil {
+		status, message = queueFailed, runErr.Error()
+		Log.Warnf("Line %d failed: %v\n", line, runErr)
+	}
+	if err := q.update(fmt.Sprintf("%s!A%d:D%d", q.sheetName, line, line), [][]string{{status, q.worker, time.Now().UTC().Format(time.RFC3339), message}}); err != nil {
+		return fmt.Errorf("Unable to complete line %d of %s: %v", line, q.sheetName, err)
+	}
+	if len(outputs) == 0 {
+		return nil
+	}
+	rows, err := readRows(q.srv, q.spreadsheetID, fmt.Sprintf("%s!1:1", q.sheetName))
+	if err != nil {
+		return err
+	}
+	header := rows[0]
+	keys := []string{}
+	for key := range outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	row := make([]string, len(header)-4-vars)
+	for _, key := range keys {
+		found := false
+		for i := 4 + vars; i < len(header); i++ {
+			if header[i] == key {
+				row[i-4-vars] = outputs[key]
+				found = true
+			}
+		}
+		if !found {
+			column := int64(len(header))
+			if err := q.update(fmt.Sprintf("%s!%s1", q.sheetName, columnLetters(column)), [][]string{{key}}); err != nil {
+				return err
+			}
+			header = append(header, key)
+			row = append(row, outputs[key])
+		}
+	}
+	if len(row) == 0 {
+		return nil
+	}
+	return q.update(fmt.Sprintf("%s!%s%d", q.sheetName, columnLetters(int64(4+vars)), line), [][]string{row})
+}
@@ -0,0 +1,74 @@
+package blackbox
+
+import (
+	"time"
+)
+
+// Google Sheets API write requests allowed per minute per user.
+const sheetsWriteQuotaPerMinute = 60
+
+// Write requests of a tab written with WriteRows besides its rows, which go
+// one per request: creating, formatting, renaming and filtering it.
+const sheetsTabWrites = 4
+
+// EstimateSheetsWrites returns how many write requests recording inputSets
+// results takes: creating the tab, then the header and rows in batches.
+func EstimateSheetsWrites(inputSets, rowsPerWrite int) int {
+	rows := inputSets + 1
+	return 1 + (rows+rowsPerWrite-1)/rowsPerWrite
+}
+
+// SheetsRunWrites returns how many write requests a run makes besides
+// recording its results: formatting and finalizing the result tab with its
+// conditional formats, filters, charts and pivot tables, then writing the
+// summary tab, of summaryRows rows, and the meta tab.
+func SheetsRunWrites(sinkContext *SinkContext, summaryRows int) int {
+	// Formatting, renaming and filters
+	writes := 3
+	if len(sinkContext.Thresholds) > 0 {
+		writes++
+	}
+	if len(sinkContext.Charts) > 0 {
+		writes++
+	}
+	// A tab and its pivot table each
+	writes += 2 * len(sinkContext.Pivots)
+	writes += sheetsTabWrites + summaryRows
+	writes += sheetsTabWrites + len(MetadataRows(RunMetadata{}))
+	return writes
+}
+
+// SummaryRows returns how many rows the summary tab of inputSets has at
+// least: a header, then a row for each value of each variable and output.
+func SummaryRows(varNames []string, inputSets [][]string) int {
+	rows := 1
+	for i := range varNames {
+		values := map[string]bool{}
+		for _, inputSet := range inputSets {
+			values[cell(inputSet, i)] = true
+		}
+		rows += len(values)
+	}
+	return rows
+}
+
+// PlanSheetsWrites reports the expected Sheets API usage of a run making
+// fixedWrites requests besides its results, and returns the minimum
+// interval between writes that keeps it under the per-minute quota, or
+// zero if the whole run fits in one minute's quota.
+func PlanSheetsWrites(inputSets, rowsPerWrite, fixedWrites int) time.Duration {
+	if rowsPerWrite < 1 {
+		rowsPerWrite = 1
+	}
+	writes := EstimateSheetsWrites(inputSets, rowsPerWrite) + fixedWrites
+	if writes <= sheetsWriteQuotaPerMinute {
+		Log.Infof("Sheets API: about %d write requests for %d input sets at %d rows per write (quota %d/min)\n",
+			writes, inputSets, rowsPerWrite, sheetsWriteQuotaPerMinute)
+		return 0
+	}
+	interval := time.Minute / sheetsWriteQuotaPerMinute
+	Log.Warnf("Sheets API: about %d write requests for %d input sets at %d rows per write exceed the quota of %d/min "+
+		"for fast runs: pacing writes to one per %v, batching rows in between\n",
+		writes, inputSets, rowsPerWrite, sheetsWriteQuotaPerMinute, interval)
+	return interval
+}
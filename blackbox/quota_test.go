@@ -0,0 +1,20 @@
+package blackbox
+
+import "testing"
+
+func TestSummaryRows(t *testing.T) {
+	tests := []struct {
+		varNames  []string
+		inputSets [][]string
+		want      int
+	}{
+		{varNames: []string{"size"}, inputSets: nil, want: 1},
+		{varNames: []string{"size", "rate"}, inputSets: [][]string{{"1", "a"}, {"2", "a"}, {"1", "b"}}, want: 5},
+		{varNames: []string{"size"}, inputSets: [][]string{{"1"}, {"1"}, {"1"}}, want: 2},
+	}
+	for _, test := range tests {
+		if got := SummaryRows(test.varNames, test.inputSets); got != test.want {
+			t.Errorf("SummaryRows(%q, %q) = %d, want %d", test.varNames, test.inputSets, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package blackbox
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket bounding the rate of program runs across
+// all workers, e.g. to respect the quota of an API behind the program. A
+// nil *RateLimiter does not limit.
+type RateLimiter struct {
+	mu sync.Mutex
+	// Tokens per second, and at most burst of them saved up
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+var rateUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+// ParseRate returns the limiter of a rate such as "5/s", "100/m" or "2/h".
+func ParseRate(rate string) (*RateLimiter, error) {
+	parts := strings.SplitN(rate, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid rate %q, expected e.g. 5/s", rate)
+	}
+	count, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	unit, ok := rateUnits[strings.TrimSpace(parts[1])]
+	if err != nil || !ok || count <= 0 {
+		return nil, fmt.Errorf("Invalid rate %q, expected e.g. 5/s, 100/m or 2/h", rate)
+	}
+	perSecond := count / unit.Seconds()
+	// A second's worth of runs may start at once
+	burst := math.Max(1, math.Floor(perSecond))
+	return &RateLimiter{rate: perSecond, burst: burst, tokens: burst, last: time.Now()}, nil
+}
+
+// Wait blocks until n runs may start. Waiters take their tokens in turn,
+// going into debt that later ones wait for.
+func (l *RateLimiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
@@ -0,0 +1,224 @@
+package blackbox
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Share of the intervals between adjacent values refined when looking for
+// the fastest changes of an output.
+const refineFraction = 0.25
+
+// RefineSpec tells where a sweep is refined: around the values where
+// Output crosses Threshold, or, without a threshold, where it changes
+// fastest.
+type RefineSpec struct {
+	Output       string
+	Threshold    float64
+	HasThreshold bool
+}
+
+// ParseRefineSpec parses "latency_ms" or "latency_ms=200".
+func ParseRefineSpec(text string) (RefineSpec, error) {
+	parts := strings.SplitN(text, "=", 2)
+	spec := RefineSpec{Output: strings.TrimSpace(parts[0])}
+	if spec.Output == "" {
+		return spec, fmt.Errorf("Invalid refinement %q, expected OUTPUT or OUTPUT=THRESHOLD", text)
+	}
+	if len(parts) == 2 {
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return spec, fmt.Errorf("Invalid refinement threshold in %q: %v", text, err)
+		}
+		spec.Threshold, spec.HasThreshold = threshold, true
+	}
+	return spec, nil
+}
+
+// numericAxis holds the values of a numeric variable explored so far, in
+// increasing order.
+type numericAxis struct {
+	numbers []float64
+	values  []string
+	integer bool
+}
+
+func (a *numericAxis) insert(value string) {
+	number, _ := strconv.ParseFloat(value, 64)
+	i := sort.SearchFloat64s(a.numbers, number)
+	if i < len(a.numbers) && a.numbers[i] == number {
+		return
+	}
+	a.numbers = append(a.numbers[:i], append([]float64{number}, a.numbers[i:]...)...)
+	a.values = append(a.values[:i], append([]string{value}, a.values[i:]...)...)
+}
+
+// midpoint returns the value halfway between two values of the axis,
+// false if there is none, e.g. between consecutive integers.
+func (a *numericAxis) midpoint(low, high float64) (string, bool) {
+	if a.integer {
+		mid := math.Floor((low + high) / 2)
+		if mid <= low || mid >= high {
+			return "", false
+		}
+		return strconv.FormatInt(int64(mid), 10), true
+	}
+	return FormatValue((low + high) / 2), true
+}
+
+// Refiner refines a coarse sweep: once a pass completed, it runs the
+// midpoints of the intervals between adjacent values of each numeric
+// variable where an output crosses a threshold or changes fastest, for
+// the given number of passes.
+type Refiner struct {
+	mu          sync.Mutex
+	spec        RefineSpec
+	passes      int
+	pass        int
+	baseVars    []string
+	varNames    []string
+	derived     []DerivedVar
+	constraints []string
+	// axes of the numeric variables, nil for the others
+	axes []*numericAxis
+	// values of the input sets run, and their output, by key
+	points  map[string][]string
+	outputs map[string]float64
+}
+
+// NewRefiner prepares the refinement of a sweep over the examples of
+// baseVars, extended with derived variables into varNames.
+func NewRefiner(spec RefineSpec, passes int, baseVars []string, exampleSets [][]string, varNames []string, derived []DerivedVar, constraints []string) *Refiner {
+	if passes < 1 {
+		passes = 1
+	}
+	r := &Refiner{
+		spec:        spec,
+		passes:      passes,
+		baseVars:    baseVars,
+		varNames:    varNames,
+		derived:     derived,
+		constraints: constraints,
+		points:      map[string][]string{},
+		outputs:     map[string]float64{},
+	}
+	for i, examples := range exampleSets {
+		axis := &numericAxis{integer: true}
+		for _, example := range examples {
+			if _, err := strconv.ParseFloat(example, 64); err != nil || IsMetaVar(baseVars[i]) {
+				axis = nil
+				break
+			}
+			if _, err := strconv.ParseInt(example, 10, 64); err != nil {
+				axis.integer = false
+			}
+			axis.insert(example)
+		}
+		r.axes = append(r.axes, axis)
+	}
+	return r
+}
+
+// Observe records the output of a completed run.
+func (r *Refiner) Observe(inputSet []string, outputMap map[string]string, runErr error) {
+	if runErr != nil {
+		return
+	}
+	value, err := strconv.ParseFloat(outputMap[r.spec.Output], 64)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	base := inputSet[:len(r.baseVars)]
+	key := inputSetKey(base)
+	r.points[key] = base
+	r.outputs[key] = value
+}
+
+type refinedInterval struct {
+	change float64
+	point  []string
+	axis   int
+	low    float64
+	high   float64
+}
+
+// NextBatch returns the input sets of the next refinement pass, none once
+// every pass ran or nothing is left to refine.
+func (r *Refiner) NextBatch() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pass >= r.passes {
+		return nil
+	}
+	r.pass++
+
+	intervals := []refinedInterval{}
+	for key, point := range r.points {
+		for i, axis := range r.axes {
+			if axis == nil {
+				continue
+			}
+			number, _ := strconv.ParseFloat(point[i], 64)
+			// Find the next larger value run with the other variables unchanged
+			neighbor := append([]string{}, point...)
+			for j := sort.SearchFloat64s(axis.numbers, number) + 1; j < len(axis.values); j++ {
+				neighbor[i] = axis.values[j]
+				output, ok := r.outputs[inputSetKey(neighbor)]
+				if !ok {
+					continue
+				}
+				a, b := r.outputs[key], output
+				interval := refinedInterval{change: math.Abs(b - a), point: point, axis: i, low: number, high: axis.numbers[j]}
+				if !r.spec.HasThreshold || (a-r.spec.Threshold)*(b-r.spec.Threshold) < 0 {
+					intervals = append(intervals, interval)
+				}
+				break
+			}
+		}
+	}
+	if !r.spec.HasThreshold {
+		sort.Slice(intervals, func(i, j int) bool { return intervals[i].change > intervals[j].change })
+		intervals = intervals[:int(math.Ceil(float64(len(intervals))*refineFraction))]
+	}
+
+	inputSets := [][]string{}
+	scheduled := map[string]bool{}
+	for _, interval := range intervals {
+		mid, ok := r.axes[interval.axis].midpoint(interval.low, interval.high)
+		if !ok {
+			continue
+		}
+		inputSet := append([]string{}, interval.point...)
+		inputSet[interval.axis] = mid
+		key := inputSetKey(inputSet)
+		if _, ran := r.points[key]; ran || scheduled[key] {
+			continue
+		}
+		scheduled[key] = true
+		inputSets = append(inputSets, inputSet)
+	}
+
+	_, inputSets, err := AddDerivedVars(r.baseVars, inputSets, r.derived)
+	if err == nil {
+		inputSets, err = FilterInputSets(r.varNames, inputSets, r.constraints)
+	}
+	if err != nil {
+		Log.Warnf("Unable to refine: %v\n", err)
+		return nil
+	}
+	for _, inputSet := range inputSets {
+		for i, axis := range r.axes {
+			if axis != nil {
+				axis.insert(inputSet[i])
+			}
+		}
+	}
+	Log.Infof("Refinement pass %d: %d new input sets in %d intervals\n", r.pass, len(inputSets), len(intervals))
+	return inputSets
+}
@@ -0,0 +1,382 @@
+package blackbox
+
+import (
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Failed runs listed in a report, and size of its charts.
+const (
+	reportFailures    = 20
+	reportChartWidth  = 480
+	reportChartHeight = 240
+	reportChartMargin = 40
+)
+
+// ReportCommand implements "blackbox report": it renders a result tab as
+// a Markdown or HTML report with the metadata of the run, statistics of
+// its outputs and, in HTML, charts of them, to share a run without
+// sharing the spreadsheet.
+func ReportCommand(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	from := flags.String("from", "", "result tab to report on, e.g. result_1699000000")
+	format := flags.String("format", "md", "report format: md or html")
+	output := flags.String("o", "", "file to write the report to (default stdout)")
+	inputs := flags.String("inputs", "", "tab defining the input variables (default the inputs of the run, or inputs)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox report -from TAB [-format md|html] [-o FILE] SPREADSHEET_ID|FILE.xlsx\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 || *from == "" {
+		flags.Usage()
+		return fmt.Errorf("spreadsheet or from param is missing")
+	}
+	if *format != "md" && *format != "html" {
+		return fmt.Errorf("Invalid report format %q, expected md or html", *format)
+	}
+	spreadsheet := SpreadsheetID(flags.Arg(0))
+
+	var srv *sheets.Service
+	if IsGoogleSpreadsheet(spreadsheet) {
+		var err error
+		if srv, err = Auth(); err != nil {
+			return err
+		}
+	}
+	source, err := OpenSource(srv, spreadsheet)
+	if err != nil {
+		return err
+	}
+	rows, err := source.ReadRows(*from)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("No results in %s", *from)
+	}
+	metadata, err := ReadOptionalRows(source, relatedSheetName("meta", *from))
+	if err != nil {
+		return err
+	}
+	inputsSheet := *inputs
+	for _, row := range metadata {
+		if cell(row, 0) == "inputs" && inputsSheet == "" {
+			inputsSheet = cell(row, 1)
+		}
+	}
+	if inputsSheet == "" {
+		inputsSheet = "inputs"
+	}
+	varNames, err := ReadVarNames(source, inputsSheet)
+	if err != nil {
+		return fmt.Errorf("Unable to find the input columns, use -inputs: %v", err)
+	}
+
+	report := NewReport(*from, metadata, rows[0], rows[1:], varNames)
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("Unable to write the report: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "html" {
+		err = htmlReportTemplate.Execute(w, report)
+	} else {
+		err = markdownReportTemplate.Execute(w, report)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to render the report: %v", err)
+	}
+	if *output != "" {
+		Log.Infof("Wrote the report of %s to %s\n", *from, *output)
+	}
+	return nil
+}
+
+// Report is what the report templates render.
+type Report struct {
+	Run      string
+	Metadata [][]string
+	Runs     int
+	Failed   int
+	// Statistics of every numeric output over the whole run, header first
+	Outputs [][]string
+	// Statistics grouped by input values, header first
+	Summary  [][]string
+	Failures []ReportFailure
+	// Failures not listed
+	MoreFailures int
+	Charts       []ReportChart
+}
+
+// ReportFailure is a failed run of a report.
+type ReportFailure struct {
+	Input string
+	Error string
+}
+
+// ReportChart is an SVG scatter plot of an output against an input.
+type ReportChart struct {
+	Title         string
+	Width, Height int
+	// Plot area, between the axes
+	Left, Top, Right, Bottom int
+	Points                   []ReportPoint
+	// Labels of the axis bounds
+	MinX, MaxX, MinY, MaxY string
+}
+
+// ReportPoint is a point of a chart in SVG coordinates.
+type ReportPoint struct {
+	X, Y  float64
+	Label string
+}
+
+// NewReport gathers the report of the rows of a result tab, its metadata
+// rows being key/value pairs after a header.
+func NewReport(run string, metadata [][]string, header []string, rows [][]string, varNames []string) *Report {
+	report := &Report{Run: run, Runs: len(rows)}
+	if len(metadata) > 1 {
+		report.Metadata = metadata[1:]
+	}
+	index := columnIndex(header)
+	present := []string{}
+	for _, varName := range varNames {
+		if _, ok := index[varName]; ok {
+			present = append(present, varName)
+		}
+	}
+	for _, row := range rows {
+		problem := cell(row, indexOr(index, errorColumn))
+		if assertions := cell(row, indexOr(index, assertionsColumn)); problem == "" && IsAssertionFailure(assertions) {
+			problem = assertions
+		}
+		if problem == "" {
+			continue
+		}
+		report.Failed++
+		if len(report.Failures) == reportFailures {
+			report.MoreFailures++
+			continue
+		}
+		inputSet := make([]string, len(present))
+		for i, varName := range present {
+			inputSet[i] = cell(row, index[varName])
+		}
+		report.Failures = append(report.Failures, ReportFailure{Input: inputLabel(present, inputSet), Error: problem})
+	}
+
+	isInput := map[string]bool{}
+	for _, varName := range varNames {
+		isInput[varName] = true
+	}
+	report.Outputs = [][]string{{"output", "runs", "min", "max", "mean", "median", "p95"}}
+	for column, name := range header {
+		if isInput[name] || isBookkeepingColumn(name) {
+			continue
+		}
+		numbers := numericColumn(rows, column)
+		if len(numbers) == 0 {
+			continue
+		}
+		sum := 0.0
+		for _, number := range numbers {
+			sum += number
+		}
+		sort.Float64s(numbers)
+		report.Outputs = append(report.Outputs, []string{
+			name,
+			strconv.Itoa(len(numbers)),
+			FormatValue(numbers[0]),
+			FormatValue(numbers[len(numbers)-1]),
+			FormatValue(sum / float64(len(numbers))),
+			FormatValue(Quantile(numbers, 0.5)),
+			FormatValue(Quantile(numbers, 0.95)),
+		})
+		if chart, ok := scatterChart(rows, index, present, column, name); ok {
+			report.Charts = append(report.Charts, chart)
+		}
+	}
+	if len(report.Outputs) == 1 {
+		report.Outputs = nil
+	}
+	if summary := SummarizeResults(header, rows, varNames); len(summary) > 1 {
+		report.Summary = summary
+	}
+	return report
+}
+
+func indexOr(index map[string]int, column string) int {
+	if i, ok := index[column]; ok {
+		return i
+	}
+	return -1
+}
+
+// numericColumn returns the numbers of a column, skipping other values.
+func numericColumn(rows [][]string, column int) []float64 {
+	numbers := []float64{}
+	for _, row := range rows {
+		if number, err := strconv.ParseFloat(cell(row, column), 64); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	return numbers
+}
+
+// scatterChart plots an output against the first numeric input variable,
+// or against the row number if no variable is numeric.
+func scatterChart(rows [][]string, index map[string]int, varNames []string, column int, output string) (ReportChart, bool) {
+	xColumn, xName := -1, "row"
+	for _, varName := range varNames {
+		if !IsMetaVar(varName) && len(numericColumn(rows, index[varName])) == len(rows) {
+			xColumn, xName = index[varName], varName
+			break
+		}
+	}
+	xs, ys := []float64{}, []float64{}
+	for i, row := range rows {
+		y, err := strconv.ParseFloat(cell(row, column), 64)
+		if err != nil {
+			continue
+		}
+		x := float64(i + 1)
+		if xColumn >= 0 {
+			x, _ = strconv.ParseFloat(cell(row, xColumn), 64)
+		}
+		xs, ys = append(xs, x), append(ys, y)
+	}
+	if len(xs) == 0 {
+		return ReportChart{}, false
+	}
+	minX, maxX := bounds(xs)
+	minY, maxY := bounds(ys)
+	chart := ReportChart{
+		Title:  output + " by " + xName,
+		Width:  reportChartWidth,
+		Height: reportChartHeight,
+		Left:   reportChartMargin,
+		Top:    reportChartMargin,
+		Right:  reportChartWidth - reportChartMargin,
+		Bottom: reportChartHeight - reportChartMargin,
+		MinX:   FormatValue(minX),
+		MaxX:   FormatValue(maxX),
+		MinY:   FormatValue(minY),
+		MaxY:   FormatValue(maxY),
+	}
+	for i := range xs {
+		chart.Points = append(chart.Points, ReportPoint{
+			X:     float64(chart.Left) + scale(xs[i], minX, maxX)*float64(chart.Right-chart.Left),
+			Y:     float64(chart.Bottom) - scale(ys[i], minY, maxY)*float64(chart.Bottom-chart.Top),
+			Label: fmt.Sprintf("%s=%s %s=%s", xName, FormatValue(xs[i]), output, FormatValue(ys[i])),
+		})
+	}
+	return chart, true
+}
+
+func bounds(values []float64) (float64, float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, value := range values {
+		min, max = math.Min(min, value), math.Max(max, value)
+	}
+	return min, max
+}
+
+// scale maps value from [min, max] to [0, 1], centering a single value.
+func scale(value, min, max float64) float64 {
+	if max == min {
+		return 0.5
+	}
+	return (value - min) / (max - min)
+}
+
+var reportFuncs = map[string]interface{}{
+	// Escapes pipes in Markdown table cells
+	"cellmd": func(value string) string {
+		return strings.NewReplacer("|", `\|`, "\n", " ").Replace(value)
+	},
+}
+
+var markdownReportTemplate = template.Must(template.New("report.md").Funcs(reportFuncs).Parse(`# {{.Run}}
+
+{{.Runs}} runs, {{.Failed}} failed.
+{{if .Metadata}}
+## Run
+
+| key | value |
+| --- | --- |
+{{range .Metadata}}| {{cellmd (index . 0)}} | {{if gt (len .) 1}}{{cellmd (index . 1)}}{{end}} |
+{{end}}{{end}}{{if .Outputs}}
+## Outputs
+
+{{template "table" .Outputs}}{{end}}{{if .Summary}}
+## By input
+
+{{template "table" .Summary}}{{end}}{{if .Failures}}
+## Failures
+
+{{range .Failures}}- {{.Input}}: {{cellmd .Error}}
+{{end}}{{if .MoreFailures}}- and {{.MoreFailures}} more
+{{end}}{{end}}
+{{- define "table"}}{{range $i, $row := .}}|{{range $row}} {{cellmd .}} |{{end}}
+{{if eq $i 0}}|{{range $row}} --- |{{end}}
+{{end}}{{end}}{{end}}`))
+
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("report.html").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Run}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; }
+th { background: #eee; }
+.failed { color: #c00; }
+svg { margin: 0 1em 1em 0; }
+</style>
+</head>
+<body>
+<h1>{{.Run}}</h1>
+<p>{{.Runs}} runs, <span class="failed">{{.Failed}} failed</span>.</p>
+{{if .Metadata}}<h2>Run</h2>
+<table>
+{{range .Metadata}}<tr><th>{{index . 0}}</th><td>{{if gt (len .) 1}}{{index . 1}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Outputs}}<h2>Outputs</h2>
+{{template "table" .Outputs}}{{end}}{{if .Charts}}<h2>Charts</h2>
+{{range .Charts}}<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+<text x="{{.Left}}" y="20">{{.Title}}</text>
+<polyline points="{{.Left}},{{.Top}} {{.Left}},{{.Bottom}} {{.Right}},{{.Bottom}}" fill="none" stroke="#999"/>
+{{range .Points}}<circle cx="{{printf "%.1f" .X}}" cy="{{printf "%.1f" .Y}}" r="3" fill="#4285f4"><title>{{.Label}}</title></circle>
+{{end}}<text x="{{.Left}}" y="{{.Bottom}}" dy="16" font-size="11">{{.MinX}}</text>
+<text x="{{.Right}}" y="{{.Bottom}}" dy="16" font-size="11" text-anchor="end">{{.MaxX}}</text>
+<text x="{{.Left}}" y="{{.Top}}" dx="-4" font-size="11" text-anchor="end">{{.MaxY}}</text>
+<text x="{{.Left}}" y="{{.Bottom}}" dx="-4" font-size="11" text-anchor="end">{{.MinY}}</text>
+</svg>
+{{end}}{{end}}{{if .Summary}}<h2>By input</h2>
+{{template "table" .Summary}}{{end}}{{if .Failures}}<h2>Failures</h2>
+<ul>
+{{range .Failures}}<li>{{.Input}}: <span class="failed">{{.Error}}</span></li>
+{{end}}{{if .MoreFailures}}<li>and {{.MoreFailures}} more</li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+{{- define "table"}}<table>
+{{range $i, $row := .}}<tr>{{range $row}}{{if eq $i 0}}<th>{{.}}</th>{{else}}<td>{{.}}</td>{{end}}{{end}}</tr>
+{{end}}</table>
+{{end}}`))
@@ -0,0 +1,59 @@
+package blackbox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Columns Google Forms adds to a responses tab, which are not variables.
+var formColumns = map[string]bool{
+	"timestamp":     true,
+	"email address": true,
+	"score":         true,
+}
+
+// ReadResponses reads the input sets of the responses tab of a Google
+// Form, so that anyone can submit scenarios to run through the form:
+// every response is an input set, and every question a variable named
+// after its title made an identifier, e.g. "Payload size" is Payload_size.
+// Variables of the same name in the inputs tab give their types.
+func ReadResponses(source Source, sheetName string) ([]string, [][]string, error) {
+	rows, err := source.ReadRows(sheetName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to read responses %s: %v", sheetName, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("The responses tab %s is empty", sheetName)
+	}
+	varNames := []string{}
+	columns := []int{}
+	seen := map[string]bool{}
+	for i, title := range rows[0] {
+		title = strings.TrimSpace(title)
+		if title == "" || formColumns[strings.ToLower(title)] {
+			continue
+		}
+		varName := sanitizeIdentifier(title)
+		if seen[varName] {
+			return nil, nil, fmt.Errorf("Several questions of %s are named %s as a variable", sheetName, varName)
+		}
+		seen[varName] = true
+		varNames = append(varNames, varName)
+		columns = append(columns, i)
+	}
+	if len(varNames) == 0 {
+		return nil, nil, fmt.Errorf("No questions in the header row of %s", sheetName)
+	}
+	inputSets := [][]string{}
+	for _, row := range rows[1:] {
+		if isBlankRow(row) {
+			continue
+		}
+		inputSet := make([]string, len(columns))
+		for j, column := range columns {
+			inputSet[j] = strings.TrimSpace(cell(row, column))
+		}
+		inputSets = append(inputSets, inputSet)
+	}
+	return varNames, inputSets, nil
+}
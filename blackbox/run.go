@@ -0,0 +1,771 @@
+package blackbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Experiment describes one exploration: which program to run over the
+// variables of which inputs tab or named range, and where to record the
+// results.
+type Experiment struct {
+	Name    string   `json:"name"`
+	Program string   `json:"program"`
+	Inputs  string   `json:"inputs"`
+	Outputs []string `json:"outputs"`
+	// Other inputs tabs whose variables are merged with those of Inputs,
+	// e.g. libraries shared by several experiments, see ParseInputSource
+	InputSources []string `json:"input_sources"`
+	// Responses tab of a Google Form whose responses are the input sets to
+	// run, rather than combinations of examples, see ReadResponses
+	Responses string `json:"responses"`
+	// Where the program runs, see OpenTarget, e.g. "docker:python:3.9",
+	// with the container limits and image pull policy of TargetOptions
+	Target string `json:"target"`
+	CPUs   string `json:"cpus"`
+	Memory string `json:"memory"`
+	Pull   string `json:"pull"`
+	// Template file rendering the program input over the input map, for
+	// programs expecting another format than a JSON object
+	StdinTemplate string `json:"stdin_template"`
+	// Another program run over every input set, whose outputs are recorded
+	// next to those of Program with the outputs they disagree on, leaving
+	// out CompareIgnore, see CompareTarget. Compare rather lists programs
+	// with their own targets, replacing Program and Target.
+	CompareWith   string        `json:"compare_with"`
+	Compare       []CompareSpec `json:"compare"`
+	CompareIgnore []string      `json:"compare_ignore"`
+
+	// Runner defaults, overridable per input set with meta-variables
+	Timeout     string            `json:"timeout"`
+	Concurrency int               `json:"concurrency"`
+	Env         map[string]string `json:"env"`
+	// Record the resources used by every run of a local program as rusage_*
+	// outputs, see ProcessUsage
+	MeasureRusage bool `json:"measure_rusage"`
+	// Memory, e.g. 512M, and CPUs every run of a local program may use, see
+	// ResourceLimits
+	LimitMemory string `json:"limit_memory"`
+	LimitCPU    string `json:"limit_cpu"`
+	// Run every run of a local program in a fresh working directory, kept
+	// when the run fails if KeepFailed is set, recording it as an output
+	WorkdirPerRun bool `json:"workdir_per_run"`
+	KeepFailed    bool `json:"keep_failed"`
+	// Glob patterns of the files to upload to ArtifactsFolder, or a new Drive
+	// folder, from the working directory of every run, see ArtifactUploader
+	Artifacts       []string `json:"artifacts"`
+	ArtifactsFolder string   `json:"artifacts_folder"`
+	// Give each parallel worker a state directory kept for the whole run
+	WorkerState bool `json:"worker_state"`
+	// Input sets sent to the program at once, as a JSON array, if above 1
+	BatchSize int `json:"batch_size"`
+	// Start the program once and send it an input set per line, see
+	// PersistentTarget
+	Persistent bool `json:"persistent"`
+	// Scenario file run by the program for every input set, see Scenario
+	Scenario string `json:"scenario"`
+	// Variable whose values are sequences sent step by step to a single
+	// program run, every ScheduleInterval, see ScheduleTarget
+	Schedule         string `json:"schedule"`
+	ScheduleInterval string `json:"schedule_interval"`
+	// Variable whose input sets sharing a value run on the same worker
+	Affinity string `json:"affinity"`
+	// Maximum rate of program runs across workers, e.g. "5/s", see
+	// RateLimiter
+	Rate string `json:"rate"`
+	// Where to post a summary of the run once it finished or failed, see
+	// Notify
+	Notify []string `json:"notify"`
+	// Budget of the exploration, e.g. "2h" and 10000, stopping it
+	// cleanly once exhausted
+	MaxDuration string `json:"max_duration"`
+	MaxRuns     int    `json:"max_runs"`
+
+	// Numeric outputs whose percentiles are shown while running
+	Track []string `json:"track"`
+	// Charts embedded in the result tab, as "out_var:in_var[:scatter|line]"
+	Charts []string `json:"charts"`
+	// Pivot tables added in tabs of their own, see ParsePivotSpec
+	Pivots []string `json:"pivots"`
+	// Output values to color, as "column: warn>200 crit>500"
+	Thresholds []string `json:"thresholds"`
+
+	// Variables computed per input set, as "name = expression"
+	Derived []string `json:"derived"`
+
+	// Expressions every input set must satisfy, in addition to the
+	// constraints tab
+	Constraints []string `json:"constraints"`
+	// Input combinations to skip, in addition to the exclude tab, e.g.
+	// [{"size": "0", "mode": "tls"}]
+	Exclude []Exclusion `json:"exclude"`
+	// Expression scoring input sets, those of the lowest scores running
+	// first, e.g. "abs(rate-100)"
+	OrderBy string `json:"order_by"`
+	// Name the result tab after the date and time of the run, e.g.
+	// result_2021-03-04_0300, as for runs on a schedule
+	Dated bool `json:"dated"`
+	// Append a row summarizing the run to the trend tab of the experiment,
+	// see TrendSink, charting the mean of TrendCharts outputs over time
+	Trend       bool     `json:"trend"`
+	TrendCharts []string `json:"trend_charts"`
+	// Rules failing the run once complete, see GateRule, possibly against
+	// the results of Baseline, a result tab or CSV file
+	FailIf   string `json:"fail_if"`
+	Baseline string `json:"baseline"`
+	// JSON file recording the outputs of every input set on the first run,
+	// which later runs must reproduce, see GoldenSink
+	Golden string `json:"golden"`
+
+	// Rows per Sheets API write request, unless set in Buffering
+	SheetsBatch int `json:"sheets_batch"`
+	// Buffering policies by output kind, e.g. "sheets" or "bq"
+	Buffering map[string]BufferPolicy `json:"buffering"`
+	// Columns per result tab before outputs spill into auxiliary tabs
+	MaxColumns int `json:"max_columns"`
+	// Rows per result tab before results roll over into result_x_part2...
+	MaxRows int `json:"max_rows"`
+	// Written rows read back from Sheets at the end to check they match
+	VerifyWrites int `json:"verify_writes"`
+	// Encoders rendering the values of each output kind, see EncoderFor
+	Encoders map[string]string `json:"encoders"`
+
+	// Expressions over inputs and outputs every run should satisfy, in
+	// addition to the assertions tab
+	Assertions []string `json:"assertions"`
+
+	// Failure handling, see ExplorationOptions
+	KeepGoing bool   `json:"keep_going"`
+	AbortIf   string `json:"abort_if"`
+
+	// How input sets are explored: "exhaustive" (the default) runs every
+	// one, search strategies such as "anneal" look for the best Objective,
+	// e.g. "minimize latency_ms", within Budget runs, and "fuzz" runs
+	// FuzzRuns input sets drawn at random
+	Strategy  string `json:"strategy"`
+	Objective string `json:"objective"`
+	Budget    int    `json:"budget"`
+	// Input sets drawn by the fuzz strategy
+	FuzzRuns int `json:"runs"`
+	// Size and number of generations of the genetic strategy
+	Population  int `json:"population"`
+	Generations int `json:"generations"`
+
+	// Output around whose fastest changes, or threshold crossings with
+	// "output=threshold", an exhaustive sweep is refined with finer values
+	// of its numeric variables, for RefinePasses passes
+	Refine       string `json:"refine"`
+	RefinePasses int    `json:"refine_passes"`
+	// Program runs spent minimizing each input set crashing the program,
+	// see CrashTriage, 0 for the default and negative not to minimize
+	MinimizeRuns int `json:"minimize_runs"`
+	// Random seed of search strategies, chosen at start if unset
+	Seed int64 `json:"seed"`
+}
+
+// RunnerConfig returns the experiment's base runner config.
+func (e Experiment) RunnerConfig() (RunnerConfig, error) {
+	config := RunnerConfig{Concurrency: e.Concurrency, MeasureRusage: e.MeasureRusage, WorkdirPerRun: e.WorkdirPerRun || len(e.Artifacts) > 0, KeepFailed: e.KeepFailed}
+	if e.Timeout != "" {
+		timeout, err := time.ParseDuration(e.Timeout)
+		if err != nil {
+			return config, fmt.Errorf("Invalid timeout %q: %v", e.Timeout, err)
+		}
+		config.Timeout = timeout
+	}
+	limits, err := ParseResourceLimits(e.LimitMemory, e.LimitCPU)
+	if err != nil {
+		return config, err
+	}
+	config.Limits = limits
+	for name, value := range e.Env {
+		config.Env = append(config.Env, name+"="+value)
+	}
+	return config, nil
+}
+
+// RunResult summarises a finished (or failed) experiment.
+type RunResult struct {
+	Experiment Experiment
+	RunID      string
+	ResultName string
+	Start      time.Time
+	InputSets  int
+	// Input variables of the results
+	VarNames []string
+	// Runs recorded, and those that failed
+	Runs     int
+	Failures int
+	// Header and rows recorded, kept only when needed once the run finished:
+	// by notifications attaching them, gates or comparisons
+	Recorded [][]string
+	Duration time.Duration
+	Err      error
+}
+
+// relatedSheetName names a tab written alongside a result tab, e.g.
+// summary_fib_1600000000 for result_fib_1600000000.
+func relatedSheetName(kind, runName string) string {
+	return kind + "_" + strings.TrimPrefix(runName, "result_")
+}
+
+// InputsSheet returns the tab defining the variables of an experiment: its
+// inputs, or else inputs_NAME for a named experiment if the spreadsheet
+// has that tab, so that one spreadsheet can hold several experiments, or
+// else "inputs".
+func InputsSheet(source Source, experiment Experiment) (string, error) {
+	if experiment.Inputs != "" {
+		return experiment.Inputs, nil
+	}
+	if experiment.Name != "" {
+		names, err := source.SheetNames()
+		if err != nil {
+			return "", err
+		}
+		for _, name := range names {
+			if name == "inputs_"+experiment.Name {
+				return name, nil
+			}
+		}
+	}
+	return "inputs", nil
+}
+
+func resultName(experiment Experiment, start time.Time) string {
+	if experiment.Dated {
+		if experiment.Name != "" {
+			return fmt.Sprintf("result_%s_%s", experiment.Name, start.Format("2006-01-02_1504"))
+		}
+		return fmt.Sprintf("result_%s", start.Format("2006-01-02_1504"))
+	}
+	if experiment.Name != "" {
+		return fmt.Sprintf("result_%s_%d", experiment.Name, start.Unix())
+	}
+	return fmt.Sprintf("result_%d", start.Unix())
+}
+
+// streamingTarget returns the target of the local programs exchanging
+// several messages per run or per exploration, or target if the program
+// answers a single message.
+func streamingTarget(experiment Experiment, varNames []string, target Target) (Target, error) {
+	modes := []string{}
+	if experiment.Schedule != "" {
+		modes = append(modes, "schedule")
+	}
+	if experiment.Scenario != "" {
+		modes = append(modes, "scenario")
+	}
+	if experiment.Persistent {
+		modes = append(modes, "persistent")
+	}
+	switch {
+	case len(modes) == 0:
+		return target, nil
+	case len(modes) > 1:
+		return nil, fmt.Errorf("Only one of %s applies", strings.Join(modes, ", "))
+	case len(compareSpecs(experiment)) > 0:
+		return nil, fmt.Errorf("The %s mode runs a single program, not a comparison", modes[0])
+	case experiment.Target != "" && experiment.Target != "local":
+		return nil, fmt.Errorf("The %s mode only runs with the local target", modes[0])
+	case experiment.StdinTemplate != "":
+		return nil, fmt.Errorf("The %s mode exchanges JSON lines, without a stdin template", modes[0])
+	}
+	switch {
+	case experiment.Schedule != "":
+		return scheduleTarget(experiment, varNames)
+	case experiment.Scenario != "":
+		scenario, err := LoadScenario(experiment.Scenario)
+		if err != nil {
+			return nil, err
+		}
+		return ScenarioTarget{Program: experiment.Program, Scenario: scenario}, nil
+	}
+	return NewPersistentTarget(experiment.Program), nil
+}
+
+// scheduleTarget returns the target running the program over the
+// schedules of the experiment.
+func scheduleTarget(experiment Experiment, varNames []string) (Target, error) {
+	known := false
+	for _, varName := range varNames {
+		known = known || varName == experiment.Schedule
+	}
+	if !known || IsMetaVar(experiment.Schedule) {
+		return nil, fmt.Errorf("Unknown schedule variable %s", experiment.Schedule)
+	}
+	target := ScheduleTarget{Program: experiment.Program, Var: experiment.Schedule}
+	if experiment.ScheduleInterval != "" {
+		interval, err := time.ParseDuration(experiment.ScheduleInterval)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schedule interval %q: %v", experiment.ScheduleInterval, err)
+		}
+		target.Interval = interval
+	}
+	return target, nil
+}
+
+// RunExperiment reads the experiment's inputs, runs the program over every
+// input set and records the results in the experiment's sinks.
+func RunExperiment(srv *sheets.Service, spreadsheetID string, experiment Experiment) RunResult {
+	start := time.Now()
+	result := RunResult{
+		Experiment: experiment,
+		RunID:      NewRunID(start),
+		ResultName: resultName(experiment, start),
+		Start:      start,
+	}
+	Log.Infof("Run %s: %s\n", result.RunID, result.ResultName)
+	if (IsSearchStrategy(experiment.Strategy) || experiment.Strategy == fuzzStrategy) && experiment.Seed == 0 {
+		experiment.Seed = start.UnixNano()
+		result.Experiment.Seed = experiment.Seed
+	}
+	metadata := NewRunMetadata(result.RunID, result.ResultName, spreadsheetID, experiment, start)
+	record, err := NewRunRecord(metadata)
+	if err != nil {
+		Log.Warnf("Not keeping local history of the run: %v\n", err)
+		record = nil
+	}
+	ctx, span := tracer.Start(context.Background(), "experiment", trace.WithAttributes(
+		attribute.String("blackbox.run_id", result.RunID),
+		attribute.String("blackbox.run", result.ResultName),
+		attribute.String("blackbox.program", experiment.Program)))
+	result.Err = runExperiment(ctx, srv, spreadsheetID, experiment, &result, record)
+	if result.Err == nil && experiment.FailIf != "" {
+		result.Err = CheckGates(srv, spreadsheetID, experiment, result.Recorded)
+	}
+	endSpan(span, result.Err)
+	result.Duration = time.Since(start)
+	if err := record.Finish(result); err != nil {
+		Log.Warnf("Unable to record the run outcome: %v\n", err)
+	}
+	// Runs that got as far as recording results get a meta tab
+	if result.InputSets > 0 {
+		metadata.Finish(result)
+		if err := WriteMetaTab(srv, spreadsheetID, metadata); err != nil {
+			Log.Warnf("Unable to write the run metadata: %v\n", err)
+		}
+	}
+	for _, target := range experiment.Notify {
+		if err := Notify(target, NewRunSummary(result, spreadsheetID)); err != nil {
+			Log.Warnf("%v", err)
+		}
+	}
+	return result
+}
+
+func runExperiment(ctx context.Context, srv *sheets.Service, spreadsheetID string, experiment Experiment, result *RunResult, record *RunRecord) error {
+	baseConfig, err := experiment.RunnerConfig()
+	if err != nil {
+		return err
+	}
+	baseConfig.Env = append(baseConfig.Env, "BLACKBOX_RUN_ID="+result.RunID)
+	if len(experiment.Artifacts) > 0 {
+		if baseConfig.Artifacts, err = NewArtifactUploader(experiment.Artifacts, experiment.ArtifactsFolder, result.ResultName); err != nil {
+			return err
+		}
+	}
+	abortRules, err := ParseAbortRules(experiment.AbortIf)
+	if err != nil {
+		return err
+	}
+	if _, err := ParseGateRules(experiment.FailIf); err != nil {
+		return err
+	}
+	var limiter *RateLimiter
+	if experiment.Rate != "" {
+		if limiter, err = ParseRate(experiment.Rate); err != nil {
+			return err
+		}
+	}
+	charts, err := ParseChartSpecs(experiment.Charts)
+	if err != nil {
+		return err
+	}
+	pivots, err := ParsePivotSpecs(experiment.Pivots)
+	if err != nil {
+		return err
+	}
+	thresholds, err := ParseThresholdSpecs(experiment.Thresholds)
+	if err != nil {
+		return err
+	}
+	searching := IsSearchStrategy(experiment.Strategy)
+	fuzzing := experiment.Strategy == fuzzStrategy
+	if !searching && !fuzzing && experiment.Strategy != "" && experiment.Strategy != "exhaustive" {
+		return fmt.Errorf("Unknown strategy %s, expected exhaustive, %s, %s", experiment.Strategy, fuzzStrategy, StrategyNames())
+	}
+	if experiment.Responses != "" && (searching || fuzzing) {
+		return fmt.Errorf("Form responses are run as submitted, not with the %s strategy", experiment.Strategy)
+	}
+	var refineSpec RefineSpec
+	if experiment.Refine != "" {
+		if searching || fuzzing || experiment.Responses != "" {
+			return fmt.Errorf("Refinement only applies to exhaustive sweeps, not to the %s strategy", experiment.Strategy)
+		}
+		if refineSpec, err = ParseRefineSpec(experiment.Refine); err != nil {
+			return err
+		}
+	}
+	var objective Objective
+	if searching {
+		if objective, err = ParseObjective(experiment.Objective); err != nil {
+			return err
+		}
+	}
+	targetOptions := TargetOptions{
+		CPUs:   experiment.CPUs,
+		Memory: experiment.Memory,
+		Pull:   experiment.Pull,
+	}
+	if experiment.StdinTemplate != "" {
+		if targetOptions.StdinTemplate, err = LoadStdinTemplate(experiment.StdinTemplate); err != nil {
+			return err
+		}
+	}
+	var target Target
+	if specs := compareSpecs(experiment); len(specs) > 0 {
+		target, err = OpenCompareTarget(specs, targetOptions, experiment.CompareIgnore)
+	} else {
+		target, err = OpenTarget(experiment.Target, experiment.Program, targetOptions)
+	}
+	if err != nil {
+		return err
+	}
+	source, err := OpenSource(srv, spreadsheetID)
+	if err != nil {
+		return err
+	}
+	source = TracedSource{Source: source, ctx: ctx}
+	inputsSheet, err := InputsSheet(source, experiment)
+	if err != nil {
+		return err
+	}
+	// retreive data from spreadsheet/inputs
+	setupRows, err := source.ReadRows(inputsSheet)
+	if err != nil {
+		if experiment.Responses == "" {
+			return err
+		}
+		// The questions of the form are enough to define the variables
+		Log.Infof("No inputs tab %s, taking the variables from %s\n", inputsSheet, experiment.Responses)
+		setupRows = nil
+	}
+	if err := record.WriteTable("inputs.csv", setupRows); err != nil {
+		return err
+	}
+
+	layout := ParseSetupLayout(setupRows)
+	layout.Experiment = experiment.Name
+	setupRows, varConstraints := layout.Rows(setupRows)
+	if err := CheckInputTypes(inputsSheet, layout, setupRows); err != nil {
+		return err
+	}
+	if len(experiment.InputSources) > 0 {
+		vars := InputVars{Origin: inputsSheet, Rows: setupRows, Constraints: varConstraints, Generators: layout.Generators}
+		for _, spec := range experiment.InputSources {
+			other, err := ReadInputSource(srv, spec, experiment.Name)
+			if err != nil {
+				return err
+			}
+			if err := vars.Merge(other); err != nil {
+				return err
+			}
+		}
+		setupRows, varConstraints = vars.Rows, vars.Constraints
+	}
+	baseConfig.Types = VarTypes(setupRows)
+
+	setupRows, derived := SplitDerivedRows(setupRows)
+	for _, definition := range experiment.Derived {
+		derivedVar, err := ParseDerivedVar(definition)
+		if err != nil {
+			return err
+		}
+		derived = append(derived, derivedVar)
+	}
+
+	var varNames []string
+	var inputSets [][]string
+	var space SearchSpace
+	if fuzzing {
+		varNames, inputSets, err = FuzzInputSets(setupRows, layout.Generators, experiment.FuzzRuns, rand.New(rand.NewSource(experiment.Seed)))
+		if err != nil {
+			return err
+		}
+		Log.Infof("Fuzzing with %d random input sets (seed %d)\n", len(inputSets), experiment.Seed)
+		// Crashes are findings to record, not reasons to stop
+		experiment.KeepGoing = true
+	} else if experiment.Responses != "" {
+		if varNames, inputSets, err = ReadResponses(source, experiment.Responses); err != nil {
+			return err
+		}
+		Log.Infof("Running %d form responses from %s\n", len(inputSets), experiment.Responses)
+	} else {
+		// Create cartesian product from the inputs
+		var exampleSets [][]string
+		varNames, exampleSets, err = GetVarsExamplesSets(setupRows)
+		if err != nil {
+			return err
+		}
+		inputSets = GetInputSets(exampleSets)
+		space = SearchSpace{VarNames: varNames, Values: exampleSets}
+	}
+	varNames, inputSets, err = AddDerivedVars(varNames, inputSets, derived)
+	if err != nil {
+		return err
+	}
+	constraints, err := ReadConstraints(source)
+	if err != nil {
+		return err
+	}
+	constraints = append(append(constraints, varConstraints...), experiment.Constraints...)
+	inputSets, err = FilterInputSets(varNames, inputSets, constraints)
+	if err != nil {
+		return err
+	}
+	exclusions, err := ReadExclusions(source)
+	if err != nil {
+		return err
+	}
+	if inputSets, err = ExcludeInputSets(varNames, inputSets, append(exclusions, experiment.Exclude...)); err != nil {
+		return err
+	}
+	inputSets = DedupeInputSets(inputSets)
+	if experiment.OrderBy != "" {
+		if inputSets, err = OrderInputSets(varNames, inputSets, experiment.OrderBy); err != nil {
+			return err
+		}
+	}
+	if target, err = streamingTarget(experiment, varNames, target); err != nil {
+		return err
+	}
+	// Targets holding processes or connections release them at the end
+	if closer, ok := target.(io.Closer); ok {
+		defer closer.Close()
+	}
+	result.InputSets = len(inputSets)
+	result.VarNames = varNames
+	if err := record.WriteTable("plan.csv", append([][]string{varNames}, inputSets...)); err != nil {
+		return err
+	}
+	Log.Infof("Got %d input sets for %d variables\n", len(inputSets), len(varNames))
+
+	outputs := experiment.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{DefaultOutput(spreadsheetID)}
+	}
+	sinkContext := &SinkContext{
+		Context:       ctx,
+		Service:       srv,
+		SpreadsheetID: spreadsheetID,
+		RunID:         result.RunID,
+		RunName:       result.ResultName,
+		Start:         result.Start,
+		VarNames:      varNames,
+		Buffering:     map[string]BufferPolicy{},
+		Charts:        charts,
+		Pivots:        pivots,
+		Thresholds:    thresholds,
+		MaxColumns:    experiment.MaxColumns,
+		MaxRows:       experiment.MaxRows,
+		ExpectedRows:  len(inputSets),
+		VerifyWrites:  experiment.VerifyWrites,
+		Encoders:      experiment.Encoders,
+	}
+	for kind, policy := range experiment.Buffering {
+		sinkContext.Buffering[kind] = policy
+	}
+	sheetsPolicy := sinkContext.Buffering["sheets"]
+	if sheetsPolicy.Rows == 0 {
+		sheetsPolicy.Rows = experiment.SheetsBatch
+	}
+	for _, output := range outputs {
+		if output == "sheets" || strings.HasPrefix(output, "sheets:") {
+			fixedWrites := SheetsRunWrites(sinkContext, SummaryRows(varNames, inputSets))
+			sheetsPolicy.MinInterval = PlanSheetsWrites(len(inputSets), sheetsPolicy.Rows, fixedWrites)
+		}
+	}
+	sinkContext.Buffering["sheets"] = sheetsPolicy
+	sinks := []Sink{NewRunCounter(result, AttachesResults(experiment.Notify) || experiment.FailIf != "" || len(compareSpecs(experiment)) > 0)}
+	for _, output := range outputs {
+		sink, err := OpenSink(output, sinkContext)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	if record != nil {
+		sink, err := record.ResultsSink()
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	sinks = append(sinks, NewSummarySink(sinkContext))
+	if experiment.Trend || len(experiment.TrendCharts) > 0 {
+		sinks = append(sinks, NewTrendSink(sinkContext, experiment.Name, experiment.TrendCharts))
+	}
+	// Last, as differing outputs fail the run once the others finalized
+	if experiment.Golden != "" {
+		sinks = append(sinks, NewGoldenSink(experiment.Golden, sinkContext))
+	}
+
+	assertions, err := ReadAssertions(source)
+	if err != nil {
+		return err
+	}
+	options := ExplorationOptions{
+		KeepGoing:  experiment.KeepGoing || len(abortRules) > 0,
+		AbortRules: abortRules,
+		Assertions: append(assertions, experiment.Assertions...),
+		Affinity:   experiment.Affinity,
+		BatchSize:  experiment.BatchSize,
+		Limiter:    limiter,
+		Context:    ctx,
+		Status:     Status.Track(result.RunID, result.ResultName, result.Start),
+		MaxRuns:    experiment.MaxRuns,
+	}
+	defer options.Status.Finish()
+	if experiment.MaxDuration != "" {
+		if options.MaxDuration, err = time.ParseDuration(experiment.MaxDuration); err != nil {
+			return fmt.Errorf("Invalid max duration %q: %v", experiment.MaxDuration, err)
+		}
+	}
+	options.Progress = func(completed, total int) {
+		fmt.Fprintf(os.Stderr, " ===> [%d/%d] <===\r", completed, total)
+	}
+	if experiment.WorkerState {
+		if options.StateDir, err = ioutil.TempDir("", "blackbox-state-"); err != nil {
+			return fmt.Errorf("Unable to create worker state directory: %v", err)
+		}
+		defer os.RemoveAll(options.StateDir)
+	}
+	if len(experiment.Track) > 0 {
+		tracker := NewPercentileTracker(experiment.Track)
+		sinks = append(sinks, tracker)
+		options.Progress = func(completed, total int) {
+			fmt.Fprintf(os.Stderr, " ===> [%d/%d] <=== %s\r", completed, total, tracker.Summary())
+		}
+	}
+	// The progress line is chatter for terminals, not for quiet or JSON logs
+	if !Log.Enabled(LevelInfo) || Log.JSON() {
+		options.Progress = nil
+	}
+
+	explored := inputSets
+	var search *Search
+	if searching {
+		strategy, err := strategies[experiment.Strategy](space, StrategyParams{
+			Budget:      experiment.Budget,
+			Population:  experiment.Population,
+			Generations: experiment.Generations,
+		}, rand.New(rand.NewSource(experiment.Seed)))
+		if err != nil {
+			return err
+		}
+		search = NewSearch(space, inputSets, objective, strategy, experiment.Budget, baseConfig.Concurrency)
+		// Failed runs only score badly in a search
+		options.KeepGoing = true
+		options.Observe = search.Observe
+		options.NextBatch = search.NextBatch
+		options.Total = search.Budget()
+		result.InputSets = search.Budget()
+		explored = search.NextBatch()
+		Log.Infof("Searching up to %d of %d input sets to %s (%s, seed %d)\n",
+			search.Budget(), len(inputSets), objective, experiment.Strategy, experiment.Seed)
+	}
+	if experiment.Refine != "" {
+		refiner := NewRefiner(refineSpec, experiment.RefinePasses, space.VarNames, space.Values, varNames, derived, constraints)
+		options.Observe = refiner.Observe
+		options.NextBatch = refiner.NextBatch
+	}
+	crashes := NewCrashTriage(target, baseConfig, varNames, derived, experiment.MinimizeRuns)
+	observe := options.Observe
+	options.Observe = func(inputSet []string, outputMap map[string]string, runErr error) {
+		crashes.Observe(inputSet, runErr)
+		if observe != nil {
+			observe(inputSet, outputMap, runErr)
+		}
+	}
+	// triage records the crashes of the run, which fail it only when the
+	// run was otherwise complete.
+	triage := func() error {
+		if err := crashes.Write(sinkContext); err != nil {
+			return fmt.Errorf("Unable to record crashes: %v", err)
+		}
+		return nil
+	}
+	// complete marks the results complete and reports the best input set
+	// of a search.
+	complete := func() error {
+		if err := FinalizeSinks(sinks); err != nil {
+			return err
+		}
+		if err := triage(); err != nil {
+			return err
+		}
+		if search != nil {
+			return reportBest(sinkContext, search, varNames)
+		}
+		return nil
+	}
+
+	resultChannel := make(chan []string)
+	recordErrorChannel := make(chan error, 1)
+	exploreErrorChannel := make(chan error, 1)
+	cancel := make(chan struct{})
+	options.Cancel = cancel
+
+	go func() {
+		recordErrorChannel <- RecordResults(sinks, resultChannel)
+	}()
+
+	go func() {
+		exploreErrorChannel <- RunExploration(target, baseConfig, varNames, explored, resultChannel, options)
+		close(resultChannel)
+	}()
+
+	// Recording ends once the exploration ended and closed the results,
+	// or as soon as a sink fails
+	if err := <-recordErrorChannel; err != nil {
+		// Stop the exploration, letting the runs in progress finish without
+		// blocking on results, and wait for it before cleaning up after it
+		close(cancel)
+		go func() {
+			for range resultChannel {
+			}
+		}()
+		if exploreErr := <-exploreErrorChannel; exploreErr != nil && exploreErr != errCanceled {
+			Log.Errorf("%v", exploreErr)
+		}
+		return err
+	}
+	// The results are complete only if the exploration succeeded
+	err = <-exploreErrorChannel
+	if err == nil {
+		return complete()
+	}
+	// Runs failing assertions, or stopped by the budget, still make a
+	// complete result
+	switch err.(type) {
+	case *AssertionError, *BudgetError:
+		if err := complete(); err != nil {
+			return err
+		}
+	default:
+		if err := triage(); err != nil {
+			Log.Errorf("%v", err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package blackbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Local outputs given no path, e.g. "-output csv", go to a directory per
+// run under runsDir named after its start date and run ID, e.g.
+// runs/2021-03-04-01F0..., so that runs do not overwrite each other, and
+// runs/latest links to the directory of the last run.
+var runsDir = GetVariableOrDefault("BLACKBOX_RUNS_DIR", "runs")
+
+// latestRun is the symlink to the output directory of the last run.
+const latestRun = "latest"
+
+// RunOutputDir returns the output directory of a run, creating it and
+// pointing the latest symlink at it.
+func RunOutputDir(runID string, start time.Time) (string, error) {
+	name := start.Format("2006-01-02") + "-" + runID
+	dir := filepath.Join(runsDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("Unable to create run output directory: %v", err)
+	}
+	// Replace the link atomically, for scripts reading through it
+	link := filepath.Join(runsDir, latestRun)
+	tmp := link + "." + runID
+	os.Remove(tmp)
+	if err := os.Symlink(name, tmp); err != nil {
+		return "", fmt.Errorf("Unable to link the latest run: %v", err)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("Unable to link the latest run: %v", err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,15 @@
+package blackbox
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// NewRunID returns the identifier of a run starting at start: a ULID,
+// which sorts by start time and is the same in the meta tab, the local
+// history, the logs, the metrics and the program environment of the run.
+func NewRunID(start time.Time) string {
+	return ulid.MustNew(ulid.Timestamp(start), rand.Reader).String()
+}
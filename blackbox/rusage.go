@@ -0,0 +1,32 @@
+package blackbox
+
+import (
+	"os"
+	"strconv"
+)
+
+// Prefix of the outputs measuring the resources used by a run.
+const rusagePrefix = "rusage_"
+
+// ProcessUsage returns the resources used by a finished program as
+// outputs: its user and system CPU seconds, and where the system reports
+// them, its maximum resident set size in KiB and its block input and
+// output operations.
+func ProcessUsage(state *os.ProcessState) map[string]string {
+	usage := map[string]string{
+		rusagePrefix + "user_s": strconv.FormatFloat(state.UserTime().Seconds(), 'f', 3, 64),
+		rusagePrefix + "sys_s":  strconv.FormatFloat(state.SystemTime().Seconds(), 'f', 3, 64),
+	}
+	addSysUsage(state, usage)
+	return usage
+}
+
+// addUsage adds the measured resources to the outputs of a run, unless the
+// program output them itself.
+func addUsage(outputMap, usage map[string]string) {
+	for name, value := range usage {
+		if _, ok := outputMap[name]; !ok {
+			outputMap[name] = value
+		}
+	}
+}
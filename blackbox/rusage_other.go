@@ -0,0 +1,8 @@
+//go:build windows || plan9
+
+package blackbox
+
+import "os"
+
+// The system does not report more than the CPU time of a process.
+func addSysUsage(state *os.ProcessState, usage map[string]string) {}
@@ -0,0 +1,25 @@
+//go:build !windows && !plan9
+
+package blackbox
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+func addSysUsage(state *os.ProcessState, usage map[string]string) {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+	maxRSS := int64(rusage.Maxrss)
+	// In bytes on macOS, KiB elsewhere
+	if runtime.GOOS == "darwin" {
+		maxRSS /= 1024
+	}
+	usage[rusagePrefix+"max_rss_kb"] = strconv.FormatInt(maxRSS, 10)
+	usage[rusagePrefix+"inblock"] = strconv.FormatInt(int64(rusage.Inblock), 10)
+	usage[rusagePrefix+"oublock"] = strconv.FormatInt(int64(rusage.Oublock), 10)
+}
@@ -0,0 +1,137 @@
+package blackbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Scenario is a script run for every input set by a single run of the
+// program, for programs with a protocol rather than a single answer. Sent
+// messages refer to the input variables as ${name}; expectations are
+// expressions over the input variables and the outputs of the last
+// answer. For example:
+//
+//	{
+//	  "steps": [
+//	    {"send": {"op": "deposit", "amount": "${amount}"}},
+//	    {"expect": "balance == amount"},
+//	    {"wait": "100ms"},
+//	    {"send": {"op": "withdraw", "amount": "${amount}"}},
+//	    {"expect": "balance == 0"}
+//	  ]
+//	}
+type Scenario struct {
+	Steps []ScenarioStep `json:"steps"`
+}
+
+// ScenarioStep does one of sending a message, checking the last answer
+// or waiting.
+type ScenarioStep struct {
+	Send   map[string]string `json:"send"`
+	Expect string            `json:"expect"`
+	Wait   string            `json:"wait"`
+
+	wait time.Duration
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read scenario file: %v", err)
+	}
+	scenario := &Scenario{}
+	if err := json.Unmarshal(b, scenario); err != nil {
+		return nil, fmt.Errorf("Unable to parse scenario file: %v", err)
+	}
+	if len(scenario.Steps) == 0 {
+		return nil, fmt.Errorf("Scenario %s has no steps", path)
+	}
+	for i, step := range scenario.Steps {
+		actions := 0
+		for _, set := range []bool{step.Send != nil, step.Expect != "", step.Wait != ""} {
+			if set {
+				actions++
+			}
+		}
+		if actions != 1 {
+			return nil, fmt.Errorf("Step %d of scenario %s must have one of send, expect or wait", i+1, path)
+		}
+		if step.Wait != "" {
+			if scenario.Steps[i].wait, err = time.ParseDuration(step.Wait); err != nil {
+				return nil, fmt.Errorf("Invalid wait of step %d of scenario %s: %v", i+1, path, err)
+			}
+		}
+	}
+	return scenario, nil
+}
+
+// ScenarioTarget runs a scenario per input set. A failed expectation fails
+// the run; otherwise its outputs are those of every answer, later answers
+// overriding earlier ones, and the number of steps run.
+type ScenarioTarget struct {
+	Program  string
+	Scenario *Scenario
+}
+
+func (t ScenarioTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	inputMap := InputMap(varNames, inputSet)
+	session, err := StartLineSession(t.Program, config)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	outputs := map[string]string{}
+	var last map[string]string
+	for i, step := range t.Scenario.Steps {
+		switch {
+		case step.Send != nil:
+			message := map[string]string{}
+			for key, value := range step.Send {
+				message[key] = os.Expand(value, func(name string) string { return inputMap[name] })
+			}
+			if last, err = session.Exchange(message); err != nil {
+				return nil, fmt.Errorf("Step %d: %v", i+1, err)
+			}
+			for output, value := range last {
+				outputs[output] = value
+			}
+		case step.Expect != "":
+			if err := checkExpectation(step.Expect, inputMap, last); err != nil {
+				return nil, fmt.Errorf("Step %d: %v", i+1, err)
+			}
+		default:
+			time.Sleep(step.wait)
+		}
+	}
+	outputs["steps"] = strconv.Itoa(len(t.Scenario.Steps))
+	return outputs, nil
+}
+
+// checkExpectation evaluates an expectation over the inputs and the last
+// answer.
+func checkExpectation(expectation string, inputMap, answer map[string]string) error {
+	names, values := []string{}, []string{}
+	for _, m := range []map[string]string{inputMap, answer} {
+		for name, value := range m {
+			names = append(names, name)
+			values = append(values, value)
+		}
+	}
+	expression, err := CompileExpression(expectation, names)
+	if err != nil {
+		return err
+	}
+	ok, err := expression.EvalBool(ValueEnv(names, values))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Expected %s", expectation)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package blackbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value recorded for a step at which the program did not return an output.
+const missingStepValue = "-"
+
+// ScheduleTarget explores programs with state: the values of a schedule
+// variable are sequences, e.g. "10 50 200 50" for a load pattern, and for
+// every input set the program is started once and sent one JSON line per
+// step of the sequence, with the other inputs, the step's value and the
+// step number in "step". It answers each line with a line of outputs, and
+// every output is recorded as the sequence of its values at each step.
+type ScheduleTarget struct {
+	Program string
+	Var     string
+	// Pause between steps, for schedules over time
+	Interval time.Duration
+}
+
+// Steps returns the steps of a schedule value.
+func Steps(value string) []string {
+	return strings.Fields(value)
+}
+
+func (t ScheduleTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	column := -1
+	for i, varName := range varNames {
+		if varName == t.Var {
+			column = i
+		}
+	}
+	if column < 0 {
+		return nil, fmt.Errorf("Unknown schedule variable %s", t.Var)
+	}
+	steps := Steps(inputSet[column])
+
+	session, err := StartLineSession(t.Program, config)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	samples := map[string][]string{}
+	for i, step := range steps {
+		if i > 0 && t.Interval > 0 {
+			time.Sleep(t.Interval)
+		}
+		inputMap := InputMap(varNames, inputSet)
+		inputMap[t.Var] = step
+		inputMap["step"] = strconv.Itoa(i + 1)
+		outputMap, err := session.Exchange(inputMap)
+		if err != nil {
+			return nil, fmt.Errorf("Step %d: %v", i+1, err)
+		}
+		addStepSamples(samples, i, outputMap)
+	}
+
+	outputMap := map[string]string{}
+	for output, values := range samples {
+		outputMap[output] = strings.Join(values, " ")
+	}
+	return outputMap, nil
+}
+
+// addStepSamples appends the outputs of step i, counting from 0, to their
+// samples, padding outputs first seen at this step and outputs missing
+// from it with missingStepValue so that every output has i+1 samples.
+func addStepSamples(samples map[string][]string, i int, outputMap map[string]string) {
+	for output, value := range outputMap {
+		if _, ok := samples[output]; !ok {
+			samples[output] = make([]string, i)
+			for j := range samples[output] {
+				samples[output][j] = missingStepValue
+			}
+		}
+		samples[output] = append(samples[output], value)
+	}
+	for output := range samples {
+		if len(samples[output]) == i {
+			samples[output] = append(samples[output], missingStepValue)
+		}
+	}
+}
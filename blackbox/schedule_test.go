@@ -0,0 +1,66 @@
+package blackbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSteps(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{value: "10 50 200 50", want: []string{"10", "50", "200", "50"}},
+		{value: "  10\t50\n", want: []string{"10", "50"}},
+		{value: "1", want: []string{"1"}},
+		{value: "", want: []string{}},
+	}
+	for _, test := range tests {
+		if got := Steps(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Steps(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestAddStepSamples(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []map[string]string
+		want  map[string][]string
+	}{
+		{
+			name:  "every output at every step",
+			steps: []map[string]string{{"rps": "10", "p99": "5"}, {"rps": "50", "p99": "7"}},
+			want:  map[string][]string{"rps": {"10", "50"}, "p99": {"5", "7"}},
+		},
+		{
+			name:  "output first seen late",
+			steps: []map[string]string{{"rps": "10"}, {"rps": "50"}, {"rps": "200", "errors": "3"}},
+			want:  map[string][]string{"rps": {"10", "50", "200"}, "errors": {"-", "-", "3"}},
+		},
+		{
+			name:  "output missing from a step",
+			steps: []map[string]string{{"rps": "10", "p99": "5"}, {"rps": "50"}, {"rps": "200", "p99": "9"}},
+			want:  map[string][]string{"rps": {"10", "50", "200"}, "p99": {"5", "-", "9"}},
+		},
+		{
+			name:  "step without outputs",
+			steps: []map[string]string{{"rps": "10"}, {}, {"rps": "200"}},
+			want:  map[string][]string{"rps": {"10", "-", "200"}},
+		},
+		{
+			name:  "no outputs",
+			steps: []map[string]string{{}, {}},
+			want:  map[string][]string{},
+		},
+	}
+	for _, test := range tests {
+		samples := map[string][]string{}
+		for i, outputMap := range test.steps {
+			addStepSamples(samples, i, outputMap)
+		}
+		if !reflect.DeepEqual(samples, test.want) {
+			t.Errorf("%s: samples = %q, want %q", test.name, samples, test.want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package blackbox
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Objective is the output an optimization searches the best value of.
+type Objective struct {
+	Output   string
+	Maximize bool
+}
+
+// ParseObjective parses "minimize latency_ms" or "maximize:throughput"
+// ("min" and "max" work too).
+func ParseObjective(text string) (Objective, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == ':' || r == ' ' || r == '\t' })
+	if len(fields) != 2 {
+		return Objective{}, fmt.Errorf("Invalid objective %q, expected e.g. \"minimize latency_ms\"", text)
+	}
+	switch strings.ToLower(fields[0]) {
+	case "minimize", "min":
+		return Objective{Output: fields[1]}, nil
+	case "maximize", "max":
+		return Objective{Output: fields[1], Maximize: true}, nil
+	}
+	return Objective{}, fmt.Errorf("Invalid objective %q, expected minimize or maximize", text)
+}
+
+func (o Objective) String() string {
+	if o.Maximize {
+		return "maximize " + o.Output
+	}
+	return "minimize " + o.Output
+}
+
+// Score returns the objective of a run as a value to minimize, +Inf for
+// failed runs and runs without a numeric objective.
+func (o Objective) Score(outputMap map[string]string, runErr error) float64 {
+	if runErr != nil {
+		return math.Inf(1)
+	}
+	value, err := strconv.ParseFloat(outputMap[o.Output], 64)
+	if err != nil || math.IsNaN(value) {
+		return math.Inf(1)
+	}
+	if o.Maximize {
+		return -value
+	}
+	return value
+}
+
+// Point is an input set of a search, as the index of each variable's
+// value among its examples.
+type Point []int
+
+func (p Point) key() string {
+	parts := make([]string, len(p))
+	for i, index := range p {
+		parts[i] = strconv.Itoa(index)
+	}
+	return strings.Join(parts, ",")
+}
+
+// SearchSpace is the discrete space of the examples of each variable.
+type SearchSpace struct {
+	VarNames []string
+	Values   [][]string
+}
+
+// Size returns the number of points of the space, at most math.MaxInt32.
+func (s SearchSpace) Size() int {
+	size := 1
+	for _, values := range s.Values {
+		if size > math.MaxInt32/len(values) {
+			return math.MaxInt32
+		}
+		size *= len(values)
+	}
+	return size
+}
+
+// Random returns a uniformly random point.
+func (s SearchSpace) Random(rng *rand.Rand) Point {
+	point := make(Point, len(s.Values))
+	for i, values := range s.Values {
+		point[i] = rng.Intn(len(values))
+	}
+	return point
+}
+
+// Neighbors returns the points one step away along a single variable.
+func (s SearchSpace) Neighbors(point Point) []Point {
+	neighbors := []Point{}
+	for i := range point {
+		for _, step := range []int{-1, 1} {
+			index := point[i] + step
+			if index < 0 || index >= len(s.Values[i]) {
+				continue
+			}
+			neighbor := append(Point{}, point...)
+			neighbor[i] = index
+			neighbors = append(neighbors, neighbor)
+		}
+	}
+	return neighbors
+}
+
+// Coordinates returns a point as numbers, using the example values when
+// they are numeric and their index otherwise, for strategies modelling
+// the objective over the space.
+func (s SearchSpace) Coordinates(point Point) []float64 {
+	coordinates := make([]float64, len(point))
+	for i, index := range point {
+		coordinates[i] = float64(index)
+		if value, err := strconv.ParseFloat(s.Values[i][index], 64); err == nil {
+			coordinates[i] = value
+		}
+	}
+	return coordinates
+}
+
+// Strategy chooses the points a search evaluates. Every point returned
+// by Next is passed to Observe with its score, lower being better, before
+// Next is called again.
+type Strategy interface {
+	// Next returns the points to evaluate next, about batchSize of them,
+	// or none once the strategy has nothing left to try.
+	Next(batchSize int) []Point
+	Observe(point Point, score float64)
+}
+
+// StrategyParams configure the search strategies.
+type StrategyParams struct {
+	// Maximum number of program runs
+	Budget int
+	// Size and number of generations of the genetic strategy
+	Population  int
+	Generations int
+}
+
+// defaultBudgeter is implemented by strategies knowing how many runs they
+// need when no budget is set.
+type defaultBudgeter interface {
+	DefaultBudget() int
+}
+
+// strategies create the search strategies by name.
+var strategies = map[string]func(space SearchSpace, params StrategyParams, rng *rand.Rand) (Strategy, error){
+	"hillclimb": NewHillClimbing,
+	"anneal":    NewSimulatedAnnealing,
+	"bayesian":  NewBayesianOptimization,
+	"genetic":   NewGeneticAlgorithm,
+}
+
+// IsSearchStrategy reports whether a strategy explores adaptively rather
+// than running every input set.
+func IsSearchStrategy(name string) bool {
+	_, ok := strategies[name]
+	return ok
+}
+
+// StrategyNames lists the search strategies, for the usage of -strategy.
+func StrategyNames() string {
+	names := []string{}
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Default maximum number of runs of a search.
+const defaultSearchBudget = 50
+
+// Proposals of already evaluated points tolerated in a row before a
+// search is considered converged.
+const maxStalledProposals = 100
+
+// Search runs a strategy over the feasible input sets of an exploration,
+// until its budget is spent or the strategy is done.
+type Search struct {
+	mu        sync.Mutex
+	space     SearchSpace
+	objective Objective
+	strategy  Strategy
+	budget    int
+	batchSize int
+	// index of each feasible point's input set
+	feasible  map[string]int
+	inputSets [][]string
+	// points by the key of their input set, for runs being observed
+	pending map[string]Point
+	scores  map[string]float64
+	runs    int
+
+	best        []string
+	bestOutputs map[string]string
+	bestScore   float64
+}
+
+// NewSearch prepares a search over inputSets, whose first columns are the
+// values of the space's variables.
+func NewSearch(space SearchSpace, inputSets [][]string, objective Objective, strategy Strategy, budget, batchSize int) *Search {
+	if budgeter, ok := strategy.(defaultBudgeter); ok && budget < 1 {
+		budget = budgeter.DefaultBudget()
+	}
+	if budget < 1 {
+		budget = defaultSearchBudget
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	search := &Search{
+		space:     space,
+		objective: objective,
+		strategy:  strategy,
+		budget:    budget,
+		batchSize: batchSize,
+		feasible:  map[string]int{},
+		inputSets: inputSets,
+		pending:   map[string]Point{},
+		scores:    map[string]float64{},
+		bestScore: math.Inf(1),
+	}
+	indexes := make([]map[string]int, len(space.Values))
+	for i, values := range space.Values {
+		indexes[i] = map[string]int{}
+		for j, value := range values {
+			indexes[i][value] = j
+		}
+	}
+	for i, inputSet := range inputSets {
+		point := make(Point, len(space.Values))
+		for j := range point {
+			point[j] = indexes[j][inputSet[j]]
+		}
+		search.feasible[point.key()] = i
+	}
+	if budget > len(search.feasible) {
+		search.budget = len(search.feasible)
+	}
+	return search
+}
+
+// Budget returns the maximum number of runs of the search.
+func (s *Search) Budget() int {
+	return s.budget
+}
+
+func inputSetKey(inputSet []string) string {
+	return strings.Join(inputSet, "\x00")
+}
+
+// NextBatch returns the input sets to run next, none when the search is
+// over. Points already evaluated or infeasible are scored without running.
+func (s *Search) NextBatch() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stalled := 0
+	for s.runs < s.budget && stalled < maxStalledProposals {
+		points := s.strategy.Next(s.batchSize)
+		if len(points) == 0 {
+			return nil
+		}
+		batch := [][]string{}
+		for _, point := range points {
+			key := point.key()
+			score, seen := s.scores[key]
+			i, feasible := s.feasible[key]
+			switch {
+			case seen:
+				// Points still running are observed once they complete
+				if !math.IsNaN(score) {
+					s.strategy.Observe(point, score)
+				}
+			case !feasible:
+				s.scores[key] = math.Inf(1)
+				s.strategy.Observe(point, math.Inf(1))
+			case len(batch) < s.budget-s.runs:
+				s.pending[inputSetKey(s.inputSets[i])] = point
+				s.scores[key] = math.NaN()
+				batch = append(batch, s.inputSets[i])
+			default:
+				// Over budget
+				s.strategy.Observe(point, math.Inf(1))
+			}
+		}
+		if len(batch) > 0 {
+			s.runs += len(batch)
+			return batch
+		}
+		stalled++
+	}
+	return nil
+}
+
+// Observe scores a completed run for the strategy.
+func (s *Search) Observe(inputSet []string, outputMap map[string]string, runErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	point, ok := s.pending[inputSetKey(inputSet)]
+	if !ok {
+		return
+	}
+	delete(s.pending, inputSetKey(inputSet))
+	score := s.objective.Score(outputMap, runErr)
+	s.scores[point.key()] = score
+	if score < s.bestScore {
+		s.bestScore = score
+		s.best = inputSet
+		s.bestOutputs = outputMap
+	}
+	s.strategy.Observe(point, score)
+}
+
+// Best returns the best input set found and its outputs, nil if no run
+// had a numeric objective.
+func (s *Search) Best() ([]string, map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.best, s.bestOutputs
+}
+
+// BestRows renders the best input set and its outputs as key/value rows,
+// header first.
+func BestRows(varNames, best []string, outputs map[string]string) [][]string {
+	rows := [][]string{{"key", "value"}}
+	for i, varName := range varNames {
+		rows = append(rows, []string{varName, best[i]})
+	}
+	for _, output := range RecordSortedKeys(outputs) {
+		rows = append(rows, []string{output, outputs[output]})
+	}
+	return rows
+}
+
+// reportBest logs the best input set of a search and records it in a
+// best tab next to the results.
+func reportBest(sinkContext *SinkContext, search *Search, varNames []string) error {
+	best, outputs := search.Best()
+	if best == nil {
+		Log.Warnf("No run produced a numeric %s\n", search.objective.Output)
+		return nil
+	}
+	values := []string{}
+	for i, varName := range varNames {
+		values = append(values, varName+"="+best[i])
+	}
+	Log.Infof("Best %s=%s at %s\n", search.objective.Output, outputs[search.objective.Output], strings.Join(values, " "))
+	return WriteRows(sinkContext.Service, sinkContext.SpreadsheetID, relatedSheetName("best", sinkContext.RunName), BestRows(varNames, best, outputs))
+}
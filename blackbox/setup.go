@@ -0,0 +1,125 @@
+package blackbox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Columns of an inputs tab starting with a header row, in any order, e.g.
+//
+//	variable | type | examples   | constraint | description
+//	size     | int  | 1, 10, 100 | size > 0   | number of items
+//
+// Only variable and examples are required; a generator column declares
+// how the fuzz strategy draws values, see ParseGenerator, without needing
+// examples. Several experiments can share
+// the tab with an experiment column: rows naming another experiment than
+// the one run are skipped. Without a header, column A
+// holds the variables and column B their examples. Blank rows and rows
+// whose variable starts with commentPrefix are skipped, to document and
+// separate the variables.
+var setupColumns = []string{"variable", "examples", "type", "constraint", "description", "experiment", "generator"}
+
+const commentPrefix = "#"
+
+// SetupLayout is where the columns of an inputs tab are, -1 for missing
+// ones.
+type SetupLayout struct {
+	Header  bool
+	Columns map[string]int
+	// Name of the experiment run, selecting rows by the experiment column
+	Experiment string
+	// 0-based tab rows of the variable rows returned by Rows
+	lines []int
+	// Generators of the variable rows returned by Rows, by variable
+	Generators map[string]string
+}
+
+// ParseSetupLayout reads the layout of the rows of an inputs tab from its
+// header row, if any.
+func ParseSetupLayout(setupRows [][]string) SetupLayout {
+	layout := SetupLayout{Columns: map[string]int{"variable": 0, "examples": 1, "type": -1, "constraint": -1, "description": -1, "experiment": -1, "generator": -1}}
+	if len(setupRows) == 0 {
+		return layout
+	}
+	found := map[string]int{}
+	for i, cell := range setupRows[0] {
+		name := strings.ToLower(strings.TrimSpace(cell))
+		for _, column := range setupColumns {
+			if name == column {
+				found[column] = i
+			}
+		}
+	}
+	if _, ok := found["variable"]; !ok {
+		return layout
+	}
+	if _, ok := found["examples"]; !ok {
+		return layout
+	}
+	layout.Header = true
+	for _, column := range setupColumns {
+		layout.Columns[column] = -1
+		if i, ok := found[column]; ok {
+			layout.Columns[column] = i
+		}
+	}
+	return layout
+}
+
+func (l SetupLayout) cell(row []string, column string) string {
+	i := l.Columns[column]
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// Rows returns the variable rows of an inputs tab as pairs of a variable,
+// tagged with its type if any, and its examples, along with the
+// constraints of the constraint column.
+func (l *SetupLayout) Rows(setupRows [][]string) ([][]string, []string) {
+	rows := [][]string{}
+	constraints := []string{}
+	l.lines = nil
+	l.Generators = map[string]string{}
+	for i, row := range setupRows {
+		if (i == 0 && l.Header) || isBlankRow(row) {
+			continue
+		}
+		variable := l.cell(row, "variable")
+		if strings.HasPrefix(variable, commentPrefix) {
+			continue
+		}
+		if name := l.cell(row, "experiment"); name != "" && name != l.Experiment {
+			continue
+		}
+		l.lines = append(l.lines, i)
+		if generator := l.cell(row, "generator"); generator != "" {
+			l.Generators[variable] = generator
+		}
+		if varType := l.cell(row, "type"); varType != "" {
+			variable += ":" + varType
+		}
+		rows = append(rows, []string{variable, l.cell(row, "examples")})
+		if constraint := l.cell(row, "constraint"); constraint != "" {
+			constraints = append(constraints, constraint)
+		}
+	}
+	return rows, constraints
+}
+
+// ExamplesCell returns the A1 notation of the examples of the i-th
+// variable row returned by Rows.
+func (l SetupLayout) ExamplesCell(inputsSheet string, i int) string {
+	return fmt.Sprintf("%s!%s%d", inputsSheet, columnLetters(int64(l.Columns["examples"])), l.lines[i]+1)
+}
+
+func isBlankRow(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package blackbox
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+var (
+	spreadsheetURLRegexp = regexp.MustCompile(`^https?://docs\.google\.com/spreadsheets/(?:u/\d+/)?d/([a-zA-Z0-9_-]+)`)
+	sheetGIDRegexp       = regexp.MustCompile(`[#?&]gid=(\d+)`)
+)
+
+// ParseSpreadsheetRef returns the ID of a spreadsheet given by ID or by
+// URL, as copied from the browser, and the ID of the tab the URL shows
+// after #gid=, -1 if none. Anything else, such as an .xlsx path, is
+// returned as is.
+func ParseSpreadsheetRef(ref string) (string, int64) {
+	match := spreadsheetURLRegexp.FindStringSubmatch(ref)
+	if match == nil {
+		return ref, -1
+	}
+	gid := int64(-1)
+	if gidMatch := sheetGIDRegexp.FindStringSubmatch(ref); gidMatch != nil {
+		if n, err := strconv.ParseInt(gidMatch[1], 10, 64); err == nil {
+			gid = n
+		}
+	}
+	return match[1], gid
+}
+
+// SpreadsheetID returns the ID of a spreadsheet given by ID or by URL.
+func SpreadsheetID(ref string) string {
+	id, _ := ParseSpreadsheetRef(ref)
+	return id
+}
+
+// SheetTitle returns the name of the tab of a spreadsheet with an ID, as
+// in a #gid= URL.
+func SheetTitle(srv *sheets.Service, spreadsheetID string, gid int64) (string, error) {
+	spreadsheet, err := srv.Spreadsheets.Get(spreadsheetID).Fields("sheets.properties(sheetId,title)").Do()
+	if err != nil {
+		return "", fmt.Errorf("Unable to read the tabs of %s: %v", spreadsheetID, err)
+	}
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties.SheetId == gid {
+			return sheet.Properties.Title, nil
+		}
+	}
+	return "", fmt.Errorf("No tab with gid %d in %s", gid, spreadsheetID)
+}
@@ -0,0 +1,251 @@
+package blackbox
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Sink receives the result header followed by one row per explored input set.
+type Sink interface {
+	WriteHeader(header []string) error
+	WriteRow(row []string) error
+	Close() error
+}
+
+// Finalizer is implemented by sinks that mark their results complete once
+// the run finished successfully.
+type Finalizer interface {
+	Finalize() error
+}
+
+// FinalizeSinks finalizes the sinks that support it.
+func FinalizeSinks(sinks []Sink) error {
+	for _, sink := range sinks {
+		if finalizer, ok := sink.(Finalizer); ok {
+			if err := finalizer.Finalize(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SinkContext carries what the sinks need to know about the current run.
+type SinkContext struct {
+	// Context of the run, whose trace records the writes if set
+	Context       context.Context
+	Service       *sheets.Service
+	SpreadsheetID string
+	RunID         string
+	RunName       string
+	Start         time.Time
+	// Input variable names; the result header starts with these
+	VarNames []string
+
+	// Buffering policies by output kind
+	Buffering map[string]BufferPolicy
+	// Charts to embed in the result tab
+	Charts []ChartSpec
+	// Pivot tables of the result tab, added in tabs of their own
+	Pivots []PivotSpec
+	// Thresholds to color result cells by
+	Thresholds []Threshold
+	// Columns per result tab before outputs spill into auxiliary tabs
+	MaxColumns int
+	// Rows per result tab before results roll over into the next part
+	MaxRows int
+	// Number of result rows the run should produce, 0 if unknown
+	ExpectedRows int
+	// Rows read back from Sheets to check they were written as sent
+	VerifyWrites int
+	// Encoder names by output kind, overriding defaultEncoders
+	Encoders map[string]string
+}
+
+// ListFlags collects the values of a repeatable flag, e.g. -output.
+type ListFlags []string
+
+func (o *ListFlags) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *ListFlags) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
+// OpenSink creates the sink described by spec, e.g. "sheets",
+// "sheets:RESULTS" for the named range RESULTS, "bq:project.dataset.table",
+// "parquet:results.parquet" or "gs://bucket/run-{{.RunID}}.csv", buffered
+// according to the policy configured for its kind.
+func OpenSink(spec string, sinkContext *SinkContext) (Sink, error) {
+	kind, target := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, target = spec[:i], spec[i+1:]
+	}
+	if kind == "bigquery" {
+		kind = "bq"
+	}
+	if kind == "postgresql" {
+		kind = "postgres"
+	}
+	if target == "" && (kind == "csv" || kind == "parquet" || kind == "junit" || kind == "lineproto") {
+		dir, err := RunOutputDir(sinkContext.RunID, sinkContext.Start)
+		if err != nil {
+			return nil, err
+		}
+		extension := kind
+		if kind == "junit" {
+			extension = "xml"
+		}
+		if kind == "lineproto" {
+			extension = "lp"
+		}
+		target = filepath.Join(dir, sinkContext.RunName+"."+extension)
+	}
+	var sink Sink
+	var err error
+	switch kind {
+	case "sheets":
+		if target != "" {
+			sink, err = NewNamedRangeSink(sinkContext.Service, sinkContext.SpreadsheetID, target)
+			break
+		}
+		var sheetsSink *SheetsSink
+		if sheetsSink, err = NewSheetsSink(sinkContext.Service, sinkContext.SpreadsheetID, sinkContext.RunName); err == nil {
+			sheetsSink.charts = sinkContext.Charts
+			sheetsSink.pivots = sinkContext.Pivots
+			sheetsSink.verifySample = sinkContext.VerifyWrites
+			sink = NewWideSheetsSink(sheetsSink, sinkContext)
+		}
+	case "bq":
+		sink, err = NewBigQuerySink(target, sinkContext.RunID)
+	case "csv":
+		sink, err = NewCSVSink(target, sinkContext.RunName)
+	case "parquet":
+		sink, err = NewParquetSink(target, sinkContext.RunName)
+	case "xlsx":
+		sink, err = NewXlsxSink(target, sinkContext.RunName)
+	case "pushgateway":
+		sink, err = NewPushgatewaySink(target, sinkContext)
+	case "junit":
+		sink, err = NewJUnitSink(target, sinkContext)
+	case "airtable":
+		sink, err = NewAirtableSink(target, sinkContext)
+	case "influx":
+		sink, err = NewInfluxSink(target, sinkContext)
+	case "lineproto":
+		sink, err = NewLineProtocolSink(target, sinkContext)
+	case "postgres", "mysql":
+		sink, err = NewSQLSink(spec, sinkContext)
+	case "gs", "s3":
+		sink, err = NewObjectStoreSink(spec, sinkContext)
+	default:
+		return nil, fmt.Errorf("Unknown output %q", spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := EncoderFor(kind, sinkContext.Encoders)
+	if err != nil {
+		return nil, err
+	}
+	if encodingSink, ok := sink.(EncodingSink); ok && encoder != nil {
+		encodingSink.SetEncoder(encoder)
+	}
+	buffered, err := NewBufferedSink(sink, BufferPolicyFor(kind, sinkContext.Buffering))
+	if err != nil {
+		return nil, err
+	}
+	if sinkContext.Context != nil {
+		return &tracedSink{Sink: buffered, ctx: sinkContext.Context, kind: kind}, nil
+	}
+	return buffered, nil
+}
+
+// ValueKind is the column type inferred from a result value, for sinks that
+// need typed columns.
+type ValueKind int
+
+const (
+	KindString ValueKind = iota
+	KindInt
+	KindFloat
+	KindBool
+)
+
+func InferValueKind(value string) ValueKind {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return KindInt
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return KindFloat
+	}
+	if _, err := strconv.ParseBool(value); err == nil {
+		return KindBool
+	}
+	return KindString
+}
+
+// ParseValue converts value to the Go type matching kind. It returns false
+// if value does not fit the kind.
+func ParseValue(kind ValueKind, value string) (interface{}, bool) {
+	switch kind {
+	case KindInt:
+		v, err := strconv.ParseInt(value, 10, 64)
+		return v, err == nil
+	case KindFloat:
+		v, err := strconv.ParseFloat(value, 64)
+		return v, err == nil
+	case KindBool:
+		v, err := strconv.ParseBool(value)
+		return v, err == nil
+	}
+	return value, true
+}
+
+// InferColumnKinds infers one kind per column from a batch of rows: a
+// column keeps a numeric or boolean kind only if the encoder gives all
+// its non-empty values that kind, widening ints to floats when needed.
+func InferColumnKinds(encoder Encoder, columns int, rows [][]string) []ValueKind {
+	kinds := make([]ValueKind, columns)
+	for column := range kinds {
+		kind, seen := KindString, false
+		for _, row := range rows {
+			if column >= len(row) || row[column] == "" {
+				continue
+			}
+			valueKind := encoder.Kind(row[column])
+			switch {
+			case !seen:
+				kind, seen = valueKind, true
+			case kind == valueKind:
+			case kind == KindInt && valueKind == KindFloat, kind == KindFloat && valueKind == KindInt:
+				kind = KindFloat
+			default:
+				kind = KindString
+			}
+		}
+		kinds[column] = kind
+	}
+	return kinds
+}
+
+var invalidIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// sanitizeIdentifier turns a variable name into an identifier usable as a
+// column, metric or label name.
+func sanitizeIdentifier(name string) string {
+	identifier := invalidIdentifierChars.ReplaceAllString(name, "_")
+	if identifier == "" || (identifier[0] >= '0' && identifier[0] <= '9') {
+		identifier = "_" + identifier
+	}
+	return identifier
+}
@@ -0,0 +1,243 @@
+package blackbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+)
+
+// Rows buffered before the column types are inferred and the table is
+// created or checked.
+const bigQueryInferenceRows = 100
+
+// BigQuerySink streams results into a BigQuery table, creating it with a
+// schema inferred from the header and the first batch of rows, or adding
+// the columns an existing table lacks. It authenticates with Application
+// Default Credentials.
+type BigQuerySink struct {
+	ctx      context.Context
+	client   *bigquery.Client
+	table    *bigquery.Table
+	runID    string
+	header   []string
+	kinds    []ValueKind
+	schema   bigquery.Schema
+	inserter *bigquery.Inserter
+	pending  [][]string
+	rowCount int
+	encoder  Encoder
+}
+
+// NewBigQuerySink opens a sink for target in the form project.dataset.table.
+func NewBigQuerySink(target, runID string) (*BigQuerySink, error) {
+	parts := strings.Split(target, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("BigQuery output must be bq:project.dataset.table, got %q", target)
+	}
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create BigQuery client: %v", err)
+	}
+	return &BigQuerySink{
+		ctx:     ctx,
+		client:  client,
+		table:   client.Dataset(parts[1]).Table(parts[2]),
+		runID:   runID,
+		encoder: TypedEncoder{},
+	}, nil
+}
+
+func bigQueryFieldType(kind ValueKind) bigquery.FieldType {
+	switch kind {
+	case KindInt:
+		return bigquery.IntegerFieldType
+	case KindFloat:
+		return bigquery.FloatFieldType
+	case KindBool:
+		return bigquery.BooleanFieldType
+	}
+	return bigquery.StringFieldType
+}
+
+func (s *BigQuerySink) SetEncoder(encoder Encoder) {
+	s.encoder = encoder
+}
+
+func (s *BigQuerySink) WriteHeader(header []string) error {
+	s.header = header
+	return nil
+}
+
+func bigQueryValueKind(fieldType bigquery.FieldType) ValueKind {
+	switch fieldType {
+	case bigquery.IntegerFieldType:
+		return KindInt
+	case bigquery.FloatFieldType, bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		return KindFloat
+	case bigquery.BooleanFieldType:
+		return KindBool
+	}
+	return KindString
+}
+
+// bigQueryRunIDColumn identifies the run of each row of the table.
+const bigQueryRunIDColumn = "run_id"
+
+// bigQueryColumns returns the BigQuery column name of each result column:
+// the column name made a valid identifier, numbered when it collides with
+// run_id or an earlier column, e.g. a-b and a_b becoming a_b and a_b_2.
+// BigQuery column names are case-insensitive.
+func bigQueryColumns(header []string) []string {
+	taken := map[string]bool{bigQueryRunIDColumn: true}
+	columns := []string{}
+	for _, name := range header {
+		identifier := sanitizeIdentifier(name)
+		column := identifier
+		for n := 2; taken[strings.ToLower(column)]; n++ {
+			column = identifier + "_" + strconv.Itoa(n)
+		}
+		taken[strings.ToLower(column)] = true
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// prepareTable infers the column kinds from the buffered rows and creates
+// the table unless it already exists. The columns of an existing table
+// must accept the values of the results, and missing ones are added.
+func (s *BigQuerySink) prepareTable(rows [][]string) error {
+	s.kinds = InferColumnKinds(s.encoder, len(s.header), rows)
+	s.schema = bigquery.Schema{{Name: bigQueryRunIDColumn, Type: bigquery.StringFieldType}}
+	for i, name := range bigQueryColumns(s.header) {
+		s.schema = append(s.schema, &bigquery.FieldSchema{
+			Name: name,
+			Type: bigQueryFieldType(s.kinds[i]),
+		})
+	}
+
+	metadata, err := s.table.Metadata(s.ctx)
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+		err = s.table.Create(s.ctx, &bigquery.TableMetadata{Schema: s.schema})
+		if err != nil {
+			return fmt.Errorf("Unable to create BigQuery table: %v", err)
+		}
+		s.inserter = s.table.Inserter()
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Unable to read BigQuery table metadata: %v", err)
+	}
+	if err := s.checkSchema(metadata); err != nil {
+		return err
+	}
+	s.inserter = s.table.Inserter()
+	return nil
+}
+
+// checkSchema takes the column types of an existing table, failing if the
+// results do not fit them, and adds the columns it lacks.
+func (s *BigQuerySink) checkSchema(metadata *bigquery.TableMetadata) error {
+	existing := map[string]*bigquery.FieldSchema{}
+	for _, field := range metadata.Schema {
+		existing[strings.ToLower(field.Name)] = field
+	}
+	schema := append(bigquery.Schema{}, metadata.Schema...)
+	added := false
+	for i, field := range s.schema {
+		current, ok := existing[strings.ToLower(field.Name)]
+		if !ok {
+			schema = append(schema, field)
+			added = true
+			continue
+		}
+		if i == 0 {
+			continue
+		}
+		kind := bigQueryValueKind(current.Type)
+		inferred := s.kinds[i-1]
+		if kind != inferred && kind != KindString && !(kind == KindFloat && inferred == KindInt) {
+			return fmt.Errorf("Column %s of BigQuery table %s is %s, which does not fit the results of %s", field.Name, s.table.FullyQualifiedName(), current.Type, s.header[i-1])
+		}
+		s.kinds[i-1] = kind
+		s.schema[i] = current
+	}
+	if !added {
+		return nil
+	}
+	update := bigquery.TableMetadataToUpdate{Schema: schema}
+	if _, err := s.table.Update(s.ctx, update, metadata.ETag); err != nil {
+		return fmt.Errorf("Unable to add columns to BigQuery table %s: %v", s.table.FullyQualifiedName(), err)
+	}
+	return nil
+}
+
+func (s *BigQuerySink) WriteRow(row []string) error {
+	return s.WriteRows([][]string{row})
+}
+
+// WriteRows streams several rows with a single insert request.
+func (s *BigQuerySink) WriteRows(rows [][]string) error {
+	if s.inserter == nil {
+		s.pending = append(s.pending, rows...)
+		if len(s.pending) < bigQueryInferenceRows {
+			return nil
+		}
+		return s.flushPending()
+	}
+	return s.insert(rows)
+}
+
+// flushPending creates or checks the table from the buffered rows and
+// inserts them.
+func (s *BigQuerySink) flushPending() error {
+	if err := s.prepareTable(s.pending); err != nil {
+		return err
+	}
+	pending := s.pending
+	s.pending = nil
+	return s.insert(pending)
+}
+
+func (s *BigQuerySink) insert(rows [][]string) error {
+	savers := []*bigquery.ValuesSaver{}
+	for _, row := range rows {
+		values := []bigquery.Value{s.runID}
+		for i, kind := range s.kinds {
+			if i >= len(row) || row[i] == "" {
+				values = append(values, nil)
+				continue
+			}
+			value, ok := s.encoder.Encode(kind, row[i])
+			if !ok {
+				Log.Warnf("Value %q does not match the type of column %s, recording NULL\n", row[i], s.header[i])
+				value = nil
+			}
+			values = append(values, value)
+		}
+		s.rowCount++
+		savers = append(savers, &bigquery.ValuesSaver{
+			Schema:   s.schema,
+			InsertID: fmt.Sprintf("%s-%d", s.runID, s.rowCount),
+			Row:      values,
+		})
+	}
+	if err := s.inserter.Put(s.ctx, savers); err != nil {
+		return fmt.Errorf("Unable to insert rows into BigQuery: %v", err)
+	}
+	return nil
+}
+
+func (s *BigQuerySink) Close() error {
+	if len(s.pending) > 0 {
+		if err := s.flushPending(); err != nil {
+			s.client.Close()
+			return err
+		}
+	}
+	return s.client.Close()
+}
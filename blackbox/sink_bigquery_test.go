@@ -0,0 +1,26 @@
+package blackbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBigQueryColumns(t *testing.T) {
+	tests := []struct {
+		header []string
+		want   []string
+	}{
+		{header: []string{"size", "latency ms"}, want: []string{"size", "latency_ms"}},
+		{header: []string{"a-b", "a_b", "a.b"}, want: []string{"a_b", "a_b_2", "a_b_3"}},
+		{header: []string{"Size", "size"}, want: []string{"Size", "size_2"}},
+		{header: []string{"run_id", "run-id"}, want: []string{"run_id_2", "run_id_3"}},
+		{header: []string{"a_2", "a", "a"}, want: []string{"a_2", "a", "a_3"}},
+		{header: []string{"1st"}, want: []string{"_1st"}},
+		{header: []string{}, want: []string{}},
+	}
+	for _, test := range tests {
+		if got := bigQueryColumns(test.header); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("bigQueryColumns(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package blackbox
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+)
+
+// CSVSink writes results into a local CSV file, flushing every row so the
+// file is usable while the run is in progress.
+type CSVSink struct {
+	file    *os.File
+	writer  *csv.Writer
+	encoder Encoder
+}
+
+// NewCSVSink creates a CSV file at path, named after the run if path is empty.
+func NewCSVSink(path, runName string) (*CSVSink, error) {
+	if path == "" {
+		path = runName + ".csv"
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create CSV file: %v", err)
+	}
+	return &CSVSink{file: file, writer: csv.NewWriter(file), encoder: StringEncoder{}}, nil
+}
+
+func (s *CSVSink) WriteHeader(header []string) error {
+	return s.WriteRow(header)
+}
+
+func (s *CSVSink) SetEncoder(encoder Encoder) {
+	s.encoder = encoder
+}
+
+func (s *CSVSink) WriteRow(row []string) error {
+	encoded := make([]string, len(row))
+	for i, value := range row {
+		v, _ := s.encoder.Encode(s.encoder.Kind(value), value)
+		encoded[i] = FormatValue(v)
+	}
+	if err := s.writer.Write(encoded); err != nil {
+		return err
+	}
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *CSVSink) Close() error {
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
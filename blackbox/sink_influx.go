@@ -0,0 +1,185 @@
+package blackbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Measurement of the points of the results, unless the URL names one
+	// with ?measurement=
+	influxMeasurement = "blackbox"
+	// Points written per request to InfluxDB
+	influxBatch = 5000
+)
+
+// InfluxSink writes results as InfluxDB line protocol points, to a write
+// endpoint for "influx:URL", e.g.
+// influx:http://localhost:8086/api/v2/write?org=lab&bucket=bench, or to a
+// file for "lineproto[:FILE]". Every result is a point whose tags are the
+// run ID and the input variables and whose fields are the numeric and
+// boolean outputs. Points are timestamped with the start of the run plus
+// the index of the result in nanoseconds, so that every run of a benchmark
+// matrix shows as one instant in dashboards while repeated results with
+// the same inputs stay distinct points instead of overwriting each other;
+// this needs the default ns precision. Writing to InfluxDB 2 needs a
+// token in $BLACKBOX_INFLUX_TOKEN.
+type InfluxSink struct {
+	writeURL    string
+	token       string
+	file        *os.File
+	writer      *bufio.Writer
+	measurement string
+	runID       string
+	varNames    []string
+	timestamp   int64
+	// results rendered so far
+	rows    int64
+	header  []string
+	pending []string
+}
+
+// NewInfluxSink opens a sink writing to an InfluxDB write URL.
+func NewInfluxSink(target string, sinkContext *SinkContext) (*InfluxSink, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid InfluxDB write URL %q, expected e.g. http://localhost:8086/api/v2/write?org=ORG&bucket=BUCKET", target)
+	}
+	query := u.Query()
+	measurement := query.Get("measurement")
+	if measurement == "" {
+		measurement = influxMeasurement
+	}
+	query.Del("measurement")
+	if precision := query.Get("precision"); precision == "" {
+		query.Set("precision", "ns")
+	} else if precision != "ns" {
+		return nil, fmt.Errorf("Invalid InfluxDB precision %q, points are written in ns", precision)
+	}
+	u.RawQuery = query.Encode()
+	s := newInfluxSink(measurement, sinkContext)
+	s.writeURL = u.String()
+	s.token = GetVariableOrDefault("BLACKBOX_INFLUX_TOKEN", "")
+	return s, nil
+}
+
+// NewLineProtocolSink opens a sink writing the points to a file, e.g. for
+// telegraf or influx write to pick up.
+func NewLineProtocolSink(path string, sinkContext *SinkContext) (*InfluxSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create line protocol file: %v", err)
+	}
+	s := newInfluxSink(influxMeasurement, sinkContext)
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	return s, nil
+}
+
+func newInfluxSink(measurement string, sinkContext *SinkContext) *InfluxSink {
+	return &InfluxSink{
+		measurement: measurement,
+		runID:       sinkContext.RunID,
+		varNames:    sinkContext.VarNames,
+		timestamp:   sinkContext.Start.UnixNano(),
+	}
+}
+
+// Characters escaped in measurements, and in tag keys, tag values and
+// field keys.
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+func (s *InfluxSink) WriteHeader(header []string) error {
+	s.header = header
+	return nil
+}
+
+// point renders a result as a line, empty if it has no field.
+func (s *InfluxSink) point(row []string) string {
+	timestamp := s.timestamp + s.rows
+	s.rows++
+	tags := []string{"run_id=" + influxKeyEscaper.Replace(s.runID)}
+	for i, varName := range s.varNames {
+		// Empty tag values are invalid
+		if value := cell(row, i); value != "" {
+			tags = append(tags, influxKeyEscaper.Replace(varName)+"="+influxKeyEscaper.Replace(value))
+		}
+	}
+	fields := []string{}
+	for i := len(s.varNames); i < len(row) && i < len(s.header); i++ {
+		key := influxKeyEscaper.Replace(s.header[i])
+		if value, err := strconv.ParseFloat(row[i], 64); err == nil {
+			fields = append(fields, key+"="+strconv.FormatFloat(value, 'g', -1, 64))
+		} else if row[i] == "true" || row[i] == "false" {
+			fields = append(fields, key+"="+row[i])
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s,%s %s %d", influxMeasurementEscaper.Replace(s.measurement), strings.Join(tags, ","), strings.Join(fields, ","), timestamp)
+}
+
+func (s *InfluxSink) WriteRow(row []string) error {
+	line := s.point(row)
+	if line == "" {
+		return nil
+	}
+	if s.writer != nil {
+		_, err := s.writer.WriteString(line + "\n")
+		return err
+	}
+	s.pending = append(s.pending, line)
+	if len(s.pending) >= influxBatch {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush posts the pending points.
+func (s *InfluxSink) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	body := strings.Join(s.pending, "\n") + "\n"
+	req, err := http.NewRequest(http.MethodPost, s.writeURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to write points to InfluxDB: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Unable to write points to InfluxDB: %s: %s", resp.Status, bytes.TrimSpace(content))
+	}
+	s.pending = nil
+	return nil
+}
+
+func (s *InfluxSink) Close() error {
+	if s.writer == nil {
+		return s.flush()
+	}
+	if err := s.writer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,74 @@
+package blackbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInfluxSinkPoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		measurement string
+		varNames    []string
+		header      []string
+		row         []string
+		want        string
+	}{
+		{
+			name:        "numeric and boolean fields",
+			measurement: "blackbox",
+			varNames:    []string{"size"},
+			header:      []string{"size", "latency_ms", "ok", "label"},
+			row:         []string{"10", "2.50", "true", "fast"},
+			want:        "blackbox,run_id=run-1,size=10 latency_ms=2.5,ok=true 1000",
+		},
+		{
+			name:        "escaped measurement, tags and field keys",
+			measurement: "my bench,v2",
+			varNames:    []string{"data set", "mode=x"},
+			header:      []string{"data set", "mode=x", "p99, ms"},
+			row:         []string{"a,b c", "k=v", "7"},
+			want:        `my\ bench\,v2,run_id=run-1,data\ set=a\,b\ c,mode\=x=k\=v p99\,\ ms=7 1000`,
+		},
+		{
+			name:        "empty tag values left out",
+			measurement: "blackbox",
+			varNames:    []string{"size", "mode"},
+			header:      []string{"size", "mode", "latency_ms"},
+			row:         []string{"", "fast", "1e3"},
+			want:        "blackbox,run_id=run-1,mode=fast latency_ms=1000 1000",
+		},
+		{
+			name:        "no field",
+			measurement: "blackbox",
+			varNames:    []string{"size"},
+			header:      []string{"size", "error"},
+			row:         []string{"10", "exit status 1"},
+			want:        "",
+		},
+	}
+	for _, test := range tests {
+		s := newInfluxSink(test.measurement, &SinkContext{RunID: "run-1", VarNames: test.varNames, Start: time.Unix(0, 1000)})
+		s.WriteHeader(test.header)
+		if got := s.point(test.row); got != test.want {
+			t.Errorf("%s: point = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInfluxSinkPointTimestamps(t *testing.T) {
+	s := newInfluxSink("blackbox", &SinkContext{RunID: "run-1", VarNames: []string{"size"}, Start: time.Unix(0, 1000)})
+	s.WriteHeader([]string{"size", "latency_ms"})
+	rows := [][]string{{"10", "1"}, {"10", "2"}, {"10", ""}, {"10", "3"}}
+	want := []string{
+		"blackbox,run_id=run-1,size=10 latency_ms=1 1000",
+		"blackbox,run_id=run-1,size=10 latency_ms=2 1001",
+		"",
+		"blackbox,run_id=run-1,size=10 latency_ms=3 1003",
+	}
+	for i, row := range rows {
+		if got := s.point(row); got != want[i] {
+			t.Errorf("point(%q) = %q, want %q", row, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package blackbox
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// JUnitSink writes a JUnit XML report of a run when it completes, with a
+// test case per input set failed by its assertions or its error, so CI
+// servers like Jenkins or GitLab show the runs in their test views.
+type JUnitSink struct {
+	path     string
+	runName  string
+	varNames []string
+	start    time.Time
+	header   []string
+	rows     [][]string
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// NewJUnitSink opens a sink writing the report of run runName to path.
+func NewJUnitSink(path string, sinkContext *SinkContext) (*JUnitSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("Missing JUnit report path")
+	}
+	return &JUnitSink{
+		path:     path,
+		runName:  sinkContext.RunName,
+		varNames: sinkContext.VarNames,
+		start:    time.Now(),
+	}, nil
+}
+
+func (s *JUnitSink) WriteHeader(header []string) error {
+	s.header = header
+	return nil
+}
+
+func (s *JUnitSink) WriteRow(row []string) error {
+	s.rows = append(s.rows, row)
+	return nil
+}
+
+// testCase returns the test case of a result row, its input set naming it
+// and its outputs as its output.
+func (s *JUnitSink) testCase(row []string) junitTestCase {
+	n := len(s.varNames)
+	if n > len(row) {
+		n = len(row)
+	}
+	testCase := junitTestCase{ClassName: "blackbox." + s.runName, Name: inputLabel(s.varNames[:n], row[:n])}
+	outputs := []string{}
+	for i := n; i < len(row) && i < len(s.header); i++ {
+		switch column := s.header[i]; {
+		case column == errorColumn && row[i] != "":
+			testCase.Error = &junitProblem{Message: row[i], Text: row[i]}
+		case column == assertionsColumn && IsAssertionFailure(row[i]):
+			testCase.Failure = &junitProblem{Message: row[i], Text: row[i]}
+		case !isBookkeepingColumn(column):
+			outputs = append(outputs, column+"="+row[i])
+		}
+	}
+	testCase.SystemOut = strings.Join(outputs, "\n")
+	return testCase
+}
+
+func (s *JUnitSink) Close() error {
+	suite := junitTestSuite{
+		Name:      s.runName,
+		Tests:     len(s.rows),
+		Time:      fmt.Sprintf("%.3f", time.Since(s.start).Seconds()),
+		Timestamp: s.start.Format("2006-01-02T15:04:05"),
+	}
+	for _, row := range s.rows {
+		testCase := s.testCase(row)
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+		if testCase.Error != nil {
+			suite.Errors++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	report, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, append([]byte(xml.Header), append(report, '\n')...), 0644); err != nil {
+		return fmt.Errorf("Unable to write the JUnit report: %v", err)
+	}
+	Log.Infof("Wrote the JUnit report to %s\n", s.path)
+	return nil
+}
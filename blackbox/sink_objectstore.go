@@ -0,0 +1,192 @@
+package blackbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Content types of the result files, by the extension of the object name,
+// which tells the format to write.
+var objectContentTypes = map[string]string{
+	".csv":     "text/csv",
+	".parquet": "application/vnd.apache.parquet",
+	".xlsx":    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".lp":      "text/plain",
+	".xml":     "application/xml",
+}
+
+// ObjectStoreSink writes results into a file of the run output directory,
+// uploaded on close to Google Cloud Storage for
+// "gs://bucket/path/run-{{.RunID}}.csv" or to S3 for "s3://bucket/...", so
+// that headless workers need no spreadsheet. The object name is a template
+// of the run's RunID, RunName and Date, and its extension the format of
+// the file: .csv, .parquet, .xlsx, .lp for line protocol or .xml for JUnit.
+// GCS uploads authenticate with Application Default Credentials, S3 ones
+// with the usual AWS environment, shared config and instance roles. If the
+// file cannot be written or uploaded, it is kept in the run output
+// directory.
+type ObjectStoreSink struct {
+	Sink
+	url         string
+	path        string
+	contentType string
+	upload      func(ctx context.Context, file *os.File, contentType string) error
+	// releases the storage client
+	closeClient func() error
+}
+
+// NewObjectStoreSink opens a sink for a gs:// or s3:// URL.
+func NewObjectStoreSink(spec string, sinkContext *SinkContext) (*ObjectStoreSink, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("Invalid object storage output %q, expected e.g. gs://bucket/path/run-{{.RunID}}.csv", spec)
+	}
+	tmpl, err := template.New("object").Option("missingkey=error").Parse(strings.TrimPrefix(u.Path, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid object name in %q: %v", spec, err)
+	}
+	var name bytes.Buffer
+	data := map[string]string{
+		"RunID":   sinkContext.RunID,
+		"RunName": sinkContext.RunName,
+		"Date":    sinkContext.Start.Format("2006-01-02"),
+	}
+	if err := tmpl.Execute(&name, data); err != nil {
+		return nil, fmt.Errorf("Invalid object name in %q: %v", spec, err)
+	}
+	bucket, object := u.Host, name.String()
+	extension := strings.ToLower(path.Ext(object))
+	contentType, ok := objectContentTypes[extension]
+	if !ok {
+		return nil, fmt.Errorf("Unable to tell the format of %s, expected a .csv, .parquet, .xlsx, .lp or .xml object", object)
+	}
+	s := &ObjectStoreSink{
+		url:         u.Scheme + "://" + bucket + "/" + object,
+		contentType: contentType,
+		closeClient: func() error { return nil },
+	}
+	ctx := context.Background()
+	switch u.Scheme {
+	case "gs":
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create Cloud Storage client: %v", err)
+		}
+		s.closeClient = client.Close
+		s.upload = func(ctx context.Context, file *os.File, contentType string) error {
+			w := client.Bucket(bucket).Object(object).NewWriter(ctx)
+			w.ContentType = contentType
+			if _, err := w.ReadFrom(file); err != nil {
+				w.Close()
+				return err
+			}
+			return w.Close()
+		}
+	case "s3":
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load AWS configuration: %v", err)
+		}
+		client := s3.NewFromConfig(cfg)
+		s.upload = func(ctx context.Context, file *os.File, contentType string) error {
+			_, err := client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:      aws.String(bucket),
+				Key:         aws.String(object),
+				Body:        file,
+				ContentType: aws.String(contentType),
+			})
+			return err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown object storage %q, expected gs:// or s3://", u.Scheme)
+	}
+
+	dir, err := RunOutputDir(sinkContext.RunID, sinkContext.Start)
+	if err != nil {
+		s.closeClient()
+		return nil, err
+	}
+	s.path = filepath.Join(dir, path.Base(object))
+	kind := strings.TrimPrefix(extension, ".")
+	switch extension {
+	case ".csv":
+		s.Sink, err = NewCSVSink(s.path, sinkContext.RunName)
+	case ".parquet":
+		s.Sink, err = NewParquetSink(s.path, sinkContext.RunName)
+	case ".xlsx":
+		s.Sink, err = NewXlsxSink(s.path, sinkContext.RunName)
+	case ".lp":
+		kind = "lineproto"
+		s.Sink, err = NewLineProtocolSink(s.path, sinkContext)
+	case ".xml":
+		kind = "junit"
+		s.Sink, err = NewJUnitSink(s.path, sinkContext)
+	}
+	if err != nil {
+		s.closeClient()
+		return nil, err
+	}
+	// The file is encoded as its local output kind would be
+	encoder, err := EncoderFor(kind, sinkContext.Encoders)
+	if err != nil {
+		s.Sink.Close()
+		s.closeClient()
+		return nil, err
+	}
+	if encodingSink, ok := s.Sink.(EncodingSink); ok && encoder != nil {
+		encodingSink.SetEncoder(encoder)
+	}
+	return s, nil
+}
+
+// WriteRows writes a batch to the file sink if it supports batches.
+func (s *ObjectStoreSink) WriteRows(rows [][]string) error {
+	if batchSink, ok := s.Sink.(BatchSink); ok {
+		return batchSink.WriteRows(rows)
+	}
+	for _, row := range rows {
+		if err := s.Sink.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ObjectStoreSink) Finalize() error {
+	if finalizer, ok := s.Sink.(Finalizer); ok {
+		return finalizer.Finalize()
+	}
+	return nil
+}
+
+// Close closes the file and uploads it.
+func (s *ObjectStoreSink) Close() error {
+	defer s.closeClient()
+	if err := s.Sink.Close(); err != nil {
+		Log.Errorf("Results were not uploaded to %s, what was written is in %s\n", s.url, s.path)
+		return err
+	}
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("Unable to upload %s: %v", s.path, err)
+	}
+	defer file.Close()
+	if err := s.upload(context.Background(), file, s.contentType); err != nil {
+		Log.Errorf("Results were not uploaded to %s, they are in %s\n", s.url, s.path)
+		return fmt.Errorf("Unable to upload results to %s: %v", s.url, err)
+	}
+	Log.Infof("Uploaded results to %s\n", s.url)
+	return nil
+}
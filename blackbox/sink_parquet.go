@@ -0,0 +1,151 @@
+package blackbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	parquet "github.com/parquet-go/parquet-go"
+)
+
+// Rows buffered before the column types are inferred and the file is created.
+const parquetInferenceRows = 100
+
+// ParquetSink writes results into a local Parquet file with typed, nullable
+// columns inferred from the first batch of rows.
+type ParquetSink struct {
+	path    string
+	header  []string
+	pending [][]string
+	kinds   []ValueKind
+	columns []int // schema column index of each header column
+	encoder Encoder
+	file    *os.File
+	writer  *parquet.Writer
+}
+
+// NewParquetSink opens a sink writing to path. An empty path or a directory
+// gets a file named after the run.
+func NewParquetSink(path, runName string) (*ParquetSink, error) {
+	if path == "" {
+		path = runName + ".parquet"
+	} else if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, runName+".parquet")
+	}
+	return &ParquetSink{path: path, encoder: TypedEncoder{}}, nil
+}
+
+func parquetNode(kind ValueKind) parquet.Node {
+	switch kind {
+	case KindInt:
+		return parquet.Optional(parquet.Int(64))
+	case KindFloat:
+		return parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	case KindBool:
+		return parquet.Optional(parquet.Leaf(parquet.BooleanType))
+	}
+	return parquet.Optional(parquet.String())
+}
+
+func (s *ParquetSink) SetEncoder(encoder Encoder) {
+	s.encoder = encoder
+}
+
+func (s *ParquetSink) WriteHeader(header []string) error {
+	s.header = header
+	return nil
+}
+
+func (s *ParquetSink) WriteRow(row []string) error {
+	if s.writer != nil {
+		return s.write(row)
+	}
+	s.pending = append(s.pending, row)
+	if len(s.pending) < parquetInferenceRows {
+		return nil
+	}
+	return s.flushPending()
+}
+
+// flushPending infers the schema from the buffered rows, creates the file
+// and writes the buffered rows into it.
+func (s *ParquetSink) flushPending() error {
+	s.kinds = InferColumnKinds(s.encoder, len(s.header), s.pending)
+	group := parquet.Group{}
+	for i, name := range s.header {
+		group[name] = parquetNode(s.kinds[i])
+	}
+	schema := parquet.NewSchema("result", group)
+	index := map[string]int{}
+	for i, field := range schema.Fields() {
+		index[field.Name()] = i
+	}
+	for _, name := range s.header {
+		s.columns = append(s.columns, index[name])
+	}
+
+	file, err := os.Create(s.path)
+	if err != nil {
+		return fmt.Errorf("Unable to create parquet file: %v", err)
+	}
+	s.file = file
+	s.writer = parquet.NewWriter(file, schema)
+	Log.Debugf("Writing results to %s\n", s.path)
+
+	pending := s.pending
+	s.pending = nil
+	for _, row := range pending {
+		if err := s.write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ParquetSink) write(row []string) error {
+	values := make(parquet.Row, len(s.header))
+	for i, kind := range s.kinds {
+		value := parquet.NullValue()
+		if i < len(row) && row[i] != "" {
+			parsed, ok := s.encoder.Encode(kind, row[i])
+			if !ok {
+				Log.Warnf("Value %q does not match the type of column %s, recording null\n", row[i], s.header[i])
+			}
+			switch v := parsed.(type) {
+			case int64:
+				value = parquet.Int64Value(v)
+			case float64:
+				value = parquet.DoubleValue(v)
+			case bool:
+				value = parquet.BooleanValue(v)
+			case string:
+				value = parquet.ByteArrayValue([]byte(v))
+			}
+			if !ok {
+				value = parquet.NullValue()
+			}
+		}
+		definitionLevel := 1
+		if value.IsNull() {
+			definitionLevel = 0
+		}
+		values[s.columns[i]] = value.Level(0, definitionLevel, s.columns[i])
+	}
+	_, err := s.writer.WriteRows([]parquet.Row{values})
+	return err
+}
+
+func (s *ParquetSink) Close() error {
+	if s.writer == nil {
+		if len(s.header) == 0 {
+			return nil
+		}
+		if err := s.flushPending(); err != nil {
+			return err
+		}
+	}
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,160 @@
+package blackbox
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pushgatewayJob = "blackbox"
+
+// Labels the Pushgateway or the sink itself sets, which input variables of
+// the same name are pushed under with an input_ prefix instead.
+var reservedLabels = map[string]bool{
+	"job":      true,
+	"instance": true,
+	"run_id":   true,
+}
+
+// Metrics of the run itself, which outputs of the same name are pushed
+// under with a blackbox_output_ prefix instead.
+var runMetrics = map[string]bool{
+	"blackbox_run_duration_seconds": true,
+	"blackbox_input_sets":           true,
+}
+
+// PushgatewaySink pushes the metrics of a run to a Prometheus Pushgateway
+// when the run completes: the run duration and number of input sets, and
+// every numeric output as a gauge labeled by the run ID and the input
+// variables. Pushing replaces the previous run's metrics of the same job.
+type PushgatewaySink struct {
+	gatewayURL string
+	runID      string
+	// label name of each input variable
+	labels []string
+	start  time.Time
+	header []string
+	// metric name of each output column, empty for input columns
+	metrics []string
+	// metric name -> label set -> value
+	samples map[string]map[string]float64
+	rows    int
+}
+
+// NewPushgatewaySink opens a sink pushing to gateway, e.g. http://localhost:9091.
+func NewPushgatewaySink(gateway string, sinkContext *SinkContext) (*PushgatewaySink, error) {
+	if _, err := url.Parse(gateway); err != nil || gateway == "" {
+		return nil, fmt.Errorf("Invalid pushgateway URL %q", gateway)
+	}
+	labels := []string{}
+	seen := map[string]string{}
+	for _, varName := range sinkContext.VarNames {
+		label := sanitizeIdentifier(varName)
+		if reservedLabels[label] || strings.HasPrefix(label, "__") {
+			label = "input_" + label
+		}
+		if other, ok := seen[label]; ok {
+			return nil, fmt.Errorf("Variables %s and %s are both pushed as label %s", other, varName, label)
+		}
+		seen[label] = varName
+		labels = append(labels, label)
+	}
+	return &PushgatewaySink{
+		gatewayURL: strings.TrimRight(gateway, "/"),
+		runID:      sinkContext.RunID,
+		labels:     labels,
+		start:      time.Now(),
+		samples:    map[string]map[string]float64{},
+	}, nil
+}
+
+// metricName returns the metric of an output.
+func metricName(output string) string {
+	metric := "blackbox_" + sanitizeIdentifier(output)
+	if runMetrics[metric] {
+		metric = "blackbox_output_" + sanitizeIdentifier(output)
+	}
+	return metric
+}
+
+func (s *PushgatewaySink) WriteHeader(header []string) error {
+	s.header = header
+	s.metrics = make([]string, len(header))
+	seen := map[string]string{}
+	for i := len(s.labels); i < len(header); i++ {
+		metric := metricName(header[i])
+		if other, ok := seen[metric]; ok {
+			return fmt.Errorf("Outputs %s and %s are both pushed as metric %s", other, header[i], metric)
+		}
+		seen[metric] = header[i]
+		s.metrics[i] = metric
+	}
+	return nil
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func (s *PushgatewaySink) WriteRow(row []string) error {
+	s.rows++
+	labels := []string{fmt.Sprintf(`run_id="%s"`, s.runID)}
+	for i, label := range s.labels {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, label, escapeLabelValue(cell(row, i))))
+	}
+	labelSet := "{" + strings.Join(labels, ",") + "}"
+	for i := len(s.labels); i < len(row) && i < len(s.header); i++ {
+		value, err := strconv.ParseFloat(row[i], 64)
+		if err != nil {
+			continue
+		}
+		metric := s.metrics[i]
+		if s.samples[metric] == nil {
+			s.samples[metric] = map[string]float64{}
+		}
+		s.samples[metric][labelSet] = value
+	}
+	return nil
+}
+
+// exposition renders the collected metrics in the Prometheus text format.
+func (s *PushgatewaySink) exposition() []byte {
+	var b bytes.Buffer
+	runLabel := fmt.Sprintf(`{run_id="%s"}`, s.runID)
+	fmt.Fprintf(&b, "# TYPE blackbox_run_duration_seconds gauge\nblackbox_run_duration_seconds%s %g\n", runLabel, time.Since(s.start).Seconds())
+	fmt.Fprintf(&b, "# TYPE blackbox_input_sets gauge\nblackbox_input_sets%s %d\n", runLabel, s.rows)
+	metrics := []string{}
+	for metric := range s.samples {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", metric)
+		for labelSet, value := range s.samples[metric] {
+			fmt.Fprintf(&b, "%s%s %g\n", metric, labelSet, value)
+		}
+	}
+	return b.Bytes()
+}
+
+func (s *PushgatewaySink) Close() error {
+	req, err := http.NewRequest(http.MethodPut, s.gatewayURL+"/metrics/job/"+pushgatewayJob, bytes.NewReader(s.exposition()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to push metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Unable to push metrics: pushgateway returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,813 @@
+package blackbox
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Results are written to a tab with this suffix, removed when the run
+// finalizes, so that unfinished runs are recognizable.
+const inProgressSuffix = "_in_progress"
+
+// SheetsSink writes results into a new tab of the input spreadsheet.
+type SheetsSink struct {
+	srv           *sheets.Service
+	spreadsheetID string
+	finalName     string
+	sheetName     string
+	sheetID       int64
+	// 1-based line following the last rows appended
+	currentLine int
+	// index of the column deciding which rows to highlight, -1 if none
+	highlightColumn int
+	highlight       func(value string) bool
+	header          []string
+	charts          []ChartSpec
+	pivots          []PivotSpec
+	thresholds      []Threshold
+	// number of leading columns holding inputs, frozen and shaded
+	inputColumns int
+	// every row written so far, to replay into a recreated tab
+	written [][]string
+	// 1-based line of each written row, as reported by the appends
+	lines []int
+	// local file receiving the results once the tab could not be recreated
+	fallback *CSVSink
+	// number of written rows read back and compared when closing
+	verifySample int
+	encoder      Encoder
+}
+
+// Rows are colored red when one of these columns marks them as failed.
+var highlightedColumns = map[string]func(value string) bool{
+	assertionsColumn:  IsAssertionFailure,
+	regressionsColumn: func(value string) bool { return value != "" },
+	mismatchColumn:    func(value string) bool { return value != "" },
+}
+
+func NewSheetsSink(srv *sheets.Service, spreadsheetID, sheetName string) (*SheetsSink, error) {
+	sheetID, err := CreateNewResultSheet(srv, spreadsheetID, sheetName+inProgressSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &SheetsSink{
+		srv:             srv,
+		spreadsheetID:   spreadsheetID,
+		finalName:       sheetName,
+		sheetName:       sheetName + inProgressSuffix,
+		sheetID:         sheetID,
+		currentLine:     1,
+		highlightColumn: -1,
+		encoder:         SheetsEncoder{},
+	}, nil
+}
+
+func (s *SheetsSink) SetEncoder(encoder Encoder) {
+	s.encoder = encoder
+}
+
+func (s *SheetsSink) WriteHeader(header []string) error {
+	s.header = header
+	for i, column := range header {
+		if highlight, ok := highlightedColumns[column]; ok {
+			s.highlightColumn = i
+			s.highlight = highlight
+		}
+	}
+	if err := s.WriteRow(header); err != nil {
+		return err
+	}
+	return s.format()
+}
+
+// format sets the formatting of the tab once its header is known: the
+// header row and input columns frozen, the inputs shaded and separated
+// from the outputs by a border, and the cells colored by thresholds.
+func (s *SheetsSink) format() error {
+	requests := []*sheets.Request{{UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+		Properties: &sheets.SheetProperties{
+			SheetId: s.sheetID,
+			GridProperties: &sheets.GridProperties{
+				FrozenRowCount:    1,
+				FrozenColumnCount: int64(s.inputColumns),
+				ForceSendFields:   []string{"FrozenColumnCount"},
+			},
+		},
+		Fields: "gridProperties.frozenRowCount,gridProperties.frozenColumnCount",
+	}}}
+	if s.inputColumns > 0 {
+		requests = append(requests,
+			&sheets.Request{RepeatCell: &sheets.RepeatCellRequest{
+				Range: &sheets.GridRange{SheetId: s.sheetID, EndColumnIndex: int64(s.inputColumns)},
+				Cell: &sheets.CellData{UserEnteredFormat: &sheets.CellFormat{
+					BackgroundColor: &sheets.Color{Red: 0.91, Green: 0.94, Blue: 0.98},
+				}},
+				Fields: "userEnteredFormat.backgroundColor",
+			}},
+			&sheets.Request{UpdateBorders: &sheets.UpdateBordersRequest{
+				Range: &sheets.GridRange{
+					SheetId:          s.sheetID,
+					StartColumnIndex: int64(s.inputColumns - 1),
+					EndColumnIndex:   int64(s.inputColumns),
+				},
+				Right: &sheets.Border{Style: "SOLID_MEDIUM"},
+			}},
+		)
+	}
+	rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	if _, err := s.srv.Spreadsheets.BatchUpdate(s.spreadsheetID, rb).Do(); err != nil {
+		return fmt.Errorf("Unable to format result sheet %s: %v", s.sheetName, err)
+	}
+	if len(s.thresholds) > 0 {
+		return AddConditionalFormats(s.srv, s.spreadsheetID, s.sheetID, s.header, s.thresholds)
+	}
+	return nil
+}
+
+func (s *SheetsSink) WriteRow(row []string) error {
+	return s.WriteRows([][]string{row})
+}
+
+// WriteRows writes several rows with a single API request.
+func (s *SheetsSink) WriteRows(rows [][]string) error {
+	if s.fallback != nil {
+		return s.writeFallback(rows)
+	}
+	err := s.writeValues(rows)
+	if isMissingSheet(err) {
+		err = s.recreate(rows)
+	}
+	if err != nil {
+		return err
+	}
+	s.written = append(s.written, rows...)
+	return nil
+}
+
+func (s *SheetsSink) writeValues(rows [][]string) error {
+	values := [][]interface{}{}
+	for _, row := range rows {
+		resultRow := make([]interface{}, 0)
+		for _, value := range row {
+			encoded, _ := s.encoder.Encode(s.encoder.Kind(value), value)
+			resultRow = append(resultRow, encoded)
+		}
+		values = append(values, resultRow)
+	}
+
+	vr := sheets.ValueRange{
+		Values: values,
+	}
+
+	// Appending after the last row of the table, rather than at a computed
+	// line, keeps rows someone added to the tab during the run
+	resp, err := s.srv.Spreadsheets.Values.Append(s.spreadsheetID, s.sheetName+"!A1", &vr).
+		ValueInputOption("USER_ENTERED").InsertDataOption("INSERT_ROWS").Do()
+	if err != nil {
+		return err
+	}
+	firstLine := s.currentLine
+	if resp.Updates != nil {
+		if line, err := firstRow(resp.Updates.UpdatedRange); err == nil {
+			firstLine = line
+		}
+	}
+	if err := s.highlightFailures(rows, firstLine); err != nil {
+		return err
+	}
+	for i := range rows {
+		s.lines = append(s.lines, firstLine+i)
+	}
+	s.currentLine = firstLine + len(rows)
+	return nil
+}
+
+// firstRow returns the 1-based first row of a range in A1 notation, e.g.
+// 5 for result_x!A5:D7.
+func firstRow(a1 string) (int, error) {
+	cells := a1[strings.LastIndex(a1, "!")+1:]
+	if i := strings.Index(cells, ":"); i >= 0 {
+		cells = cells[:i]
+	}
+	row, err := strconv.Atoi(strings.TrimLeft(cells, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+	if err != nil {
+		return 0, fmt.Errorf("No row in range %s", a1)
+	}
+	return row, nil
+}
+
+// isMissingSheet reports whether a write failed because its tab is gone,
+// e.g. deleted by someone while the run was in progress.
+func isMissingSheet(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	return apiErr.Code == http.StatusNotFound ||
+		(apiErr.Code == http.StatusBadRequest && strings.Contains(apiErr.Message, "Unable to parse range"))
+}
+
+// recreate replays the rows written so far, followed by rows, into a new
+// tab replacing a deleted one, or into a local CSV file if the tab cannot
+// be recreated.
+func (s *SheetsSink) recreate(rows [][]string) error {
+	Log.Warnf("Result tab %s disappeared, recreating it with the %d rows written so far\n", s.sheetName, len(s.written))
+	replay := append(append([][]string{}, s.written...), rows...)
+	sheetID, err := CreateNewResultSheet(s.srv, s.spreadsheetID, s.sheetName)
+	if err == nil {
+		s.sheetID = sheetID
+		s.currentLine = 1
+		s.lines = nil
+		// Formatted first, so that failed rows are highlighted over the inputs
+		if err = s.format(); err == nil {
+			err = s.writeValues(replay)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	Log.Warnf("Unable to recreate result tab %s, writing results to %s.csv instead: %v\n", s.sheetName, s.finalName, err)
+	if s.fallback, err = NewCSVSink("", s.finalName); err != nil {
+		return fmt.Errorf("Unable to recreate result tab %s or fall back to a local file: %v", s.sheetName, err)
+	}
+	return s.writeFallback(replay)
+}
+
+func (s *SheetsSink) writeFallback(rows [][]string) error {
+	for _, row := range rows {
+		if err := s.fallback.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// highlightFailures colors the rows that failed, e.g. their assertions,
+// red; firstLine is the 1-based line of rows[0].
+func (s *SheetsSink) highlightFailures(rows [][]string, firstLine int) error {
+	if s.highlightColumn < 0 {
+		return nil
+	}
+	requests := []*sheets.Request{}
+	for i, row := range rows {
+		if firstLine+i == 1 || s.highlightColumn >= len(row) || !s.highlight(row[s.highlightColumn]) {
+			continue
+		}
+		requests = append(requests, &sheets.Request{RepeatCell: &sheets.RepeatCellRequest{
+			Range: &sheets.GridRange{
+				SheetId:       s.sheetID,
+				StartRowIndex: int64(firstLine + i - 1),
+				EndRowIndex:   int64(firstLine + i),
+			},
+			Cell: &sheets.CellData{UserEnteredFormat: &sheets.CellFormat{
+				BackgroundColor: &sheets.Color{Red: 1.0, Green: 0.8, Blue: 0.8},
+			}},
+			Fields: "userEnteredFormat.backgroundColor",
+		}})
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	_, err := s.srv.Spreadsheets.BatchUpdate(s.spreadsheetID, rb).Do()
+	return err
+}
+
+func (s *SheetsSink) Close() error {
+	if s.fallback != nil {
+		return s.fallback.Close()
+	}
+	if s.verifySample > 0 {
+		return s.verifyWrites()
+	}
+	return nil
+}
+
+// verifyWrites reads back a random sample of the written rows, from the
+// lines their appends reported, and logs those differing from what was
+// sent, e.g. values reinterpreted by Sheets or edited by someone during
+// the run.
+func (s *SheetsSink) verifyWrites() error {
+	sample := rand.Perm(len(s.written))
+	if len(sample) > s.verifySample {
+		sample = sample[:s.verifySample]
+	}
+	sort.Ints(sample)
+	ranges := []string{}
+	for _, i := range sample {
+		ranges = append(ranges, fmt.Sprintf("%s!A%d:%d", s.sheetName, s.lines[i], s.lines[i]))
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+	resp, err := s.srv.Spreadsheets.Values.BatchGet(s.spreadsheetID).Ranges(ranges...).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to read back %s: %v", s.sheetName, err)
+	}
+	differing := 0
+	for j, valueRange := range resp.ValueRanges {
+		sent := s.written[sample[j]]
+		read := []string{}
+		if len(valueRange.Values) > 0 {
+			for _, value := range valueRange.Values[0] {
+				read = append(read, fmt.Sprint(value))
+			}
+		}
+		differs := false
+		for c := 0; c < len(sent) || c < len(read); c++ {
+			if cell(sent, c) != cell(read, c) {
+				Log.Warnf("%s line %d column %d: sent %q, sheet has %q\n", s.sheetName, s.lines[sample[j]], c+1, cell(sent, c), cell(read, c))
+				differs = true
+			}
+		}
+		if differs {
+			differing++
+		}
+	}
+	Log.Infof("Write verification of %s: %d of %d sampled rows differ\n", s.sheetName, differing, len(sample))
+	return nil
+}
+
+// Finalize renames the tab to its final name, colors it green and adds the
+// filters, charts and pivot tables of the results.
+func (s *SheetsSink) Finalize() error {
+	if s.fallback != nil {
+		Log.Infof("Results of %s are in %s.csv\n", s.finalName, s.finalName)
+		return nil
+	}
+	rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{{
+		UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+			Properties: &sheets.SheetProperties{
+				SheetId:  s.sheetID,
+				Title:    s.finalName,
+				TabColor: &sheets.Color{Red: 0.3, Green: 0.8, Blue: 0.4},
+			},
+			Fields: "title,tabColor",
+		},
+	}}}
+	if _, err := s.srv.Spreadsheets.BatchUpdate(s.spreadsheetID, rb).Do(); err != nil {
+		return fmt.Errorf("Unable to finalize result sheet %s: %v", s.sheetName, err)
+	}
+	s.sheetName = s.finalName
+	if err := s.addFilters(); err != nil {
+		return err
+	}
+	if len(s.charts) > 0 {
+		if err := AddCharts(s.srv, s.spreadsheetID, s.sheetID, s.header, s.currentLine-1, s.charts); err != nil {
+			return err
+		}
+	}
+	if len(s.pivots) > 0 {
+		return AddPivotTables(s.srv, s.spreadsheetID, s.sheetID, s.finalName, s.header, s.currentLine-1, s.pivots)
+	}
+	return nil
+}
+
+// Input columns with more distinct values than this get no dropdown.
+const maxDropdownValues = 100
+
+// addFilters adds a basic filter over the results and, to every input
+// column, a dropdown of its values, so that the results can be sliced in
+// Sheets right away.
+func (s *SheetsSink) addFilters() error {
+	if len(s.written) < 2 {
+		return nil
+	}
+	requests := []*sheets.Request{{SetBasicFilter: &sheets.SetBasicFilterRequest{
+		Filter: &sheets.BasicFilter{Range: &sheets.GridRange{
+			SheetId:        s.sheetID,
+			EndRowIndex:    int64(len(s.written)),
+			EndColumnIndex: int64(len(s.header)),
+		}},
+	}}}
+	for column := 0; column < s.inputColumns && column < len(s.header); column++ {
+		if s.header[column] == rowIndexColumn {
+			continue
+		}
+		seen := map[string]bool{}
+		values := []*sheets.ConditionValue{}
+		for _, row := range s.written[1:] {
+			if value := cell(row, column); value != "" && !seen[value] {
+				seen[value] = true
+				values = append(values, &sheets.ConditionValue{UserEnteredValue: value})
+			}
+		}
+		if len(values) == 0 || len(values) > maxDropdownValues {
+			continue
+		}
+		requests = append(requests, &sheets.Request{SetDataValidation: &sheets.SetDataValidationRequest{
+			Range: &sheets.GridRange{
+				SheetId:          s.sheetID,
+				StartRowIndex:    1,
+				EndRowIndex:      int64(len(s.written)),
+				StartColumnIndex: int64(column),
+				EndColumnIndex:   int64(column + 1),
+			},
+			Rule: &sheets.DataValidationRule{
+				Condition:    &sheets.BooleanCondition{Type: "ONE_OF_LIST", Values: values},
+				ShowCustomUi: true,
+			},
+		}})
+	}
+	rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	if _, err := s.srv.Spreadsheets.BatchUpdate(s.spreadsheetID, rb).Do(); err != nil {
+		return fmt.Errorf("Unable to add filters to result sheet %s: %v", s.sheetName, err)
+	}
+	return nil
+}
+
+// Result tabs wider than this are split into linked tabs by default.
+const defaultMaxSheetColumns = 250
+
+// Google Sheets holds at most this many cells per spreadsheet.
+const sheetsCellLimit = 10000000
+
+// New tabs have this many columns, and grow wider as needed.
+const newSheetColumns = 26
+
+// rowIndexColumn links the rows of a result split across several tabs.
+const rowIndexColumn = "row"
+
+// WideSheetsSink spreads results over as many tabs as they need.
+//
+// Results with more columns than fit comfortably in one tab go to linked
+// tabs: the inputs, bookkeeping columns and first outputs to the result
+// tab, the other outputs to result_x_aux1, result_x_aux2... Every tab then
+// starts with a shared row index column.
+//
+// Once a tab holds MaxRows results, writing rolls over to result_x_part2,
+// result_x_part3... Once the spreadsheet has no room left for a row, as
+// every tab counts towards its cell limit, writing rolls over to the next
+// part in a new spreadsheet.
+type WideSheetsSink struct {
+	context    *SinkContext
+	maxColumns int
+	header     []string
+	// tabs of the current part, result tab first
+	tabs []*SheetsSink
+	// tabs of the previous parts
+	filled []*SheetsSink
+	// result columns written to each tab of a part
+	columns  [][]int
+	indexed  bool
+	rows     int
+	part     int
+	partRows int
+	// spreadsheet holding the tabs of the current part
+	spreadsheetID string
+	// cells the spreadsheet can still grow by, and cells a row takes
+	// across the tabs of a part
+	cellsLeft int64
+	rowCells  int64
+}
+
+func NewWideSheetsSink(primary *SheetsSink, sinkContext *SinkContext) *WideSheetsSink {
+	maxColumns := sinkContext.MaxColumns
+	if maxColumns < 2 {
+		maxColumns = defaultMaxSheetColumns
+	}
+	return &WideSheetsSink{
+		context:       sinkContext,
+		maxColumns:    maxColumns,
+		tabs:          []*SheetsSink{primary},
+		part:          1,
+		spreadsheetID: sinkContext.SpreadsheetID,
+	}
+}
+
+// SplitColumns assigns result columns to tabs of at most perTab columns,
+// keeping the inputs and bookkeeping columns in the first one.
+func SplitColumns(header, varNames []string, perTab int) [][]int {
+	isInput := map[string]bool{}
+	for _, varName := range varNames {
+		isInput[varName] = true
+	}
+	first, outputs := []int{}, []int{}
+	for i, column := range header {
+		if isInput[column] || isBookkeepingColumn(column) {
+			first = append(first, i)
+		} else {
+			outputs = append(outputs, i)
+		}
+	}
+	for len(outputs) > 0 && len(first) < perTab {
+		first = append(first, outputs[0])
+		outputs = outputs[1:]
+	}
+	split := [][]int{first}
+	for len(outputs) > 0 {
+		n := perTab
+		if n > len(outputs) {
+			n = len(outputs)
+		}
+		split = append(split, outputs[:n])
+		outputs = outputs[n:]
+	}
+	return split
+}
+
+// SpreadsheetCells returns the number of cells used by the tabs of a
+// spreadsheet.
+func SpreadsheetCells(srv *sheets.Service, spreadsheetID string) (int64, error) {
+	resp, err := srv.Spreadsheets.Get(spreadsheetID).Fields("sheets.properties.gridProperties").Do()
+	if err != nil {
+		return 0, err
+	}
+	var cells int64
+	for _, sheet := range resp.Sheets {
+		if grid := sheet.Properties.GridProperties; grid != nil {
+			cells += grid.RowCount * grid.ColumnCount
+		}
+	}
+	return cells, nil
+}
+
+// measureCells reads how many cells the spreadsheet of the current part
+// can still grow by, and counts the cells a row takes across the tabs of
+// the part: appended rows are inserted over the whole width of each tab.
+func (w *WideSheetsSink) measureCells() error {
+	used, err := SpreadsheetCells(w.context.Service, w.spreadsheetID)
+	if err != nil {
+		return fmt.Errorf("Unable to check the spreadsheet size: %v", err)
+	}
+	w.cellsLeft = sheetsCellLimit - used
+	w.rowCells = 0
+	for i := range w.tabs {
+		columns := len(w.columns[i])
+		if w.indexed {
+			columns++
+		}
+		if columns < newSheetColumns {
+			columns = newSheetColumns
+		}
+		w.rowCells += int64(columns)
+	}
+	return nil
+}
+
+// warnCapacity suggests, before any result is written, recording results
+// that cannot fit in the spreadsheet elsewhere.
+func (w *WideSheetsSink) warnCapacity() {
+	needed := w.rowCells * int64(w.context.ExpectedRows)
+	if needed <= w.cellsLeft {
+		return
+	}
+	Log.Warnf("Results need about %d cells but the spreadsheet only has room for %d of Google Sheets' %d; "+
+		"the rows that do not fit will continue in new spreadsheets, or record them with "+
+		"-output csv:FILE, parquet:FILE or bq:project.dataset.table instead\n",
+		needed, w.cellsLeft, sheetsCellLimit)
+}
+
+// nextWrite returns how many of rows the current part can still take,
+// given the rows it holds before rolling over and the cells left in its
+// spreadsheet. When it can take none, newSpreadsheet tells whether the
+// spreadsheet ran out of cells rather than the part of rows.
+func (w *WideSheetsSink) nextWrite(rows int) (n int, newSpreadsheet bool) {
+	partRowsLeft := w.maxRows() - w.partRows
+	n = rows
+	if n > partRowsLeft {
+		n = partRowsLeft
+	}
+	if fit := w.cellsLeft / w.rowCells; int64(n) > fit {
+		n = int(fit)
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n, n == 0 && partRowsLeft > 0
+}
+
+func (w *WideSheetsSink) maxRows() int {
+	if w.context.MaxRows > 0 {
+		return w.context.MaxRows
+	}
+	return sheetsCellLimit
+}
+
+func (w *WideSheetsSink) SetEncoder(encoder Encoder) {
+	w.tabs[0].SetEncoder(encoder)
+}
+
+func (w *WideSheetsSink) WriteHeader(header []string) error {
+	w.header = header
+	if len(header) <= w.maxColumns {
+		w.columns = [][]int{nil}
+		for i := range header {
+			w.columns[0] = append(w.columns[0], i)
+		}
+	} else {
+		w.indexed = true
+		w.columns = SplitColumns(header, w.context.VarNames, w.maxColumns-1)
+		if err := w.checkCharts(); err != nil {
+			return err
+		}
+	}
+	if err := w.openPart(); err != nil {
+		return err
+	}
+	w.warnCapacity()
+	return nil
+}
+
+// checkCharts fails when a chart plots a column spilled into an auxiliary
+// tab, as charts only go to the result tab.
+func (w *WideSheetsSink) checkCharts() error {
+	index := columnIndex(w.header)
+	inResultTab := map[string]bool{}
+	for _, column := range w.columns[0] {
+		inResultTab[w.header[column]] = true
+	}
+	for _, chart := range w.context.Charts {
+		for _, column := range []string{chart.Output, chart.Input} {
+			if _, ok := index[column]; ok && !inResultTab[column] {
+				return fmt.Errorf("Chart %s:%s plots %s, which spills into an auxiliary tab of %s; "+
+					"raise max_columns to keep it in the result tab", chart.Output, chart.Input, column, w.context.RunName)
+			}
+		}
+	}
+	return nil
+}
+
+// openPart adds the auxiliary tabs of the current part and writes the
+// headers of its tabs.
+func (w *WideSheetsSink) openPart() error {
+	name := w.context.RunName
+	if w.part > 1 {
+		name = fmt.Sprintf("%s_part%d", name, w.part)
+	}
+	for i := 1; i < len(w.columns); i++ {
+		tab, err := NewSheetsSink(w.context.Service, w.spreadsheetID, fmt.Sprintf("%s_aux%d", name, i))
+		if err != nil {
+			return err
+		}
+		tab.verifySample = w.context.VerifyWrites
+		tab.encoder = w.tabs[0].encoder
+		w.tabs = append(w.tabs, tab)
+	}
+	for i, tab := range w.tabs {
+		tab.inputColumns = w.inputColumns(i)
+		tab.thresholds = w.tabThresholds(i)
+		if err := tab.WriteHeader(w.project(rowIndexColumn, w.header, i)); err != nil {
+			return err
+		}
+	}
+	w.partRows = 0
+	return w.measureCells()
+}
+
+// inputColumns returns the number of leading columns of a tab holding the
+// row index or inputs.
+func (w *WideSheetsSink) inputColumns(tab int) int {
+	isInput := map[string]bool{}
+	for _, varName := range w.context.VarNames {
+		isInput[varName] = true
+	}
+	n := 0
+	if w.indexed {
+		n++
+	}
+	for _, column := range w.columns[tab] {
+		if !isInput[w.header[column]] {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// tabThresholds returns the thresholds of the columns written to a tab.
+// Those of columns missing from the results go to the result tab, which
+// reports them.
+func (w *WideSheetsSink) tabThresholds(tab int) []Threshold {
+	index := columnIndex(w.header)
+	inTab := map[string]bool{}
+	for _, column := range w.columns[tab] {
+		inTab[w.header[column]] = true
+	}
+	thresholds := []Threshold{}
+	for _, threshold := range w.context.Thresholds {
+		_, isResult := index[threshold.Column]
+		if inTab[threshold.Column] || (tab == 0 && !isResult) {
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	return thresholds
+}
+
+// rollOver continues writing results in the tabs of a new part, in a new
+// spreadsheet if newSpreadsheet.
+func (w *WideSheetsSink) rollOver(newSpreadsheet bool) error {
+	w.filled = append(w.filled, w.tabs...)
+	w.part++
+	name := fmt.Sprintf("%s_part%d", w.context.RunName, w.part)
+	var defaultSheetID int64
+	if newSpreadsheet {
+		spreadsheetID, url, sheetID, err := CreateSpreadsheet(w.context.Service, "blackbox "+name)
+		if err != nil {
+			return err
+		}
+		Log.Warnf("The spreadsheet of %s is full after %d rows, continuing in %s of %s\n", w.tabs[0].finalName, w.partRows, name, url)
+		w.spreadsheetID = spreadsheetID
+		defaultSheetID = sheetID
+	} else {
+		Log.Infof("%s holds %d rows, continuing in %s\n", w.tabs[0].finalName, w.partRows, name)
+	}
+	primary, err := NewSheetsSink(w.context.Service, w.spreadsheetID, name)
+	if err != nil {
+		return err
+	}
+	if newSpreadsheet {
+		if err := DeleteSheet(w.context.Service, w.spreadsheetID, defaultSheetID); err != nil {
+			return fmt.Errorf("Unable to remove the default tab of the spreadsheet of %s: %v", name, err)
+		}
+	}
+	primary.charts = w.tabs[0].charts
+	primary.pivots = w.tabs[0].pivots
+	primary.verifySample = w.tabs[0].verifySample
+	primary.encoder = w.tabs[0].encoder
+	w.tabs = []*SheetsSink{primary}
+	if err := w.openPart(); err != nil {
+		return err
+	}
+	if newSpreadsheet && w.cellsLeft < w.rowCells {
+		return fmt.Errorf("A row of %s takes %d cells, more than a new spreadsheet has room for", w.context.RunName, w.rowCells)
+	}
+	return nil
+}
+
+// project returns the columns of row written to a tab, after the row index.
+func (w *WideSheetsSink) project(index string, row []string, tab int) []string {
+	projected := []string{}
+	if w.indexed {
+		projected = append(projected, index)
+	}
+	for _, column := range w.columns[tab] {
+		projected = append(projected, cell(row, column))
+	}
+	return projected
+}
+
+func (w *WideSheetsSink) WriteRow(row []string) error {
+	return w.WriteRows([][]string{row})
+}
+
+func (w *WideSheetsSink) WriteRows(rows [][]string) error {
+	for len(rows) > 0 {
+		n, newSpreadsheet := w.nextWrite(len(rows))
+		if n == 0 {
+			if err := w.rollOver(newSpreadsheet); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.writeRows(rows[:n]); err != nil {
+			return err
+		}
+		w.cellsLeft -= int64(n) * w.rowCells
+		rows = rows[n:]
+	}
+	return nil
+}
+
+func (w *WideSheetsSink) writeRows(rows [][]string) error {
+	for i, tab := range w.tabs {
+		projected := rows
+		if w.indexed {
+			projected = [][]string{}
+			for j, row := range rows {
+				projected = append(projected, w.project(strconv.Itoa(w.rows+j+1), row, i))
+			}
+		}
+		if err := tab.WriteRows(projected); err != nil {
+			return err
+		}
+	}
+	w.rows += len(rows)
+	w.partRows += len(rows)
+	return nil
+}
+
+func (w *WideSheetsSink) Close() error {
+	for _, tab := range append(w.filled, w.tabs...) {
+		if err := tab.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WideSheetsSink) Finalize() error {
+	for _, tab := range append(w.filled, w.tabs...) {
+		if err := tab.Finalize(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package blackbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitColumns(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []string
+		varNames []string
+		perTab   int
+		want     [][]int
+	}{
+		{
+			name:     "fits in one tab",
+			header:   []string{"size", "latency_ms", "error"},
+			varNames: []string{"size"},
+			perTab:   5,
+			want:     [][]int{{0, 2, 1}},
+		},
+		{
+			name:     "outputs spill into tabs of perTab columns",
+			header:   []string{"size", "rate", "o1", "o2", "o3", "o4", "o5"},
+			varNames: []string{"size", "rate"},
+			perTab:   3,
+			want:     [][]int{{0, 1, 2}, {3, 4, 5}, {6}},
+		},
+		{
+			name:     "inputs and bookkeeping columns stay in the first tab",
+			header:   []string{"o1", "size", "o2", "error", "workdir", "artifacts", "assertions", "o3"},
+			varNames: []string{"size"},
+			perTab:   2,
+			want:     [][]int{{1, 3, 4, 5, 6}, {0, 2}, {7}},
+		},
+		{
+			name:     "no outputs",
+			header:   []string{"size", "rate"},
+			varNames: []string{"size", "rate"},
+			perTab:   1,
+			want:     [][]int{{0, 1}},
+		},
+	}
+	for _, test := range tests {
+		got := SplitColumns(test.header, test.varNames, test.perTab)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: SplitColumns = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWideSheetsSinkRollOver(t *testing.T) {
+	// A new tab with a header row takes 1001 rows of 26 columns
+	const tabCells = 1001 * newSheetColumns
+	tests := []struct {
+		name    string
+		maxRows int
+		// cells used before the run, by the inputs tab and the result tab
+		used  int64
+		rows  int
+		batch int
+		// rows of each part, and whether it starts a new spreadsheet
+		wantParts []int
+		wantNew   []bool
+	}{
+		{
+			name:      "default config reaching the cell limit",
+			used:      26000 + tabCells,
+			rows:      1000000,
+			batch:     1000,
+			wantParts: []int{382614, 383614, 233772},
+			wantNew:   []bool{false, true, true},
+		},
+		{
+			name:      "fits in the spreadsheet",
+			used:      26000 + tabCells,
+			rows:      10000,
+			batch:     7,
+			wantParts: []int{10000},
+			wantNew:   []bool{false},
+		},
+		{
+			name:      "max rows, then the cell limit",
+			maxRows:   300000,
+			used:      26000 + tabCells,
+			rows:      700000,
+			batch:     1000,
+			wantParts: []int{300000, 81613, 300000, 18387},
+			wantNew:   []bool{false, false, true, false},
+		},
+		{
+			name:      "full spreadsheet",
+			used:      sheetsCellLimit - 10,
+			rows:      5,
+			batch:     1,
+			wantParts: []int{0, 5},
+			wantNew:   []bool{false, true},
+		},
+	}
+	for _, test := range tests {
+		w := &WideSheetsSink{
+			context:   &SinkContext{MaxRows: test.maxRows},
+			cellsLeft: sheetsCellLimit - test.used,
+			rowCells:  newSheetColumns,
+		}
+		parts, news := []int{0}, []bool{false}
+		for left := test.rows; left > 0; {
+			batch := test.batch
+			if batch > left {
+				batch = left
+			}
+			for batch > 0 {
+				n, newSpreadsheet := w.nextWrite(batch)
+				if n == 0 {
+					// As rollOver and openPart do, with a new tab
+					if newSpreadsheet {
+						w.cellsLeft = sheetsCellLimit
+					}
+					w.cellsLeft -= tabCells
+					w.partRows = 0
+					parts, news = append(parts, 0), append(news, newSpreadsheet)
+					continue
+				}
+				w.partRows += n
+				w.cellsLeft -= int64(n) * w.rowCells
+				parts[len(parts)-1] += n
+				batch -= n
+				left -= n
+			}
+		}
+		if !reflect.DeepEqual(parts, test.wantParts) || !reflect.DeepEqual(news, test.wantNew) {
+			t.Errorf("%s: parts %v new spreadsheets %v, want %v %v", test.name, parts, news, test.wantParts, test.wantNew)
+		}
+	}
+}
+
+func TestWideSheetsSinkTabThresholds(t *testing.T) {
+	latency := Threshold{Column: "latency_ms", Level: "warn", Condition: "NUMBER_GREATER", Value: 200}
+	failures := Threshold{Column: "errors", Level: "crit", Condition: "NUMBER_GREATER", Value: 0}
+	missing := Threshold{Column: "missing", Level: "warn", Condition: "NUMBER_GREATER", Value: 1}
+	w := &WideSheetsSink{
+		context: &SinkContext{Thresholds: []Threshold{latency, failures, missing}},
+		header:  []string{"size", "latency_ms", "errors"},
+		columns: [][]int{{0, 1}, {2}},
+		indexed: true,
+	}
+	want := [][]Threshold{{latency, missing}, {failures}}
+	for tab := range w.columns {
+		if got := w.tabThresholds(tab); !reflect.DeepEqual(got, want[tab]) {
+			t.Errorf("tabThresholds(%d) = %v, want %v", tab, got, want[tab])
+		}
+	}
+}
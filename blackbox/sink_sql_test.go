@@ -0,0 +1,56 @@
+package blackbox
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestSQLColumns(t *testing.T) {
+	tests := []struct {
+		varNames []string
+		want     []string
+		wantErr  bool
+	}{
+		{varNames: []string{"size", "rate"}, want: []string{"size", "rate"}},
+		{varNames: []string{"run_id", "outputs", "Recorded_At"}, want: []string{"input_run_id", "input_outputs", "input_Recorded_At"}},
+		{varNames: []string{}, want: []string{}},
+		{varNames: []string{"run_id", "input_run_id"}, wantErr: true},
+		{varNames: []string{"Size", "size"}, wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := sqlColumns(test.varNames)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("sqlColumns(%q) = %q, want an error", test.varNames, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sqlColumns(%q) = %q, %v, want %q", test.varNames, got, err, test.want)
+		}
+	}
+}
+
+func TestMySQLDSN(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "mysql://user:secret@db:3306/lab", want: "user:secret@tcp(db:3306)/lab"},
+		{url: "mysql://user@db/lab", want: "user@tcp(db)/lab"},
+		{url: "mysql://user:@db/lab", want: "user:@tcp(db)/lab"},
+		{url: "mysql://db:3306/lab", want: "tcp(db:3306)/lab"},
+		{url: "mysql://us%40er:p%40ss%3Aword@db/lab", want: "us@er:p@ss:word@tcp(db)/lab"},
+		{url: "mysql://user:secret@db/lab?parseTime=true&tls=skip-verify", want: "user:secret@tcp(db)/lab?parseTime=true&tls=skip-verify"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatalf("url.Parse(%q) failed: %v", test.url, err)
+		}
+		if got := mysqlDSN(u); got != test.want {
+			t.Errorf("mysqlDSN(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package blackbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInferColumnKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns int
+		rows    [][]string
+		want    []ValueKind
+	}{
+		{
+			name:    "one kind per column",
+			columns: 4,
+			rows:    [][]string{{"1", "1.5", "true", "fast"}, {"2", "2.5", "false", "slow"}},
+			want:    []ValueKind{KindInt, KindFloat, KindBool, KindString},
+		},
+		{
+			name:    "ints widened to floats",
+			columns: 2,
+			rows:    [][]string{{"1", "0.5"}, {"2.5", "3"}},
+			want:    []ValueKind{KindFloat, KindFloat},
+		},
+		{
+			name:    "mixed kinds",
+			columns: 3,
+			rows:    [][]string{{"1", "true", "1.5"}, {"n/a", "1", "false"}},
+			want:    []ValueKind{KindString, KindString, KindString},
+		},
+		{
+			name:    "empty and missing values skipped",
+			columns: 3,
+			rows:    [][]string{{"", "true"}, {"7"}, {"8", "", ""}},
+			want:    []ValueKind{KindInt, KindBool, KindString},
+		},
+		{
+			name:    "no rows",
+			columns: 2,
+			want:    []ValueKind{KindString, KindString},
+		},
+	}
+	for _, test := range tests {
+		got := InferColumnKinds(TypedEncoder{}, test.columns, test.rows)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: InferColumnKinds = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package blackbox
+
+import (
+	"fmt"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Source provides the rows of the experiment definition tabs, e.g. "inputs".
+type Source interface {
+	ReadRows(sheetName string) ([][]string, error)
+	SheetNames() ([]string, error)
+}
+
+// SheetsSource reads tabs of a Google spreadsheet.
+type SheetsSource struct {
+	srv           *sheets.Service
+	spreadsheetID string
+}
+
+func (s *SheetsSource) ReadRows(sheetName string) ([][]string, error) {
+	return ReadSheetRows(s.srv, s.spreadsheetID, sheetName)
+}
+
+func (s *SheetsSource) SheetNames() ([]string, error) {
+	resp, err := s.srv.Spreadsheets.Get(s.spreadsheetID).Fields("sheets.properties.title").Do()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list sheets: %v", err)
+	}
+	names := []string{}
+	for _, sheet := range resp.Sheets {
+		names = append(names, sheet.Properties.Title)
+	}
+	return names, nil
+}
+
+// ReadOptionalRows reads a tab that experiments may leave out, returning no
+// rows if the tab does not exist.
+func ReadOptionalRows(source Source, sheetName string) ([][]string, error) {
+	names, err := source.SheetNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if name == sheetName {
+			return source.ReadRows(sheetName)
+		}
+	}
+	return nil, nil
+}
+
+// IsXlsxPath reports whether a spreadsheet argument names a local Excel
+// file rather than a Google spreadsheet ID.
+func IsXlsxPath(spreadsheet string) bool {
+	return strings.HasSuffix(strings.ToLower(spreadsheet), ".xlsx")
+}
+
+// IsGoogleSpreadsheet reports whether a spreadsheet argument is the ID of
+// a Google spreadsheet, rather than an Excel file or an Airtable base.
+func IsGoogleSpreadsheet(spreadsheet string) bool {
+	return !IsXlsxPath(spreadsheet) && !IsAirtableBase(spreadsheet)
+}
+
+// OpenSource returns the source for a spreadsheet argument: a local .xlsx
+// file, an Airtable base or the ID of a Google spreadsheet.
+func OpenSource(srv *sheets.Service, spreadsheet string) (Source, error) {
+	if IsXlsxPath(spreadsheet) {
+		return &XlsxSource{path: spreadsheet}, nil
+	}
+	if IsAirtableBase(spreadsheet) {
+		return NewAirtableSource(strings.TrimPrefix(spreadsheet, airtablePrefix))
+	}
+	if srv == nil {
+		return nil, fmt.Errorf("Not authenticated to read spreadsheet %s", spreadsheet)
+	}
+	return &SheetsSource{srv: srv, spreadsheetID: spreadsheet}, nil
+}
+
+// DefaultOutput is where results go when no output is given: next to the
+// inputs, in the same spreadsheet, Excel file or Airtable base.
+func DefaultOutput(spreadsheet string) string {
+	if IsXlsxPath(spreadsheet) {
+		return "xlsx:" + spreadsheet
+	}
+	if IsAirtableBase(spreadsheet) {
+		return spreadsheet
+	}
+	if NoWriteSheet {
+		return "csv"
+	}
+	return "sheets"
+}
+
+// NeedsSheetsService reports whether reading from spreadsheet or writing to
+// outputs requires authenticating with Google.
+func NeedsSheetsService(spreadsheet string, outputs []string) bool {
+	if IsGoogleSpreadsheet(spreadsheet) {
+		return true
+	}
+	for _, output := range outputs {
+		if output == "sheets" || strings.HasPrefix(output, "sheets:") {
+			return true
+		}
+	}
+	return false
+}
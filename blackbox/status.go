@@ -0,0 +1,197 @@
+package blackbox
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors kept per run for the status page.
+const statusRecentErrors = 10
+
+// RunStatus is the progress of a run shown by the status endpoint. A nil
+// *RunStatus tracks nothing, when no status endpoint is served.
+type RunStatus struct {
+	mu        sync.Mutex
+	RunID     string        `json:"run_id"`
+	Run       string        `json:"run"`
+	Start     time.Time     `json:"start"`
+	Total     int           `json:"total"`
+	Completed int           `json:"completed"`
+	Failures  int           `json:"failures"`
+	Running   []string      `json:"running"`
+	ETA       string        `json:"eta,omitempty"`
+	Errors    []StatusError `json:"recent_errors"`
+	Done      bool          `json:"done"`
+	running   map[string]int
+}
+
+// StatusError is a failed run of the status page.
+type StatusError struct {
+	Time  time.Time `json:"time"`
+	Input string    `json:"input"`
+	Error string    `json:"error"`
+}
+
+// inputLabel describes an input set, e.g. "size=10 mode=fast".
+func inputLabel(varNames, inputSet []string) string {
+	parts := make([]string, len(inputSet))
+	for i, value := range inputSet {
+		parts[i] = varNames[i] + "=" + value
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *RunStatus) SetTotal(total int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Total = total
+}
+
+// Started marks an input set as running.
+func (s *RunStatus) Started(varNames, inputSet []string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running[inputLabel(varNames, inputSet)]++
+}
+
+// Finished records the outcome of a run of an input set.
+func (s *RunStatus) Finished(varNames, inputSet []string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	label := inputLabel(varNames, inputSet)
+	if s.running[label]--; s.running[label] <= 0 {
+		delete(s.running, label)
+	}
+	s.Completed++
+	if err != nil {
+		s.Failures++
+		s.Errors = append(s.Errors, StatusError{Time: time.Now(), Input: label, Error: err.Error()})
+		if len(s.Errors) > statusRecentErrors {
+			s.Errors = s.Errors[len(s.Errors)-statusRecentErrors:]
+		}
+	}
+}
+
+// Finish marks the run done.
+func (s *RunStatus) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Done = true
+	s.running = map[string]int{}
+}
+
+// snapshot returns a copy of the status with its running input sets and
+// estimated time left filled in.
+func (s *RunStatus) snapshot() *RunStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := &RunStatus{RunID: s.RunID, Run: s.Run, Start: s.Start, Total: s.Total, Completed: s.Completed,
+		Failures: s.Failures, Errors: append([]StatusError{}, s.Errors...), Done: s.Done, Running: []string{}}
+	for label := range s.running {
+		snapshot.Running = append(snapshot.Running, label)
+	}
+	sort.Strings(snapshot.Running)
+	if !s.Done && s.Completed > 0 && s.Total > s.Completed {
+		elapsed := time.Since(s.Start)
+		snapshot.ETA = (elapsed / time.Duration(s.Completed) * time.Duration(s.Total-s.Completed)).Round(time.Second).String()
+	}
+	return snapshot
+}
+
+// StatusBoard lists the runs of the process for the status endpoint.
+type StatusBoard struct {
+	mu      sync.Mutex
+	serving bool
+	runs    []*RunStatus
+}
+
+// Status lists the runs of the process, served by -status-addr.
+var Status = &StatusBoard{}
+
+// Track returns the status of a new run, nil unless the status endpoint
+// is served.
+func (b *StatusBoard) Track(runID, run string, start time.Time) *RunStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.serving {
+		return nil
+	}
+	status := &RunStatus{RunID: runID, Run: run, Start: start, running: map[string]int{}}
+	b.runs = append(b.runs, status)
+	return status
+}
+
+func (b *StatusBoard) snapshots() []*RunStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snapshots := []*RunStatus{}
+	for _, run := range b.runs {
+		snapshots = append(snapshots, run.snapshot())
+	}
+	return snapshots
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>blackbox status</title>
+<meta http-equiv="refresh" content="10">
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+.failed { color: #c00; }
+</style>
+</head>
+<body>
+{{range .}}
+<h2>{{.Run}} <small>{{.RunID}}</small></h2>
+<p>{{.Completed}} of {{.Total}} runs, <span class="failed">{{.Failures}} failed</span>,
+{{if .Done}}done{{else}}started {{.Start.Format "2006-01-02 15:04:05"}}{{if .ETA}}, about {{.ETA}} left{{end}}{{end}}</p>
+{{if .Running}}<p>Running:</p><ul>{{range .Running}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .Errors}}<p>Recent errors:</p><ul>{{range .Errors}}<li class="failed">{{.Time.Format "15:04:05"}} {{.Input}}: {{.Error}}</li>{{end}}</ul>{{end}}
+{{else}}
+<p>No run yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// ServeStatus serves the status of the runs on addr, as an HTML page on /
+// and as JSON on /status.json, for checking a long run from elsewhere.
+func (b *StatusBoard) ServeStatus(addr string) {
+	b.mu.Lock()
+	b.serving = true
+	b.mu.Unlock()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b.snapshots())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if err := statusTemplate.Execute(w, b.snapshots()); err != nil {
+			Log.Errorf("Unable to render the status page: %v", err)
+		}
+	})
+	go func() {
+		Log.Infof("Serving the run status on http://%s/", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			Log.Errorf("Unable to serve the run status: %v", err)
+		}
+	}()
+}
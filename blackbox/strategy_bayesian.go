@@ -0,0 +1,291 @@
+package blackbox
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Tuning of BayesianOptimization: random points evaluated before modelling,
+// points scored by the acquisition function per step, the kernel length
+// scale over normalized coordinates, the observation noise and the
+// exploration margin of expected improvement.
+const (
+	bayesianInitialPoints = 5
+	bayesianCandidates    = 1000
+	bayesianLengthScale   = 0.25
+	bayesianNoise         = 1e-4
+	bayesianExploration   = 0.01
+)
+
+// BayesianOptimization models the objective over the input space with a
+// Gaussian process and evaluates the points of highest expected
+// improvement, which needs far fewer runs than a sweep or a random search
+// when each run is expensive.
+type BayesianOptimization struct {
+	space SearchSpace
+	rng   *rand.Rand
+	// coordinate range of each variable, for normalization
+	low, high []float64
+	tried     map[string]bool
+	points    [][]float64
+	scores    []float64
+}
+
+func NewBayesianOptimization(space SearchSpace, params StrategyParams, rng *rand.Rand) (Strategy, error) {
+	b := &BayesianOptimization{space: space, rng: rng, tried: map[string]bool{}}
+	for i, values := range space.Values {
+		low, high := math.Inf(1), math.Inf(-1)
+		for j := range values {
+			point := make(Point, len(space.Values))
+			point[i] = j
+			coordinate := space.Coordinates(point)[i]
+			low, high = math.Min(low, coordinate), math.Max(high, coordinate)
+		}
+		b.low, b.high = append(b.low, low), append(b.high, high)
+	}
+	return b, nil
+}
+
+func (b *BayesianOptimization) normalize(point Point) []float64 {
+	coordinates := b.space.Coordinates(point)
+	for i := range coordinates {
+		if b.high[i] > b.low[i] {
+			coordinates[i] = (coordinates[i] - b.low[i]) / (b.high[i] - b.low[i])
+		} else {
+			coordinates[i] = 0
+		}
+	}
+	return coordinates
+}
+
+// candidates returns untried points: all of them in small spaces, a random
+// sample otherwise.
+func (b *BayesianOptimization) candidates() []Point {
+	candidates := []Point{}
+	if size := b.space.Size(); size <= bayesianCandidates {
+		for i := 0; i < size; i++ {
+			point := make(Point, len(b.space.Values))
+			rest := i
+			for j := len(point) - 1; j >= 0; j-- {
+				point[j] = rest % len(b.space.Values[j])
+				rest /= len(b.space.Values[j])
+			}
+			if !b.tried[point.key()] {
+				candidates = append(candidates, point)
+			}
+		}
+		return candidates
+	}
+	seen := map[string]bool{}
+	for i := 0; i < bayesianCandidates; i++ {
+		point := b.space.Random(b.rng)
+		if key := point.key(); !b.tried[key] && !seen[key] {
+			seen[key] = true
+			candidates = append(candidates, point)
+		}
+	}
+	return candidates
+}
+
+func (b *BayesianOptimization) Next(batchSize int) []Point {
+	candidates := b.candidates()
+	if len(candidates) == 0 {
+		return nil
+	}
+	if len(b.points) < bayesianInitialPoints {
+		b.rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+		return b.propose(candidates, batchSize)
+	}
+
+	model, ok := fitGaussianProcess(b.points, b.targets())
+	if !ok {
+		b.rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+		return b.propose(candidates, batchSize)
+	}
+	best := math.Inf(1)
+	for _, y := range model.y {
+		best = math.Min(best, y)
+	}
+	improvements := make([]float64, len(candidates))
+	for i, candidate := range candidates {
+		mean, variance := model.predict(b.normalize(candidate))
+		improvements[i] = expectedImprovement(mean, math.Sqrt(variance), best)
+	}
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return improvements[order[i]] > improvements[order[j]] })
+	sorted := make([]Point, len(candidates))
+	for i, index := range order {
+		sorted[i] = candidates[index]
+	}
+	return b.propose(sorted, batchSize)
+}
+
+func (b *BayesianOptimization) propose(candidates []Point, batchSize int) []Point {
+	if len(candidates) > batchSize {
+		candidates = candidates[:batchSize]
+	}
+	for _, candidate := range candidates {
+		b.tried[candidate.key()] = true
+	}
+	return candidates
+}
+
+func (b *BayesianOptimization) Observe(point Point, score float64) {
+	b.tried[point.key()] = true
+	b.points = append(b.points, b.normalize(point))
+	b.scores = append(b.scores, score)
+}
+
+// targets returns the standardized scores, failed runs counting as a bit
+// worse than the worst successful one.
+func (b *BayesianOptimization) targets() []float64 {
+	worst, sum, count := math.Inf(-1), 0.0, 0
+	for _, score := range b.scores {
+		if !math.IsInf(score, 0) {
+			worst = math.Max(worst, score)
+			sum += score
+			count++
+		}
+	}
+	if count == 0 {
+		return make([]float64, len(b.scores))
+	}
+	mean := sum / float64(count)
+	deviation := 0.0
+	for _, score := range b.scores {
+		if !math.IsInf(score, 0) {
+			deviation += (score - mean) * (score - mean)
+		}
+	}
+	deviation = math.Sqrt(deviation / float64(count))
+	if deviation == 0 {
+		deviation = 1
+	}
+	targets := make([]float64, len(b.scores))
+	for i, score := range b.scores {
+		if math.IsInf(score, 0) {
+			score = worst + deviation
+		}
+		targets[i] = (score - mean) / deviation
+	}
+	return targets
+}
+
+// gaussianProcess is a Gaussian process regression with a squared
+// exponential kernel.
+type gaussianProcess struct {
+	x [][]float64
+	y []float64
+	// Cholesky factor of the kernel matrix and the kernel matrix inverse
+	// applied to y
+	chol  [][]float64
+	alpha []float64
+}
+
+func kernel(a, b []float64) float64 {
+	distance := 0.0
+	for i := range a {
+		distance += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return math.Exp(-distance / (2 * bayesianLengthScale * bayesianLengthScale))
+}
+
+func fitGaussianProcess(x [][]float64, y []float64) (*gaussianProcess, bool) {
+	n := len(x)
+	k := make([][]float64, n)
+	for i := range x {
+		k[i] = make([]float64, n)
+		for j := range x {
+			k[i][j] = kernel(x[i], x[j])
+		}
+		k[i][i] += bayesianNoise
+	}
+	chol, ok := cholesky(k)
+	if !ok {
+		return nil, false
+	}
+	return &gaussianProcess{x: x, y: y, chol: chol, alpha: choleskySolve(chol, y)}, true
+}
+
+// predict returns the posterior mean and variance at a point.
+func (g *gaussianProcess) predict(point []float64) (float64, float64) {
+	k := make([]float64, len(g.x))
+	mean := 0.0
+	for i := range g.x {
+		k[i] = kernel(point, g.x[i])
+		mean += k[i] * g.alpha[i]
+	}
+	v := forwardSubstitution(g.chol, k)
+	variance := 1.0
+	for _, vi := range v {
+		variance -= vi * vi
+	}
+	return mean, math.Max(variance, 1e-12)
+}
+
+// expectedImprovement of a prediction below the best target so far.
+func expectedImprovement(mean, deviation, best float64) float64 {
+	improvement := best - mean - bayesianExploration
+	z := improvement / deviation
+	cdf := 0.5 * math.Erfc(-z/math.Sqrt2)
+	pdf := math.Exp(-z*z/2) / math.Sqrt(2*math.Pi)
+	return improvement*cdf + deviation*pdf
+}
+
+// cholesky returns the lower triangular L with L·Lᵀ = a, false if a is not
+// positive definite.
+func cholesky(a [][]float64) ([][]float64, bool) {
+	n := len(a)
+	l := make([][]float64, n)
+	for i := range l {
+		l[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i; j++ {
+			sum := a[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if sum <= 0 {
+					return nil, false
+				}
+				l[i][i] = math.Sqrt(sum)
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l, true
+}
+
+// forwardSubstitution solves L·x = b.
+func forwardSubstitution(l [][]float64, b []float64) []float64 {
+	x := make([]float64, len(b))
+	for i := range b {
+		sum := b[i]
+		for k := 0; k < i; k++ {
+			sum -= l[i][k] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
+
+// choleskySolve solves L·Lᵀ·x = b.
+func choleskySolve(l [][]float64, b []float64) []float64 {
+	y := forwardSubstitution(l, b)
+	x := make([]float64, len(y))
+	for i := len(y) - 1; i >= 0; i-- {
+		sum := y[i]
+		for k := i + 1; k < len(y); k++ {
+			sum -= l[k][i] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
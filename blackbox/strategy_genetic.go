@@ -0,0 +1,139 @@
+package blackbox
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Defaults and tuning of GeneticAlgorithm: best members kept as they are
+// in the next generation and candidates per tournament selection.
+const (
+	DefaultPopulation  = 20
+	DefaultGenerations = 10
+	geneticElites      = 2
+	geneticTournament  = 3
+)
+
+// GeneticAlgorithm evolves a population of input sets toward the
+// objective: every generation keeps its best members and breeds the rest
+// from members picked by tournament, crossing over their variables and
+// mutating a few of them.
+type GeneticAlgorithm struct {
+	space       SearchSpace
+	rng         *rand.Rand
+	population  int
+	generations int
+	generation  int
+	members     []Point
+	scores      map[string]float64
+}
+
+func NewGeneticAlgorithm(space SearchSpace, params StrategyParams, rng *rand.Rand) (Strategy, error) {
+	g := &GeneticAlgorithm{
+		space:       space,
+		rng:         rng,
+		population:  params.Population,
+		generations: params.Generations,
+		scores:      map[string]float64{},
+	}
+	if g.population < 2 {
+		g.population = DefaultPopulation
+	}
+	if g.generations < 1 {
+		g.generations = DefaultGenerations
+	}
+	return g, nil
+}
+
+// DefaultBudget allows a run for every member of every generation.
+func (g *GeneticAlgorithm) DefaultBudget() int {
+	return g.population * g.generations
+}
+
+func (g *GeneticAlgorithm) Next(batchSize int) []Point {
+	if g.generation >= g.generations {
+		return nil
+	}
+	if g.generation == 0 {
+		seen := map[string]bool{}
+		for attempt := 0; len(g.members) < g.population && attempt < 10*g.population; attempt++ {
+			point := g.space.Random(g.rng)
+			if !seen[point.key()] {
+				seen[point.key()] = true
+				g.members = append(g.members, point)
+			}
+		}
+	} else {
+		g.members = g.breed()
+	}
+	g.generation++
+	return g.members
+}
+
+func (g *GeneticAlgorithm) score(point Point) float64 {
+	if score, ok := g.scores[point.key()]; ok {
+		return score
+	}
+	return math.Inf(1)
+}
+
+// breed returns the next generation.
+func (g *GeneticAlgorithm) breed() []Point {
+	ranked := append([]Point{}, g.members...)
+	sort.SliceStable(ranked, func(i, j int) bool { return g.score(ranked[i]) < g.score(ranked[j]) })
+	next := []Point{}
+	for i := 0; i < geneticElites && i < len(ranked); i++ {
+		next = append(next, ranked[i])
+	}
+	for len(next) < g.population {
+		child := g.crossover(g.tournament(), g.tournament())
+		g.mutate(child)
+		next = append(next, child)
+	}
+	return next
+}
+
+// tournament picks the best of a few random members.
+func (g *GeneticAlgorithm) tournament() Point {
+	best := g.members[g.rng.Intn(len(g.members))]
+	for i := 1; i < geneticTournament; i++ {
+		candidate := g.members[g.rng.Intn(len(g.members))]
+		if g.score(candidate) < g.score(best) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// crossover takes each variable from either parent.
+func (g *GeneticAlgorithm) crossover(a, b Point) Point {
+	child := make(Point, len(a))
+	for i := range child {
+		child[i] = a[i]
+		if g.rng.Intn(2) == 0 {
+			child[i] = b[i]
+		}
+	}
+	return child
+}
+
+// mutate changes one variable on average, either to a neighboring value
+// or to any value.
+func (g *GeneticAlgorithm) mutate(point Point) {
+	for i := range point {
+		if g.rng.Float64() >= 1/float64(len(point)) {
+			continue
+		}
+		values := len(g.space.Values[i])
+		if g.rng.Intn(2) == 0 {
+			point[i] = g.rng.Intn(values)
+		} else if step := point[i] + 2*g.rng.Intn(2) - 1; step >= 0 && step < values {
+			point[i] = step
+		}
+	}
+}
+
+func (g *GeneticAlgorithm) Observe(point Point, score float64) {
+	g.scores[point.key()] = score
+}
@@ -0,0 +1,127 @@
+package blackbox
+
+import (
+	"math"
+	"math/rand"
+)
+
+// HillClimbing moves from a random input set to its best neighbor, one
+// step along a single variable, as long as that improves the objective,
+// and restarts from another random input set once no neighbor does.
+type HillClimbing struct {
+	space        SearchSpace
+	rng          *rand.Rand
+	current      Point
+	currentScore float64
+	// whether the neighbors of current were proposed
+	climbing          bool
+	bestNeighbor      Point
+	bestNeighborScore float64
+}
+
+func NewHillClimbing(space SearchSpace, params StrategyParams, rng *rand.Rand) (Strategy, error) {
+	return &HillClimbing{space: space, rng: rng}, nil
+}
+
+func (h *HillClimbing) restart() []Point {
+	h.current = h.space.Random(h.rng)
+	h.currentScore = math.Inf(1)
+	h.climbing = false
+	return []Point{h.current}
+}
+
+func (h *HillClimbing) Next(batchSize int) []Point {
+	if h.current == nil {
+		return h.restart()
+	}
+	if h.climbing {
+		if h.bestNeighborScore >= h.currentScore {
+			return h.restart()
+		}
+		h.current, h.currentScore = h.bestNeighbor, h.bestNeighborScore
+	}
+	h.climbing = true
+	h.bestNeighbor, h.bestNeighborScore = nil, math.Inf(1)
+	return h.space.Neighbors(h.current)
+}
+
+func (h *HillClimbing) Observe(point Point, score float64) {
+	if !h.climbing {
+		h.currentScore = score
+	} else if score < h.bestNeighborScore {
+		h.bestNeighbor, h.bestNeighborScore = point, score
+	}
+}
+
+// Starting temperature and cooling rate per step of SimulatedAnnealing.
+const (
+	annealingTemperature = 1.0
+	annealingCooling     = 0.9
+)
+
+// SimulatedAnnealing walks through random neighbors of the current input
+// set, always moving to better ones and to worse ones with a probability
+// shrinking as the temperature cools down, to escape local optima early
+// in the search.
+type SimulatedAnnealing struct {
+	space          SearchSpace
+	rng            *rand.Rand
+	temperature    float64
+	current        Point
+	currentScore   float64
+	started        bool
+	candidate      Point
+	candidateScore float64
+}
+
+func NewSimulatedAnnealing(space SearchSpace, params StrategyParams, rng *rand.Rand) (Strategy, error) {
+	return &SimulatedAnnealing{space: space, rng: rng, temperature: annealingTemperature}, nil
+}
+
+func (a *SimulatedAnnealing) Next(batchSize int) []Point {
+	if a.current == nil {
+		a.current = a.space.Random(a.rng)
+		a.currentScore = math.Inf(1)
+		return []Point{a.current}
+	}
+	if a.candidate != nil {
+		if a.accept(a.candidateScore) {
+			a.current, a.currentScore = a.candidate, a.candidateScore
+		}
+		a.temperature *= annealingCooling
+	}
+	a.started = true
+	a.candidate, a.candidateScore = nil, math.Inf(1)
+
+	// Try batchSize random neighbors at once and keep the best
+	neighbors := a.space.Neighbors(a.current)
+	a.rng.Shuffle(len(neighbors), func(i, j int) { neighbors[i], neighbors[j] = neighbors[j], neighbors[i] })
+	if len(neighbors) > batchSize {
+		neighbors = neighbors[:batchSize]
+	}
+	return neighbors
+}
+
+// accept decides whether to move to a candidate, comparing scores
+// relative to the current one so the temperature does not depend on the
+// objective's unit.
+func (a *SimulatedAnnealing) accept(score float64) bool {
+	if score <= a.currentScore || math.IsInf(a.currentScore, 1) {
+		return true
+	}
+	if math.IsInf(score, 1) {
+		return false
+	}
+	delta := (score - a.currentScore) / math.Max(math.Abs(a.currentScore), 1e-9)
+	return a.rng.Float64() < math.Exp(-delta/a.temperature)
+}
+
+func (a *SimulatedAnnealing) Observe(point Point, score float64) {
+	if !a.started {
+		a.currentScore = score
+		return
+	}
+	if a.candidate == nil || score < a.candidateScore {
+		a.candidate, a.candidateScore = point, score
+	}
+}
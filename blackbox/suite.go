@@ -0,0 +1,96 @@
+package blackbox
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Tabs defining the experiments of a suite start with this prefix, e.g.
+// inputs_small and inputs_large.
+const suiteInputsPrefix = "inputs_"
+
+// SuiteCommand implements "blackbox suite": it runs the experiment of
+// every inputs_NAME tab of a spreadsheet, in tab order or several at once,
+// and writes an index tab linking to their result tabs with their status
+// and duration.
+func SuiteCommand(args []string) error {
+	flags := flag.NewFlagSet("suite", flag.ExitOnError)
+	parallel := flags.Int("parallel", 1, "number of experiments to run at once")
+	var outputs ListFlags
+	flags.Var(&outputs, "output", "where to record results, as for a sweep (repeatable, default next to the inputs)")
+	timeout := flags.Duration("timeout", 0, "kill the program if a single run takes longer than this (0 for no limit)")
+	concurrency := flags.Int("concurrency", 1, "number of program invocations to run in parallel in each experiment")
+	keepGoing := flags.Bool("keep-going", false, "record failed runs in an error column instead of stopping")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox suite [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return fmt.Errorf("spreadsheet or progpath param is missing")
+	}
+	spreadsheet := SpreadsheetID(flags.Arg(0))
+
+	var srv *sheets.Service
+	if NeedsSheetsService(spreadsheet, outputs) {
+		var err error
+		if srv, err = Auth(); err != nil {
+			return err
+		}
+	}
+	if WritesToSpreadsheet(spreadsheet, outputs) {
+		if err := CheckEditAccess(srv, spreadsheet); err != nil {
+			return err
+		}
+	}
+	source, err := OpenSource(srv, spreadsheet)
+	if err != nil {
+		return err
+	}
+	names, err := source.SheetNames()
+	if err != nil {
+		return err
+	}
+	campaign := &Campaign{Parallel: *parallel > 1, MaxParallel: *parallel, summaryPrefix: "suite"}
+	for _, name := range names {
+		if !strings.HasPrefix(name, suiteInputsPrefix) {
+			continue
+		}
+		experiment := Experiment{
+			Name:        strings.TrimPrefix(name, suiteInputsPrefix),
+			Program:     flags.Arg(1),
+			Inputs:      name,
+			Outputs:     outputs,
+			Concurrency: *concurrency,
+			KeepGoing:   *keepGoing,
+		}
+		if *timeout > 0 {
+			experiment.Timeout = timeout.String()
+		}
+		campaign.Runs = append(campaign.Runs, experiment)
+	}
+	if len(campaign.Runs) == 0 {
+		return fmt.Errorf("No %s* tab in %s", suiteInputsPrefix, spreadsheet)
+	}
+	Log.Infof("Suite of %d experiments\n", len(campaign.Runs))
+	results, err := RunCampaign(srv, spreadsheet, campaign)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			Log.Errorf("Experiment %s failed: %v\n", result.Experiment.Name, result.Err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d experiments failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package blackbox
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var summaryHeader = []string{"input", "value", "output", "runs", "min", "max", "mean", "median", "p95"}
+
+// SummarySink keeps the results of a run to write, once the run completed,
+// a summary tab with statistics of every numeric output grouped by the
+// values of each input variable.
+type SummarySink struct {
+	mu      sync.Mutex
+	context *SinkContext
+	header  []string
+	rows    [][]string
+}
+
+func NewSummarySink(context *SinkContext) *SummarySink {
+	return &SummarySink{context: context}
+}
+
+func (s *SummarySink) WriteHeader(header []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = header
+	return nil
+}
+
+func (s *SummarySink) WriteRow(row []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rows = append(s.rows, row)
+	return nil
+}
+
+func (s *SummarySink) Close() error {
+	return nil
+}
+
+// SheetName returns the name of the summary tab.
+func (s *SummarySink) SheetName() string {
+	return relatedSheetName("summary", s.context.RunName)
+}
+
+// Finalize writes the summary tab next to the inputs.
+func (s *SummarySink) Finalize() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := SummarizeResults(s.header, s.rows, s.context.VarNames)
+	if len(summary) < 2 {
+		return nil
+	}
+	if err := WriteRows(s.context.Service, s.context.SpreadsheetID, s.SheetName(), summary); err != nil {
+		return err
+	}
+	Log.Infof("Wrote %s\n", s.SheetName())
+	return nil
+}
+
+// Quantile returns the q quantile of sorted values, interpolating between
+// the closest ranks.
+func Quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
+
+// SummarizeResults computes, for every input variable and each of its
+// values, the runs, min, max, mean, median and p95 of every numeric output.
+// It returns the summary rows, header first.
+func SummarizeResults(header []string, rows [][]string, varNames []string) [][]string {
+	isInput := map[string]bool{}
+	for _, varName := range varNames {
+		isInput[varName] = true
+	}
+	index := columnIndex(header)
+	outputs := []string{}
+	for column, name := range header {
+		if isInput[name] || isBookkeepingColumn(name) {
+			continue
+		}
+		for _, row := range rows {
+			if _, err := strconv.ParseFloat(cell(row, column), 64); err == nil {
+				outputs = append(outputs, name)
+				break
+			}
+		}
+	}
+
+	summary := [][]string{summaryHeader}
+	for _, varName := range varNames {
+		inputColumn, ok := index[varName]
+		if !ok || IsMetaVar(varName) {
+			continue
+		}
+		// Group values in order of first appearance
+		values := []string{}
+		groups := map[string][][]string{}
+		for _, row := range rows {
+			value := cell(row, inputColumn)
+			if _, ok := groups[value]; !ok {
+				values = append(values, value)
+			}
+			groups[value] = append(groups[value], row)
+		}
+		for _, value := range values {
+			for _, output := range outputs {
+				numbers := []float64{}
+				sum := 0.0
+				for _, row := range groups[value] {
+					if number, err := strconv.ParseFloat(cell(row, index[output]), 64); err == nil {
+						numbers = append(numbers, number)
+						sum += number
+					}
+				}
+				if len(numbers) == 0 {
+					continue
+				}
+				sort.Float64s(numbers)
+				summary = append(summary, []string{
+					varName,
+					value,
+					output,
+					strconv.Itoa(len(numbers)),
+					FormatValue(numbers[0]),
+					FormatValue(numbers[len(numbers)-1]),
+					FormatValue(sum / float64(len(numbers))),
+					FormatValue(Quantile(numbers, 0.5)),
+					FormatValue(Quantile(numbers, 0.95)),
+				})
+			}
+		}
+	}
+	return summary
+}
@@ -0,0 +1,306 @@
+package blackbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Target runs the program over an input set and returns its outputs.
+type Target interface {
+	Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error)
+}
+
+// BatchTarget is implemented by targets able to run the program over
+// several input sets at once: the program gets a JSON array of input
+// objects and answers with the array of their outputs, in the same order.
+type BatchTarget interface {
+	RunBatch(config RunnerConfig, varNames []string, inputSets [][]string) ([]map[string]string, error)
+}
+
+// EncodeInput returns what the program gets on stdin for an input set:
+// the JSON object of its inputs, or the rendering of tmpl over them, e.g.
+// a config file or a query, for programs expecting another format.
+func EncodeInput(tmpl *template.Template, types map[string]string, varNames, inputSet []string) ([]byte, error) {
+	if tmpl == nil {
+		return ProgramInput(types, varNames, inputSet)
+	}
+	return renderInput(tmpl, InputMap(varNames, inputSet))
+}
+
+// BatchInput returns the JSON array of the input sets of a batch, or the
+// rendering of tmpl over the array of their inputs.
+func BatchInput(tmpl *template.Template, types map[string]string, varNames []string, inputSets [][]string) ([]byte, error) {
+	if tmpl != nil {
+		inputMaps := make([]map[string]string, len(inputSets))
+		for i, inputSet := range inputSets {
+			inputMaps[i] = InputMap(varNames, inputSet)
+		}
+		return renderInput(tmpl, inputMaps)
+	}
+	inputMaps := make([]map[string]interface{}, len(inputSets))
+	for i, inputSet := range inputSets {
+		inputMap, err := TypedInputMap(types, varNames, inputSet)
+		if err != nil {
+			return nil, err
+		}
+		inputMaps[i] = inputMap
+	}
+	return json.Marshal(inputMaps)
+}
+
+func renderInput(tmpl *template.Template, data interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("Unable to render the program input: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// LoadStdinTemplate reads a template of the program input; inputs missing
+// from an input set fail its run rather than render empty.
+func LoadStdinTemplate(path string) (*template.Template, error) {
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read stdin template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// ParseBatchOutput reads the outputs of a batch of size input sets.
+func ParseBatchOutput(output []byte, size int) ([]map[string]string, error) {
+	outputMaps := []map[string]string{}
+	if err := json.Unmarshal(output, &outputMaps); err != nil {
+		return nil, fmt.Errorf("Unable to read the outputs of a batch: %v", err)
+	}
+	if len(outputMaps) != size {
+		return nil, fmt.Errorf("Got %d outputs for a batch of %d input sets", len(outputMaps), size)
+	}
+	return outputMaps, nil
+}
+
+// batchConfig returns the config of a batch run, whose timeout covers
+// every input set of the batch.
+func batchConfig(config RunnerConfig, size int) RunnerConfig {
+	config.Timeout *= time.Duration(size)
+	return config
+}
+
+// TargetOptions configure the targets running the program elsewhere than
+// in a local process.
+type TargetOptions struct {
+	// CPU and memory limits of a container, e.g. "1.5" and "512m"
+	CPUs   string
+	Memory string
+	// When to pull a container image: "missing" (the default), "always"
+	// or "never"
+	Pull string
+	// Template of the program input, JSON if nil, see EncodeInput
+	StdinTemplate *template.Template
+}
+
+// targets open the targets by the scheme of a -target, e.g.
+// "docker:python:3.9" for the docker target of image python:3.9.
+var targets = map[string]func(address, program string, options TargetOptions) (Target, error){
+	"docker": NewDockerTarget,
+	"func":   NewFuncTarget,
+	"grpc":   NewGRPCTarget,
+	"http":   NewHTTPTarget("http"),
+	"https":  NewHTTPTarget("https"),
+	"k8s":    NewK8sTarget,
+	"ssh":    NewSSHTarget,
+}
+
+func targetNames() string {
+	names := []string{}
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// OpenTarget opens the target running program, a local process unless
+// spec names another target.
+func OpenTarget(spec, program string, options TargetOptions) (Target, error) {
+	if spec == "" || spec == "local" {
+		return ProcessTarget{Program: program, Template: options.StdinTemplate}, nil
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	open, ok := targets[parts[0]]
+	if !ok || len(parts) < 2 || parts[1] == "" {
+		return nil, fmt.Errorf("Invalid target %q, expected local or SCHEME:ADDRESS with SCHEME one of %s", spec, targetNames())
+	}
+	return open(parts[1], program, options)
+}
+
+// ProcessTarget runs the program in a local process per input set.
+type ProcessTarget struct {
+	Program  string
+	Template *template.Template
+}
+
+func (t ProcessTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	if t.Template == nil {
+		return RunBlackBoxCmd(t.Program, config, varNames, inputSet)
+	}
+	input, err := EncodeInput(t.Template, config.Types, varNames, inputSet)
+	if err != nil {
+		return nil, err
+	}
+	output, usage, err := RunProgramUsage(t.Program, config, input)
+	if err != nil {
+		return nil, err
+	}
+	outputMap := make(map[string]string)
+	err = json.Unmarshal(output, &outputMap)
+	addUsage(outputMap, usage)
+	return outputMap, err
+}
+
+func (t ProcessTarget) RunBatch(config RunnerConfig, varNames []string, inputSets [][]string) ([]map[string]string, error) {
+	jsonBytes, err := BatchInput(t.Template, config.Types, varNames, inputSets)
+	if err != nil {
+		return nil, err
+	}
+	output, err := RunProgram(t.Program, batchConfig(config, len(inputSets)), jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBatchOutput(output, len(inputSets))
+}
+
+// Path of the worker state directory inside containers.
+const containerStateDir = "/blackbox/state"
+
+// DockerTarget runs the program in a fresh container per input set,
+// passing the input set on stdin and reading the outputs from stdout as
+// for a local process.
+type DockerTarget struct {
+	Image string
+	// Command run in the container, the image's default one if empty
+	Command []string
+	Options TargetOptions
+	// Number of containers started, for naming them
+	started int64
+}
+
+// NewDockerTarget prepares a docker target for image, pulling the image
+// according to options.Pull. The program is the command run in the
+// container, "-" for the image's default command.
+func NewDockerTarget(image, program string, options TargetOptions) (Target, error) {
+	t := &DockerTarget{Image: image, Options: options}
+	if program != "-" {
+		t.Command = strings.Fields(program)
+	}
+	if err := t.pull(); err != nil {
+		return nil, err
+	}
+	id, err := exec.Command("docker", "image", "inspect", "--format", "{{.Id}}", image).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to inspect image %s: %v", image, err)
+	}
+	Log.Infof("Running in containers of %s (%s)\n", image, strings.TrimSpace(string(id)))
+	return t, nil
+}
+
+func (t *DockerTarget) pull() error {
+	present := exec.Command("docker", "image", "inspect", t.Image).Run() == nil
+	switch t.Options.Pull {
+	case "", "missing":
+		if present {
+			return nil
+		}
+	case "always":
+	case "never":
+		if !present {
+			return fmt.Errorf("Image %s is not available locally and pulling is disabled", t.Image)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Invalid pull policy %q, expected missing, always or never", t.Options.Pull)
+	}
+	Log.Infof("Pulling %s\n", t.Image)
+	if out, err := exec.Command("docker", "pull", t.Image).CombinedOutput(); err != nil {
+		return fmt.Errorf("Unable to pull image %s: %v: %s", t.Image, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// args returns the docker run arguments of a container named name.
+func (t *DockerTarget) args(name string, config RunnerConfig) []string {
+	args := []string{"run", "--rm", "-i", "--name", name}
+	if t.Options.CPUs != "" {
+		args = append(args, "--cpus", t.Options.CPUs)
+	}
+	if t.Options.Memory != "" {
+		args = append(args, "--memory", t.Options.Memory)
+	}
+	for _, env := range config.Env {
+		// Worker state directories are mounted into the container
+		if strings.HasPrefix(env, "BLACKBOX_STATE_DIR=") {
+			dir := strings.TrimPrefix(env, "BLACKBOX_STATE_DIR=")
+			args = append(args, "-v", dir+":"+containerStateDir)
+			env = "BLACKBOX_STATE_DIR=" + containerStateDir
+		}
+		args = append(args, "-e", env)
+	}
+	args = append(args, t.Image)
+	return append(args, t.Command...)
+}
+
+func (t *DockerTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	jsonBytes, err := EncodeInput(t.Options.StdinTemplate, config.Types, varNames, inputSet)
+	if err != nil {
+		return nil, err
+	}
+	output, err := t.run(config, jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	outputMap := make(map[string]string)
+	err = json.Unmarshal(output, &outputMap)
+	return outputMap, err
+}
+
+func (t *DockerTarget) RunBatch(config RunnerConfig, varNames []string, inputSets [][]string) ([]map[string]string, error) {
+	jsonBytes, err := BatchInput(t.Options.StdinTemplate, config.Types, varNames, inputSets)
+	if err != nil {
+		return nil, err
+	}
+	output, err := t.run(batchConfig(config, len(inputSets)), jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBatchOutput(output, len(inputSets))
+}
+
+// run runs a container with input on its stdin and returns its stdout.
+func (t *DockerTarget) run(config RunnerConfig, jsonBytes []byte) ([]byte, error) {
+	name := fmt.Sprintf("blackbox-%d-%d", os.Getpid(), atomic.AddInt64(&t.started, 1))
+
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "docker", t.args(name, config)...)
+	cmd.Stdin = bytes.NewReader(jsonBytes)
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		// Killing the client leaves the container running
+		exec.Command("docker", "rm", "-f", name).Run()
+		return nil, fmt.Errorf("%s timed out after %v", t.Image, config.Timeout)
+	}
+	return output, err
+}
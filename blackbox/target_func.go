@@ -0,0 +1,89 @@
+package blackbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunnerFunc computes the outputs of an input set in process.
+type RunnerFunc func(inputs map[string]string) (map[string]string, error)
+
+var (
+	runnerFuncsMu sync.Mutex
+	runnerFuncs   = map[string]RunnerFunc{}
+)
+
+// RegisterFunc makes a Go function available as the target func:NAME, so
+// it can be explored without starting a process or encoding its inputs
+// and outputs, e.g. from a program of its own importing this package:
+//
+//	blackbox.RegisterFunc("fib", func(inputs map[string]string) (map[string]string, error) {
+//		...
+//	})
+//	result := blackbox.RunExperiment(nil, "fib.xlsx", blackbox.Experiment{Target: "func:fib"})
+func RegisterFunc(name string, fn RunnerFunc) {
+	runnerFuncsMu.Lock()
+	defer runnerFuncsMu.Unlock()
+	if _, ok := runnerFuncs[name]; ok {
+		panic(fmt.Sprintf("RegisterFunc called twice for %s", name))
+	}
+	runnerFuncs[name] = fn
+}
+
+// FuncTarget runs a registered function per input set.
+type FuncTarget struct {
+	Name string
+	Func RunnerFunc
+}
+
+// NewFuncTarget opens the target of the function registered as name.
+func NewFuncTarget(name, program string, options TargetOptions) (Target, error) {
+	runnerFuncsMu.Lock()
+	defer runnerFuncsMu.Unlock()
+	fn, ok := runnerFuncs[name]
+	if !ok {
+		names := []string{}
+		for name := range runnerFuncs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown function %s, registered: %s", name, strings.Join(names, ", "))
+	}
+	return FuncTarget{Name: name, Func: fn}, nil
+}
+
+// call calls the function, failing the run if it panics.
+func (t FuncTarget) call(inputs map[string]string) (outputs map[string]string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			outputs, err = nil, fmt.Errorf("%s panicked: %v", t.Name, r)
+		}
+	}()
+	return t.Func(inputs)
+}
+
+// Run calls the function. A function running past the timeout cannot be
+// stopped; its run fails and its outputs are ignored.
+func (t FuncTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	if config.Timeout <= 0 {
+		return t.call(InputMap(varNames, inputSet))
+	}
+	type result struct {
+		outputs map[string]string
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		outputs, err := t.call(InputMap(varNames, inputSet))
+		done <- result{outputs, err}
+	}()
+	select {
+	case r := <-done:
+		return r.outputs, r.err
+	case <-time.After(config.Timeout):
+		return nil, fmt.Errorf("%s timed out after %v", t.Name, config.Timeout)
+	}
+}
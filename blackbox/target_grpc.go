@@ -0,0 +1,160 @@
+package blackbox
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Method of the BlackBox service of proto/blackbox.proto.
+const exploreMethod = "/blackbox.BlackBox/Explore"
+
+// stringMap is an InputMap or OutputMap of proto/blackbox.proto: a
+// map<string, string> as field 1, and an error as field 2. It is encoded
+// by hand, as the whole protocol is these two messages.
+type stringMap struct {
+	values map[string]string
+	err    string
+}
+
+func (m *stringMap) marshal() []byte {
+	var b []byte
+	for key, value := range m.values {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, value)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if m.err != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, m.err)
+	}
+	return b
+}
+
+// consumeField reads a field, returning the number and value of
+// length-delimited ones and a zero number for the others.
+func consumeField(b []byte) (protowire.Number, []byte, []byte, error) {
+	number, wireType, n := protowire.ConsumeTag(b)
+	if n < 0 {
+		return 0, nil, nil, protowire.ParseError(n)
+	}
+	b = b[n:]
+	if wireType != protowire.BytesType {
+		n = protowire.ConsumeFieldValue(number, wireType, b)
+		if n < 0 {
+			return 0, nil, nil, protowire.ParseError(n)
+		}
+		return 0, nil, b[n:], nil
+	}
+	value, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return 0, nil, nil, protowire.ParseError(n)
+	}
+	return number, value, b[n:], nil
+}
+
+func (m *stringMap) unmarshal(b []byte) error {
+	m.values = map[string]string{}
+	for len(b) > 0 {
+		number, value, rest, err := consumeField(b)
+		if err != nil {
+			return err
+		}
+		b = rest
+		switch number {
+		case 1:
+			var key, entryValue string
+			for len(value) > 0 {
+				entryNumber, field, entryRest, err := consumeField(value)
+				if err != nil {
+					return err
+				}
+				value = entryRest
+				switch entryNumber {
+				case 1:
+					key = string(field)
+				case 2:
+					entryValue = string(field)
+				}
+			}
+			m.values[key] = entryValue
+		case 2:
+			m.err = string(value)
+		}
+	}
+	return nil
+}
+
+// stringMapCodec encodes stringMaps as protocol buffers.
+type stringMapCodec struct{}
+
+func (stringMapCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(*stringMap)
+	if !ok {
+		return nil, fmt.Errorf("Unable to encode %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (stringMapCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*stringMap)
+	if !ok {
+		return fmt.Errorf("Unable to decode into %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+func (stringMapCodec) Name() string {
+	return "proto"
+}
+
+// GRPCTarget explores a long-lived service implementing the BlackBox
+// service of proto/blackbox.proto, calling Explore for every input set.
+type GRPCTarget struct {
+	Address string
+	conn    *grpc.ClientConn
+}
+
+// NewGRPCTarget connects to a service at address, as HOST:PORT.
+func NewGRPCTarget(address, program string, options TargetOptions) (Target, error) {
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(stringMapCodec{})))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to %s: %v", address, err)
+	}
+	return &GRPCTarget{Address: address, conn: conn}, nil
+}
+
+func (t *GRPCTarget) Close() error {
+	return t.conn.Close()
+}
+
+// Run calls Explore. The environment of the run does not apply to a
+// service.
+func (t *GRPCTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	outputs := &stringMap{}
+	if err := t.conn.Invoke(ctx, exploreMethod, &stringMap{values: InputMap(varNames, inputSet)}, outputs); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timed out after %v", t.Address, config.Timeout)
+		}
+		return nil, err
+	}
+	if outputs.err != "" {
+		return nil, fmt.Errorf("%s", outputs.err)
+	}
+	return outputs.values, nil
+}
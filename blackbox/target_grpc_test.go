@@ -0,0 +1,116 @@
+package blackbox
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestStringMapCodec(t *testing.T) {
+	tests := []struct {
+		name string
+		in   stringMap
+	}{
+		{name: "values", in: stringMap{values: map[string]string{"size": "10", "latency_ms": "2.5"}}},
+		{name: "empty key and value", in: stringMap{values: map[string]string{"": "", "note": ""}}},
+		{name: "unicode and separators", in: stringMap{values: map[string]string{"name": "héllo, wörld\n", "a=b": "c;d"}}},
+		{name: "error", in: stringMap{values: map[string]string{}, err: "out of memory"}},
+		{name: "values and error", in: stringMap{values: map[string]string{"step": "3"}, err: "timeout"}},
+		{name: "empty", in: stringMap{values: map[string]string{}}},
+	}
+	codec := stringMapCodec{}
+	for _, test := range tests {
+		b, err := codec.Marshal(&test.in)
+		if err != nil {
+			t.Errorf("%s: Marshal failed: %v", test.name, err)
+			continue
+		}
+		got := stringMap{}
+		if err := codec.Unmarshal(b, &got); err != nil {
+			t.Errorf("%s: Unmarshal failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.in) {
+			t.Errorf("%s: round trip = %+v, want %+v", test.name, got, test.in)
+		}
+	}
+}
+
+func TestStringMapUnmarshal(t *testing.T) {
+	entry := func(key, value string) []byte {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, key)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		return protowire.AppendString(b, value)
+	}
+	field := func(number protowire.Number, value []byte) []byte {
+		b := protowire.AppendTag(nil, number, protowire.BytesType)
+		return protowire.AppendBytes(b, value)
+	}
+	varint := protowire.AppendVarint(protowire.AppendTag(nil, 3, protowire.VarintType), 42)
+	valueFirst := append(protowire.AppendString(protowire.AppendTag(nil, 2, protowire.BytesType), "1"),
+		protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "k")...)
+	tests := []struct {
+		name    string
+		in      []byte
+		want    stringMap
+		wantErr bool
+	}{
+		{
+			name: "entries",
+			in:   append(field(1, entry("a", "1")), field(1, entry("b", "2"))...),
+			want: stringMap{values: map[string]string{"a": "1", "b": "2"}},
+		},
+		{
+			name: "entry fields in any order",
+			in:   field(1, valueFirst),
+			want: stringMap{values: map[string]string{"k": "1"}},
+		},
+		{
+			name: "later entries override earlier ones",
+			in:   append(field(1, entry("a", "1")), field(1, entry("a", "2"))...),
+			want: stringMap{values: map[string]string{"a": "2"}},
+		},
+		{
+			name: "unknown fields skipped",
+			in:   append(append(varint, field(1, entry("a", "1"))...), field(4, []byte("x"))...),
+			want: stringMap{values: map[string]string{"a": "1"}},
+		},
+		{
+			name: "missing value",
+			in:   field(1, protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "a")),
+			want: stringMap{values: map[string]string{"a": ""}},
+		},
+		{
+			name: "error",
+			in:   field(2, []byte("boom")),
+			want: stringMap{values: map[string]string{}, err: "boom"},
+		},
+		{
+			name: "nothing",
+			want: stringMap{values: map[string]string{}},
+		},
+		{name: "truncated", in: field(1, entry("a", "1"))[:4], wantErr: true},
+		{name: "truncated entry", in: field(1, entry("a", "1")[:2]), wantErr: true},
+		{name: "invalid tag", in: []byte{0x80}, wantErr: true},
+	}
+	for _, test := range tests {
+		got := stringMap{}
+		err := got.unmarshal(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: unmarshal = %+v, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unmarshal failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: unmarshal = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package blackbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// HTTPTarget posts the input of every input set to a service, as it
+// would be written to the stdin of a program, and reads the outputs from
+// the JSON object of the response, e.g. for -target http://localhost:8080/run.
+type HTTPTarget struct {
+	URL     string
+	Options TargetOptions
+}
+
+// NewHTTPTarget opens the target of the URL scheme:address. The program
+// is ignored, "-" by convention.
+func NewHTTPTarget(scheme string) func(address, program string, options TargetOptions) (Target, error) {
+	return func(address, program string, options TargetOptions) (Target, error) {
+		return &HTTPTarget{URL: scheme + ":" + address, Options: options}, nil
+	}
+}
+
+// Run posts the input set. The environment of the run does not apply to a
+// service.
+func (t *HTTPTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	input, err := EncodeInput(t.Options.StdinTemplate, config.Types, varNames, inputSet)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	if t.Options.StdinTemplate == nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timed out after %v", t.URL, config.Timeout)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s returned %s: %s", t.URL, resp.Status, bytes.TrimSpace(body))
+	}
+	outputMap := make(map[string]string)
+	err = json.Unmarshal(body, &outputMap)
+	return outputMap, err
+}
@@ -0,0 +1,191 @@
+package blackbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Interval between checks of the status of a Kubernetes job.
+const k8sPollInterval = 2 * time.Second
+
+// K8sJob is what a job template is rendered with.
+type K8sJob struct {
+	// Unique name of the job
+	Name string
+	// PROGPATH, e.g. to use as the container command
+	Program string
+	// JSON of the input set, or of the array of input sets of a batch, or
+	// their rendering of the stdin template, as a quoted string to use as
+	// a YAML value
+	Input string
+	// Environment of the run, e.g. BLACKBOX_WORKER
+	Env map[string]string
+}
+
+// K8sTarget runs the program as a Kubernetes job per input set, or per
+// batch, created from a template with kubectl. The job gets its input
+// from the template, e.g. in an environment variable, and its logs are
+// read as its output. For example:
+//
+//	apiVersion: batch/v1
+//	kind: Job
+//	metadata:
+//	  name: {{.Name}}
+//	spec:
+//	  backoffLimit: 0
+//	  template:
+//	    spec:
+//	      restartPolicy: Never
+//	      containers:
+//	      - name: program
+//	        image: registry.example.com/fib:1.2
+//	        command: ["{{.Program}}"]
+//	        env:
+//	        - name: BLACKBOX_INPUT
+//	          value: {{.Input}}
+type K8sTarget struct {
+	Template *template.Template
+	// Template of the program input, JSON if nil
+	Input     *template.Template
+	Namespace string
+	Program   string
+	// Jobs created, for naming them
+	created int64
+	prefix  string
+}
+
+// NewK8sTarget opens a target for an address such as job.yaml or
+// job.yaml?namespace=sweeps.
+func NewK8sTarget(address, program string, options TargetOptions) (Target, error) {
+	parts := strings.SplitN(address, "?", 2)
+	t := &K8sTarget{
+		Program: program,
+		Input:   options.StdinTemplate,
+		prefix:  "blackbox-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	if len(parts) == 2 {
+		query, err := url.ParseQuery(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Kubernetes target %q: %v", address, err)
+		}
+		t.Namespace = query.Get("namespace")
+	}
+	var err error
+	if t.Template, err = template.ParseFiles(parts[0]); err != nil {
+		return nil, fmt.Errorf("Unable to read job template: %v", err)
+	}
+	return t, nil
+}
+
+func (t *K8sTarget) kubectl(stdin []byte, args ...string) ([]byte, error) {
+	if t.Namespace != "" {
+		args = append([]string{"--namespace", t.Namespace}, args...)
+	}
+	cmd := exec.Command("kubectl", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl %s: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return output, nil
+}
+
+// wait returns once the job completed, or with an error once it failed or
+// the timeout expired.
+func (t *K8sTarget) wait(name string, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+	for {
+		out, err := t.kubectl(nil, "get", "job", name, "-o", `jsonpath={.status.conditions[?(@.status=="True")].type}`)
+		if err != nil {
+			return err
+		}
+		conditions := strings.Fields(string(out))
+		for _, condition := range conditions {
+			switch condition {
+			case "Complete":
+				return nil
+			case "Failed":
+				return fmt.Errorf("Job %s failed", name)
+			}
+		}
+		select {
+		case <-deadline:
+			return fmt.Errorf("Job %s timed out after %v", name, timeout)
+		case <-time.After(k8sPollInterval):
+		}
+	}
+}
+
+// run creates a job for input, waits for it and returns its logs.
+func (t *K8sTarget) run(config RunnerConfig, jsonBytes []byte) ([]byte, error) {
+	input, err := json.Marshal(string(jsonBytes))
+	if err != nil {
+		return nil, err
+	}
+	job := K8sJob{
+		Name:    fmt.Sprintf("%s-%d", t.prefix, atomic.AddInt64(&t.created, 1)),
+		Program: t.Program,
+		Input:   string(input),
+		Env:     map[string]string{},
+	}
+	for _, variable := range config.Env {
+		parts := strings.SplitN(variable, "=", 2)
+		// Worker state directories are local
+		if len(parts) == 2 && parts[0] != "BLACKBOX_STATE_DIR" {
+			job.Env[parts[0]] = parts[1]
+		}
+	}
+	var manifest bytes.Buffer
+	if err := t.Template.Execute(&manifest, job); err != nil {
+		return nil, fmt.Errorf("Unable to render job template: %v", err)
+	}
+	if _, err := t.kubectl(manifest.Bytes(), "create", "-f", "-"); err != nil {
+		return nil, err
+	}
+	defer t.kubectl(nil, "delete", "job", job.Name, "--ignore-not-found", "--wait=false")
+	if err := t.wait(job.Name, config.Timeout); err != nil {
+		return nil, err
+	}
+	return t.kubectl(nil, "logs", "job/"+job.Name)
+}
+
+func (t *K8sTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	jsonBytes, err := EncodeInput(t.Input, config.Types, varNames, inputSet)
+	if err != nil {
+		return nil, err
+	}
+	output, err := t.run(config, jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	outputMap := make(map[string]string)
+	err = json.Unmarshal(output, &outputMap)
+	return outputMap, err
+}
+
+func (t *K8sTarget) RunBatch(config RunnerConfig, varNames []string, inputSets [][]string) ([]map[string]string, error) {
+	jsonBytes, err := BatchInput(t.Input, config.Types, varNames, inputSets)
+	if err != nil {
+		return nil, err
+	}
+	output, err := t.run(batchConfig(config, len(inputSets)), jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBatchOutput(output, len(inputSets))
+}
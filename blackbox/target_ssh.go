@@ -0,0 +1,298 @@
+package blackbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Sessions multiplexed over one SSH connection, below the default
+// MaxSessions of sshd.
+const sshSessionsPerConnection = 8
+
+// Directory of the worker state directories on remote hosts, relative to
+// the remote home directory.
+const remoteStateDir = ".blackbox/state"
+
+// Time given to ssh to kill a remote program that timed out.
+const sshKillTimeout = 10 * time.Second
+
+// sshHost is a remote host shared by the SSH targets of a process: it
+// limits the programs running there at once and multiplexes their
+// sessions over a pool of connections.
+type sshHost struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	// Maximum programs running at once, 0 for no limit
+	limit int
+	// Slots of the running programs: slot s runs over connection
+	// s / sshSessionsPerConnection
+	used map[int]bool
+	// Connections opened so far, closed with the last target of the host
+	connections map[int]bool
+	// Open targets of the host
+	targets int
+}
+
+var (
+	sshHostsMu sync.Mutex
+	sshHosts   = map[string]*sshHost{}
+	// Directory of the control sockets of the SSH connections, removed
+	// with the last SSH target
+	sshControlDir string
+	sshTargets    int
+)
+
+// getSSHHost returns the host of an address, counting one more target of
+// it; sshHostsMu must be held.
+func getSSHHost(address string, limit int) *sshHost {
+	host, ok := sshHosts[address]
+	if !ok {
+		host = &sshHost{used: map[int]bool{}, connections: map[int]bool{}}
+		host.freed = sync.NewCond(&host.mu)
+		sshHosts[address] = host
+	}
+	// The strictest limit of the targets sharing the host applies
+	if limit > 0 && (host.limit == 0 || limit < host.limit) {
+		host.limit = limit
+	}
+	host.targets++
+	return host
+}
+
+// acquire waits for the host to run one more program and returns its slot.
+func (h *sshHost) acquire() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for h.limit > 0 && len(h.used) >= h.limit {
+		h.freed.Wait()
+	}
+	slot := 0
+	for h.used[slot] {
+		slot++
+	}
+	h.used[slot] = true
+	h.connections[slot/sshSessionsPerConnection] = true
+	return slot
+}
+
+func (h *sshHost) release(slot int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.used, slot)
+	h.freed.Signal()
+}
+
+// SSHTarget runs the program on a remote host over SSH, using the ssh
+// client and its configuration, e.g. for a GPU machine, while results are
+// recorded locally.
+type SSHTarget struct {
+	// user@host, as given to ssh
+	Destination string
+	Port        string
+	Program     string
+	// Template of the program input, JSON if nil
+	Input   *template.Template
+	host    *sshHost
+	address string
+	closed  bool
+}
+
+// NewSSHTarget opens a target for an address such as
+// //user@host:2222/path/to/prog?max=4, max limiting the programs running
+// on the host at once. Without a path, the program is run by its name.
+func NewSSHTarget(address, program string, options TargetOptions) (Target, error) {
+	u, err := url.Parse("ssh:" + address)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("Invalid SSH target %q, expected ssh://[user@]host[:port]/path/to/prog", address)
+	}
+	t := &SSHTarget{Destination: u.Hostname(), Port: u.Port(), Program: program, Input: options.StdinTemplate}
+	if u.User != nil {
+		t.Destination = u.User.Username() + "@" + t.Destination
+	}
+	switch {
+	case strings.HasPrefix(u.Path, "/~/"):
+		// ssh://host/~/prog is relative to the remote home directory,
+		// where commands start
+		t.Program = "./" + strings.TrimPrefix(u.Path, "/~/")
+	case u.Path != "" && u.Path != "/":
+		t.Program = u.Path
+	}
+	limit := 0
+	if max := u.Query().Get("max"); max != "" {
+		if limit, err = strconv.Atoi(max); err != nil || limit < 1 {
+			return nil, fmt.Errorf("Invalid max %q of SSH target, expected a positive number", max)
+		}
+	}
+
+	sshHostsMu.Lock()
+	defer sshHostsMu.Unlock()
+	if sshControlDir == "" {
+		if sshControlDir, err = ioutil.TempDir("", "blackbox-ssh-"); err != nil {
+			return nil, fmt.Errorf("Unable to create SSH control directory: %v", err)
+		}
+	}
+	sshTargets++
+	t.address = t.Destination + ":" + t.Port
+	t.host = getSSHHost(t.address, limit)
+	return t, nil
+}
+
+// Close closes the connections to the host once no other target uses it,
+// and removes the control directory with the last SSH target.
+func (t *SSHTarget) Close() error {
+	sshHostsMu.Lock()
+	defer sshHostsMu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	t.host.targets--
+	if t.host.targets == 0 {
+		delete(sshHosts, t.address)
+		for connection := range t.host.connections {
+			args := append(t.controlArgs(connection*sshSessionsPerConnection), "-O", "exit", t.Destination)
+			// Fails harmlessly if the connection already timed out
+			exec.Command("ssh", args...).Run()
+		}
+	}
+	sshTargets--
+	if sshTargets == 0 {
+		err := os.RemoveAll(sshControlDir)
+		sshControlDir = ""
+		return err
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// command returns the remote shell command running the program with the
+// environment of config. It first prints the PID of the program on a line
+// of its own, for run to kill the program when it times out: stopping the
+// ssh client leaves a remote command without a terminal running.
+func (t *SSHTarget) command(config RunnerConfig) string {
+	prefix := ""
+	env := []string{}
+	for _, variable := range config.Env {
+		// Worker state directories live on the remote host
+		if strings.HasPrefix(variable, "BLACKBOX_STATE_DIR=") {
+			dir := remoteStateDir + "/" + filepath.Base(strings.TrimPrefix(variable, "BLACKBOX_STATE_DIR="))
+			prefix = "mkdir -p " + shellQuote(dir) + " && "
+			variable = "BLACKBOX_STATE_DIR=" + dir
+		}
+		env = append(env, shellQuote(variable))
+	}
+	prefix += "echo $$ && exec "
+	if len(env) == 0 {
+		return prefix + shellQuote(t.Program)
+	}
+	return prefix + "env " + strings.Join(env, " ") + " " + shellQuote(t.Program)
+}
+
+// controlArgs returns the ssh options using the connection of slot.
+func (t *SSHTarget) controlArgs(slot int) []string {
+	controlPath := filepath.Join(sshControlDir, fmt.Sprintf("%%C-%d", slot/sshSessionsPerConnection))
+	args := []string{
+		"-o", "BatchMode=yes",
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + controlPath,
+		"-o", "ControlPersist=60",
+	}
+	if t.Port != "" {
+		args = append(args, "-p", t.Port)
+	}
+	return args
+}
+
+// args returns the ssh arguments of a program run over the connection of
+// slot.
+func (t *SSHTarget) args(slot int, config RunnerConfig) []string {
+	return append(t.controlArgs(slot), t.Destination, t.command(config))
+}
+
+// splitPID separates the PID line the remote command starts its output
+// with from the output of the program.
+func splitPID(output []byte) (string, []byte) {
+	i := bytes.IndexByte(output, '\n')
+	if i < 0 {
+		return strings.TrimSpace(string(output)), nil
+	}
+	return strings.TrimSpace(string(output[:i])), output[i+1:]
+}
+
+// kill terminates a remote program still running after its ssh client was
+// stopped.
+func (t *SSHTarget) kill(slot int, pid string) {
+	if _, err := strconv.Atoi(pid); err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sshKillTimeout)
+	defer cancel()
+	args := append(t.controlArgs(slot), t.Destination, "kill -TERM "+pid)
+	if err := exec.CommandContext(ctx, "ssh", args...).Run(); err != nil {
+		Log.Warnf("Unable to kill %s on %s: %v\n", t.Program, t.Destination, err)
+	}
+}
+
+func (t *SSHTarget) Run(config RunnerConfig, varNames, inputSet []string) (map[string]string, error) {
+	jsonBytes, err := EncodeInput(t.Input, config.Types, varNames, inputSet)
+	if err != nil {
+		return nil, err
+	}
+	output, err := t.run(config, jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	outputMap := make(map[string]string)
+	err = json.Unmarshal(output, &outputMap)
+	return outputMap, err
+}
+
+func (t *SSHTarget) RunBatch(config RunnerConfig, varNames []string, inputSets [][]string) ([]map[string]string, error) {
+	jsonBytes, err := BatchInput(t.Input, config.Types, varNames, inputSets)
+	if err != nil {
+		return nil, err
+	}
+	output, err := t.run(batchConfig(config, len(inputSets)), jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBatchOutput(output, len(inputSets))
+}
+
+// run runs the program remotely with input on its stdin and returns its
+// stdout.
+func (t *SSHTarget) run(config RunnerConfig, jsonBytes []byte) ([]byte, error) {
+	slot := t.host.acquire()
+	defer t.host.release(slot)
+
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "ssh", t.args(slot, config)...)
+	cmd.Stdin = bytes.NewReader(jsonBytes)
+	output, err := cmd.Output()
+	pid, output := splitPID(output)
+	if ctx.Err() == context.DeadlineExceeded {
+		t.kill(slot, pid)
+		return nil, fmt.Errorf("%s on %s timed out after %v", t.Program, t.Destination, config.Timeout)
+	}
+	return output, err
+}
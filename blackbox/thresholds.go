@@ -0,0 +1,128 @@
+package blackbox
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Threshold marks the values of a result column beyond which a cell is
+// colored, to make hot spots of the parameter space visible.
+type Threshold struct {
+	Column string
+	// "warn" or "crit"
+	Level string
+	// Sheets condition type, e.g. NUMBER_GREATER
+	Condition string
+	Value     float64
+}
+
+// thresholdColors are the background colors of each level, as red, green, blue.
+var thresholdColors = map[string][3]float64{
+	"warn": {1.0, 0.9, 0.6},
+	"crit": {0.95, 0.5, 0.5},
+}
+
+var thresholdConditions = map[string]string{
+	">":  "NUMBER_GREATER",
+	">=": "NUMBER_GREATER_THAN_EQ",
+	"<":  "NUMBER_LESS",
+	"<=": "NUMBER_LESS_THAN_EQ",
+}
+
+var thresholdPattern = regexp.MustCompile(`^(warn|crit)\s*(>=|<=|>|<)\s*(\S+)$`)
+
+// ParseThresholds parses "column: warn>200 crit>500"; either level may be
+// omitted.
+func ParseThresholds(spec string) ([]Threshold, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("Invalid thresholds %q, expected column: warn>N crit>N", spec)
+	}
+	column := strings.TrimSpace(spec[:i])
+	thresholds := []Threshold{}
+	for _, level := range strings.Fields(spec[i+1:]) {
+		match := thresholdPattern.FindStringSubmatch(level)
+		if match == nil {
+			return nil, fmt.Errorf("Invalid threshold %q of %s, expected e.g. warn>200 or crit<=0.5", level, column)
+		}
+		value, err := strconv.ParseFloat(match[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid threshold %q of %s: %v", level, column, err)
+		}
+		thresholds = append(thresholds, Threshold{
+			Column:    column,
+			Level:     match[1],
+			Condition: thresholdConditions[match[2]],
+			Value:     value,
+		})
+	}
+	if len(thresholds) == 0 {
+		return nil, fmt.Errorf("No thresholds given for %s", column)
+	}
+	return thresholds, nil
+}
+
+func ParseThresholdSpecs(specs []string) ([]Threshold, error) {
+	all := []Threshold{}
+	for _, spec := range specs {
+		thresholds, err := ParseThresholds(spec)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, thresholds...)
+	}
+	return all, nil
+}
+
+// AddConditionalFormats adds conditional formatting rules coloring the
+// cells of a result tab beyond their thresholds. Critical rules come first
+// so they win over warnings.
+func AddConditionalFormats(srv *sheets.Service, spreadsheetID string, sheetID int64, header []string, thresholds []Threshold) error {
+	index := columnIndex(header)
+	requests := []*sheets.Request{}
+	for _, level := range []string{"crit", "warn"} {
+		for _, threshold := range thresholds {
+			if threshold.Level != level {
+				continue
+			}
+			column, ok := index[threshold.Column]
+			if !ok {
+				Log.Warnf("Skipping thresholds of %s, not a result column\n", threshold.Column)
+				continue
+			}
+			color := thresholdColors[level]
+			requests = append(requests, &sheets.Request{AddConditionalFormatRule: &sheets.AddConditionalFormatRuleRequest{
+				Index: int64(len(requests)),
+				Rule: &sheets.ConditionalFormatRule{
+					Ranges: []*sheets.GridRange{{
+						SheetId:          sheetID,
+						StartRowIndex:    1,
+						StartColumnIndex: int64(column),
+						EndColumnIndex:   int64(column + 1),
+					}},
+					BooleanRule: &sheets.BooleanRule{
+						Condition: &sheets.BooleanCondition{
+							Type:   threshold.Condition,
+							Values: []*sheets.ConditionValue{{UserEnteredValue: FormatValue(threshold.Value)}},
+						},
+						Format: &sheets.CellFormat{
+							BackgroundColor: &sheets.Color{Red: color[0], Green: color[1], Blue: color[2]},
+						},
+					},
+				},
+			}})
+		}
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	if _, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do(); err != nil {
+		return fmt.Errorf("Unable to add conditional formatting: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package blackbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records spans of the exploration pipeline: an experiment span
+// with the source reads, program runs and sink writes under it. Until
+// StartTracing, spans are not recorded.
+var tracer = otel.Tracer("github.com/oozie/blackbox")
+
+// StartTracing exports spans over OTLP/gRPC to endpoint, or to the
+// endpoint of the standard OTEL_EXPORTER_OTLP_* variables, and returns the
+// function flushing them at exit. Without an endpoint it does nothing.
+func StartTracing(ctx context.Context, endpoint string) (func(), error) {
+	if endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}, nil
+	}
+	options := []otlptracegrpc.Option{}
+	if endpoint != "" {
+		options = append(options, otlptracegrpc.WithEndpoint(endpoint))
+	}
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to export traces: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "blackbox"))),
+	)
+	otel.SetTracerProvider(provider)
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			Log.Warnf("Unable to flush traces: %v", err)
+		}
+	}, nil
+}
+
+// InputAttributes returns the attributes of a span over an input set.
+func InputAttributes(varNames, inputSet []string) []attribute.KeyValue {
+	attributes := make([]attribute.KeyValue, 0, len(inputSet))
+	for i, value := range inputSet {
+		attributes = append(attributes, attribute.String("blackbox.input."+varNames[i], value))
+	}
+	return attributes
+}
+
+// endSpan ends a span, marking it failed with err if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TracedSource records the reads of a source as spans.
+type TracedSource struct {
+	Source
+	ctx context.Context
+}
+
+func (s TracedSource) ReadRows(sheetName string) ([][]string, error) {
+	_, span := tracer.Start(s.ctx, "source.read", trace.WithAttributes(attribute.String("blackbox.sheet", sheetName)))
+	rows, err := s.Source.ReadRows(sheetName)
+	span.SetAttributes(attribute.Int("blackbox.rows", len(rows)))
+	endSpan(span, err)
+	return rows, err
+}
+
+// tracedSink records the writes to a sink as spans.
+type tracedSink struct {
+	Sink
+	ctx  context.Context
+	kind string
+}
+
+func (s *tracedSink) write(name string, write func() error) error {
+	_, span := tracer.Start(s.ctx, name, trace.WithAttributes(attribute.String("blackbox.output", s.kind)))
+	err := write()
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedSink) WriteHeader(header []string) error {
+	return s.write("sink.header", func() error { return s.Sink.WriteHeader(header) })
+}
+
+func (s *tracedSink) WriteRow(row []string) error {
+	return s.write("sink.write", func() error { return s.Sink.WriteRow(row) })
+}
+
+func (s *tracedSink) Close() error {
+	return s.write("sink.close", s.Sink.Close)
+}
+
+func (s *tracedSink) Finalize() error {
+	finalizer, ok := s.Sink.(Finalizer)
+	if !ok {
+		return nil
+	}
+	return s.write("sink.finalize", finalizer.Finalize)
+}
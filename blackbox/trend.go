@@ -0,0 +1,228 @@
+package blackbox
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Trend charts cover this many rows, so that they keep up with the rows
+// appended by later runs.
+const trendChartRows = 5000
+
+var trendHeader = []string{"date", "run_id", "run", "runs", "failures"}
+
+// TrendSink keeps the results of a run to append, once the run completed,
+// a row summarizing it to a trend tab kept across runs of the experiment:
+// the date, the number of runs and failures, and the mean and p95 of every
+// numeric output, for following an experiment rerun e.g. nightly.
+type TrendSink struct {
+	mu      sync.Mutex
+	context *SinkContext
+	// Tab of the trend, trend or trend_NAME for a named experiment
+	sheetName string
+	// Outputs whose mean to chart over time when creating the tab
+	charts []string
+	header []string
+	rows   [][]string
+}
+
+func NewTrendSink(context *SinkContext, experimentName string, charts []string) *TrendSink {
+	sheetName := "trend"
+	if experimentName != "" {
+		sheetName += "_" + experimentName
+	}
+	return &TrendSink{context: context, sheetName: sheetName, charts: charts}
+}
+
+func (s *TrendSink) WriteHeader(header []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = header
+	return nil
+}
+
+func (s *TrendSink) WriteRow(row []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rows = append(s.rows, row)
+	return nil
+}
+
+func (s *TrendSink) Close() error {
+	return nil
+}
+
+// TrendRow summarizes the results of a run as a trend row, returning its
+// header and values.
+func TrendRow(header []string, rows [][]string, varNames []string, runID, run string, start string) ([]string, []string) {
+	isInput := map[string]bool{}
+	for _, varName := range varNames {
+		isInput[varName] = true
+	}
+	index := columnIndex(header)
+	failures := 0
+	if column, ok := index[errorColumn]; ok {
+		for _, row := range rows {
+			if cell(row, column) != "" {
+				failures++
+			}
+		}
+	}
+	trendColumns := append([]string{}, trendHeader...)
+	values := []string{start, runID, run, strconv.Itoa(len(rows)), strconv.Itoa(failures)}
+	for column, name := range header {
+		if isInput[name] || isBookkeepingColumn(name) {
+			continue
+		}
+		numbers := []float64{}
+		sum := 0.0
+		for _, row := range rows {
+			if number, err := strconv.ParseFloat(cell(row, column), 64); err == nil {
+				numbers = append(numbers, number)
+				sum += number
+			}
+		}
+		if len(numbers) == 0 {
+			continue
+		}
+		sort.Float64s(numbers)
+		trendColumns = append(trendColumns, name+" mean", name+" p95")
+		values = append(values, FormatValue(sum/float64(len(numbers))), FormatValue(Quantile(numbers, 0.95)))
+	}
+	return trendColumns, values
+}
+
+// mergeTrendHeader returns the header of a trend extended by the columns
+// it lacks, and row laid out along it.
+func mergeTrendHeader(existing, columns, values []string) ([]string, []string) {
+	header := append([]string{}, existing...)
+	index := columnIndex(header)
+	for _, column := range columns {
+		if _, ok := index[column]; !ok {
+			index[column] = len(header)
+			header = append(header, column)
+		}
+	}
+	row := make([]string, len(header))
+	for i, column := range columns {
+		row[index[column]] = values[i]
+	}
+	return header, row
+}
+
+// Finalize appends the row of the run to the trend tab, or to a CSV file
+// under runsDir when the spreadsheet is not written.
+func (s *TrendSink) Finalize() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	columns, values := TrendRow(s.header, s.rows, s.context.VarNames, s.context.RunID, s.context.RunName,
+		s.context.Start.Format("2006-01-02 15:04:05"))
+	var err error
+	if !IsGoogleSpreadsheet(s.context.SpreadsheetID) || NoWriteSheet {
+		err = s.appendFile(columns, values)
+	} else {
+		err = s.appendSheet(columns, values)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to update the trend: %v", err)
+	}
+	return nil
+}
+
+func (s *TrendSink) appendFile(columns, values []string) error {
+	if err := os.MkdirAll(runsDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(runsDir, s.sheetName+".csv")
+	rows := [][]string{}
+	if content, err := ioutil.ReadFile(path); err == nil {
+		if rows, err = parseCSV(content); err != nil {
+			return err
+		}
+	}
+	existing := []string{}
+	if len(rows) > 0 {
+		existing, rows = rows[0], rows[1:]
+	}
+	header, row := mergeTrendHeader(existing, columns, values)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write(header)
+	w.WriteAll(append(rows, row))
+	if err := w.Error(); err != nil {
+		return err
+	}
+	Log.Infof("Added the run to %s\n", path)
+	return nil
+}
+
+func (s *TrendSink) appendSheet(columns, values []string) error {
+	srv, spreadsheetID := s.context.Service, s.context.SpreadsheetID
+	names, err := (&SheetsSource{srv: srv, spreadsheetID: spreadsheetID}).SheetNames()
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, name := range names {
+		exists = exists || name == s.sheetName
+	}
+	existing := []string{}
+	var sheetID int64 = -1
+	if exists {
+		if rows, err := readRows(srv, spreadsheetID, s.sheetName+"!1:1"); err == nil && len(rows) > 0 {
+			existing = rows[0]
+		}
+	} else {
+		rb := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{{
+			AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: s.sheetName}},
+		}}}
+		resp, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do()
+		if err != nil {
+			return err
+		}
+		sheetID = resp.Replies[0].AddSheet.Properties.SheetId
+	}
+	header, row := mergeTrendHeader(existing, columns, values)
+	if len(header) > len(existing) {
+		vr := sheets.ValueRange{Values: [][]interface{}{toInterfaces(header)}}
+		if _, err := srv.Spreadsheets.Values.Update(spreadsheetID, s.sheetName+"!A1", &vr).ValueInputOption("RAW").Do(); err != nil {
+			return err
+		}
+	}
+	vr := sheets.ValueRange{Values: [][]interface{}{toInterfaces(row)}}
+	if _, err := srv.Spreadsheets.Values.Append(spreadsheetID, s.sheetName+"!A1", &vr).
+		ValueInputOption("USER_ENTERED").InsertDataOption("INSERT_ROWS").Do(); err != nil {
+		return err
+	}
+	if sheetID >= 0 && len(s.charts) > 0 {
+		charts := []ChartSpec{}
+		for _, output := range s.charts {
+			charts = append(charts, ChartSpec{Output: output + " mean", Input: "date", Type: "LINE"})
+		}
+		if err := AddCharts(srv, spreadsheetID, sheetID, header, trendChartRows, charts); err != nil {
+			return err
+		}
+	}
+	Log.Infof("Added the run to %s\n", s.sheetName)
+	return nil
+}
+
+func toInterfaces(row []string) []interface{} {
+	values := make([]interface{}, len(row))
+	for i, value := range row {
+		values[i] = value
+	}
+	return values
+}
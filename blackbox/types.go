@@ -0,0 +1,138 @@
+package blackbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Types a variable can be tagged with in its cell, as in "size:int", for
+// its values to be sent to the program as JSON numbers, booleans or
+// values rather than strings. The examples of a json variable are a JSON
+// array of values, e.g. [{"depth": 1}, {"depth": 2, "fanout": 4}].
+var varTypes = map[string]bool{"string": true, "int": true, "float": true, "bool": true, "json": true}
+
+// ParseVarCell returns the variable name of a cell and its type, empty
+// if untagged.
+func ParseVarCell(cell string) (string, string) {
+	name := strings.Trim(cell, "\t \n")
+	if i := strings.LastIndex(name, ":"); i > 0 && varTypes[strings.TrimSpace(name[i+1:])] {
+		return strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+	}
+	return name, ""
+}
+
+// ExtractTypedExamples returns the examples of a variable of type varType.
+func ExtractTypedExamples(varType, examplesCell string) ([]string, error) {
+	if varType != "json" {
+		examples := ExtractExamples(examplesCell)
+		if varType == "" || varType == "string" {
+			examples, _, err := ExpandFileExamples(examples)
+			return examples, err
+		}
+		for _, example := range examples {
+			if _, err := TypedValue(varType, example); err != nil {
+				return nil, err
+			}
+		}
+		return examples, nil
+	}
+	values := []json.RawMessage{}
+	if err := json.Unmarshal([]byte(examplesCell), &values); err != nil {
+		return nil, fmt.Errorf("Examples of a json variable must be a JSON array: %v", err)
+	}
+	examples := []string{}
+	for _, value := range values {
+		// Compact values compare and record the same however written
+		compact, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		examples = append(examples, string(compact))
+	}
+	return examples, nil
+}
+
+// TypedValue returns the JSON value of a value of type varType.
+func TypedValue(varType, value string) (interface{}, error) {
+	switch varType {
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an int", value)
+		}
+		return n, nil
+	case "float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a float", value)
+		}
+		return f, nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a bool", value)
+		}
+		return b, nil
+	case "json":
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("%q is not JSON", value)
+		}
+		return json.RawMessage(value), nil
+	case "base64":
+		return FileContent(value)
+	}
+	return value, nil
+}
+
+// VarTypes returns the types of the tagged variables of the setup rows,
+// and "base64" for those sending file contents.
+func VarTypes(setupRows [][]string) map[string]string {
+	types := map[string]string{}
+	for _, row := range setupRows {
+		if len(row) == 0 {
+			continue
+		}
+		name, varType := ParseVarCell(row[0])
+		if varType != "" && varType != "string" {
+			types[name] = varType
+			continue
+		}
+		if len(row) > 1 {
+			if _, content, err := ExpandFileExamples(ExtractExamples(row[1])); err == nil && content {
+				types[name] = "base64"
+			}
+		}
+	}
+	return types
+}
+
+// CheckInputTypes checks the examples of the variable rows of the inputs
+// tab, pointing at the cell of the first invalid one.
+func CheckInputTypes(inputsSheet string, layout SetupLayout, setupRows [][]string) error {
+	for i, row := range setupRows {
+		if len(row) < 2 || strings.HasPrefix(strings.TrimSpace(row[1]), derivedPrefix) {
+			continue
+		}
+		name, varType := ParseVarCell(row[0])
+		if _, err := ExtractTypedExamples(varType, row[1]); err != nil {
+			return fmt.Errorf("Invalid example of %s in %s: %v", name, layout.ExamplesCell(inputsSheet, i), err)
+		}
+	}
+	return nil
+}
+
+// TypedInputMap returns the inputs of an input set sent to the program,
+// with the values of typed variables converted.
+func TypedInputMap(types map[string]string, varNames, inputSet []string) (map[string]interface{}, error) {
+	typed := map[string]interface{}{}
+	for name, value := range InputMap(varNames, inputSet) {
+		v, err := TypedValue(types[name], value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value of %s: %v", name, err)
+		}
+		typed[name] = v
+	}
+	return typed, nil
+}
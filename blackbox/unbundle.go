@@ -0,0 +1,151 @@
+package blackbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// ReadBundle returns the files of a bundle written by WriteBundle, by base name.
+func ReadBundle(bundlePath string) (map[string][]byte, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read bundle %s: %v", bundlePath, err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read bundle %s: %v", bundlePath, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Base(header.Name)] = content
+	}
+	return files, nil
+}
+
+func parseCSV(content []byte) ([][]string, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
+
+// CreateSpreadsheet creates a new spreadsheet and returns its ID, URL and
+// the sheet ID of its default tab.
+func CreateSpreadsheet(srv *sheets.Service, title string) (string, string, int64, error) {
+	spreadsheet := &sheets.Spreadsheet{Properties: &sheets.SpreadsheetProperties{Title: title}}
+	resp, err := srv.Spreadsheets.Create(spreadsheet).Do()
+	if err != nil {
+		return "", "", 0, fmt.Errorf("Unable to create spreadsheet: %v", err)
+	}
+	return resp.SpreadsheetId, resp.SpreadsheetUrl, resp.Sheets[0].Properties.SheetId, nil
+}
+
+// DeleteSheet removes a tab from a spreadsheet.
+func DeleteSheet(srv *sheets.Service, spreadsheetID string, sheetID int64) error {
+	rb := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: sheetID}}},
+	}
+	_, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do()
+	return err
+}
+
+// UnbundleCommand implements "blackbox unbundle": it recreates the inputs
+// and result tabs of a bundled run in a spreadsheet or Excel file.
+func UnbundleCommand(args []string) error {
+	flags := flag.NewFlagSet("unbundle", flag.ExitOnError)
+	to := flags.String("to", "", "spreadsheet ID or .xlsx file to add the tabs to (default: a new spreadsheet)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox unbundle [-to SPREADSHEET_ID|FILE.xlsx] BUNDLE.tar.gz\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return fmt.Errorf("bundle param is missing")
+	}
+
+	files, err := ReadBundle(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	metadata := RunMetadata{}
+	if err := json.Unmarshal(files["metadata.json"], &metadata); err != nil {
+		return fmt.Errorf("Bundle has no valid metadata.json: %v", err)
+	}
+	inputs, err := parseCSV(files["inputs.csv"])
+	if err != nil || len(inputs) == 0 {
+		return fmt.Errorf("Bundle has no valid inputs.csv: %v", err)
+	}
+	results, err := parseCSV(files["results.csv"])
+	if err != nil || len(results) == 0 {
+		return fmt.Errorf("Bundle has no valid results.csv: %v", err)
+	}
+
+	spreadsheet := SpreadsheetID(*to)
+	var srv *sheets.Service
+	if !IsXlsxPath(spreadsheet) {
+		if srv, err = Auth(); err != nil {
+			return err
+		}
+	}
+
+	inputsSheet := "inputs"
+	var defaultSheetID int64 = -1
+	if spreadsheet == "" {
+		var url string
+		if spreadsheet, url, defaultSheetID, err = CreateSpreadsheet(srv, "blackbox "+metadata.Run); err != nil {
+			return err
+		}
+		Log.Infof("Created spreadsheet %s\n", url)
+	} else if source, err := OpenSource(srv, spreadsheet); err == nil {
+		// Keep the inputs of an existing experiment
+		if names, err := source.SheetNames(); err == nil {
+			for _, name := range names {
+				if strings.EqualFold(name, inputsSheet) {
+					inputsSheet = "inputs_" + metadata.Run
+				}
+			}
+		}
+	}
+
+	if err := WriteRows(srv, spreadsheet, inputsSheet, inputs); err != nil {
+		return err
+	}
+	if err := WriteRows(srv, spreadsheet, metadata.Run, results); err != nil {
+		return err
+	}
+	if defaultSheetID >= 0 {
+		if err := DeleteSheet(srv, spreadsheet, defaultSheetID); err != nil {
+			return err
+		}
+	}
+	Log.Infof("Recreated %s and %s in %s\n", inputsSheet, metadata.Run, spreadsheet)
+	return nil
+}
@@ -0,0 +1,220 @@
+package blackbox
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// validation reports the checks of "blackbox validate" as they pass or
+// fail.
+type validation struct {
+	failed int
+}
+
+func (v *validation) ok(check, format string, args ...interface{}) {
+	fmt.Printf("ok    %-12s %s\n", check, fmt.Sprintf(format, args...))
+}
+
+func (v *validation) fail(check string, err error) {
+	v.failed++
+	fmt.Printf("FAIL  %-12s %v\n", check, err)
+}
+
+func (v *validation) skip(check, reason string) {
+	fmt.Printf("skip  %-12s %s\n", check, reason)
+}
+
+// ValidateCommand implements "blackbox validate": it checks what a run
+// needs before committing to it, from the credentials and the spreadsheet
+// to the inputs and the program, which it runs once over the first input
+// set, and reports every check.
+func ValidateCommand(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	inputs := flags.String("inputs", "", "tab or named range defining the input variables (default inputs, or inputs_NAME with -experiment)")
+	experimentName := flags.String("experiment", "", "name of the experiment to validate")
+	target := flags.String("target", "", "where to run the program, as for a run")
+	var outputs ListFlags
+	flags.Var(&outputs, "output", "where results would be recorded, as for a run (repeatable, default next to the inputs)")
+	timeout := flags.Duration("timeout", 0, "kill the smoke run of the program if it takes longer than this (0 for no limit)")
+	smoke := flags.Bool("smoke", true, "run the program once over the first input set")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox validate [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return fmt.Errorf("spreadsheet or progpath param is missing")
+	}
+	spreadsheet := SpreadsheetID(flags.Arg(0))
+	experiment := Experiment{
+		Name:    *experimentName,
+		Program: flags.Arg(1),
+		Inputs:  *inputs,
+		Target:  *target,
+		Outputs: outputs,
+	}
+	if *timeout > 0 {
+		experiment.Timeout = timeout.String()
+	}
+
+	v := &validation{}
+	v.validate(spreadsheet, experiment, *smoke)
+	if v.failed > 0 {
+		return fmt.Errorf("%d checks failed", v.failed)
+	}
+	fmt.Printf("Ready to run\n")
+	return nil
+}
+
+func (v *validation) validate(spreadsheet string, experiment Experiment, smoke bool) {
+	var srv *sheets.Service
+	if NeedsSheetsService(spreadsheet, experiment.Outputs) {
+		var err error
+		if srv, err = Auth(); err != nil {
+			v.fail("credentials", err)
+			return
+		}
+		if email := AuthenticatedEmail(); email != "" {
+			v.ok("credentials", "authorized as %s", email)
+		} else {
+			v.ok("credentials", "authorized")
+		}
+	} else {
+		v.skip("credentials", "only local files are used")
+	}
+
+	source, err := OpenSource(srv, spreadsheet)
+	if err != nil {
+		v.fail("spreadsheet", err)
+		return
+	}
+	names, err := source.SheetNames()
+	if err != nil {
+		v.fail("spreadsheet", err)
+		return
+	}
+	if WritesToSpreadsheet(spreadsheet, experiment.Outputs) {
+		if err := CheckEditAccess(srv, spreadsheet); err != nil {
+			v.fail("spreadsheet", err)
+		} else {
+			v.ok("spreadsheet", "%s has %d tabs and can be edited", spreadsheet, len(names))
+		}
+	} else {
+		v.ok("spreadsheet", "%s has %d tabs", spreadsheet, len(names))
+	}
+
+	inputsSheet, err := InputsSheet(source, experiment)
+	if err != nil {
+		v.fail("inputs", err)
+		return
+	}
+	setupRows, err := source.ReadRows(inputsSheet)
+	if err != nil {
+		v.fail("inputs", fmt.Errorf("Unable to read %s: %v", inputsSheet, err))
+		return
+	}
+	v.ok("inputs", "%s has %d rows", inputsSheet, len(setupRows))
+
+	config, varNames, inputSets, err := planInputSets(source, inputsSheet, setupRows, experiment)
+	if err != nil {
+		v.fail("variables", err)
+		return
+	}
+	v.ok("variables", "%d variables (%s), %d input sets", len(varNames), strings.Join(varNames, ", "), len(inputSets))
+
+	if experiment.Target == "" || experiment.Target == "local" {
+		if err := checkProgram(experiment.Program); err != nil {
+			v.fail("program", err)
+			return
+		}
+		v.ok("program", "%s is executable", experiment.Program)
+	}
+	runTarget, err := OpenTarget(experiment.Target, experiment.Program, TargetOptions{})
+	if err != nil {
+		v.fail("program", err)
+		return
+	}
+
+	switch {
+	case !smoke:
+		v.skip("smoke run", "disabled with -smoke=false")
+	case len(inputSets) == 0:
+		v.skip("smoke run", "no input set to run")
+	default:
+		outputs, err := runTarget.Run(config, varNames, inputSets[0])
+		if err != nil {
+			v.fail("smoke run", fmt.Errorf("%s: %v", inputLabel(varNames, inputSets[0]), err))
+			return
+		}
+		keys := []string{}
+		for key := range outputs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		v.ok("smoke run", "%s answered %d outputs (%s)", inputLabel(varNames, inputSets[0]), len(keys), strings.Join(keys, ", "))
+	}
+}
+
+// planInputSets parses the variables of an inputs tab as a run would,
+// returning the config of the program and the input sets.
+func planInputSets(source Source, inputsSheet string, setupRows [][]string, experiment Experiment) (RunnerConfig, []string, [][]string, error) {
+	config, err := experiment.RunnerConfig()
+	if err != nil {
+		return config, nil, nil, err
+	}
+	layout := ParseSetupLayout(setupRows)
+	layout.Experiment = experiment.Name
+	setupRows, varConstraints := layout.Rows(setupRows)
+	if err := CheckInputTypes(inputsSheet, layout, setupRows); err != nil {
+		return config, nil, nil, err
+	}
+	config.Types = VarTypes(setupRows)
+	setupRows, derived := SplitDerivedRows(setupRows)
+	varNames, exampleSets, err := GetVarsExamplesSets(setupRows)
+	if err != nil {
+		return config, nil, nil, err
+	}
+	varNames, inputSets, err := AddDerivedVars(varNames, GetInputSets(exampleSets), derived)
+	if err != nil {
+		return config, nil, nil, err
+	}
+	constraints, err := ReadConstraints(source)
+	if err != nil {
+		return config, nil, nil, err
+	}
+	inputSets, err = FilterInputSets(varNames, inputSets, append(constraints, varConstraints...))
+	if err != nil {
+		return config, nil, nil, err
+	}
+	exclusions, err := ReadExclusions(source)
+	if err != nil {
+		return config, nil, nil, err
+	}
+	if inputSets, err = ExcludeInputSets(varNames, inputSets, append(exclusions, experiment.Exclude...)); err != nil {
+		return config, nil, nil, err
+	}
+	return config, varNames, DedupeInputSets(inputSets), nil
+}
+
+// checkProgram verifies that a local program exists and is executable.
+func checkProgram(program string) error {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return fmt.Errorf("Unable to find program %s: %v", program, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Unable to find program %s: %v", program, err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return fmt.Errorf("Program %s is not executable", path)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package blackbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// WatchCommand implements "blackbox watch": it polls the tabs defining an
+// experiment, its inputs, constraints and exclusions, and runs the
+// experiment into a new result tab whenever they change, to iterate on an
+// experiment from the spreadsheet alone.
+func WatchCommand(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", 30*time.Second, "how often to check the inputs for changes")
+	now := flags.Bool("now", false, "also run the experiment as it is when starting")
+	inputs := flags.String("inputs", "", "tab or named range defining the input variables (default inputs, or inputs_NAME with -experiment)")
+	experimentName := flags.String("experiment", "", "name of the experiment to run")
+	var outputs ListFlags
+	flags.Var(&outputs, "output", "where to record results, as for a run (repeatable, default next to the inputs)")
+	timeout := flags.Duration("timeout", 0, "kill the program if a single run takes longer than this (0 for no limit)")
+	concurrency := flags.Int("concurrency", 1, "number of program invocations to run in parallel")
+	keepGoing := flags.Bool("keep-going", false, "record failed runs in an error column instead of stopping")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox watch [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return fmt.Errorf("spreadsheet or progpath param is missing")
+	}
+	spreadsheet := SpreadsheetID(flags.Arg(0))
+	experiment := Experiment{
+		Name:        *experimentName,
+		Program:     flags.Arg(1),
+		Inputs:      *inputs,
+		Outputs:     outputs,
+		Concurrency: *concurrency,
+		KeepGoing:   *keepGoing,
+	}
+	if *timeout > 0 {
+		experiment.Timeout = timeout.String()
+	}
+
+	var srv *sheets.Service
+	if NeedsSheetsService(spreadsheet, outputs) {
+		var err error
+		if srv, err = Auth(); err != nil {
+			return err
+		}
+	}
+	if WritesToSpreadsheet(spreadsheet, outputs) {
+		if err := CheckEditAccess(srv, spreadsheet); err != nil {
+			return err
+		}
+	}
+	last, err := experimentDefinition(srv, spreadsheet, experiment)
+	if err != nil {
+		return err
+	}
+	Log.Infof("Watching %s every %v\n", spreadsheet, *interval)
+	if *now {
+		watchRun(srv, spreadsheet, experiment)
+	}
+	for range time.Tick(*interval) {
+		definition, err := experimentDefinition(srv, spreadsheet, experiment)
+		if err != nil {
+			// e.g. the tab is being renamed, or the network is down
+			Log.Warnf("Unable to check the inputs: %v\n", err)
+			continue
+		}
+		if definition == last {
+			continue
+		}
+		last = definition
+		Log.Infof("The inputs of %s changed\n", spreadsheet)
+		watchRun(srv, spreadsheet, experiment)
+	}
+	return nil
+}
+
+// watchRun runs the experiment, a failure waiting for the next change.
+func watchRun(srv *sheets.Service, spreadsheet string, experiment Experiment) {
+	result := RunExperiment(srv, spreadsheet, experiment)
+	if result.Err != nil {
+		Log.Errorf("Run %s failed: %v\n", result.ResultName, result.Err)
+		return
+	}
+	Log.Infof("Run %s done in %v, waiting for changes\n", result.ResultName, result.Duration.Round(time.Second))
+}
+
+// experimentDefinition returns a digest of the tabs defining an
+// experiment, changing when any of them is edited.
+func experimentDefinition(srv *sheets.Service, spreadsheet string, experiment Experiment) (string, error) {
+	source, err := OpenSource(srv, spreadsheet)
+	if err != nil {
+		return "", err
+	}
+	inputsSheet, err := InputsSheet(source, experiment)
+	if err != nil {
+		return "", err
+	}
+	tabs := map[string][][]string{}
+	if tabs[inputsSheet], err = source.ReadRows(inputsSheet); err != nil {
+		return "", err
+	}
+	for _, sheetName := range []string{constraintsSheet, excludeSheet, assertionsSheet} {
+		if tabs[sheetName], err = ReadOptionalRows(source, sheetName); err != nil {
+			return "", err
+		}
+	}
+	content, err := json.Marshal(tabs)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(content)
+	return hex.EncodeToString(digest[:]), nil
+}
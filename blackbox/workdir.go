@@ -0,0 +1,49 @@
+package blackbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// workdirColumn records the working directory of a run, with
+// -workdir-per-run.
+const workdirColumn = "workdir"
+
+// runWorkdir creates a fresh working directory for a run of a program, so
+// that programs writing scratch files to their working directory do not
+// interfere when running in parallel.
+func runWorkdir() (string, error) {
+	dir, err := ioutil.TempDir("", "blackbox-run-")
+	if err != nil {
+		return "", fmt.Errorf("Unable to create run working directory: %v", err)
+	}
+	return dir, nil
+}
+
+// releaseWorkdir removes the working directory of a run, unless the run
+// failed and keepFailed is set, to look into what the program left there.
+func releaseWorkdir(dir string, keepFailed bool, runErr error) {
+	if runErr != nil && keepFailed {
+		Log.Warnf("Kept the working directory of a failed run: %s\n", dir)
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		Log.Warnf("Unable to remove run working directory: %v\n", err)
+	}
+}
+
+// commandPath returns the path of a program to run from another working
+// directory: relative paths, e.g. ./fib, are made absolute, while bare
+// names are still looked up in $PATH.
+func commandPath(progPath string) string {
+	if !strings.ContainsRune(progPath, filepath.Separator) && !strings.ContainsRune(progPath, '/') {
+		return progPath
+	}
+	if abs, err := filepath.Abs(progPath); err == nil {
+		return abs
+	}
+	return progPath
+}
@@ -0,0 +1,207 @@
+package blackbox
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+// Excel limits worksheet names to 31 characters.
+const xlsxMaxSheetName = 31
+
+// XlsxSource reads the experiment definition from a local Excel file.
+type XlsxSource struct {
+	path string
+}
+
+func (s *XlsxSource) ReadRows(sheetName string) ([][]string, error) {
+	f, err := excelize.OpenFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows(sheetName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read sheet %s of %s: %v", sheetName, s.path, err)
+	}
+	if len(rows) == 0 {
+		return rows, fmt.Errorf("No data found.")
+	}
+	return rows, nil
+}
+
+func (s *XlsxSource) SheetNames() ([]string, error) {
+	f, err := excelize.OpenFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+	return f.GetSheetList(), nil
+}
+
+// xlsxLocks serializes writers of the same file, e.g. parallel campaign runs.
+var xlsxLocks = struct {
+	sync.Mutex
+	paths map[string]*sync.Mutex
+}{paths: map[string]*sync.Mutex{}}
+
+func lockXlsx(path string) *sync.Mutex {
+	xlsxLocks.Lock()
+	defer xlsxLocks.Unlock()
+	lock, ok := xlsxLocks.paths[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		xlsxLocks.paths[path] = lock
+	}
+	lock.Lock()
+	return lock
+}
+
+// XlsxSink appends a results worksheet to a local Excel file, creating the
+// file if needed. Rows are spooled to a temporary file during the run and
+// streamed into the workbook when the sink closes, so that writers of the
+// same file only hold it while saving.
+type XlsxSink struct {
+	path      string
+	sheetName string
+	spool     *os.File
+	writer    *csv.Writer
+	encoder   Encoder
+}
+
+func NewXlsxSink(path, sheetName string) (*XlsxSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("xlsx output needs a file name, e.g. xlsx:results.xlsx")
+	}
+	spool, err := ioutil.TempFile("", "blackbox-xlsx-")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create xlsx spool file: %v", err)
+	}
+	return &XlsxSink{
+		path:      path,
+		sheetName: sheetName,
+		spool:     spool,
+		writer:    csv.NewWriter(spool),
+		encoder:   TypedEncoder{},
+	}, nil
+}
+
+// truncateRunes cuts s to at most n characters.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) > n {
+		runes = runes[:n]
+	}
+	return string(runes)
+}
+
+// xlsxSheetName returns the name of a new worksheet of f: name, truncated
+// to the length Excel allows, and numbered if f already has a worksheet of
+// that name, e.g. "result_2024-01-02 (2)".
+func xlsxSheetName(f *excelize.File, name string) string {
+	existing := map[string]bool{}
+	for _, sheet := range f.GetSheetList() {
+		// Excel compares worksheet names ignoring case
+		existing[strings.ToLower(sheet)] = true
+	}
+	candidate := truncateRunes(name, xlsxMaxSheetName)
+	for n := 2; existing[strings.ToLower(candidate)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		candidate = truncateRunes(name, xlsxMaxSheetName-len(suffix)) + suffix
+	}
+	return candidate
+}
+
+func (s *XlsxSink) SetEncoder(encoder Encoder) {
+	s.encoder = encoder
+}
+
+func (s *XlsxSink) WriteHeader(header []string) error {
+	return s.WriteRow(header)
+}
+
+func (s *XlsxSink) WriteRow(row []string) error {
+	if err := s.writer.Write(row); err != nil {
+		return fmt.Errorf("Unable to spool xlsx rows: %v", err)
+	}
+	return nil
+}
+
+func (s *XlsxSink) Close() error {
+	defer os.Remove(s.spool.Name())
+	defer s.spool.Close()
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return fmt.Errorf("Unable to spool xlsx rows: %v", err)
+	}
+	if _, err := s.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	lock := lockXlsx(s.path)
+	defer lock.Unlock()
+
+	var f *excelize.File
+	_, err := os.Stat(s.path)
+	created := os.IsNotExist(err)
+	if created {
+		f = excelize.NewFile()
+	} else if f, err = excelize.OpenFile(s.path); err != nil {
+		return fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	sheetName := truncateRunes(s.sheetName, xlsxMaxSheetName)
+	if created {
+		// Use the empty default sheet of a new workbook
+		if err := f.SetSheetName("Sheet1", sheetName); err != nil {
+			return err
+		}
+	} else {
+		sheetName = xlsxSheetName(f, s.sheetName)
+		if _, err := f.NewSheet(sheetName); err != nil {
+			return err
+		}
+	}
+	stream, err := f.NewStreamWriter(sheetName)
+	if err != nil {
+		return err
+	}
+	reader := csv.NewReader(s.spool)
+	reader.FieldsPerRecord = -1
+	for i := 1; ; i++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read xlsx spool file: %v", err)
+		}
+		cells := make([]interface{}, len(row))
+		for j, value := range row {
+			// Numbers stay numeric by default so Excel can compute with them
+			cells[j], _ = s.encoder.Encode(s.encoder.Kind(value), value)
+		}
+		cell, err := excelize.CoordinatesToCellName(1, i)
+		if err != nil {
+			return err
+		}
+		if err := stream.SetRow(cell, cells); err != nil {
+			return err
+		}
+	}
+	if err := stream.Flush(); err != nil {
+		return err
+	}
+	if err := f.SaveAs(s.path); err != nil {
+		return fmt.Errorf("Unable to save %s: %v", s.path, err)
+	}
+	Log.Infof("Wrote %s to %s\n", sheetName, s.path)
+	return nil
+}
@@ -1,376 +1,494 @@
+// Command blackbox runs a program over the input sets of a spreadsheet and
+// records its outputs, see package github.com/oozie/blackbox/blackbox for
+// running experiments from Go.
 package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
 	"os"
-	"os/exec"
-	"sort"
-	"strings"
-	"time"
 
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"github.com/oozie/blackbox/blackbox"
 	sheets "google.golang.org/api/sheets/v4"
 )
 
-func getVariableOrDefault(varName, defaultValue string) string {
-	varValue := os.Getenv(varName)
-	if len(varValue) > 0 {
-		return varValue
-	}
-	return defaultValue
-}
-
-var clientSecretFile = getVariableOrDefault("CLIENT_SECRET_FILE", "client_secret.json")
-var cachedCredsFile = getVariableOrDefault("CACHED_CREDS_FILE", "blackbox.creds.json")
-
-const spreadsheetsScope = "https://www.googleapis.com/auth/spreadsheets"
-
-func auth() (*sheets.Service, error) {
-	ctx := context.Background()
-	b, err := ioutil.ReadFile(clientSecretFile)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to read client secret file: %v", err)
-	}
-
-	config, err := google.ConfigFromJSON(b, spreadsheetsScope)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to parse client secret file to config: %v", err)
-	}
-	client, err := getClient(ctx, config)
-	if err != nil {
-		return nil, err
-	}
-
-	return sheets.New(client)
+// commands are the subcommands run as "blackbox COMMAND [flags] ...".
+var commands = map[string]func(args []string) error{
+	"diff":     blackbox.DiffCommand,
+	"bundle":   blackbox.BundleCommand,
+	"unbundle": blackbox.UnbundleCommand,
+	"optimize": blackbox.OptimizeCommand,
+	"browse":   blackbox.BrowseCommand,
+	"suite":    blackbox.SuiteCommand,
+	"auth":     blackbox.AuthCommand,
+	"validate": blackbox.ValidateCommand,
+	"queue":    blackbox.QueueCommand,
+	"watch":    blackbox.WatchCommand,
+	"report":   blackbox.ReportCommand,
+	"compare":  blackbox.CompareCommand,
 }
 
-func getClient(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
-	tok, err := tokenFromFile(cachedCredsFile)
-	if err != nil {
-		tok, err = getTokenFromWeb(config)
-		if err != nil {
-			return nil, err
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			stopTracing, err := blackbox.StartTracing(context.Background(), "")
+			if err != nil {
+				blackbox.Log.Fatalf("%v", err)
+			}
+			err = command(os.Args[2:])
+			stopTracing()
+			exitOnError(err)
+			return
 		}
-		saveToken(cachedCredsFile, tok)
 	}
-	return config.Client(ctx, tok), nil
-}
 
-// getTokenFromWeb uses Config to request a Token.
-// It returns the retrieved Token.
-func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var code string
-	if _, err := fmt.Scan(&code); err != nil {
-		return nil, fmt.Errorf("Unable to read authorization code %v", err)
-	}
-
-	tok, err := config.Exchange(oauth2.NoContext, code)
+	var outputs blackbox.ListFlags
+	flag.Var(&outputs, "output", "where to record results: sheets, sheets:NAMED_RANGE, xlsx:FILE, csv[:FILE], bq:project.dataset.table, parquet:FILE, pushgateway:URL, junit[:FILE], airtable:BASE[/TABLE], influx:WRITE_URL, lineproto[:FILE], postgres://URL or mysql://URL[?table=NAME], gs://BUCKET/OBJECT or s3://BUCKET/OBJECT uploading a .csv, .parquet, .xlsx, .lp or .xml file, e.g. gs://bucket/run-{{.RunID}}.csv (repeatable, default next to the inputs)")
+	var charts blackbox.ListFlags
+	flag.Var(&charts, "chart", "embed a chart of an output against an input in the result tab, as out_var:in_var[:scatter|line] (repeatable)")
+	var notify blackbox.ListFlags
+	flag.Var(&notify, "notify", "post a summary of each run once it finished or failed to slack://HOOK, a Slack incoming webhook such as slack://hooks.slack.com/services/..., to an http(s) URL as JSON, or to email:ADDRESS with the results attached, mailed through BLACKBOX_SMTP_ADDR (repeatable)")
+	var pivots blackbox.ListFlags
+	flag.Var(&pivots, "pivot", "add a pivot table of the results in a tab of its own, as \"rows=in_var cols=in_var values=avg(out_var)\" (repeatable)")
+	var trendCharts blackbox.ListFlags
+	flag.Var(&trendCharts, "trend-chart", "chart the mean of this output over time in the trend tab, implying -trend (repeatable)")
+	var thresholds blackbox.ListFlags
+	flag.Var(&thresholds, "threshold", "color result cells beyond thresholds, e.g. \"latency_ms: warn>200 crit>500\" (repeatable)")
+	responses := flag.String("responses", "", "tab of Google Form responses to run as input sets, one per response with a variable per question")
+	var inputSources blackbox.ListFlags
+	flag.Var(&inputSources, "input", "merge the variables of another inputs tab, as sheets:ID#TAB, FILE.xlsx#TAB or a spreadsheet URL, e.g. a library shared by experiments (repeatable)")
+	inputs := flag.String("inputs", "", "tab or named range of the spreadsheet defining the input variables (default inputs, or inputs_NAME with -experiment)")
+	experimentName := flag.String("experiment", "", "name of the experiment to run, defined in the inputs_NAME tab or by the rows of the inputs tab whose experiment column is NAME, writing result_NAME_* tabs")
+	orderBy := flag.String("order-by", "", "run the input sets of the lowest values of this expression over the variables first, e.g. 'abs(rate-100)'")
+	track := flag.String("track", "", "comma separated numeric outputs to show streaming percentiles of while running")
+	timeout := flag.Duration("timeout", 0, "kill the program if a single run takes longer than this (0 for no limit)")
+	concurrency := flag.Int("concurrency", 1, "number of program invocations to run in parallel")
+	maxDuration := flag.Duration("max-duration", 0, "stop the exploration cleanly after this long, keeping the results so far (0 for no limit)")
+	maxRuns := flag.Int("max-runs", 0, "stop the exploration cleanly after this many runs (0 for no limit)")
+	rate := flag.String("rate", "", "maximum rate of program runs across all workers, e.g. 5/s, 100/m or 2/h")
+	affinity := flag.String("affinity", "", "run the input sets sharing a value of this variable on the same worker, e.g. dataset")
+	target := flag.String("target", "", "where to run the program: local, docker:IMAGE to run it in a fresh container per input set, PROGPATH being the command run in the container (- for the image's default), ssh://[user@]host[:port]/path/to/prog[?max=N] to run it on a remote host, at most N at once, k8s:JOB.yaml[?namespace=NS] to run it as Kubernetes jobs from a template, grpc:HOST:PORT to call a service implementing proto/blackbox.proto, http(s)://HOST/PATH to post the input to a service answering the outputs, PROGPATH being -, or func:NAME to call a Go function registered with RegisterFunc instead")
+	stdinTemplate := flag.String("stdin-template", "", "send the program this text/template file rendered over the inputs, e.g. {{.size}}, instead of a JSON object")
+	cpus := flag.String("cpus", "", "CPU limit of each container, e.g. 1.5")
+	memory := flag.String("memory", "", "memory limit of each container, e.g. 512m")
+	pull := flag.String("pull", "missing", "when to pull the image of a docker target: missing, always or never")
+	batchSize := flag.Int("batch-size", 1, "send up to this many input sets to the program at once, as a JSON array of inputs answered by an array of outputs")
+	persistent := flag.Bool("persistent", false, "start the program once and send it one JSON input set per line, each answered by a line of outputs, restarting it if it dies")
+	scenario := flag.String("scenario", "", "run this scenario file of messages to send, expectations and waits for every input set, see Scenario")
+	schedule := flag.String("schedule", "", "variable whose values are sequences of steps, e.g. \"10 50 200\", sent one JSON line per step to a single run of the program, which answers a line of outputs per step")
+	scheduleInterval := flag.Duration("schedule-interval", 0, "pause between the steps of a schedule")
+	workdirPerRun := flag.Bool("workdir-per-run", false, "run every run of a local program in a fresh temporary working directory, recorded in a workdir column")
+	keepFailed := flag.Bool("keep-failed", false, "with -workdir-per-run, keep the working directories of failed runs")
+	var artifacts blackbox.ListFlags
+	flag.Var(&artifacts, "artifacts", "glob of the files to upload to Drive from the working directory of every run of a local program, e.g. *.png, linked from its row (repeatable, implies -workdir-per-run)")
+	artifactsFolder := flag.String("artifacts-folder", "", "Drive folder ID to upload the -artifacts to (default a new folder per run)")
+	limitMemory := flag.String("limit-mem", "", "memory limit of every run of a local program, e.g. 512M, with cgroups on Linux or job objects on Windows")
+	limitCPU := flag.String("limit-cpu", "", "CPU limit of every run of a local program, e.g. 1 or 0.5")
+	measureRusage := flag.Bool("measure-rusage", false, "record the max RSS, user and system CPU time and block I/O of every run of a local program as rusage_* outputs")
+	workerState := flag.Bool("worker-state", false, "give each parallel worker a directory kept for the whole run, passed to the program in $BLACKBOX_STATE_DIR")
+	sheetsBatch := flag.Int("sheets-batch", 1, "result rows per Sheets API write request")
+	verifyWrites := flag.Int("verify-writes", 0, "read back this many random result rows from Sheets at the end and report those differing from what was sent")
+	keepGoing := flag.Bool("keep-going", false, "record failed runs in an error column instead of stopping")
+	failIf := flag.String("fail-if", "", "fail the run once complete, with exit status 3, when a rule matches its results, e.g. \"p95(latency_ms) > baseline*1.1\" against -baseline (separate rules with ;)")
+	golden := flag.String("golden", "", "JSON file recording the outputs of every input set on the first run, failing later runs with exit status 3 when outputs differ (delete it to record again)")
+	baseline := flag.String("baseline", "", "result tab, or CSV file, whose statistics are the baseline of -fail-if rules")
+	abortIf := flag.String("abort-if", "", "stop early when a rule matches, e.g. \"failure_rate > 0.2 after 100 runs\" (separate rules with ;)")
+	strategy := flag.String("strategy", "exhaustive", "how to explore the input sets: exhaustive, fuzz to run -runs random input sets drawn by the generator column of the inputs tab, or a search for the best -objective: "+blackbox.StrategyNames())
+	minimizeRuns := flag.Int("minimize-runs", blackbox.DefaultMinimizeRuns, "program runs spent minimizing each input set crashing the program, recorded in a crashes tab (-1 not to minimize)")
+	fuzzRuns := flag.Int("runs", blackbox.DefaultFuzzRuns, "number of input sets drawn by the fuzz strategy")
+	objective := flag.String("objective", "", "output searched by a strategy, e.g. \"minimize latency_ms\" or \"maximize:throughput\"")
+	budget := flag.Int("budget", 0, "maximum number of program runs of a search strategy (default 50, population × generations for genetic)")
+	population := flag.Int("population", blackbox.DefaultPopulation, "input sets per generation of the genetic strategy")
+	generations := flag.Int("generations", blackbox.DefaultGenerations, "number of generations of the genetic strategy")
+	seed := flag.Int64("seed", 0, "random seed of a search strategy (default: chosen at start and recorded)")
+	refine := flag.String("refine", "", "after the sweep, run finer values of numeric inputs where this output changes fastest, or crosses a threshold with OUTPUT=THRESHOLD")
+	refinePasses := flag.Int("refine-passes", 1, "number of refinement passes")
+	logLevel := flag.String("log-level", blackbox.GetVariableOrDefault("BLACKBOX_LOG_LEVEL", "info"), "least important messages logged: debug, info, warn or error, warn hiding the progress of runs")
+	logFormat := flag.String("log-format", blackbox.GetVariableOrDefault("BLACKBOX_LOG_FORMAT", "text"), "format of log messages: text, or json lines with time, level and msg")
+	statusAddr := flag.String("status-addr", "", "serve the progress, failures, running input sets, ETA and recent errors of the runs on this address, e.g. :8080, as HTML on / and JSON on /status.json")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "export traces of the source reads, program runs and result writes to this OTLP/gRPC HOST:PORT (default from OTEL_EXPORTER_OTLP_ENDPOINT, none if unset)")
+	flag.BoolVar(&blackbox.NoWriteSheet, "no-write-sheet", false, "only read the inputs from the spreadsheet, e.g. one that can only be viewed, writing results to CSV files unless other outputs are given, and the summary and metadata tabs to CSV files in BLACKBOX_RUNS_DIR")
+	createSpreadsheet := flag.String("create-spreadsheet", "", "create a new spreadsheet whose inputs tab holds the rows of this CSV file, laid out like an inputs tab, print its URL and run against it, SPREADSHEET_ID being left out")
+	driveFolder := flag.String("drive-folder", "", "Drive folder ID to create the spreadsheet of -create-spreadsheet in")
+	trend := flag.Bool("trend", false, "append a row summarizing the run, its date, runs, failures and the mean and p95 of every numeric output, to the trend tab of the experiment, trend or trend_NAME")
+	cronSpec := flag.String("cron", "", "keep running the experiment at the times of this cron schedule, e.g. '0 3 * * *' nightly, into result tabs named after the date, keeping their trend as with -trend")
+	campaignFile := flag.String("campaign", "", "run the experiments described in this campaign config file")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbox [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH\n")
+		fmt.Fprintf(os.Stderr, "       blackbox -campaign FILE [flags] SPREADSHEET_ID|FILE.xlsx\n")
+		fmt.Fprintf(os.Stderr, "       blackbox -create-spreadsheet INPUTS.csv [-drive-folder ID] [flags] PROGPATH\n")
+		fmt.Fprintf(os.Stderr, "       blackbox diff -baseline TAB -current TAB [flags] SPREADSHEET_ID|FILE.xlsx\n")
+		fmt.Fprintf(os.Stderr, "       blackbox bundle [-o FILE.tar.gz] RUN\n")
+		fmt.Fprintf(os.Stderr, "       blackbox unbundle [-to SPREADSHEET_ID|FILE.xlsx] FILE.tar.gz\n")
+		fmt.Fprintf(os.Stderr, "       blackbox optimize -objective 'minimize OUTPUT' [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH\n")
+		fmt.Fprintf(os.Stderr, "       blackbox browse [-addr HOST:PORT] [DIR]\n")
+		fmt.Fprintf(os.Stderr, "       blackbox suite [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH\n")
+		fmt.Fprintf(os.Stderr, "       blackbox validate [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH\n")
+		fmt.Fprintf(os.Stderr, "       blackbox queue [flags] SPREADSHEET_ID PROGPATH\n")
+		fmt.Fprintf(os.Stderr, "       blackbox watch [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH\n")
+		fmt.Fprintf(os.Stderr, "       blackbox compare [-targets FILE.json] [flags] SPREADSHEET_ID|FILE.xlsx PROGPATH OTHER_PROGPATH...\n")
+		fmt.Fprintf(os.Stderr, "       blackbox report -from TAB [-format md|html] [-o FILE] SPREADSHEET_ID|FILE.xlsx\n")
+		fmt.Fprintf(os.Stderr, "       blackbox auth login|status|revoke\n")
+		fmt.Fprintf(os.Stderr, "SPREADSHEET_ID may be the URL of the spreadsheet; its #gid= tab is then the default -inputs\n")
+		fmt.Fprintf(os.Stderr, "SPREADSHEET_ID may also be airtable:BASE, whose tables are used as tabs, with a token in $BLACKBOX_AIRTABLE_TOKEN\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := blackbox.Log.Configure(*logLevel, *logFormat); err != nil {
+		blackbox.Log.Fatalf("%v", err)
+	}
+	if *statusAddr != "" {
+		blackbox.Status.ServeStatus(*statusAddr)
+	}
+	stopTracing, err := blackbox.StartTracing(context.Background(), *otlpEndpoint)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to retrieve token from web %v", err)
+		blackbox.Log.Fatalf("%v", err)
 	}
-	return tok, nil
-}
+	defer stopTracing()
 
-// tokenFromFile retrieves a Token from a given file path.
-// It returns the retrieved Token and any read error encountered.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
+	// Read the spreadsheet
+	//   take the id of the spreadsheet
+	args := flag.Args()
+	if *createSpreadsheet != "" {
+		// The spreadsheet is created once authenticated
+		args = append([]string{""}, args...)
 	}
-	t := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(t)
-	defer f.Close()
-	return t, err
-}
-
-// saveToken uses a file path to create a file and store the
-// token in it.
-func saveToken(file string, token *oauth2.Token) error {
-	fmt.Printf("Saving credential file to: %s\n", file)
-	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("Unable to cache oauth token: %v", err)
+	if len(args) < 1 || (*campaignFile == "" && len(args) < 2) {
+		flag.Usage()
+		blackbox.Log.Fatalf("spreadsheet or progpath param is missing")
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-	return nil
-}
-
-func ReadSetupRows(service *sheets.Service, spreadsheetID, setupSheetName string) ([][]string, error) {
-	readRange := setupSheetName + "!A1:Z"
-	rows := [][]string{}
 
-	resp, err := service.Spreadsheets.Values.Get(spreadsheetID, readRange).Do()
-	if err != nil {
-		return rows, fmt.Errorf("Unable to retrieve data from sheet. %v", err)
+	spreadsheetId, inputsGID := blackbox.ParseSpreadsheetRef(args[0])
+	progPath := ""
+	if len(args) > 1 {
+		progPath = args[1]
 	}
+	blackbox.Log.Infof("blackbox: spreadsheet %s, program %s", spreadsheetId, progPath)
 
-	if len(resp.Values) > 0 {
-		for _, row := range resp.Values {
-			stringRow := []string{}
-			for _, item := range row {
-				stringRow = append(stringRow, item.(string))
-			}
-			rows = append(rows, stringRow)
+	var campaign *blackbox.Campaign
+	allOutputs := []string(outputs)
+	if *campaignFile != "" {
+		var err error
+		if campaign, err = blackbox.LoadCampaign(*campaignFile); err != nil {
+			blackbox.Log.Fatalf("%v", err)
 		}
-	} else {
-		return rows, fmt.Errorf("No data found.")
-	}
-
-	return rows, nil
-}
-
-func ExtractExamples(examplesCell string) []string {
-	examples := []string{}
-	for _, example := range strings.Split(examplesCell, ",") {
-		trimmed := strings.Trim(example, " \t")
-		if trimmed != "" {
-			examples = append(examples, trimmed)
+		for _, run := range campaign.Runs {
+			allOutputs = append(allOutputs, run.Outputs...)
 		}
 	}
-	return examples
-}
 
-func GetInputSets(exampleSets [][]string) [][]string {
-	result := [][]string{}
-	if len(exampleSets) == 0 {
-		return result
-	}
-	if len(exampleSets) == 1 {
-		for _, item := range exampleSets[0] {
-			result = append(result, []string{item})
+	if blackbox.NoWriteSheet {
+		if *createSpreadsheet != "" {
+			blackbox.Log.Fatalf("-create-spreadsheet writes the spreadsheet, which -no-write-sheet leaves untouched")
 		}
-	}
-	head := exampleSets[0]
-	tail := exampleSets[1:]
-	for _, item := range head {
-		for _, subitem := range GetInputSets(tail) {
-			result = append(result, append([]string{item}, subitem...))
+		if err := blackbox.CheckNoWriteSheet(allOutputs); err != nil {
+			blackbox.Log.Fatalf("%v", err)
 		}
 	}
-	return result
-}
-
-func GetVarsExamplesSets(setupRows [][]string) ([]string, [][]string, error) {
-	vars := []string{}
-	examplesSets := [][]string{}
 
-	for i, setupRow := range setupRows {
-		varCell := setupRow[0]
-		examplesCell := setupRow[1]
-		varName := strings.Trim(varCell, "\t \n")
-		if varName == "" {
-			return vars, examplesSets, fmt.Errorf("Could not extract var name from row %d", i)
+	//   authenticate, unless everything stays in local files
+	var srv *sheets.Service
+	if blackbox.NeedsSheetsService(spreadsheetId, allOutputs) || blackbox.InputSourcesNeedSheets(inputSources) {
+		var err error
+		if srv, err = blackbox.Auth(); err != nil {
+			blackbox.Log.Fatalf("%v", err)
 		}
-		examples := ExtractExamples(examplesCell)
-		if len(examples) == 0 {
-			return vars, examplesSets, fmt.Errorf("Could not extract examples from row %d", i)
-		}
-		vars = append(vars, varName)
-		examplesSets = append(examplesSets, examples)
-	}
-
-	return vars, examplesSets, nil
-}
-
-func RecordSortedKeys(output map[string]string) []string {
-	keys := make([]string, 0)
-	for key, _ := range output {
-		keys = append(keys, key)
 	}
-	sort.Strings(keys)
-	return keys
-}
-
-func RunBlackBoxCmd(progPath string, varNames, inputSet []string) (map[string]string, error) {
-	inputMap := make(map[string]string)
-	for i, inputItem := range inputSet {
-		inputMap[varNames[i]] = inputItem
-	}
-	// Marshal into JSON
-	jsonBytes, err := json.Marshal(inputMap)
-	if err != nil {
-		return nil, err
-	}
-
-	cmd := exec.Command(progPath)
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-	go func() {
-		stdin.Write(jsonBytes)
-		defer stdin.Close()
-	}()
-	// Read output
-
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
-	// Unmarshal output
-	outputMap := make(map[string]string)
-	err = json.Unmarshal(output, &outputMap)
-	return outputMap, err
-}
-
-func RecordResults(srv *sheets.Service, spreadsheetID, resultSheetName string, varNames []string, resultChannel chan []string) error {
-	currentLine := 1
-	getAddress := func() string {
-		return fmt.Sprintf("%s!A%d", resultSheetName, currentLine)
-	}
-	// While info is coming from the channel, keep updating rows
-	for resultLine := range resultChannel {
-		resultRow := make([]interface{}, 0)
-		for _, input := range resultLine {
-			resultRow = append(resultRow, input)
-		}
-
-		vr := sheets.ValueRange{
-			Values: [][]interface{}{resultRow},
-		}
-
-		_, err := srv.Spreadsheets.Values.Update(spreadsheetID, getAddress(), &vr).ValueInputOption("USER_ENTERED").Do()
+	if *createSpreadsheet != "" {
+		id, url, err := blackbox.CreateExperimentSpreadsheet(srv, *createSpreadsheet, *driveFolder)
 		if err != nil {
-			return err
+			blackbox.Log.Fatalf("%v", err)
 		}
-		currentLine++
+		blackbox.Log.Infof("Created spreadsheet %s", url)
+		// For scripts, the only line on stdout
+		fmt.Println(url)
+		spreadsheetId = id
 	}
-	return nil
-}
-
-func RunExploration(progPath string, varNames []string, inputSets [][]string, resultChan chan []string) error {
-	outputVars := []string{}
-
-	for i, inputSet := range inputSets {
-		fmt.Fprintf(os.Stderr, " ===> [%d/%d] <===", i+1, len(inputSets))
-		outputMap, err := RunBlackBoxCmd(progPath, varNames, inputSet)
-		fmt.Fprintf(os.Stderr, "\r")
-
+	if inputsGID >= 0 && *inputs == "" {
+		title, err := blackbox.SheetTitle(srv, spreadsheetId, inputsGID)
 		if err != nil {
-			return err
-		}
-		if len(outputVars) == 0 {
-			outputVars = RecordSortedKeys(outputMap)
-			// Send the header
-			resultChan <- append(varNames, outputVars...)
+			blackbox.Log.Fatalf("%v", err)
 		}
-		resultLine := append([]string{}, inputSet...)
-		for _, outputVar := range outputVars {
-			resultLine = append(resultLine, outputMap[outputVar])
-		}
-		resultChan <- resultLine
-	}
-	return nil
-}
-
-func CreateNewResultSheet(srv *sheets.Service, spreadsheetID, sheetName string) error {
-
-	addRequest := sheets.Request{}
-
-	requestsString := fmt.Sprintf(`{
-      "addSheet": {
-        "properties": {
-          "title": "%s",
-          "tabColor": {
-            "red": 1.0,
-            "green": 0.3,
-            "blue": 0.4
-          }
-        }
-      }
-	}`, sheetName)
-	err := json.Unmarshal([]byte(requestsString), &addRequest)
-	if err != nil {
-		return err
-	}
-
-	rb := &sheets.BatchUpdateSpreadsheetRequest{
-		Requests: []*sheets.Request{&addRequest},
-	}
-
-	_, err = srv.Spreadsheets.BatchUpdate(spreadsheetID, rb).Do()
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func main() {
-	fmt.Println("blackbox\n========")
-	// Read the spreadsheet
-	//   take the id of the spreadsheet
-	if len(os.Args) < 3 {
-		panic("spreadsheet or progpath param is missing")
-	}
-
-	spreadsheetId := os.Args[1]
-	progPath := os.Args[2]
-	fmt.Println(spreadsheetId, progPath)
-	//   authenticate
-	srv, err := auth()
-	if err != nil {
-		panic(err)
+		blackbox.Log.Infof("Reading inputs from tab %s of the URL", title)
+		*inputs = title
 	}
-
-	// retreive data from spreadsheet/inputs
-	setupRows, err := ReadSetupRows(srv, spreadsheetId, "inputs")
-	if err != nil {
-		panic(err)
-	}
-
-	// Create cartesian product from the inputs
-	varNames, exampleSets, err := GetVarsExamplesSets(setupRows)
-	if err != nil {
-		panic(err)
+	writes := blackbox.WritesToSpreadsheet(spreadsheetId, outputs)
+	if campaign != nil {
+		for _, run := range campaign.Runs {
+			writes = writes || (len(run.Outputs) > 0 && blackbox.WritesToSpreadsheet(spreadsheetId, run.Outputs))
+		}
 	}
-	inputSets := GetInputSets(exampleSets)
-	log.Printf("Got %d input sets for %d variables\n", len(inputSets), len(varNames))
-
-	resultSheetName := fmt.Sprintf("result_%d", time.Now().Unix())
-	err = CreateNewResultSheet(srv, spreadsheetId, resultSheetName)
-	if err != nil {
-		panic(err)
+	if writes {
+		// A hint on sharing the spreadsheet beats a stack trace
+		if err := blackbox.CheckEditAccess(srv, spreadsheetId); err != nil {
+			blackbox.Log.Fatalf("%v", err)
+		}
 	}
 
-	resultChannel := make(chan []string)
-	defer close(resultChannel)
-	recordErrorChannel := make(chan error)
-	defer close(recordErrorChannel)
-	exploreErrorChannel := make(chan error)
-	defer close(exploreErrorChannel)
-
-	go func() {
-		recordErrorChannel <- RecordResults(srv, spreadsheetId, resultSheetName, varNames, resultChannel)
-	}()
-
-	go func() {
-		exploreErrorChannel <- RunExploration(progPath, varNames, inputSets, resultChannel)
-	}()
-
-	for {
-		select {
-		case err := <-recordErrorChannel:
-			if err != nil {
-				panic(err)
+	if campaign != nil {
+		for i := range campaign.Runs {
+			if campaign.Runs[i].Program == "" {
+				campaign.Runs[i].Program = progPath
+			}
+			if len(campaign.Runs[i].Outputs) == 0 {
+				campaign.Runs[i].Outputs = outputs
+			}
+			if campaign.Runs[i].Target == "" {
+				campaign.Runs[i].Target = *target
+			}
+			if campaign.Runs[i].StdinTemplate == "" {
+				campaign.Runs[i].StdinTemplate = *stdinTemplate
+			}
+			if campaign.Runs[i].Inputs == "" {
+				campaign.Runs[i].Inputs = *inputs
+			}
+			if campaign.Runs[i].Responses == "" {
+				campaign.Runs[i].Responses = *responses
+			}
+			if len(campaign.Runs[i].InputSources) == 0 {
+				campaign.Runs[i].InputSources = inputSources
+			}
+			if campaign.Runs[i].CPUs == "" {
+				campaign.Runs[i].CPUs = *cpus
+			}
+			if campaign.Runs[i].Memory == "" {
+				campaign.Runs[i].Memory = *memory
+			}
+			if campaign.Runs[i].Pull == "" {
+				campaign.Runs[i].Pull = *pull
+			}
+			if campaign.Runs[i].Timeout == "" && *timeout > 0 {
+				campaign.Runs[i].Timeout = timeout.String()
 			}
-		case err := <-exploreErrorChannel:
+			if len(campaign.Runs[i].Charts) == 0 {
+				campaign.Runs[i].Charts = charts
+			}
+			if len(campaign.Runs[i].Pivots) == 0 {
+				campaign.Runs[i].Pivots = pivots
+			}
+			if len(campaign.Runs[i].Thresholds) == 0 {
+				campaign.Runs[i].Thresholds = thresholds
+			}
+			if len(campaign.Runs[i].Track) == 0 {
+				campaign.Runs[i].Track = blackbox.ExtractExamples(*track)
+			}
+			campaign.Runs[i].Trend = campaign.Runs[i].Trend || *trend
+			if len(campaign.Runs[i].TrendCharts) == 0 {
+				campaign.Runs[i].TrendCharts = trendCharts
+			}
+			if campaign.Runs[i].OrderBy == "" {
+				campaign.Runs[i].OrderBy = *orderBy
+			}
+			if campaign.Runs[i].FailIf == "" {
+				campaign.Runs[i].FailIf = *failIf
+				campaign.Runs[i].Baseline = *baseline
+			}
+			if campaign.Runs[i].Golden == "" {
+				campaign.Runs[i].Golden = blackbox.GoldenPath(*golden, campaign.Runs[i].Name)
+			}
+			if campaign.Runs[i].AbortIf == "" {
+				campaign.Runs[i].AbortIf = *abortIf
+			}
+			campaign.Runs[i].KeepGoing = campaign.Runs[i].KeepGoing || *keepGoing
+			if campaign.Runs[i].MinimizeRuns == 0 {
+				campaign.Runs[i].MinimizeRuns = *minimizeRuns
+			}
+			campaign.Runs[i].WorkerState = campaign.Runs[i].WorkerState || *workerState
+			campaign.Runs[i].MeasureRusage = campaign.Runs[i].MeasureRusage || *measureRusage
+			campaign.Runs[i].WorkdirPerRun = campaign.Runs[i].WorkdirPerRun || *workdirPerRun
+			campaign.Runs[i].KeepFailed = campaign.Runs[i].KeepFailed || *keepFailed
+			if len(campaign.Runs[i].Artifacts) == 0 {
+				campaign.Runs[i].Artifacts = artifacts
+			}
+			if campaign.Runs[i].ArtifactsFolder == "" {
+				campaign.Runs[i].ArtifactsFolder = *artifactsFolder
+			}
+			if campaign.Runs[i].LimitMemory == "" {
+				campaign.Runs[i].LimitMemory = *limitMemory
+			}
+			if campaign.Runs[i].LimitCPU == "" {
+				campaign.Runs[i].LimitCPU = *limitCPU
+			}
+			campaign.Runs[i].Persistent = campaign.Runs[i].Persistent || *persistent
+			if campaign.Runs[i].Scenario == "" {
+				campaign.Runs[i].Scenario = *scenario
+			}
+			if campaign.Runs[i].Schedule == "" {
+				campaign.Runs[i].Schedule = *schedule
+				if *scheduleInterval > 0 {
+					campaign.Runs[i].ScheduleInterval = scheduleInterval.String()
+				}
+			}
+			if campaign.Runs[i].BatchSize == 0 {
+				campaign.Runs[i].BatchSize = *batchSize
+			}
+			if campaign.Runs[i].Affinity == "" {
+				campaign.Runs[i].Affinity = *affinity
+			}
+			if len(campaign.Runs[i].Notify) == 0 {
+				campaign.Runs[i].Notify = notify
+			}
+			if campaign.Runs[i].Rate == "" {
+				campaign.Runs[i].Rate = *rate
+			}
+			if campaign.Runs[i].MaxDuration == "" && *maxDuration > 0 {
+				campaign.Runs[i].MaxDuration = maxDuration.String()
+			}
+			if campaign.Runs[i].MaxRuns == 0 {
+				campaign.Runs[i].MaxRuns = *maxRuns
+			}
+			if campaign.Runs[i].VerifyWrites == 0 {
+				campaign.Runs[i].VerifyWrites = *verifyWrites
+			}
+			if campaign.Runs[i].SheetsBatch == 0 {
+				campaign.Runs[i].SheetsBatch = *sheetsBatch
+			}
+			if campaign.Runs[i].Concurrency == 0 {
+				campaign.Runs[i].Concurrency = *concurrency
+			}
+			if campaign.Runs[i].Refine == "" {
+				campaign.Runs[i].Refine = *refine
+				campaign.Runs[i].RefinePasses = *refinePasses
+			}
+			if campaign.Runs[i].Strategy == "" {
+				campaign.Runs[i].Strategy = *strategy
+				campaign.Runs[i].Objective = *objective
+				campaign.Runs[i].Budget = *budget
+				campaign.Runs[i].FuzzRuns = *fuzzRuns
+				campaign.Runs[i].Seed = *seed
+				campaign.Runs[i].Population = *population
+				campaign.Runs[i].Generations = *generations
+			}
+		}
+		run := func() error {
+			results, err := blackbox.RunCampaign(srv, spreadsheetId, campaign)
 			if err != nil {
-				panic(err)
+				return err
 			}
-			return
+			regressions := &blackbox.GateError{}
+			for _, result := range results {
+				switch err := result.Err.(type) {
+				case nil:
+				case *blackbox.BudgetError:
+					blackbox.Log.Warnf("Campaign run %s: %v\n", result.Experiment.Name, result.Err)
+				case *blackbox.GateError:
+					for _, reason := range err.Reasons {
+						regressions.Reasons = append(regressions.Reasons, result.Experiment.Name+": "+reason)
+					}
+				default:
+					return fmt.Errorf("campaign run %s failed: %v", result.Experiment.Name, result.Err)
+				}
+			}
+			if len(regressions.Reasons) > 0 {
+				return regressions
+			}
+			return nil
+		}
+		if *cronSpec != "" {
+			for i := range campaign.Runs {
+				campaign.Runs[i].Dated = true
+				campaign.Runs[i].Trend = true
+			}
+			err = blackbox.RunOnSchedule(*cronSpec, run)
+		} else {
+			err = run()
 		}
+		exitOnError(err)
+		return
+	}
+
+	experiment := blackbox.Experiment{
+		Name:            *experimentName,
+		Program:         progPath,
+		Inputs:          *inputs,
+		InputSources:    inputSources,
+		Responses:       *responses,
+		Target:          *target,
+		StdinTemplate:   *stdinTemplate,
+		CPUs:            *cpus,
+		Memory:          *memory,
+		Pull:            *pull,
+		Outputs:         outputs,
+		Concurrency:     *concurrency,
+		WorkerState:     *workerState,
+		MeasureRusage:   *measureRusage,
+		LimitMemory:     *limitMemory,
+		LimitCPU:        *limitCPU,
+		WorkdirPerRun:   *workdirPerRun,
+		KeepFailed:      *keepFailed,
+		Artifacts:       artifacts,
+		ArtifactsFolder: *artifactsFolder,
+		BatchSize:       *batchSize,
+		Persistent:      *persistent,
+		Schedule:        *schedule,
+		Scenario:        *scenario,
+		Affinity:        *affinity,
+		Rate:            *rate,
+		Notify:          notify,
+		OrderBy:         *orderBy,
+		Trend:           *trend,
+		TrendCharts:     trendCharts,
+		MaxRuns:         *maxRuns,
+		Track:           blackbox.ExtractExamples(*track),
+		Charts:          charts,
+		Pivots:          pivots,
+		Thresholds:      thresholds,
+		SheetsBatch:     *sheetsBatch,
+		VerifyWrites:    *verifyWrites,
+		KeepGoing:       *keepGoing,
+		AbortIf:         *abortIf,
+		FailIf:          *failIf,
+		Baseline:        *baseline,
+		Golden:          *golden,
+		Strategy:        *strategy,
+		Objective:       *objective,
+		Budget:          *budget,
+		FuzzRuns:        *fuzzRuns,
+		MinimizeRuns:    *minimizeRuns,
+		Seed:            *seed,
+		Population:      *population,
+		Generations:     *generations,
+		Refine:          *refine,
+		RefinePasses:    *refinePasses,
+	}
+	if *timeout > 0 {
+		experiment.Timeout = timeout.String()
+	}
+	if *scheduleInterval > 0 {
+		experiment.ScheduleInterval = scheduleInterval.String()
+	}
+	if *maxDuration > 0 {
+		experiment.MaxDuration = maxDuration.String()
+	}
+	run := func() error {
+		result := blackbox.RunExperiment(srv, spreadsheetId, experiment)
+		if _, ok := result.Err.(*blackbox.BudgetError); ok {
+			blackbox.Log.Warnf("%v", result.Err)
+			return nil
+		}
+		return result.Err
+	}
+	if *cronSpec != "" {
+		experiment.Dated = true
+		experiment.Trend = true
+		err = blackbox.RunOnSchedule(*cronSpec, run)
+	} else {
+		err = run()
+	}
+	exitOnError(err)
+}
+
+// exitOnError exits with the status telling regressed results, failing
+// -fail-if rules, differing from -golden outputs or from the outputs of
+// compared programs, from other errors.
+func exitOnError(err error) {
+	switch err.(type) {
+	case nil:
+	case *blackbox.GateError:
+		blackbox.Log.Errorf("%v", err)
+		os.Exit(blackbox.GateExitCode)
+	default:
+		blackbox.Log.Fatalf("%v", err)
 	}
 }